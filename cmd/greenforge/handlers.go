@@ -3,11 +3,15 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -21,6 +25,7 @@ import (
 	"github.com/greencode/greenforge/internal/model"
 	"github.com/greencode/greenforge/internal/rbac"
 	"github.com/greencode/greenforge/internal/tools"
+	"golang.org/x/crypto/ssh"
 )
 
 func loadConfig() *config.Config {
@@ -117,7 +122,25 @@ func runSession(project, modelOverride string) error {
 
 	// Initialize components
 	router := model.NewRouter(cfg)
+	auditor, err := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db"))
+	if err == nil {
+		defer auditor.Close()
+		router.SetAuditor(auditor)
+	}
+	if cache := openResponseCache(cfg); cache != nil {
+		defer cache.Close()
+		router.SetCache(cache)
+	}
+	if ledger := openUsageLedger(cfg); ledger != nil {
+		defer ledger.Close()
+		router.SetUsageLedger(ledger)
+	}
+	rbacEngine, _, err := loadRBACEngine(cfg)
+	if err != nil {
+		return err
+	}
 	runtime := agent.NewRuntime(cfg, router)
+	runtime.SetToolExecutor(newToolRegistry(router, auditor, rbacEngine))
 
 	// Set up streaming callbacks for CLI
 	runtime.SetCallbacks(agent.Callbacks{
@@ -177,6 +200,14 @@ func runSession(project, modelOverride string) error {
 	defer cancel()
 
 	ctx = model.WithProject(ctx, project)
+	id := localIdentity(cfg)
+	ctx = rbac.WithIdentity(ctx, id)
+	ctx = rbac.WithAttributes(ctx, rbac.Attributes{
+		Project:  project,
+		Branch:   gateway.CurrentBranch(project),
+		CertType: id.CertType(),
+		Time:     time.Now(),
+	})
 
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
@@ -219,11 +250,17 @@ func runSession(project, modelOverride string) error {
 	return nil
 }
 
-func runQuery(question, project string) error {
-	_ = loadConfig()
+func runQuery(question, project string, k int) error {
+	cfg := loadConfig()
+	projectPath := ""
 	if project == "" {
-		cwd, _ := os.Getwd()
-		project = filepath.Base(cwd)
+		projectPath, _ = os.Getwd()
+		project = filepath.Base(projectPath)
+	}
+	for _, p := range cfg.Projects {
+		if p.Name == project || filepath.Base(p.Path) == project {
+			projectPath = p.Path
+		}
 	}
 
 	indexDB := filepath.Join(config.GreenForgeHome(), "index", project+".db")
@@ -268,7 +305,43 @@ func runQuery(question, project string) error {
 		return nil
 	}
 
-	// Full-text search
+	// Semantic search: embedding similarity fused with full-text rank
+	router := model.NewRouter(cfg)
+	if auditor, err := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db")); err == nil {
+		defer auditor.Close()
+		router.SetAuditor(auditor)
+	}
+	if ledger := openUsageLedger(cfg); ledger != nil {
+		defer ledger.Close()
+		router.SetUsageLedger(ledger)
+	}
+	idx.SetEmbedder(router)
+	ctx := rbac.WithIdentity(context.Background(), localIdentity(cfg))
+	if projectPath != "" {
+		ctx = model.WithProject(ctx, projectPath)
+	}
+	matches, err := idx.SemanticSearch(ctx, question, k)
+	if err != nil {
+		return err
+	}
+	if len(matches) > 0 {
+		fmt.Printf("Results for \"%s\" (%d):\n\n", question, len(matches))
+		for _, m := range matches {
+			match := "text"
+			if m.Similarity > 0 {
+				match = fmt.Sprintf("similarity %.2f", m.Similarity)
+				if m.TextMatch {
+					match += " + text"
+				}
+			}
+			fmt.Printf("  📄 %s (%s)\n", m.Name, m.Kind)
+			fmt.Printf("     File: %s:%d  [%s]\n", m.File, m.Line, match)
+			fmt.Println()
+		}
+		return nil
+	}
+
+	// Older indexes without chunks: class-level full-text search
 	results, err := idx.Search(question)
 	if err != nil {
 		return err
@@ -293,6 +366,8 @@ func runQuery(question, project string) error {
 }
 
 func runIndex(projectPath string, incremental bool) error {
+	cfg := loadConfig()
+
 	// Resolve absolute path
 	absPath, err := filepath.Abs(projectPath)
 	if err != nil {
@@ -315,7 +390,18 @@ func runIndex(projectPath string, incremental bool) error {
 	fmt.Printf("Index database:   %s\n", indexDB)
 	fmt.Println()
 
-	ctx := context.Background()
+	router := model.NewRouter(cfg)
+	if auditor, err := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db")); err == nil {
+		defer auditor.Close()
+		router.SetAuditor(auditor)
+	}
+	if ledger := openUsageLedger(cfg); ledger != nil {
+		defer ledger.Close()
+		router.SetUsageLedger(ledger)
+	}
+	idx.SetEmbedder(router)
+	ctx := rbac.WithIdentity(context.Background(), localIdentity(cfg))
+	ctx = model.WithProject(ctx, absPath)
 
 	var stats *index.IndexStats
 	if incremental {
@@ -337,6 +423,10 @@ func runIndex(projectPath string, incremental bool) error {
 	fmt.Printf("  Build files:  %d\n", stats.BuildFiles)
 	fmt.Printf("  Config files: %d\n", stats.ConfigFiles)
 	fmt.Printf("  Build tool:   %s\n", stats.BuildTool)
+	fmt.Printf("  Embedded:     %d new chunks (%s)\n", stats.Embedded, router.EmbeddingModel())
+	if stats.EmbedError != nil {
+		fmt.Printf("  ⚠ Embeddings incomplete, search falls back to full text: %v\n", stats.EmbedError)
+	}
 
 	// Show summary
 	status, _ := idx.GetStats()
@@ -348,6 +438,7 @@ func runIndex(projectPath string, incremental bool) error {
 		fmt.Printf("  Kafka topics: %d\n", status.KafkaTopics)
 		fmt.Printf("  Spring beans: %d\n", status.SpringBeans)
 		fmt.Printf("  JPA entities: %d\n", status.Entities)
+		fmt.Printf("  Chunks:       %d (%d embedded)\n", status.Chunks, status.Embedded)
 	}
 
 	return nil
@@ -419,7 +510,10 @@ func runSessionNew(project string) error {
 
 func runSessionList() error {
 	cfg := loadConfig()
-	rbacEngine := rbac.NewEngine(rbac.DefaultRoles())
+	rbacEngine, _, err := loadRBACEngine(cfg)
+	if err != nil {
+		return err
+	}
 	auditor, _ := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db"))
 	if auditor != nil {
 		defer auditor.Close()
@@ -449,18 +543,45 @@ func runSessionClose(id string) error {
 	return nil
 }
 
-func runAuditList(limit int, user, tool string) error {
+// auditListOptions holds the `audit list` flags.
+type auditListOptions struct {
+	limit                       int
+	user, tool, project, action string
+	details                     []string
+	since, until                string
+	cursor                      int64
+}
+
+func runAuditList(opts auditListOptions) error {
+	filter := audit.QueryFilter{
+		Limit:        opts.limit,
+		Cursor:       opts.cursor,
+		User:         opts.user,
+		Tool:         opts.tool,
+		Project:      opts.project,
+		ActionPrefix: opts.action,
+	}
+	if err := parseTimeRange(opts.since, opts.until, &filter); err != nil {
+		return err
+	}
+	for _, kv := range opts.details {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid --detail %q: use key=value", kv)
+		}
+		if filter.Details == nil {
+			filter.Details = make(map[string]string)
+		}
+		filter.Details[k] = v
+	}
+
 	auditor, err := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db"))
 	if err != nil {
 		return err
 	}
 	defer auditor.Close()
 
-	events, err := auditor.Query(audit.QueryFilter{
-		Limit: limit,
-		User:  user,
-		Tool:  tool,
-	})
+	page, err := auditor.QueryPage(filter)
 	if err != nil {
 		return err
 	}
@@ -468,11 +589,95 @@ func runAuditList(limit int, user, tool string) error {
 	fmt.Printf("%-5s %-20s %-15s %-10s %-10s %s\n", "ID", "TIMESTAMP", "ACTION", "USER", "TOOL", "HASH")
 	fmt.Println(strings.Repeat("-", 90))
 
-	for _, e := range events {
+	for _, e := range page.Events {
 		fmt.Printf("%-5d %-20s %-15s %-10s %-10s %s\n",
 			e.ID, e.Timestamp.Format("2006-01-02 15:04:05"),
 			e.Action, e.User, e.Tool, e.Hash[:12]+"...")
 	}
+	if page.NextCursor > 0 {
+		fmt.Printf("\nMore events: --cursor %d\n", page.NextCursor)
+	}
+	return nil
+}
+
+func runAuditStats(since, until, project string) error {
+	filter := audit.QueryFilter{Project: project}
+	if err := parseTimeRange(since, until, &filter); err != nil {
+		return err
+	}
+
+	auditor, err := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db"))
+	if err != nil {
+		return err
+	}
+	defer auditor.Close()
+
+	stats, err := auditor.Stats(filter)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Audit stats (%d events", stats.TotalEvents)
+	if stats.Since != nil {
+		fmt.Printf(" since %s", stats.Since.Local().Format("2006-01-02 15:04"))
+	}
+	if stats.Until != nil {
+		fmt.Printf(" until %s", stats.Until.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Println(")")
+
+	fmt.Println("\nTool executions:")
+	if len(stats.ToolExecutions) == 0 {
+		fmt.Println("  (none)")
+	} else {
+		fmt.Printf("  %-12s %-15s %-20s %s\n", "DAY", "USER", "TOOL", "COUNT")
+		for _, u := range stats.ToolExecutions {
+			fmt.Printf("  %-12s %-15s %-20s %d\n", u.Day, u.User, u.Tool, u.Count)
+		}
+	}
+
+	fmt.Println("\nModel calls:")
+	if len(stats.ModelCalls) == 0 {
+		fmt.Println("  (none)")
+	} else {
+		fmt.Printf("  %-15s %-30s %s\n", "PROVIDER", "MODEL", "COUNT")
+		for _, u := range stats.ModelCalls {
+			provider := u.Provider
+			if provider == "" {
+				provider = "unknown"
+			}
+			fmt.Printf("  %-15s %-30s %d\n", provider, u.Model, u.Count)
+		}
+	}
+
+	fmt.Println("\nDenied actions:")
+	if len(stats.Denied) == 0 {
+		fmt.Println("  (none)")
+	} else {
+		fmt.Printf("  %-20s %-15s %s\n", "ACTION", "USER", "COUNT")
+		for _, u := range stats.Denied {
+			fmt.Printf("  ✗ %-18s %-15s %d\n", u.Action, u.User, u.Count)
+		}
+	}
+	return nil
+}
+
+// parseTimeRange sets the filter's Since and Until from flag values.
+func parseTimeRange(since, until string, filter *audit.QueryFilter) error {
+	if since != "" {
+		t, err := audit.ParseTime(since)
+		if err != nil {
+			return err
+		}
+		filter.Since = &t
+	}
+	if until != "" {
+		t, err := audit.ParseTime(until)
+		if err != nil {
+			return err
+		}
+		filter.Until = &t
+	}
 	return nil
 }
 
@@ -483,13 +688,616 @@ func runAuditVerify() error {
 	}
 	defer auditor.Close()
 
-	valid, lastID, err := auditor.VerifyChain()
+	if _, pub := loadCAKeys(); pub != nil {
+		auditor.SetVerifyKey(pub)
+	}
+
+	report, err := auditor.Verify()
+
+	if len(report.Checkpoints) > 0 {
+		fmt.Println("Checkpoints:")
+		for _, st := range report.Checkpoints {
+			cp := st.Checkpoint
+			mark := "✓"
+			status := "signature valid"
+			if !st.Valid {
+				mark = "✗"
+				status = st.Error
+			}
+			fmt.Printf("  %s #%-4d %s  pruned %d (total %d) through event %d  %s\n",
+				mark, cp.ID, cp.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+				cp.PrunedCount, cp.TotalPruned, cp.LastEventID, status)
+		}
+		fmt.Println()
+	}
+
+	if len(report.Batches) > 0 {
+		valid, pruned := 0, 0
+		for _, st := range report.Batches {
+			switch {
+			case !st.Valid:
+				b := st.Batch
+				fmt.Printf("  ✗ batch #%d (events %d-%d): %s\n", b.ID, b.FirstEventID, b.LastEventID, st.Error)
+			case st.Pruned:
+				pruned++
+			default:
+				valid++
+			}
+		}
+		fmt.Printf("Batches: %d signed Merkle roots, %d verified, %d pruned (signature only)\n\n",
+			len(report.Batches), valid, pruned)
+	}
+
+	if report.Anchor != nil {
+		fmt.Printf("Live chain: starts after checkpoint #%d (event %d)\n", report.Anchor.ID, report.Anchor.LastEventID)
+	} else {
+		fmt.Println("Live chain: starts at genesis")
+	}
+
+	if err != nil {
+		fmt.Printf("✗ Audit verification FAILED: %v\n", err)
+		return err
+	}
+	if report.Events == 0 {
+		fmt.Println("✓ Audit chain verified successfully (no live events)")
+		return nil
+	}
+	fmt.Printf("✓ Audit chain verified successfully (%d events, #%d-#%d)\n", report.Events, report.FirstID, report.LastID)
+	return nil
+}
+
+func runAuditProof(eventID int64) error {
+	auditor, err := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db"))
+	if err != nil {
+		return err
+	}
+	defer auditor.Close()
+
+	proof, err := auditor.Proof(eventID)
+	if err != nil {
+		return err
+	}
+	// The batch must be signed by our CA, not just by the key it embeds
+	_, pub := loadCAKeys()
+	if pub == nil {
+		return fmt.Errorf("no CA public key available to verify the proof")
+	}
+	ck, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported CA key type %s", pub.Type())
+	}
+	trusted, ok := ck.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("proof verification requires an Ed25519 CA key, got %s", pub.Type())
+	}
+	if err := audit.VerifyInclusionProof(*proof, trusted); err != nil {
+		return fmt.Errorf("proof for event %d does not verify: %w", eventID, err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(proof)
+}
+
+func runAuditExport(since, format, output string) error {
+	start, err := audit.ParseTime(since)
+	if err != nil {
+		return err
+	}
+
+	auditor, err := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db"))
+	if err != nil {
+		return err
+	}
+	defer auditor.Close()
+
+	audit.ProductVersion = version
+	w := os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := auditor.Export(w, start, format)
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		fmt.Printf("✓ Exported %d events since %s to %s\n", n, start.Format(time.RFC3339), output)
+	}
+	return nil
+}
+
+// loadCAKeys loads the GreenForge user CA key pair used to sign and verify
+// audit checkpoints. Either value may be nil if unavailable.
+func loadCAKeys() (ssh.Signer, ssh.PublicKey) {
+	caDir := filepath.Join(config.GreenForgeHome(), "ca")
+
+	var pub ssh.PublicKey
+	if data, err := os.ReadFile(filepath.Join(caDir, "user_ca.pub")); err == nil {
+		pub, _, _, _, _ = ssh.ParseAuthorizedKey(data)
+	}
+
+	var signer ssh.Signer
+	if data, err := os.ReadFile(filepath.Join(caDir, "user_ca")); err == nil {
+		if s, err := ssh.ParsePrivateKey(data); err == nil {
+			signer = s
+		} else {
+			log.Printf("Warning: cannot load CA signing key: %v", err)
+		}
+	}
+	if pub == nil && signer != nil {
+		pub = signer.PublicKey()
+	}
+	return signer, pub
+}
+
+// responseCachePath resolves the response cache file: explicit config, then the data dir.
+func responseCachePath(cfg *config.Config) string {
+	if cfg.AI.Cache.Path != "" {
+		return cfg.AI.Cache.Path
+	}
+	return filepath.Join(config.GreenForgeHome(), "cache.db")
+}
+
+// openResponseCache opens the model response cache if it is enabled.
+func openResponseCache(cfg *config.Config) *model.ResponseCache {
+	if !cfg.AI.Cache.Enabled {
+		return nil
+	}
+	cache, err := model.OpenResponseCache(responseCachePath(cfg), cfg.AI.Cache)
+	if err != nil {
+		log.Printf("Warning: response cache unavailable: %v", err)
+		return nil
+	}
+	return cache
+}
+
+func runCacheClear() error {
+	cfg := loadConfig()
+	path := responseCachePath(cfg)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Println("✓ Cache is empty")
+		return nil
+	}
+	cache, err := model.OpenResponseCache(path, cfg.AI.Cache)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	n, err := cache.Clear()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Removed %d cached responses\n", n)
+	return nil
+}
+
+func runCacheStats() error {
+	cfg := loadConfig()
+	path := responseCachePath(cfg)
+	if !cfg.AI.Cache.Enabled {
+		fmt.Println("Response cache is disabled (set [ai.cache] enabled = true)")
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	cache, err := model.OpenResponseCache(path, cfg.AI.Cache)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	st, err := cache.Stats()
+	if err != nil {
+		return err
+	}
+	ratio := 0.0
+	if lookups := st.Hits + st.Misses; lookups > 0 {
+		ratio = float64(st.Hits) / float64(lookups) * 100
+	}
+	fmt.Printf("Cache:     %s\n", path)
+	fmt.Printf("Entries:   %d (%.1f MB)\n", st.Entries, float64(st.Bytes)/(1<<20))
+	fmt.Printf("Hits:      %d (%.0f%% of lookups)\n", st.Hits, ratio)
+	fmt.Printf("Misses:    %d\n", st.Misses)
+	fmt.Printf("Bypassed:  %d (temperature > 0 or tools with side effects)\n", st.Bypassed)
+	return nil
+}
+
+// usageLedgerPath resolves the usage ledger file: explicit config, then the data dir.
+func usageLedgerPath(cfg *config.Config) string {
+	if cfg.AI.Usage.Path != "" {
+		return cfg.AI.Usage.Path
+	}
+	return filepath.Join(config.GreenForgeHome(), "usage.db")
+}
+
+// openUsageLedger opens the usage ledger if it is enabled.
+func openUsageLedger(cfg *config.Config) *model.UsageLedger {
+	if !cfg.AI.Usage.Enabled {
+		return nil
+	}
+	ledger, err := model.OpenUsageLedger(usageLedgerPath(cfg), cfg.AI.Usage)
+	if err != nil {
+		log.Printf("Warning: usage ledger unavailable: %v", err)
+		return nil
+	}
+	return ledger
+}
+
+func runUsage(since, until, by, user, project string) error {
+	cfg := loadConfig()
+	path := usageLedgerPath(cfg)
+	if !cfg.AI.Usage.Enabled {
+		fmt.Println("Usage ledger is disabled (set [ai.usage] enabled = true)")
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Println("No usage recorded yet")
+		return nil
+	}
+	ledger, err := model.OpenUsageLedger(path, cfg.AI.Usage)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	filter := model.UsageFilter{User: user, Project: project, GroupBy: by}
+	if since != "" {
+		t, err := audit.ParseTime(since)
+		if err != nil {
+			return err
+		}
+		filter.Since = &t
+	}
+	if until != "" {
+		t, err := audit.ParseTime(until)
+		if err != nil {
+			return err
+		}
+		filter.Until = &t
+	}
+	rows, err := ledger.Report(filter)
+	if err != nil {
+		return err
+	}
+	currency := ledger.Currency()
+	if len(rows) == 0 {
+		fmt.Println("No model calls in this period")
+	} else {
+		fmt.Printf("%-40s %8s %12s %12s %12s %12s %10s\n", strings.ToUpper(by), "CALLS", "INPUT", "OUTPUT", "CACHE READ", "COST "+currency, "SAVED")
+		var total model.UsageRow
+		for _, row := range rows {
+			group := row.Group
+			if group == "" {
+				group = "(none)"
+			}
+			fmt.Printf("%-40s %8d %12d %12d %12d %12.2f %10.2f\n", group, row.Calls, row.InputTokens, row.OutputTokens, row.CacheRead, row.Cost, row.Saved)
+			total.Calls += row.Calls
+			total.InputTokens += row.InputTokens
+			total.OutputTokens += row.OutputTokens
+			total.CacheRead += row.CacheRead
+			total.Cost += row.Cost
+			total.Saved += row.Saved
+		}
+		fmt.Printf("%-40s %8d %12d %12d %12d %12.2f %10.2f\n", "TOTAL", total.Calls, total.InputTokens, total.OutputTokens, total.CacheRead, total.Cost, total.Saved)
+	}
+
+	budgets, err := ledger.Budgets()
 	if err != nil {
-		fmt.Printf("✗ Audit chain verification FAILED at event %d: %v\n", lastID, err)
 		return err
 	}
-	if valid {
-		fmt.Printf("✓ Audit chain verified successfully (%d events)\n", lastID)
+	if len(budgets) > 0 {
+		fmt.Println()
+		fmt.Println("Budgets:")
+	}
+	for _, b := range budgets {
+		mark := "✓"
+		switch b.State {
+		case "warning":
+			mark = "!"
+		case "exceeded":
+			mark = "✗"
+		}
+		fmt.Printf("  %s %-7s %-8s %-30s %10.2f / %.2f %s\n", mark, b.Period, b.Scope, b.Name, b.Spent, b.Limit, currency)
+	}
+	return nil
+}
+
+func runFirewallTest(path string, show bool) error {
+	cfg := loadConfig()
+	fw, err := model.NewFirewallFromConfig(cfg.AI.Firewall)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text := string(data)
+
+	findings := fw.Scan(text)
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Line < findings[j].Line })
+	redacted := 0
+	for _, fd := range findings {
+		mark := "✓ kept    "
+		if fd.Redacted {
+			mark = "✗ redacted"
+			redacted++
+		}
+		fmt.Printf("%s  line %-4d %-24s %-14s entropy %.2f  %s\n",
+			mark, fd.Line, fd.Rule, maskValue(fd.Secret), fd.Entropy, fd.Reason)
+	}
+	if redacted == 0 {
+		fmt.Println("✓ Nothing would be redacted")
+	} else {
+		fmt.Printf("\n%d value(s) would be redacted\n", redacted)
+	}
+
+	if show {
+		fmt.Println(strings.Repeat("━", 50))
+		fmt.Println(fw.ScrubText(text))
+	}
+	return nil
+}
+
+// maskValue shows only the start and length of a detected secret.
+func maskValue(s string) string {
+	r := []rune(s)
+	if len(r) <= 4 {
+		return strings.Repeat("•", len(r))
+	}
+	return fmt.Sprintf("%s…(%d)", string(r[:3]), len(r))
+}
+
+// rbacPolicyPath resolves the RBAC policy file: explicit config, data dir, then the Docker image default.
+func rbacPolicyPath(cfg *config.Config) string {
+	if cfg.RBAC.PolicyFile != "" {
+		return cfg.RBAC.PolicyFile
+	}
+	for _, p := range []string{
+		filepath.Join(config.GreenForgeHome(), "rbac.yaml"),
+		"/etc/greenforge/configs/rbac.yaml",
+	} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// newToolRegistry loads the agent's tools. Every call is checked against
+// the RBAC policy for the caller identity in its context.
+func newToolRegistry(router *model.Router, auditor *audit.Logger, engine *rbac.Engine) *tools.Registry {
+	registry := tools.NewRegistry(nil, nil, auditor)
+	registry.SetRBAC(engine)
+	for _, dir := range []string{filepath.Join(config.GreenForgeHome(), "tools"), "/etc/greenforge/tools"} {
+		if err := registry.LoadFromDir(dir); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	registry.RegisterBuiltin("code_search",
+		`Semantic search over the index of the session's project: finds classes, methods and config by meaning, not only by name. Input: {"query": "where do we validate IBANs", "k": 8}`,
+		"index", []string{"index:read"}, codeSearchTool(router))
+	return registry
+}
+
+// localIdentity is the caller of a CLI session: the OS user with
+// rbac.local_role, since local sessions have no certificate.
+func localIdentity(cfg *config.Config) rbac.Identity {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return rbac.Identity{User: name, Role: cfg.RBAC.LocalRole}
+}
+
+// codeSearchTool runs a semantic search in the index of the session's
+// project for the agent's code_search tool. Each hit is reported as a source
+// so data classification can withhold it.
+func codeSearchTool(router *model.Router) tools.BuiltinHandler {
+	return func(ctx context.Context, input map[string]interface{}) (agent.ToolResult, error) {
+		query, _ := input["query"].(string)
+		if strings.TrimSpace(query) == "" {
+			return agent.ToolResult{Error: "query is required"}, nil
+		}
+		k := 8
+		if n, ok := input["k"].(float64); ok && n > 0 {
+			k = int(n)
+		}
+		project := rbac.AttributesFromContext(ctx).Project
+		if project == "" {
+			return agent.ToolResult{Error: "code_search needs a session project"}, nil
+		}
+
+		dbPath := filepath.Join(config.GreenForgeHome(), "index", filepath.Base(project)+".db")
+		if _, err := os.Stat(dbPath); err != nil {
+			return agent.ToolResult{Output: fmt.Sprintf("No matches. %s is not indexed (greenforge index %s).", filepath.Base(project), project)}, nil
+		}
+		idx, err := index.NewEngine(dbPath)
+		if err != nil {
+			return agent.ToolResult{Error: err.Error()}, nil
+		}
+		defer idx.Close()
+		idx.SetEmbedder(router)
+		results, err := idx.SemanticSearch(ctx, query, k)
+		if err != nil {
+			return agent.ToolResult{Error: err.Error()}, nil
+		}
+		if len(results) == 0 {
+			return agent.ToolResult{Output: "No matches."}, nil
+		}
+		var sb strings.Builder
+		sources := make([]model.Source, 0, len(results))
+		for _, r := range results {
+			hit := fmt.Sprintf("--- %s %s (%s:%d)\n%s\n", r.Kind, r.Name, r.File, r.Line, r.Text)
+			sb.WriteString(hit)
+			sources = append(sources, model.Source{Project: project, Path: r.File, Text: hit})
+		}
+		return agent.ToolResult{Output: sb.String(), Sources: sources}, nil
+	}
+}
+
+// loadRBACEngine loads the RBAC policy file, or the built-in roles if there
+// is none. Returns the engine and the policy path it was loaded from ("" for
+// built-ins). A policy file that does not load is an error: falling back to
+// the built-in roles could grant what the policy denies.
+func loadRBACEngine(cfg *config.Config) (*rbac.Engine, string, error) {
+	path := rbacPolicyPath(cfg)
+	if path == "" {
+		return rbac.NewEngine(rbac.DefaultRoles()), "", nil
+	}
+	engine, err := rbac.NewEngineFromFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return engine, path, nil
+}
+
+func runRBACCheck(role, perm, project, branch, device string) error {
+	cfg := loadConfig()
+	engine, path, err := loadRBACEngine(cfg)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		path = "built-in roles"
+	}
+
+	if err := rbac.ValidatePermission(perm); err != nil {
+		return err
+	}
+
+	attrs := rbac.Attributes{
+		Project:  project,
+		Branch:   branch,
+		CertType: rbac.CertTypeUser,
+		Device:   device,
+		Time:     time.Now(),
+	}
+	if device != "" {
+		attrs.CertType = rbac.CertTypeDevice
+	}
+	ctx := rbac.WithAttributes(context.Background(), attrs)
+
+	d := engine.Explain(ctx, role, rbac.ParsePermission(perm))
+	fmt.Printf("Policy:     %s\n", path)
+	fmt.Printf("Role:       %s\n", d.Role)
+	fmt.Printf("Permission: %s\n", d.Permission)
+	if project != "" || branch != "" || device != "" {
+		fmt.Printf("Context:    project=%q branch=%q cert=%s\n", project, branch, attrs.CertType)
+	}
+	if d.Rule != "" {
+		fmt.Printf("Rule:       %s %q (from role %s)\n", d.Effect, d.Rule, d.Source)
+	} else {
+		fmt.Println("Rule:       no matching rule")
+	}
+	if d.Condition != "" {
+		fmt.Printf("Condition:  %s\n", d.Condition)
+	}
+
+	if !d.Allowed {
+		fmt.Printf("✗ DENIED: %s\n", d.Reason)
+		return fmt.Errorf("permission denied")
+	}
+	fmt.Printf("✓ ALLOWED: %s\n", d.Reason)
+	return nil
+}
+
+func runPolicyExplain(project, task, modelID string) error {
+	cfg := loadConfig()
+	if strings.HasPrefix(project, ".") {
+		if abs, err := filepath.Abs(project); err == nil {
+			project = abs
+		}
+	}
+	router := model.NewRouter(cfg)
+	exp := router.Explain(project, task, modelID)
+
+	fmt.Printf("Project:  %s\n", exp.Project)
+	fmt.Printf("Task:     %s\n", exp.Task)
+	if exp.Requested != "" {
+		fmt.Printf("Request:  %s\n", exp.Requested)
+	}
+	if exp.Policy != nil {
+		fmt.Printf("Policy:   %s → [%s]\n", exp.Policy.ProjectPattern, strings.Join(exp.Policy.AllowedProviders, ", "))
+		if exp.Policy.Reason != "" {
+			fmt.Printf("Reason:   %s\n", exp.Policy.Reason)
+		}
+	} else {
+		fmt.Println("Policy:   none matches (default model and fallbacks apply)")
+	}
+
+	fmt.Println("\nProviders:")
+	for _, c := range exp.Candidates {
+		mark := "✓"
+		if !c.Allowed || !c.Configured || !c.Available {
+			mark = "✗"
+		}
+		note := "allowed"
+		if c.Note != "" {
+			note = c.Note
+		}
+		if c.Circuit != "" && c.Circuit != "closed" && c.Note != "circuit open" {
+			note += ", circuit " + c.Circuit
+		}
+		if c.Local {
+			note += ", local"
+		}
+		if c.Clearance != "" {
+			note += ", cleared for " + c.Clearance
+		}
+		fmt.Printf("  %s %-12s %s\n", mark, c.Provider, note)
+	}
+	fmt.Println()
+
+	if exp.Error != "" {
+		fmt.Printf("✗ REFUSED: %s\n", exp.Error)
+		return fmt.Errorf("no provider qualifies")
+	}
+	fallback := ""
+	if exp.Fallback {
+		fallback = " (fallback)"
+	}
+	fmt.Printf("✓ ROUTED to %s%s: %s\n", exp.Selected, fallback, exp.Reason)
+	if len(exp.Chain) > 1 {
+		fmt.Printf("  on failure: %s\n", strings.Join(exp.Chain[1:], " → "))
+	}
+	return nil
+}
+
+func runRBACRoles() error {
+	cfg := loadConfig()
+	engine, path, err := loadRBACEngine(cfg)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		path = "built-in roles"
+	}
+
+	roles := engine.ListRoles()
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+
+	fmt.Printf("Policy: %s\n\n", path)
+	fmt.Printf("%-15s %-12s %s\n", "ROLE", "EXTENDS", "PERMISSIONS")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, r := range roles {
+		perms := strings.Join(r.Permissions, ", ")
+		if len(r.Deny) > 0 {
+			perms += " | deny: " + strings.Join(r.Deny, ", ")
+		}
+		if len(r.Grants) > 0 {
+			perms += fmt.Sprintf(" | %d conditional grant(s)", len(r.Grants))
+		}
+		if len(r.Projects) > 0 {
+			perms += " | projects: " + strings.Join(r.Projects, ", ")
+		}
+		fmt.Printf("%-15s %-12s %s\n", r.Name, r.Extends, perms)
 	}
 	return nil
 }
@@ -636,7 +1444,12 @@ func printModels(router *model.Router) {
 			status = "\033[31m○\033[0m"
 		}
 
-		fmt.Printf("  %s%s %d) %s\n", marker, status, i+1, m.ID)
+		window := ""
+		if m.ContextWindow > 0 {
+			window = fmt.Sprintf("  \033[2m%dk ctx\033[0m", m.ContextWindow/1000)
+		}
+
+		fmt.Printf("  %s%s %d) %s%s\n", marker, status, i+1, m.ID, window)
 	}
 
 	fmt.Println("  " + strings.Repeat("─", 56))
@@ -703,25 +1516,43 @@ func runServe() error {
 	fmt.Println()
 
 	// Start gateway (blocks until signal)
-	StartGateway(cfg)
-	return nil
+	return StartGateway(cfg)
 }
 
-// StartGateway starts the background gateway server.
-func StartGateway(cfg *config.Config) {
-	rbacEngine := rbac.NewEngine(rbac.DefaultRoles())
+// StartGateway starts the background gateway server. It refuses to start
+// without a valid RBAC policy or audit log.
+func StartGateway(cfg *config.Config) error {
+	rbacEngine, policyPath, err := loadRBACEngine(cfg)
+	if err != nil {
+		return err
+	}
 	auditor, err := audit.NewLogger(filepath.Join(config.GreenForgeHome(), "audit.db"))
 	if err != nil {
-		log.Printf("Warning: audit logger unavailable: %v", err)
-		return
+		return fmt.Errorf("audit logger unavailable: %w", err)
 	}
 
 	// Create model router for AI completions
 	router := model.NewRouter(cfg)
+	router.SetAuditor(auditor)
+	if cache := openResponseCache(cfg); cache != nil {
+		router.SetCache(cache)
+	}
+	ledger := openUsageLedger(cfg)
+	if ledger != nil {
+		router.SetUsageLedger(ledger)
+	}
 
 	server := gateway.NewServer(cfg, rbacEngine, auditor)
 	server.SetRouter(router)
-	_ = tools.NewRegistry(nil, nil, auditor) // Register tools
+	server.SetUsageLedger(ledger)
+	signer, caPub := loadCAKeys()
+	server.SetUserCA(caPub) // verifies client certificates
+	toolRegistry := newToolRegistry(router, auditor, rbacEngine)
+	server.SetAgentFactory(func(cfg *config.Config) *agent.Runtime {
+		rt := agent.NewRuntime(cfg, router)
+		rt.SetToolExecutor(toolRegistry)
+		return rt
+	})
 
 	// Set up Web UI with embedded static files and AI router
 	webUI := gateway.NewWebUIServer(server, router, webFS)
@@ -729,6 +1560,49 @@ func StartGateway(cfg *config.Config) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// Hot-reload RBAC policy on file changes
+	if policyPath != "" {
+		log.Printf("RBAC policy loaded from %s", policyPath)
+		go rbacEngine.Watch(ctx, policyPath, cfg.RBAC.ReloadInterval.Duration)
+	}
+
+	// Seal audit events under signed Merkle roots and prune old events
+	// behind CA-signed checkpoints
+	if signer != nil {
+		auditor.SetSigner(signer)
+		auditor.SetVerifyKey(caPub)
+		if signer.PublicKey().Type() == ssh.KeyAlgoED25519 {
+			go auditor.RunBatcher(ctx, cfg.Audit.BatchInterval.Duration)
+		} else {
+			log.Printf("Warning: audit batch signing disabled, CA key is %s (Ed25519 required)", signer.PublicKey().Type())
+		}
+		if cfg.Audit.RetainDays > 0 {
+			go auditor.RunRetention(ctx, cfg.Audit.RetainDays, cfg.Audit.RetentionInterval.Duration)
+		}
+	} else {
+		log.Printf("Warning: audit retention and batch signing disabled, no CA signing key in %s", filepath.Join(config.GreenForgeHome(), "ca"))
+	}
+
+	// Stream audit events to the SIEM
+	if fwd := cfg.Audit.Forward; fwd.Enabled {
+		audit.ProductVersion = version
+		forwarder, err := audit.NewForwarder(auditor, audit.ForwardConfig{
+			Sink:     fwd.Sink,
+			Network:  fwd.Network,
+			Address:  fwd.Address,
+			Path:     fwd.Path,
+			Format:   fwd.Format,
+			Facility: fwd.Facility,
+			SDID:     fwd.SDID,
+			Interval: fwd.Interval.Duration,
+		})
+		if err != nil {
+			log.Printf("Warning: audit forwarding disabled: %v", err)
+		} else {
+			go forwarder.Run(ctx)
+		}
+	}
+
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -736,7 +1610,5 @@ func StartGateway(cfg *config.Config) {
 		cancel()
 	}()
 
-	if err := server.Start(ctx); err != nil {
-		log.Printf("Gateway error: %v", err)
-	}
+	return server.Start(ctx)
 }
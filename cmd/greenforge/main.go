@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/greencode/greenforge/internal/config"
 	"github.com/spf13/cobra"
@@ -55,6 +57,11 @@ a pomáhá z terminálu i z mobilu.`,
 		newAuthCmd(),
 		newSessionCmd(),
 		newAuditCmd(),
+		newRBACCmd(),
+		newPolicyCmd(),
+		newCacheCmd(),
+		newUsageCmd(),
+		newFirewallCmd(),
 		newConfigCmd(),
 		newDigestCmd(),
 		newVersionCmd(),
@@ -102,10 +109,12 @@ func newQueryCmd() *cobra.Command {
 		Args:  cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			project, _ := cmd.Flags().GetString("project")
-			return runQuery(args[0], project)
+			k, _ := cmd.Flags().GetInt("limit")
+			return runQuery(strings.Join(args, " "), project, k)
 		},
 	}
 	cmd.Flags().StringP("project", "p", "", "project to query")
+	cmd.Flags().IntP("limit", "k", 10, "number of semantic search results")
 	return cmd
 }
 
@@ -161,8 +170,8 @@ func newAuthCmd() *cobra.Command {
 	deviceAddCmd.MarkFlagRequired("name")
 
 	deviceListCmd := &cobra.Command{
-		Use:   "list",
-		Short: "List registered devices",
+		Use:     "list",
+		Short:   "List registered devices",
 		Aliases: []string{"ls"},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runDeviceList()
@@ -210,8 +219,8 @@ func newSessionCmd() *cobra.Command {
 	newCmd.Flags().StringP("project", "p", "", "project for session")
 
 	listCmd := &cobra.Command{
-		Use:   "list",
-		Short: "List active sessions",
+		Use:     "list",
+		Short:   "List active sessions",
 		Aliases: []string{"ls"},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runSessionList()
@@ -256,19 +265,46 @@ func newAuditCmd() *cobra.Command {
 	}
 
 	listCmd := &cobra.Command{
-		Use:   "list",
-		Short: "List audit events",
+		Use:     "list",
+		Short:   "List audit events",
 		Aliases: []string{"ls"},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			limit, _ := cmd.Flags().GetInt("limit")
-			user, _ := cmd.Flags().GetString("user")
-			tool, _ := cmd.Flags().GetString("tool")
-			return runAuditList(limit, user, tool)
+			var opts auditListOptions
+			opts.limit, _ = cmd.Flags().GetInt("limit")
+			opts.user, _ = cmd.Flags().GetString("user")
+			opts.tool, _ = cmd.Flags().GetString("tool")
+			opts.project, _ = cmd.Flags().GetString("project")
+			opts.action, _ = cmd.Flags().GetString("action")
+			opts.details, _ = cmd.Flags().GetStringArray("detail")
+			opts.since, _ = cmd.Flags().GetString("since")
+			opts.until, _ = cmd.Flags().GetString("until")
+			opts.cursor, _ = cmd.Flags().GetInt64("cursor")
+			return runAuditList(opts)
 		},
 	}
 	listCmd.Flags().IntP("limit", "n", 50, "max entries to show")
 	listCmd.Flags().String("user", "", "filter by user")
 	listCmd.Flags().String("tool", "", "filter by tool")
+	listCmd.Flags().StringP("project", "p", "", "filter by project")
+	listCmd.Flags().String("action", "", "filter by action prefix (e.g. tool., rbac.denied)")
+	listCmd.Flags().StringArray("detail", nil, "filter by details key=value (repeatable)")
+	listCmd.Flags().String("since", "", "start time: duration (24h, 7d), date (2006-01-02) or RFC 3339")
+	listCmd.Flags().String("until", "", "end time, same formats as --since")
+	listCmd.Flags().Int64("cursor", 0, "continue from a previous page (events older than this ID)")
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show tool executions, model calls and denied actions",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			since, _ := cmd.Flags().GetString("since")
+			until, _ := cmd.Flags().GetString("until")
+			project, _ := cmd.Flags().GetString("project")
+			return runAuditStats(since, until, project)
+		},
+	}
+	statsCmd.Flags().String("since", "30d", "start time: duration (24h, 7d), date (2006-01-02) or RFC 3339")
+	statsCmd.Flags().String("until", "", "end time, same formats as --since")
+	statsCmd.Flags().StringP("project", "p", "", "only events of this project")
 
 	verifyCmd := &cobra.Command{
 		Use:   "verify",
@@ -278,7 +314,168 @@ func newAuditCmd() *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(listCmd, verifyCmd)
+	proofCmd := &cobra.Command{
+		Use:   "proof [event-id]",
+		Short: "Print a Merkle inclusion proof for an audit event (JSON)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid event id %q", args[0])
+			}
+			return runAuditProof(id)
+		},
+	}
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export audit events for a SIEM (jsonl, cef, leef)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			since, _ := cmd.Flags().GetString("since")
+			format, _ := cmd.Flags().GetString("format")
+			output, _ := cmd.Flags().GetString("output")
+			return runAuditExport(since, format, output)
+		},
+	}
+	exportCmd.Flags().String("since", "24h", "start time: duration (24h, 7d), date (2006-01-02) or RFC 3339")
+	exportCmd.Flags().String("format", "jsonl", "output format: jsonl, cef, leef")
+	exportCmd.Flags().StringP("output", "o", "", "output file (default: stdout)")
+
+	cmd.AddCommand(listCmd, statsCmd, verifyCmd, proofCmd, exportCmd)
+	return cmd
+}
+
+// newRBACCmd creates the `greenforge rbac` command
+func newRBACCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rbac",
+		Short: "Inspect RBAC roles and permissions",
+	}
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Explain whether a role has a permission",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			role, _ := cmd.Flags().GetString("role")
+			perm, _ := cmd.Flags().GetString("perm")
+			project, _ := cmd.Flags().GetString("project")
+			branch, _ := cmd.Flags().GetString("branch")
+			device, _ := cmd.Flags().GetString("device")
+			return runRBACCheck(role, perm, project, branch, device)
+		},
+	}
+	checkCmd.Flags().String("role", "", "role name (e.g. developer)")
+	checkCmd.Flags().String("perm", "", "permission (e.g. db:write)")
+	checkCmd.Flags().StringP("project", "p", "", "project path for project-scoped grants")
+	checkCmd.Flags().String("branch", "", "VCS branch for branch-scoped grants")
+	checkCmd.Flags().String("device", "", "evaluate as a device certificate with this name")
+	checkCmd.MarkFlagRequired("role")
+	checkCmd.MarkFlagRequired("perm")
+
+	rolesCmd := &cobra.Command{
+		Use:   "roles",
+		Short: "List roles from the active RBAC policy",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRBACRoles()
+		},
+	}
+
+	cmd.AddCommand(checkCmd, rolesCmd)
+	return cmd
+}
+
+// newPolicyCmd creates the `greenforge policy` command
+func newPolicyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Inspect AI model policies",
+	}
+
+	explainCmd := &cobra.Command{
+		Use:   "explain [project]",
+		Short: "Explain which model provider a project may use and why",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			modelID, _ := cmd.Flags().GetString("model")
+			task, _ := cmd.Flags().GetString("task")
+			return runPolicyExplain(args[0], task, modelID)
+		},
+	}
+	explainCmd.Flags().StringP("model", "m", "", "check an explicit model override (provider/model)")
+	explainCmd.Flags().StringP("task", "t", "chat", "task kind: chat, summarize, classify or code_edit")
+
+	cmd.AddCommand(explainCmd)
+	return cmd
+}
+
+// newCacheCmd creates the `greenforge cache` command
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the model response cache",
+	}
+
+	clearCmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Remove all cached model responses",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheClear()
+		},
+	}
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show cache hits, misses and size",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheStats()
+		},
+	}
+
+	cmd.AddCommand(clearCmd, statsCmd)
+	return cmd
+}
+
+// newUsageCmd creates the `greenforge usage` command
+func newUsageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Show model token usage, cost and budgets",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			since, _ := cmd.Flags().GetString("since")
+			until, _ := cmd.Flags().GetString("until")
+			by, _ := cmd.Flags().GetString("by")
+			user, _ := cmd.Flags().GetString("user")
+			project, _ := cmd.Flags().GetString("project")
+			return runUsage(since, until, by, user, project)
+		},
+	}
+	cmd.Flags().String("since", "30d", "start of the period (24h, 7d, a date or RFC 3339)")
+	cmd.Flags().String("until", "", "end of the period")
+	cmd.Flags().String("by", "project", "group by user, project, provider, model, session or day")
+	cmd.Flags().String("user", "", "only calls of this user")
+	cmd.Flags().String("project", "", "only calls for this project")
+	return cmd
+}
+
+// newFirewallCmd creates the `greenforge firewall` command
+func newFirewallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "Inspect the AI model firewall",
+	}
+
+	testCmd := &cobra.Command{
+		Use:   "test [file]",
+		Short: "Show what the firewall would redact in a file and why",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			show, _ := cmd.Flags().GetBool("print")
+			return runFirewallTest(args[0], show)
+		},
+	}
+	testCmd.Flags().Bool("print", false, "print the file as it would be sent to the model")
+
+	cmd.AddCommand(testCmd)
 	return cmd
 }
 
@@ -9,9 +9,11 @@ import (
 
 // Memory stores conversation history per session.
 type Memory struct {
-	mu       sync.RWMutex
-	sessions map[string][]Message
-	maxSize  int // max messages per session before summarization
+	mu        sync.RWMutex
+	sessions  map[string][]Message
+	summaries map[string]string         // summary of the messages compacted away
+	sources   map[string][]model.Source // files the summary was written from
+	maxSize   int                       // max messages per session before summarization
 }
 
 // Message represents a conversation message.
@@ -22,13 +24,17 @@ type Message struct {
 	ToolCalls  []model.ToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string           `json:"tool_call_id,omitempty"`
 	ToolName   string           `json:"tool_name,omitempty"`
+	Sources    []model.Source   `json:"-"` // files a tool result was read from
+	Images     []model.Image    `json:"-"` // image parts of a user message
 }
 
 // NewMemory creates a new session memory store.
 func NewMemory() *Memory {
 	return &Memory{
-		sessions: make(map[string][]Message),
-		maxSize:  200,
+		sessions:  make(map[string][]Message),
+		summaries: make(map[string]string),
+		sources:   make(map[string][]model.Source),
+		maxSize:   200,
 	}
 }
 
@@ -61,11 +67,51 @@ func (m *Memory) Get(sessionID string) []Message {
 	return result
 }
 
+// Compact replaces the first n messages of a session by a summary, written
+// from content of the given source files.
+func (m *Memory) Compact(sessionID string, n int, summary string, sources []model.Source) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs := m.sessions[sessionID]
+	if n > len(msgs) {
+		n = len(msgs)
+	}
+	m.sessions[sessionID] = append([]Message(nil), msgs[n:]...)
+	m.summaries[sessionID] = summary
+	m.sources[sessionID] = sources
+}
+
+// DropImages removes the image parts of a session's messages and keeps
+// their text.
+func (m *Memory) DropImages(sessionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.sessions[sessionID] {
+		m.sessions[sessionID][i].Images = nil
+	}
+}
+
+// Summary returns the summary of a session's compacted messages, or "".
+func (m *Memory) Summary(sessionID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.summaries[sessionID]
+}
+
+// SummarySources returns the files the summary of a session was written from.
+func (m *Memory) SummarySources(sessionID string) []model.Source {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sources[sessionID]
+}
+
 // Clear removes all messages for a session.
 func (m *Memory) Clear(sessionID string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	delete(m.sessions, sessionID)
+	delete(m.summaries, sessionID)
+	delete(m.sources, sessionID)
 }
 
 // SessionCount returns the number of active sessions.
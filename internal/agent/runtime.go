@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/greencode/greenforge/internal/config"
@@ -31,6 +33,7 @@ type ToolResult struct {
 	Error    string
 	Duration time.Duration
 	Metadata map[string]string
+	Sources  []model.Source // files parts of Output came from, for tools that know
 }
 
 // ToolInfo describes an available tool.
@@ -38,16 +41,28 @@ type ToolInfo struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Category    string `json:"category"`
+	ReadOnly    bool   `json:"read_only"` // calls have no side effects
 }
 
 // Callbacks for streaming responses back to the caller.
 type Callbacks struct {
-	OnThinking  func(text string)
-	OnResponse  func(text string)
-	OnToolCall  func(toolName string, input map[string]interface{})
+	OnThinking   func(text string)
+	OnResponse   func(text string)
+	OnToolCall   func(toolName string, input map[string]interface{})
 	OnToolResult func(toolName string, result ToolResult)
-	OnError     func(err error)
-	OnDone      func()
+	OnError      func(err error)
+	OnWarning    func(warning string)
+	OnDone       func()
+}
+
+// Turn is a user message with what is sent along with it for this turn
+// only: images and context retrieved for the message, e.g. index search
+// results.
+type Turn struct {
+	Message    string
+	Images     []model.Image
+	Context    []model.ContextBlock
+	WorkingDir string
 }
 
 // NewRuntime creates a new agent runtime.
@@ -71,11 +86,23 @@ func (r *Runtime) SetCallbacks(cb Callbacks) {
 
 // ProcessMessage runs one iteration of the agent loop for a user message.
 func (r *Runtime) ProcessMessage(ctx context.Context, sessionID string, message string) error {
+	return r.ProcessTurn(ctx, sessionID, Turn{Message: message})
+}
+
+// ProcessTurn runs one iteration of the agent loop for a user message with
+// its images and context. Tool calls run with ctx, which carries the
+// caller identity the tool executor authorizes them for.
+func (r *Runtime) ProcessTurn(ctx context.Context, sessionID string, turn Turn) error {
+	// The session keeps secret placeholders consistent across calls
+	ctx = model.WithSession(ctx, sessionID)
+	r.summarizeHistory(ctx, sessionID)
+
 	// Add user message to memory
 	r.memory.Add(sessionID, Message{
 		Role:      "user",
-		Content:   message,
+		Content:   turn.Message,
 		Timestamp: time.Now(),
+		Images:    turn.Images,
 	})
 
 	// Build context for the model
@@ -99,7 +126,10 @@ func (r *Runtime) ProcessMessage(ctx context.Context, sessionID string, message
 			Messages:    promptCtx,
 			Tools:       r.getToolDefs(),
 			MaxTokens:   4096,
-			Temperature: 0.1,
+			Temperature: model.Temperature(0.1),
+			Task:        model.TaskCodeEdit,
+			Context:     append(r.summaryContext(sessionID), turn.Context...),
+			WorkingDir:  turn.WorkingDir,
 		})
 		if err != nil {
 			if r.callbacks.OnError != nil {
@@ -107,6 +137,11 @@ func (r *Runtime) ProcessMessage(ctx context.Context, sessionID string, message
 			}
 			return fmt.Errorf("model completion error: %w", err)
 		}
+		if r.callbacks.OnWarning != nil {
+			for _, w := range resp.Warnings {
+				r.callbacks.OnWarning(w)
+			}
+		}
 
 		// Check if response contains tool calls
 		if len(resp.ToolCalls) == 0 {
@@ -141,7 +176,8 @@ func (r *Runtime) ProcessMessage(ctx context.Context, sessionID string, message
 
 			result, err := r.executeTool(ctx, tc)
 			if err != nil {
-				log.Printf("Tool execution error: %v", err)
+				msg, _ := r.router.Sanitize(ctx, err.Error())
+				log.Printf("Tool execution error: %s", msg)
 				result = ToolResult{Error: err.Error()}
 			}
 
@@ -149,17 +185,19 @@ func (r *Runtime) ProcessMessage(ctx context.Context, sessionID string, message
 				r.callbacks.OnToolResult(tc.Name, result)
 			}
 
-			// Add tool result to context
+			// Add tool result to context, with secrets replaced by the firewall
 			content := result.Output
 			if result.Error != "" {
 				content = fmt.Sprintf("Error: %s", result.Error)
 			}
+			content, _ = r.router.Sanitize(ctx, content)
 			r.memory.Add(sessionID, Message{
 				Role:       "tool",
 				Content:    content,
 				Timestamp:  time.Now(),
 				ToolCallID: tc.ID,
 				ToolName:   tc.Name,
+				Sources:    toolSources(tc, result, content),
 			})
 
 			promptCtx = r.buildContext(sessionID)
@@ -190,12 +228,128 @@ func (r *Runtime) buildContext(sessionID string) []model.Message {
 		if msg.ToolCallID != "" {
 			m.ToolCallID = msg.ToolCallID
 		}
+		m.Sources = msg.Sources
+		m.Images = msg.Images
 		messages = append(messages, m)
 	}
 
 	return messages
 }
 
+// prioritySummary keeps the summary of the earlier conversation in the
+// request longer than any context retrieved for the turn.
+const prioritySummary = 100
+
+// summaryContext returns the summary of the session's earlier conversation
+// as a context block. It carries the files the summary was written from,
+// so the router withholds it from providers not cleared for one of them.
+func (r *Runtime) summaryContext(sessionID string) []model.ContextBlock {
+	summary := r.memory.Summary(sessionID)
+	if summary == "" {
+		return nil
+	}
+	return []model.ContextBlock{{
+		Name:     "summary",
+		Text:     "\nSummary of the earlier conversation:\n" + summary + "\n",
+		Priority: prioritySummary,
+		Sources:  r.memory.SummarySources(sessionID),
+	}}
+}
+
+// History is summarized once a session holds summarizeAfter messages; the
+// turns among the last keepRecent messages stay verbatim.
+const (
+	summarizeAfter = 100
+	keepRecent     = 40
+)
+
+const summarizePrompt = `Summarize the conversation between a developer and a coding agent below for the agent's own memory.
+Keep decisions, requirements, file paths, class and method names, commands and open questions.
+Leave out pleasantries and tool output that is no longer needed. Answer with the summary only.`
+
+// summarizeHistory replaces the older messages of a long session by a
+// summary from the model the summarize task is routed to. If that fails the
+// history is kept and Memory trims it eventually.
+func (r *Runtime) summarizeHistory(ctx context.Context, sessionID string) {
+	history := r.memory.Get(sessionID)
+	if len(history) < summarizeAfter {
+		return
+	}
+	// Cut before a user message so tool results stay with their calls
+	cut := -1
+	for i := len(history) - keepRecent; i < len(history); i++ {
+		if history[i].Role == "user" && history[i].ToolCallID == "" {
+			cut = i
+			break
+		}
+	}
+	if cut <= 0 {
+		return
+	}
+
+	var sb strings.Builder
+	var sources []model.Source
+	if prev := r.memory.Summary(sessionID); prev != "" {
+		sb.WriteString("Summary so far:\n" + prev + "\n\n")
+		for _, src := range r.memory.SummarySources(sessionID) {
+			src.Text = prev
+			sources = append(sources, src)
+		}
+	}
+	for _, msg := range history[:cut] {
+		entry := fmt.Sprintf("%s: %s\n", msg.Role, msg.Content)
+		if msg.ToolName != "" {
+			entry = fmt.Sprintf("tool %s: %s\n", msg.ToolName, msg.Content)
+		}
+		for _, tc := range msg.ToolCalls {
+			entry += fmt.Sprintf("(called %s)\n", tc.Name)
+		}
+		sb.WriteString(entry)
+		// Only this message is withheld if its file is classified
+		for _, src := range msg.Sources {
+			if src.Text == "" {
+				src.Text = msg.Content
+			}
+			sources = append(sources, src)
+		}
+	}
+
+	resp, err := r.router.Complete(ctx, model.Request{
+		Messages: []model.Message{
+			{Role: "system", Content: summarizePrompt},
+			{Role: "user", Content: sb.String(), Sources: sources},
+		},
+		MaxTokens: 1024,
+		Task:      model.TaskSummarize,
+	})
+	if err != nil {
+		log.Printf("Warning: summarizing session %s: %v", sessionID, err)
+		return
+	}
+	r.memory.Compact(sessionID, cut, resp.Content, summarySources(sources))
+}
+
+// summarySources returns each file of sources once, for the whole summary:
+// it may paraphrase any part of it.
+func summarySources(sources []model.Source) []model.Source {
+	seen := make(map[model.Source]bool)
+	var files []model.Source
+	for _, src := range sources {
+		src.Text = ""
+		if !seen[src] {
+			seen[src] = true
+			files = append(files, src)
+		}
+	}
+	return files
+}
+
+// DropImages removes the images from a session's history, e.g. after no
+// model of the session accepted them, so its text still gets through.
+func (r *Runtime) DropImages(sessionID string) {
+	r.memory.DropImages(sessionID)
+}
+
 func (r *Runtime) buildSystemPrompt() string {
 	prompt := `You are GreenForge, a secure AI developer agent specialized for JVM teams.
 You help developers with Spring Boot, Kafka, Gradle/Maven projects.
@@ -237,11 +391,39 @@ func (r *Runtime) getToolDefs() []model.ToolDef {
 		defs = append(defs, model.ToolDef{
 			Name:        tool.Name,
 			Description: tool.Description,
+			ReadOnly:    tool.ReadOnly,
 		})
 	}
 	return defs
 }
 
+// searchLineRe matches "path:line:text" output of search tools like ripgrep.
+var searchLineRe = regexp.MustCompile(`(?m)^([^\s:]+):\d+[:-].*$`)
+
+// toolSources returns the files a tool result was read from: the sources
+// the tool reports, the path it reports or was called with, and the file of
+// each search hit.
+func toolSources(tc model.ToolCall, result ToolResult, content string) []model.Source {
+	sources := append([]model.Source(nil), result.Sources...)
+	path := result.Metadata["path"]
+	if path == "" {
+		path = result.Metadata["file"]
+	}
+	for _, key := range []string{"path", "file", "file_path", "filename"} {
+		if path != "" {
+			break
+		}
+		path, _ = tc.Input[key].(string)
+	}
+	if path != "" {
+		sources = append(sources, model.Source{Path: path})
+	}
+	for _, m := range searchLineRe.FindAllStringSubmatch(content, -1) {
+		sources = append(sources, model.Source{Path: m[1], Text: m[0]})
+	}
+	return sources
+}
+
 func (r *Runtime) executeTool(ctx context.Context, tc model.ToolCall) (ToolResult, error) {
 	if r.toolExec == nil {
 		return ToolResult{}, fmt.Errorf("no tool executor configured")
@@ -0,0 +1,262 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Checkpoint records a retention prune. It holds the hash of the last pruned
+// event so the remaining chain can be verified without the deleted events,
+// and is signed with the GreenForge CA key so it cannot be forged.
+type Checkpoint struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastEventID    int64     `json:"last_event_id"` // last pruned event
+	LastHash       string    `json:"last_hash"`     // hash of the last pruned event
+	PrunedCount    int64     `json:"pruned_count"`  // events removed by this prune
+	TotalPruned    int64     `json:"total_pruned"`  // events removed by all prunes so far
+	KeyFingerprint string    `json:"key_fingerprint"`
+	Signature      string    `json:"signature"` // base64 SSH signature over payload()
+}
+
+// CheckpointStatus is the verification result of a single checkpoint.
+type CheckpointStatus struct {
+	Checkpoint Checkpoint `json:"checkpoint"`
+	Valid      bool       `json:"valid"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// VerifyReport describes checkpoints and the live chain after them.
+type VerifyReport struct {
+	Checkpoints []CheckpointStatus `json:"checkpoints"`
+	Batches     []BatchStatus      `json:"batches"`
+	Anchor      *Checkpoint        `json:"anchor,omitempty"` // newest valid checkpoint the chain starts from
+	Events      int64              `json:"events"`           // live events verified
+	FirstID     int64              `json:"first_id"`
+	LastID      int64              `json:"last_id"`
+	Valid       bool               `json:"valid"`
+}
+
+func initCheckpointSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_checkpoints (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at      TEXT NOT NULL,
+			last_event_id   INTEGER NOT NULL,
+			last_hash       TEXT NOT NULL,
+			pruned_count    INTEGER NOT NULL,
+			total_pruned    INTEGER NOT NULL,
+			key_fingerprint TEXT NOT NULL,
+			signature       TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+// payload is the byte string covered by the checkpoint signature.
+func (c Checkpoint) payload() []byte {
+	return []byte(fmt.Sprintf("greenforge-audit-checkpoint-v1|%s|%d|%s|%d|%d",
+		c.CreatedAt.UTC().Format(time.RFC3339Nano),
+		c.LastEventID,
+		c.LastHash,
+		c.PrunedCount,
+		c.TotalPruned,
+	))
+}
+
+// verify checks the checkpoint signature against the CA public key.
+func (c Checkpoint) verify(key ssh.PublicKey) error {
+	if key == nil {
+		return fmt.Errorf("no CA public key available to verify signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(raw, &sig); err != nil {
+		return fmt.Errorf("parsing signature: %w", err)
+	}
+	if err := key.Verify(c.payload(), &sig); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return nil
+}
+
+// SetSigner sets the CA key used to sign retention checkpoints. Its public
+// key is also used for verification unless SetVerifyKey is called.
+func (l *Logger) SetSigner(signer ssh.Signer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.signer = signer
+	if l.verifyKey == nil && signer != nil {
+		l.verifyKey = signer.PublicKey()
+	}
+}
+
+// SetVerifyKey sets the CA public key used to verify checkpoints.
+func (l *Logger) SetVerifyKey(key ssh.PublicKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.verifyKey = key
+}
+
+// Checkpoints returns all checkpoints, oldest first.
+func (l *Logger) Checkpoints() ([]Checkpoint, error) {
+	rows, err := l.db.Query(`SELECT id, created_at, last_event_id, last_hash, pruned_count, total_pruned,
+		key_fingerprint, signature FROM audit_checkpoints ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cps []Checkpoint
+	for rows.Next() {
+		var c Checkpoint
+		var created string
+		if err := rows.Scan(&c.ID, &created, &c.LastEventID, &c.LastHash, &c.PrunedCount,
+			&c.TotalPruned, &c.KeyFingerprint, &c.Signature); err != nil {
+			return nil, err
+		}
+		c.CreatedAt, _ = time.Parse(time.RFC3339Nano, created)
+		cps = append(cps, c)
+	}
+	return cps, rows.Err()
+}
+
+// checkpointStatuses verifies every checkpoint and returns the newest valid one.
+func (l *Logger) checkpointStatuses(key ssh.PublicKey) ([]CheckpointStatus, *Checkpoint, error) {
+	cps, err := l.Checkpoints()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading checkpoints: %w", err)
+	}
+
+	statuses := make([]CheckpointStatus, len(cps))
+	var anchor *Checkpoint
+	for i, c := range cps {
+		statuses[i].Checkpoint = c
+		if err := c.verify(key); err != nil {
+			statuses[i].Error = err.Error()
+			continue
+		}
+		statuses[i].Valid = true
+		anchor = &statuses[i].Checkpoint
+	}
+	return statuses, anchor, nil
+}
+
+// Prune deletes events older than before. The chain up to the last pruned
+// event is verified first, then a signed checkpoint is written in the same
+// transaction as the delete. Returns nil if nothing was old enough.
+func (l *Logger) Prune(before time.Time) (*Checkpoint, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.signer == nil {
+		return nil, fmt.Errorf("audit retention requires a CA signing key")
+	}
+
+	var lastID int64
+	var lastHash string
+	err := l.db.QueryRow("SELECT id, hash FROM audit_events WHERE timestamp < ? ORDER BY id DESC LIMIT 1", before).
+		Scan(&lastID, &lastHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("finding events to prune: %w", err)
+	}
+
+	// Never sign over a segment that no longer verifies
+	_, anchor, err := l.checkpointStatuses(l.verifyKey)
+	if err != nil {
+		return nil, err
+	}
+	prevHash, afterID, totalPruned := "genesis", int64(0), int64(0)
+	if anchor != nil {
+		prevHash, afterID, totalPruned = anchor.LastHash, anchor.LastEventID, anchor.TotalPruned
+	}
+	count, _, _, err := l.verifyRange(prevHash, afterID, lastID)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to prune unverifiable events: %w", err)
+	}
+
+	cp := Checkpoint{
+		CreatedAt:      time.Now().UTC(),
+		LastEventID:    lastID,
+		LastHash:       lastHash,
+		PrunedCount:    count,
+		TotalPruned:    totalPruned + count,
+		KeyFingerprint: ssh.FingerprintSHA256(l.signer.PublicKey()),
+	}
+	sig, err := l.signer.Sign(rand.Reader, cp.payload())
+	if err != nil {
+		return nil, fmt.Errorf("signing checkpoint: %w", err)
+	}
+	cp.Signature = base64.StdEncoding.EncodeToString(ssh.Marshal(sig))
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO audit_checkpoints (created_at, last_event_id, last_hash, pruned_count,
+		total_pruned, key_fingerprint, signature) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		cp.CreatedAt.Format(time.RFC3339Nano), cp.LastEventID, cp.LastHash, cp.PrunedCount,
+		cp.TotalPruned, cp.KeyFingerprint, cp.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("writing checkpoint: %w", err)
+	}
+	cp.ID, _ = res.LastInsertId()
+
+	if _, err := tx.Exec("DELETE FROM audit_events WHERE id <= ?", lastID); err != nil {
+		return nil, fmt.Errorf("pruning events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// RunRetention prunes events older than retainDays every interval until ctx
+// is cancelled. The first prune runs immediately.
+func (l *Logger) RunRetention(ctx context.Context, retainDays int, interval time.Duration) {
+	if retainDays <= 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	prune := func() {
+		cutoff := time.Now().AddDate(0, 0, -retainDays)
+		cp, err := l.Prune(cutoff)
+		if err != nil {
+			log.Printf("Audit: retention failed: %v", err)
+			return
+		}
+		if cp != nil {
+			log.Printf("Audit: pruned %d events older than %d days (checkpoint %d)", cp.PrunedCount, retainDays, cp.ID)
+		}
+	}
+
+	prune()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			prune()
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// logAndCut logs old events, returns a cutoff after them, then logs recent ones.
+func logAndCut(t *testing.T, l *Logger, old, recent int) time.Time {
+	t.Helper()
+	logEvents(t, l, old)
+	time.Sleep(5 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	logEvents(t, l, recent)
+	return cutoff
+}
+
+func TestPrune(t *testing.T) {
+	l, _ := newTestLogger(t)
+	if _, err := l.SealBatch(); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := logAndCut(t, l, 4, 3)
+	if _, err := l.SealBatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := l.Prune(cutoff)
+	if err != nil || cp == nil {
+		t.Fatalf("Prune = %v, %v", cp, err)
+	}
+	if cp.LastEventID != 4 || cp.PrunedCount != 4 || cp.TotalPruned != 4 {
+		t.Errorf("checkpoint = %+v, want 4 events pruned through event 4", cp)
+	}
+	if cp, err := l.Prune(cutoff); cp != nil || err != nil {
+		t.Errorf("second Prune = %v, %v, want nothing to prune", cp, err)
+	}
+
+	report, err := l.Verify()
+	if err != nil || !report.Valid {
+		t.Fatalf("Verify after prune = %+v, %v", report, err)
+	}
+	if report.Anchor == nil || report.Anchor.ID != cp.ID || report.FirstID != 5 || report.Events != 3 {
+		t.Errorf("live chain = anchor %v, events %d from %d, want 3 from 5 after checkpoint %d",
+			report.Anchor, report.Events, report.FirstID, cp.ID)
+	}
+	if len(report.Batches) != 1 || !report.Batches[0].Pruned {
+		t.Errorf("batch over pruned events = %+v", report.Batches)
+	}
+
+	// New events chain on from the remaining ones; a second prune adds up
+	logEvents(t, l, 2)
+	cp2, err := l.Prune(time.Now().Add(time.Second))
+	if err != nil || cp2 == nil {
+		t.Fatalf("second prune = %v, %v", cp2, err)
+	}
+	if cp2.PrunedCount != 5 || cp2.TotalPruned != 9 {
+		t.Errorf("second checkpoint = %+v, want 5 pruned, 9 in total", cp2)
+	}
+	l.Log(Event{Action: "session.connect"})
+	if report, err := l.Verify(); err != nil || report.Anchor.ID != cp2.ID || report.Events != 1 {
+		t.Errorf("Verify after second prune = %+v, %v", report, err)
+	}
+}
+
+func TestPruneRequiresSigner(t *testing.T) {
+	l, err := NewLogger(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	logEvents(t, l, 2)
+	if _, err := l.Prune(time.Now().Add(time.Second)); err == nil {
+		t.Error("Prune without a CA key succeeded")
+	}
+}
+
+func TestPruneRefusesBrokenChain(t *testing.T) {
+	l, _ := newTestLogger(t)
+	cutoff := logAndCut(t, l, 4, 1)
+	if _, err := l.db.Exec("UPDATE audit_events SET action = 'x' WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Prune(cutoff); err == nil || !strings.Contains(err.Error(), "unverifiable") {
+		t.Errorf("Prune over tampered events: error = %v", err)
+	}
+	var n int
+	l.db.QueryRow("SELECT COUNT(*) FROM audit_events").Scan(&n)
+	if n != 5 {
+		t.Errorf("%d events left after refused prune, want 5", n)
+	}
+}
+
+func TestCheckpointVerification(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, l *Logger)
+		err    string
+	}{
+		{"forged count", func(t *testing.T, l *Logger) {
+			l.db.Exec("UPDATE audit_checkpoints SET pruned_count = 1")
+		}, "chain broken at event 5"},
+		{"moved last event", func(t *testing.T, l *Logger) {
+			l.db.Exec("UPDATE audit_checkpoints SET last_event_id = 5")
+		}, "chain broken at event 5"},
+		{"other CA", func(t *testing.T, l *Logger) {
+			pub, _, _ := ed25519.GenerateKey(rand.Reader)
+			key, _ := ssh.NewPublicKey(pub)
+			l.SetVerifyKey(key)
+		}, "chain broken at event 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLogger(t)
+			cutoff := logAndCut(t, l, 4, 2)
+			if _, err := l.Prune(cutoff); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(t, l)
+
+			report, err := l.Verify()
+			if len(report.Checkpoints) != 1 || report.Checkpoints[0].Valid {
+				t.Errorf("checkpoint status = %+v, want invalid", report.Checkpoints)
+			}
+			// Without a valid anchor the live chain cannot link to genesis
+			if report.Valid || err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Verify = valid %v, %v, want %q", report.Valid, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLastHashAfterFullPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	l, err := NewLogger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	l.SetSigner(signer)
+	logEvents(t, l, 3)
+	if _, err := l.Prune(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// A reopened logger continues the chain from the checkpoint
+	l, err = NewLogger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	key, _ := ssh.NewPublicKey(pub)
+	l.SetVerifyKey(key)
+	logEvents(t, l, 1)
+	if report, err := l.Verify(); err != nil || report.Events != 1 {
+		t.Errorf("Verify after reopening = %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export formats understood by FormatEvent.
+const (
+	FormatJSONL = "jsonl"
+	FormatCEF   = "cef"
+	FormatLEEF  = "leef"
+)
+
+// ProductVersion is reported in CEF and LEEF headers.
+var ProductVersion = "0.1.0"
+
+// EventsAfter returns up to limit events with ID > afterID in ascending
+// order, optionally only those logged at or after since.
+func (l *Logger) EventsAfter(afterID int64, since *time.Time, limit int) ([]Event, error) {
+	query := `SELECT id, timestamp, action, user, session_id, project, tool, details, hash, prev_hash, hash_version
+		FROM audit_events WHERE id > ?`
+	args := []interface{}{afterID}
+	// Timestamps are stored in local time, compare in the same zone
+	if since != nil {
+		query += " AND timestamp >= ?"
+		args = append(args, since.Local())
+	}
+	query += " ORDER BY id ASC"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var detailsStr string
+		if err := rows.Scan(
+			&e.ID, &e.Timestamp, &e.Action, &e.User,
+			&e.SessionID, &e.Project, &e.Tool,
+			&detailsStr, &e.Hash, &e.PrevHash, &e.HashVersion,
+		); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(detailsStr), &e.Details)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Export writes all events logged since the given time to w, one per line.
+func (l *Logger) Export(w io.Writer, since time.Time, format string) (int, error) {
+	if _, err := FormatEvent(Event{}, format); err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	var after int64
+	count := 0
+	for {
+		events, err := l.EventsAfter(after, &since, 500)
+		if err != nil {
+			return count, err
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, e := range events {
+			line, _ := FormatEvent(e, format)
+			if _, err := bw.WriteString(line + "\n"); err != nil {
+				return count, err
+			}
+			count++
+			after = e.ID
+		}
+	}
+	return count, bw.Flush()
+}
+
+// FormatEvent renders an event as a single line in the given format.
+// All formats include the event hash and prev_hash.
+func FormatEvent(e Event, format string) (string, error) {
+	switch format {
+	case FormatJSONL, "":
+		data, err := json.Marshal(e)
+		return string(data), err
+	case FormatCEF:
+		return formatCEF(e), nil
+	case FormatLEEF:
+		return formatLEEF(e), nil
+	default:
+		return "", fmt.Errorf("unknown export format %q (use jsonl, cef or leef)", format)
+	}
+}
+
+// Severity maps an action to a 0-10 SIEM severity.
+func Severity(action string) int {
+	switch {
+	case strings.HasSuffix(action, ".denied"):
+		return 7
+	case strings.HasSuffix(action, ".error"):
+		return 5
+	case strings.HasPrefix(action, "secret."):
+		return 6
+	default:
+		return 3
+	}
+}
+
+// formatCEF renders ArcSight Common Event Format:
+// CEF:Version|Vendor|Product|Version|SignatureID|Name|Severity|Extension
+func formatCEF(e Event) string {
+	header := strings.Join([]string{
+		"CEF:0",
+		cefHeader("GreenCode"),
+		cefHeader("GreenForge"),
+		cefHeader(ProductVersion),
+		cefHeader(e.Action),
+		cefHeader(e.Action),
+		fmt.Sprint(Severity(e.Action)),
+	}, "|")
+
+	ext := []string{
+		"rt=" + fmt.Sprint(e.Timestamp.UnixMilli()),
+		"externalId=" + fmt.Sprint(e.ID),
+		"act=" + cefValue(e.Action),
+		"suser=" + cefValue(e.User),
+		"cs1Label=sessionId", "cs1=" + cefValue(e.SessionID),
+		"cs2Label=project", "cs2=" + cefValue(e.Project),
+		"cs3Label=tool", "cs3=" + cefValue(e.Tool),
+		"cs4Label=hash", "cs4=" + cefValue(e.Hash),
+		"cs5Label=prevHash", "cs5=" + cefValue(e.PrevHash),
+	}
+	if len(e.Details) > 0 {
+		details, _ := json.Marshal(e.Details)
+		ext = append(ext, "msg="+cefValue(string(details)))
+	}
+	return header + "|" + strings.Join(ext, " ")
+}
+
+// formatLEEF renders IBM QRadar Log Event Extended Format 1.0 with
+// tab-separated attributes.
+func formatLEEF(e Event) string {
+	header := strings.Join([]string{
+		"LEEF:1.0",
+		leefHeader("GreenCode"),
+		leefHeader("GreenForge"),
+		leefHeader(ProductVersion),
+		leefHeader(e.Action),
+	}, "|")
+
+	attrs := []string{
+		"devTime=" + leefValue(e.Timestamp.UTC().Format("Jan 02 2006 15:04:05.000")),
+		"devTimeFormat=MMM dd yyyy HH:mm:ss.SSS",
+		"sev=" + fmt.Sprint(Severity(e.Action)),
+		"usrName=" + leefValue(e.User),
+		"eventId=" + fmt.Sprint(e.ID),
+		"sessionId=" + leefValue(e.SessionID),
+		"project=" + leefValue(e.Project),
+		"tool=" + leefValue(e.Tool),
+		"hash=" + leefValue(e.Hash),
+		"prevHash=" + leefValue(e.PrevHash),
+	}
+	keys := make([]string, 0, len(e.Details))
+	for k := range e.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, "detail_"+leefValue(k)+"="+leefValue(e.Details[k]))
+	}
+	return header + "|" + strings.Join(attrs, "\t")
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	leefHeaderEscape = strings.NewReplacer(`|`, `\|`, "\n", " ", "\r", " ")
+	leefValueEscape  = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+)
+
+func cefHeader(s string) string  { return cefHeaderEscaper.Replace(s) }
+func cefValue(s string) string   { return cefValueEscaper.Replace(s) }
+func leefHeader(s string) string { return leefHeaderEscape.Replace(s) }
+func leefValue(s string) string  { return leefValueEscape.Replace(s) }
@@ -0,0 +1,309 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ForwardConfig configures the SIEM forwarder.
+type ForwardConfig struct {
+	Name     string        // cursor name, allows several forwarders per database
+	Sink     string        // syslog or file
+	Network  string        // tcp or udp (syslog sink)
+	Address  string        // host:port (syslog sink)
+	Path     string        // output file (file sink)
+	Format   string        // jsonl, cef or leef
+	Facility string        // syslog facility, default local0
+	SDID     string        // structured data ID, default DefaultSDID
+	Interval time.Duration // poll interval when no new events are signalled
+}
+
+// DefaultSDID is the syslog structured data ID used when none is configured.
+// 32473 is the enterprise number IANA reserves for documentation, so
+// deployments should set their own.
+const DefaultSDID = "greenforge@32473"
+
+// Forwarder streams new audit events to a syslog receiver or file. The ID of
+// the last delivered event is stored in the audit database, so delivery
+// resumes after restarts (at-least-once).
+type Forwarder struct {
+	logger   *Logger
+	cfg      ForwardConfig
+	hostname string
+	sink     sink
+}
+
+type sink interface {
+	Write(e Event, line string) error
+	Close() error
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// NewForwarder validates the configuration and creates a forwarder.
+func NewForwarder(logger *Logger, cfg ForwardConfig) (*Forwarder, error) {
+	if cfg.Name == "" {
+		cfg.Name = "default"
+	}
+	if cfg.Format == "" {
+		cfg.Format = FormatJSONL
+	}
+	if _, err := FormatEvent(Event{}, cfg.Format); err != nil {
+		return nil, err
+	}
+	if cfg.Facility == "" {
+		cfg.Facility = "local0"
+	}
+	if _, ok := syslogFacilities[cfg.Facility]; !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+	}
+	if cfg.SDID == "" {
+		cfg.SDID = DefaultSDID
+	}
+	if !validSDID(cfg.SDID) {
+		return nil, fmt.Errorf("invalid syslog structured data ID %q: 1-32 printable ASCII characters without '=', ']', '\"' or spaces", cfg.SDID)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+
+	switch cfg.Sink {
+	case "syslog":
+		if cfg.Network != "tcp" && cfg.Network != "udp" {
+			return nil, fmt.Errorf("syslog network must be tcp or udp, got %q", cfg.Network)
+		}
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("syslog sink requires an address")
+		}
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("file sink requires a path")
+		}
+	default:
+		return nil, fmt.Errorf("unknown forward sink %q (use syslog or file)", cfg.Sink)
+	}
+
+	if _, err := logger.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_forward_cursors (
+			name       TEXT PRIMARY KEY,
+			last_id    INTEGER NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`); err != nil {
+		return nil, fmt.Errorf("creating forward cursor table: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &Forwarder{logger: logger, cfg: cfg, hostname: hostname}, nil
+}
+
+// Cursor returns the ID of the last delivered event.
+func (f *Forwarder) Cursor() int64 {
+	var id int64
+	err := f.logger.db.QueryRow("SELECT last_id FROM audit_forward_cursors WHERE name = ?", f.cfg.Name).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Audit forwarder: reading cursor: %v", err)
+	}
+	return id
+}
+
+func (f *Forwarder) saveCursor(id int64) error {
+	_, err := f.logger.db.Exec(`
+		INSERT INTO audit_forward_cursors (name, last_id, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET last_id = excluded.last_id, updated_at = excluded.updated_at`,
+		f.cfg.Name, id, time.Now().Local())
+	return err
+}
+
+// Run forwards events until ctx is cancelled. Delivery errors are retried on
+// the next wake-up; the cursor only advances past delivered events.
+func (f *Forwarder) Run(ctx context.Context) {
+	defer func() {
+		if f.sink != nil {
+			f.sink.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(f.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.flush(); err != nil {
+			log.Printf("Audit forwarder: %v", err)
+			if f.sink != nil {
+				f.sink.Close()
+				f.sink = nil // reconnect on next attempt
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.logger.logged:
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush delivers all events after the cursor.
+func (f *Forwarder) flush() error {
+	cursor := f.Cursor()
+	for {
+		events, err := f.logger.EventsAfter(cursor, nil, 200)
+		if err != nil {
+			return fmt.Errorf("reading events: %w", err)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		if f.sink == nil {
+			if f.sink, err = f.openSink(); err != nil {
+				return err
+			}
+		}
+
+		for _, e := range events {
+			line, _ := FormatEvent(e, f.cfg.Format)
+			if err := f.sink.Write(e, line); err != nil {
+				// Keep progress made so far
+				if cursor > 0 {
+					f.saveCursor(cursor)
+				}
+				return fmt.Errorf("delivering event %d: %w", e.ID, err)
+			}
+			cursor = e.ID
+		}
+		if err := f.saveCursor(cursor); err != nil {
+			return fmt.Errorf("saving cursor: %w", err)
+		}
+	}
+}
+
+func (f *Forwarder) openSink() (sink, error) {
+	if f.cfg.Sink == "file" {
+		file, err := os.OpenFile(f.cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", f.cfg.Path, err)
+		}
+		return &fileSink{file: file}, nil
+	}
+
+	conn, err := net.DialTimeout(f.cfg.Network, f.cfg.Address, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to syslog %s/%s: %w", f.cfg.Network, f.cfg.Address, err)
+	}
+	return &syslogSink{
+		conn:     conn,
+		tcp:      f.cfg.Network == "tcp",
+		facility: syslogFacilities[f.cfg.Facility],
+		hostname: f.hostname,
+		sdID:     f.cfg.SDID,
+	}, nil
+}
+
+type fileSink struct {
+	file *os.File
+}
+
+func (s *fileSink) Write(e Event, line string) error {
+	_, err := s.file.WriteString(line + "\n")
+	return err
+}
+
+func (s *fileSink) Close() error { return s.file.Close() }
+
+// syslogSink writes RFC 5424 messages. TCP uses octet-counting framing
+// (RFC 6587), UDP sends one message per datagram.
+type syslogSink struct {
+	conn     net.Conn
+	tcp      bool
+	facility int
+	hostname string
+	sdID     string
+}
+
+func (s *syslogSink) Write(e Event, line string) error {
+	msg := FormatSyslog(e, line, s.facility, s.hostname, s.sdID)
+	if s.tcp {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := s.conn.Write([]byte(msg))
+	return err
+}
+
+func (s *syslogSink) Close() error { return s.conn.Close() }
+
+// FormatSyslog builds an RFC 5424 message. The hash chain fields are also
+// carried as structured data under sdID so receivers can index them without
+// parsing MSG.
+func FormatSyslog(e Event, msg string, facility int, hostname, sdID string) string {
+	// Map 0-10 SIEM severity onto syslog severity (0 emergency .. 7 debug)
+	severity := 6 // informational
+	switch sev := Severity(e.Action); {
+	case sev >= 7:
+		severity = 4 // warning
+	case sev >= 5:
+		severity = 5 // notice
+	}
+
+	sd := fmt.Sprintf(`[%s id="%d" hash="%s" prev_hash="%s" user="%s" project="%s"]`,
+		sdID, e.ID, sdEscape(e.Hash), sdEscape(e.PrevHash), sdEscape(e.User), sdEscape(e.Project))
+
+	return fmt.Sprintf("<%d>1 %s %s greenforge %d %s %s %s",
+		facility*8+severity,
+		e.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		hostname,
+		os.Getpid(),
+		syslogMsgID(e.Action),
+		sd,
+		msg,
+	)
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func sdEscape(s string) string { return sdEscaper.Replace(s) }
+
+// syslogMsgID limits MSGID to 32 printable characters as RFC 5424 requires.
+func syslogMsgID(action string) string {
+	if action == "" {
+		return "-"
+	}
+	id := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, action)
+	if len(id) > 32 {
+		id = id[:32]
+	}
+	return id
+}
+
+// validSDID checks an SD-ID against the RFC 5424 SD-NAME syntax.
+func validSDID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, c := range id {
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
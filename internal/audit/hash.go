@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Hash encoding versions. Events keep the version they were written with so
+// old chains stay verifiable after the encoding changes.
+const (
+	// HashV1 hashes "prev|timestamp|action|user|session|tool|details" and
+	// does not cover the project.
+	HashV1 = 1
+	// HashV2 hashes a canonical JSON document covering every event field.
+	HashV2 = 2
+
+	currentHashVersion = HashV2
+)
+
+// canonicalEvent is the HashV2 encoding. Field order is fixed by the struct,
+// details keys are sorted by encoding/json, and the timestamp is UTC.
+type canonicalEvent struct {
+	Version   int               `json:"v"`
+	PrevHash  string            `json:"prev_hash"`
+	Timestamp string            `json:"timestamp"`
+	Action    string            `json:"action"`
+	User      string            `json:"user"`
+	SessionID string            `json:"session_id"`
+	Project   string            `json:"project"`
+	Tool      string            `json:"tool"`
+	Details   map[string]string `json:"details"`
+}
+
+// CanonicalEncoding returns the bytes hashed for an event under its
+// HashVersion. detailsJSON is the details as stored.
+func CanonicalEncoding(e Event, detailsJSON string) ([]byte, error) {
+	switch e.HashVersion {
+	case 0, HashV1:
+		return []byte(fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s",
+			e.PrevHash,
+			e.Timestamp.Format(time.RFC3339Nano),
+			e.Action,
+			e.User,
+			e.SessionID,
+			e.Tool,
+			detailsJSON,
+		)), nil
+	case HashV2:
+		var details map[string]string
+		if err := json.Unmarshal([]byte(detailsJSON), &details); err != nil {
+			return nil, fmt.Errorf("decoding details: %w", err)
+		}
+		return json.Marshal(canonicalEvent{
+			Version:   HashV2,
+			PrevHash:  e.PrevHash,
+			Timestamp: e.Timestamp.UTC().Format(time.RFC3339Nano),
+			Action:    e.Action,
+			User:      e.User,
+			SessionID: e.SessionID,
+			Project:   e.Project,
+			Tool:      e.Tool,
+			Details:   details,
+		})
+	default:
+		return nil, fmt.Errorf("unknown hash version %d", e.HashVersion)
+	}
+}
+
+// eventHash calculates the SHA-256 chain hash of an event.
+func eventHash(e Event, detailsJSON string) (string, error) {
+	data, err := CanonicalEncoding(e, detailsJSON)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
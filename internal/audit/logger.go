@@ -1,47 +1,56 @@
 package audit
 
 import (
-	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/ssh"
 )
 
 // Logger provides tamper-evident audit logging with hash chain.
 type Logger struct {
-	mu       sync.Mutex
-	db       *sql.DB
-	lastHash string
+	mu        sync.Mutex
+	db        *sql.DB
+	lastHash  string
+	signer    ssh.Signer    // CA key for retention checkpoints
+	verifyKey ssh.PublicKey // CA public key for checkpoint verification
+	logged    chan struct{} // signalled after each Log, see Forwarder
 }
 
 // Event represents an auditable action.
 type Event struct {
 	ID        int64             `json:"id"`
 	Timestamp time.Time         `json:"timestamp"`
-	Action    string            `json:"action"`    // e.g. "tool.execute", "session.connect", "secret.access"
-	User      string            `json:"user"`      // cert identity
+	Action    string            `json:"action"` // e.g. "tool.execute", "session.connect", "secret.access"
+	User      string            `json:"user"`   // cert identity
 	SessionID string            `json:"session_id"`
 	Project   string            `json:"project"`
 	Tool      string            `json:"tool,omitempty"`
 	Details   map[string]string `json:"details,omitempty"`
 	Hash      string            `json:"hash"` // SHA-256 hash chain
 	PrevHash  string            `json:"prev_hash"`
+
+	HashVersion int `json:"hash_version"` // encoding used for Hash, see HashV2
 }
 
 // QueryFilter for querying audit events.
 type QueryFilter struct {
-	Limit     int
-	User      string
-	Tool      string
-	Action    string
-	SessionID string
-	Since     *time.Time
-	Until     *time.Time
+	Limit        int
+	Cursor       int64 // only events with ID < Cursor (see Page.NextCursor)
+	User         string
+	Tool         string
+	Action       string
+	ActionPrefix string // e.g. "tool." or "rbac."
+	SessionID    string
+	Project      string
+	Details      map[string]string // exact match on details keys
+	Since        *time.Time
+	Until        *time.Time
 }
 
 // NewLogger creates a new audit logger with SQLite backend.
@@ -55,11 +64,19 @@ func NewLogger(dbPath string) (*Logger, error) {
 		db.Close()
 		return nil, err
 	}
+	if err := initCheckpointSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := initBatchSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	// Get last hash for chain continuity
 	lastHash := getLastHash(db)
 
-	return &Logger{db: db, lastHash: lastHash}, nil
+	return &Logger{db: db, lastHash: lastHash, logged: make(chan struct{}, 1)}, nil
 }
 
 func initAuditSchema(db *sql.DB) error {
@@ -82,7 +99,16 @@ func initAuditSchema(db *sql.DB) error {
 		CREATE INDEX IF NOT EXISTS idx_audit_timestamp ON audit_events(timestamp);
 		CREATE INDEX IF NOT EXISTS idx_audit_tool ON audit_events(tool);
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Events written before hash versioning use HashV1
+	_, err = db.Exec("ALTER TABLE audit_events ADD COLUMN hash_version INTEGER NOT NULL DEFAULT 1")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+		return fmt.Errorf("migrating audit schema: %w", err)
+	}
+	return nil
 }
 
 func getLastHash(db *sql.DB) string {
@@ -91,6 +117,11 @@ func getLastHash(db *sql.DB) string {
 	if hash.Valid {
 		return hash.String
 	}
+	// Everything pruned: continue from the last checkpoint
+	db.QueryRow("SELECT last_hash FROM audit_checkpoints ORDER BY id DESC LIMIT 1").Scan(&hash)
+	if hash.Valid {
+		return hash.String
+	}
 	return "genesis" // Initial hash for the chain
 }
 
@@ -101,24 +132,18 @@ func (l *Logger) Log(event Event) error {
 
 	event.Timestamp = time.Now()
 	event.PrevHash = l.lastHash
+	event.HashVersion = currentHashVersion
 
-	// Calculate hash: SHA-256(prevHash + timestamp + action + user + details)
 	detailsJSON, _ := json.Marshal(event.Details)
-	hashInput := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s",
-		event.PrevHash,
-		event.Timestamp.Format(time.RFC3339Nano),
-		event.Action,
-		event.User,
-		event.SessionID,
-		event.Tool,
-		string(detailsJSON),
-	)
-	hash := sha256.Sum256([]byte(hashInput))
-	event.Hash = hex.EncodeToString(hash[:])
+	hash, err := eventHash(event, string(detailsJSON))
+	if err != nil {
+		return fmt.Errorf("hashing audit event: %w", err)
+	}
+	event.Hash = hash
 
-	_, err := l.db.Exec(`
-		INSERT INTO audit_events (timestamp, action, user, session_id, project, tool, details, hash, prev_hash)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	_, err = l.db.Exec(`
+		INSERT INTO audit_events (timestamp, action, user, session_id, project, tool, details, hash, prev_hash, hash_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		event.Timestamp,
 		event.Action,
 		event.User,
@@ -128,44 +153,29 @@ func (l *Logger) Log(event Event) error {
 		string(detailsJSON),
 		event.Hash,
 		event.PrevHash,
+		event.HashVersion,
 	)
 	if err != nil {
 		return fmt.Errorf("inserting audit event: %w", err)
 	}
 
 	l.lastHash = event.Hash
+
+	// Wake the forwarder without blocking
+	select {
+	case l.logged <- struct{}{}:
+	default:
+	}
 	return nil
 }
 
-// Query retrieves audit events matching the filter.
+// Query retrieves audit events matching the filter, newest first.
 func (l *Logger) Query(filter QueryFilter) ([]Event, error) {
-	query := "SELECT id, timestamp, action, user, session_id, project, tool, details, hash, prev_hash FROM audit_events WHERE 1=1"
-	var args []interface{}
-
-	if filter.User != "" {
-		query += " AND user = ?"
-		args = append(args, filter.User)
-	}
-	if filter.Tool != "" {
-		query += " AND tool = ?"
-		args = append(args, filter.Tool)
-	}
-	if filter.Action != "" {
-		query += " AND action = ?"
-		args = append(args, filter.Action)
-	}
-	if filter.SessionID != "" {
-		query += " AND session_id = ?"
-		args = append(args, filter.SessionID)
-	}
-	if filter.Since != nil {
-		query += " AND timestamp >= ?"
-		args = append(args, *filter.Since)
-	}
-	if filter.Until != nil {
-		query += " AND timestamp <= ?"
-		args = append(args, *filter.Until)
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
 	}
+	query := "SELECT id, timestamp, action, user, session_id, project, tool, details, hash, prev_hash, hash_version FROM audit_events WHERE " + where
 
 	query += " ORDER BY id DESC"
 
@@ -186,7 +196,7 @@ func (l *Logger) Query(filter QueryFilter) ([]Event, error) {
 		if err := rows.Scan(
 			&e.ID, &e.Timestamp, &e.Action, &e.User,
 			&e.SessionID, &e.Project, &e.Tool,
-			&detailsStr, &e.Hash, &e.PrevHash,
+			&detailsStr, &e.Hash, &e.PrevHash, &e.HashVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -196,58 +206,118 @@ func (l *Logger) Query(filter QueryFilter) ([]Event, error) {
 	return events, rows.Err()
 }
 
-// VerifyChain checks the integrity of the audit log hash chain.
+// VerifyChain checks the integrity of the audit log hash chain. It returns
+// the ID of the last verified event, or of the event where the chain broke.
 func (l *Logger) VerifyChain() (bool, int64, error) {
-	rows, err := l.db.Query(
-		"SELECT id, timestamp, action, user, session_id, tool, details, hash, prev_hash FROM audit_events ORDER BY id ASC")
+	report, err := l.Verify()
+	return report.Valid, report.LastID, err
+}
+
+// Verify checks all checkpoint signatures and then the live hash chain,
+// starting from the newest valid checkpoint (or "genesis" if none), and the
+// signatures and roots of all batches. A broken chain or batch is an error.
+func (l *Logger) Verify() (VerifyReport, error) {
+	l.mu.Lock()
+	key := l.verifyKey
+	l.mu.Unlock()
+
+	var report VerifyReport
+	statuses, anchor, err := l.checkpointStatuses(key)
 	if err != nil {
-		return false, 0, err
+		return report, err
 	}
-	defer rows.Close()
+	report.Checkpoints = statuses
+	report.Anchor = anchor
+
+	prevHash, afterID := "genesis", int64(0)
+	if anchor != nil {
+		prevHash, afterID = anchor.LastHash, anchor.LastEventID
+	}
+
+	report.Batches, err = l.batchStatuses(key, afterID)
+	if err != nil {
+		return report, err
+	}
+
+	count, firstID, lastID, err := l.verifyRange(prevHash, afterID, 0)
+	report.Events = count
+	report.FirstID = firstID
+	report.LastID = lastID
+	if err != nil {
+		return report, err
+	}
+	for _, st := range report.Batches {
+		if !st.Valid {
+			b := st.Batch
+			return report, fmt.Errorf("batch %d (events %d-%d): %s", b.ID, b.FirstEventID, b.LastEventID, st.Error)
+		}
+	}
+	report.Valid = true
+	return report, nil
+}
 
-	expectedPrevHash := "genesis"
-	var lastVerifiedID int64
+// verifyRange verifies events with afterID < id <= untilID (no upper bound if
+// untilID is 0), expecting the first to link to prevHash. It returns the number
+// of verified events and the first and last verified IDs; on failure lastID is
+// the offending event.
+func (l *Logger) verifyRange(prevHash string, afterID, untilID int64) (count, firstID, lastID int64, err error) {
+	query := "SELECT id, timestamp, action, user, session_id, project, tool, details, hash, prev_hash, hash_version FROM audit_events WHERE id > ?"
+	args := []interface{}{afterID}
+	if untilID > 0 {
+		query += " AND id <= ?"
+		args = append(args, untilID)
+	}
+	rows, err := l.db.Query(query+" ORDER BY id ASC", args...)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer rows.Close()
 
+	expectedPrevHash := prevHash
+	highestVersion := 0
 	for rows.Next() {
 		var e Event
 		var detailsStr string
 		if err := rows.Scan(
 			&e.ID, &e.Timestamp, &e.Action, &e.User,
-			&e.SessionID, &e.Tool,
-			&detailsStr, &e.Hash, &e.PrevHash,
+			&e.SessionID, &e.Project, &e.Tool,
+			&detailsStr, &e.Hash, &e.PrevHash, &e.HashVersion,
 		); err != nil {
-			return false, lastVerifiedID, err
+			return count, firstID, lastID, err
 		}
 
 		// Verify prev_hash matches expected
 		if e.PrevHash != expectedPrevHash {
-			return false, e.ID, fmt.Errorf("chain broken at event %d: expected prev_hash %q, got %q",
+			return count, firstID, e.ID, fmt.Errorf("chain broken at event %d: expected prev_hash %q, got %q",
 				e.ID, expectedPrevHash, e.PrevHash)
 		}
 
-		// Verify hash
-		hashInput := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s",
-			e.PrevHash,
-			e.Timestamp.Format(time.RFC3339Nano),
-			e.Action,
-			e.User,
-			e.SessionID,
-			e.Tool,
-			detailsStr,
-		)
-		hash := sha256.Sum256([]byte(hashInput))
-		expectedHash := hex.EncodeToString(hash[:])
+		// Once events use a newer encoding, older ones may not follow
+		if e.HashVersion < highestVersion {
+			return count, firstID, e.ID, fmt.Errorf("hash version downgrade at event %d: v%d after v%d",
+				e.ID, e.HashVersion, highestVersion)
+		}
+		highestVersion = e.HashVersion
 
+		// Verify hash
+		expectedHash, err := eventHash(e, detailsStr)
+		if err != nil {
+			return count, firstID, e.ID, fmt.Errorf("event %d: %w", e.ID, err)
+		}
 		if e.Hash != expectedHash {
-			return false, e.ID, fmt.Errorf("hash mismatch at event %d: expected %q, got %q",
+			return count, firstID, e.ID, fmt.Errorf("hash mismatch at event %d: expected %q, got %q",
 				e.ID, expectedHash, e.Hash)
 		}
 
+		if firstID == 0 {
+			firstID = e.ID
+		}
 		expectedPrevHash = e.Hash
-		lastVerifiedID = e.ID
+		lastID = e.ID
+		count++
 	}
 
-	return true, lastVerifiedID, rows.Err()
+	return count, firstID, lastID, rows.Err()
 }
 
 // Close releases the database.
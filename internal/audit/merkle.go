@@ -0,0 +1,486 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxBatchSize caps the number of events sealed under one Merkle root.
+const maxBatchSize = 4096
+
+// Batch is a signed Merkle root over a contiguous range of events. Leaves
+// are the event hashes in ID order: leaf = SHA-256(0x00 || hash), node =
+// SHA-256(0x01 || left || right); an odd node is promoted unchanged.
+type Batch struct {
+	ID           int64     `json:"id"`
+	FirstEventID int64     `json:"first_event_id"`
+	LastEventID  int64     `json:"last_event_id"`
+	Count        int64     `json:"count"`
+	Root         string    `json:"root"` // hex
+	CreatedAt    time.Time `json:"created_at"`
+	PublicKey    string    `json:"public_key"` // base64 raw Ed25519 key
+	Signature    string    `json:"signature"`  // base64 raw Ed25519 signature over payload()
+}
+
+// BatchStatus is the verification result of a single batch.
+type BatchStatus struct {
+	Batch  Batch  `json:"batch"`
+	Valid  bool   `json:"valid"`
+	Pruned bool   `json:"pruned,omitempty"` // events pruned behind a checkpoint, only the signature was checked
+	Error  string `json:"error,omitempty"`
+}
+
+// ProofStep is a sibling hash on the path from a leaf to the root.
+type ProofStep struct {
+	Hash string `json:"hash"` // hex
+	Left bool   `json:"left"` // sibling is the left child
+}
+
+// InclusionProof proves that an event is part of a signed batch. It can be
+// checked with VerifyInclusionProof without access to the audit database.
+type InclusionProof struct {
+	Event       Event       `json:"event"`
+	DetailsJSON string      `json:"details_json"` // event details as stored and hashed
+	Index       int64       `json:"index"`        // leaf position within the batch
+	Path        []ProofStep `json:"path"`
+	Batch       Batch       `json:"batch"`
+}
+
+func initBatchSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_batches (
+			id             INTEGER PRIMARY KEY AUTOINCREMENT,
+			first_event_id INTEGER NOT NULL,
+			last_event_id  INTEGER NOT NULL,
+			count          INTEGER NOT NULL,
+			root           TEXT NOT NULL,
+			created_at     TEXT NOT NULL,
+			public_key     TEXT NOT NULL,
+			signature      TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+func (b Batch) payload() []byte {
+	return []byte(fmt.Sprintf("greenforge-audit-batch-v1|%d|%d|%d|%s|%s",
+		b.FirstEventID,
+		b.LastEventID,
+		b.Count,
+		b.Root,
+		b.CreatedAt.UTC().Format(time.RFC3339Nano),
+	))
+}
+
+// verifySignature checks the batch signature. If trusted is non-nil the
+// embedded public key must equal it.
+func (b Batch) verifySignature(trusted ed25519.PublicKey) error {
+	pub, err := base64.StdEncoding.DecodeString(b.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key")
+	}
+	if trusted != nil && !bytes.Equal(pub, trusted) {
+		return fmt.Errorf("batch signed by untrusted key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(b.Signature)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), b.payload(), sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// ed25519Key extracts the raw Ed25519 key from an SSH public key.
+func ed25519Key(key ssh.PublicKey) (ed25519.PublicKey, error) {
+	if key == nil {
+		return nil, fmt.Errorf("no CA public key available")
+	}
+	ck, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %s", key.Type())
+	}
+	pub, ok := ck.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("batch signing requires an Ed25519 key, got %s", key.Type())
+	}
+	return pub, nil
+}
+
+func merkleLeaf(eventHash string) ([]byte, error) {
+	raw, err := hex.DecodeString(eventHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event hash %q", eventHash)
+	}
+	h := sha256.Sum256(append([]byte{0x00}, raw...))
+	return h[:], nil
+}
+
+func merkleNode(left, right []byte) []byte {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(buf, 0x01)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+	h := sha256.Sum256(buf)
+	return h[:]
+}
+
+// merkleRoot computes the root and, for index >= 0, the proof path of a leaf.
+func merkleRoot(leaves [][]byte, index int) ([]byte, []ProofStep) {
+	if len(leaves) == 0 {
+		return nil, nil
+	}
+	var path []ProofStep
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i]) // odd node promoted
+				continue
+			}
+			if index == i {
+				path = append(path, ProofStep{Hash: hex.EncodeToString(level[i+1])})
+			} else if index == i+1 {
+				path = append(path, ProofStep{Hash: hex.EncodeToString(level[i]), Left: true})
+			}
+			next = append(next, merkleNode(level[i], level[i+1]))
+		}
+		if index >= 0 {
+			index /= 2
+		}
+		level = next
+	}
+	return level[0], path
+}
+
+// eventHashesRange returns IDs and hashes of live events in [first, last].
+func (l *Logger) eventHashesRange(first, last int64) ([]int64, []string, error) {
+	rows, err := l.db.Query("SELECT id, hash FROM audit_events WHERE id >= ? AND id <= ? ORDER BY id ASC", first, last)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var hashes []string
+	for rows.Next() {
+		var id int64
+		var h string
+		if err := rows.Scan(&id, &h); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		hashes = append(hashes, h)
+	}
+	return ids, hashes, rows.Err()
+}
+
+func leavesOf(hashes []string) ([][]byte, error) {
+	leaves := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		leaf, err := merkleLeaf(h)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf
+	}
+	return leaves, nil
+}
+
+// SealBatch signs a Merkle root over events not yet covered by a batch (at
+// most maxBatchSize). Returns nil if there is nothing to seal.
+func (l *Logger) SealBatch() (*Batch, error) {
+	l.mu.Lock()
+	signer := l.signer
+	l.mu.Unlock()
+	if signer == nil {
+		return nil, fmt.Errorf("batch signing requires a CA signing key")
+	}
+	pub, err := ed25519Key(signer.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	var after int64
+	l.db.QueryRow("SELECT COALESCE(MAX(last_event_id), 0) FROM audit_batches").Scan(&after)
+
+	rows, err := l.db.Query("SELECT id, hash FROM audit_events WHERE id > ? ORDER BY id ASC LIMIT ?", after, maxBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	var hashes []string
+	for rows.Next() {
+		var id int64
+		var h string
+		if err := rows.Scan(&id, &h); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		hashes = append(hashes, h)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	leaves, err := leavesOf(hashes)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := merkleRoot(leaves, -1)
+
+	b := Batch{
+		FirstEventID: ids[0],
+		LastEventID:  ids[len(ids)-1],
+		Count:        int64(len(ids)),
+		Root:         hex.EncodeToString(root),
+		CreatedAt:    time.Now().UTC(),
+		PublicKey:    base64.StdEncoding.EncodeToString(pub),
+	}
+	sig, err := signer.Sign(rand.Reader, b.payload())
+	if err != nil {
+		return nil, fmt.Errorf("signing batch: %w", err)
+	}
+	b.Signature = base64.StdEncoding.EncodeToString(sig.Blob)
+
+	res, err := l.db.Exec(`INSERT INTO audit_batches (first_event_id, last_event_id, count, root, created_at,
+		public_key, signature) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		b.FirstEventID, b.LastEventID, b.Count, b.Root, b.CreatedAt.Format(time.RFC3339Nano), b.PublicKey, b.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("writing batch: %w", err)
+	}
+	b.ID, _ = res.LastInsertId()
+	return &b, nil
+}
+
+// Batches returns all sealed batches, oldest first.
+func (l *Logger) Batches() ([]Batch, error) {
+	rows, err := l.db.Query(`SELECT id, first_event_id, last_event_id, count, root, created_at, public_key, signature
+		FROM audit_batches ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []Batch
+	for rows.Next() {
+		var b Batch
+		var created string
+		if err := rows.Scan(&b.ID, &b.FirstEventID, &b.LastEventID, &b.Count, &b.Root, &created,
+			&b.PublicKey, &b.Signature); err != nil {
+			return nil, err
+		}
+		b.CreatedAt, _ = time.Parse(time.RFC3339Nano, created)
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+// batchStatuses checks batch signatures and, where the events are still
+// present, recomputes the Merkle roots. Events may only be missing if they
+// were pruned, i.e. up to prunedThrough, the last event covered by the
+// anchor checkpoint; otherwise the batch has been tampered with.
+func (l *Logger) batchStatuses(key ssh.PublicKey, prunedThrough int64) ([]BatchStatus, error) {
+	batches, err := l.Batches()
+	if err != nil {
+		return nil, fmt.Errorf("reading batches: %w", err)
+	}
+	trusted, keyErr := ed25519Key(key)
+
+	statuses := make([]BatchStatus, len(batches))
+	for i, b := range batches {
+		statuses[i].Batch = b
+		if keyErr != nil {
+			statuses[i].Error = keyErr.Error()
+			continue
+		}
+		if err := b.verifySignature(trusted); err != nil {
+			statuses[i].Error = err.Error()
+			continue
+		}
+
+		ids, hashes, err := l.eventHashesRange(b.FirstEventID, b.LastEventID)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			if b.LastEventID > prunedThrough {
+				statuses[i].Error = fmt.Sprintf("all %d events missing but not covered by a checkpoint", b.Count)
+				continue
+			}
+			statuses[i].Valid = true
+			statuses[i].Pruned = true
+			continue
+		}
+		if int64(len(ids)) != b.Count {
+			// Only the head of the batch may be gone, removed by retention
+			if ids[0] > b.FirstEventID && prunedThrough >= ids[0]-1 {
+				statuses[i].Valid = true
+				statuses[i].Pruned = true
+				continue
+			}
+			statuses[i].Error = fmt.Sprintf("batch covers %d events, found %d not explained by pruning", b.Count, len(ids))
+			continue
+		}
+		leaves, err := leavesOf(hashes)
+		if err != nil {
+			statuses[i].Error = err.Error()
+			continue
+		}
+		if root, _ := merkleRoot(leaves, -1); hex.EncodeToString(root) != b.Root {
+			statuses[i].Error = "merkle root mismatch"
+			continue
+		}
+		statuses[i].Valid = true
+	}
+	return statuses, nil
+}
+
+// Proof builds an inclusion proof for an event from its sealed batch.
+func (l *Logger) Proof(eventID int64) (*InclusionProof, error) {
+	var b Batch
+	var created string
+	err := l.db.QueryRow(`SELECT id, first_event_id, last_event_id, count, root, created_at, public_key, signature
+		FROM audit_batches WHERE first_event_id <= ? AND last_event_id >= ?`, eventID, eventID).
+		Scan(&b.ID, &b.FirstEventID, &b.LastEventID, &b.Count, &b.Root, &created, &b.PublicKey, &b.Signature)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("event %d is not sealed in a batch yet", eventID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.CreatedAt, _ = time.Parse(time.RFC3339Nano, created)
+
+	ids, hashes, err := l.eventHashesRange(b.FirstEventID, b.LastEventID)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(ids)) != b.Count {
+		return nil, fmt.Errorf("batch %d is incomplete (%d of %d events present)", b.ID, len(ids), b.Count)
+	}
+	index := -1
+	for i, id := range ids {
+		if id == eventID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("event %d not found", eventID)
+	}
+
+	leaves, err := leavesOf(hashes)
+	if err != nil {
+		return nil, err
+	}
+	_, path := merkleRoot(leaves, index)
+
+	var e Event
+	var detailsStr string
+	err = l.db.QueryRow(`SELECT id, timestamp, action, user, session_id, project, tool, details, hash, prev_hash, hash_version
+		FROM audit_events WHERE id = ?`, eventID).Scan(
+		&e.ID, &e.Timestamp, &e.Action, &e.User, &e.SessionID, &e.Project, &e.Tool,
+		&detailsStr, &e.Hash, &e.PrevHash, &e.HashVersion)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(detailsStr), &e.Details)
+
+	return &InclusionProof{Event: e, DetailsJSON: detailsStr, Index: int64(index), Path: path, Batch: b}, nil
+}
+
+// VerifyInclusionProof checks a proof offline: the event hash is recomputed
+// from its fields and the details as stored, folded up the path to the batch
+// root, and the root's signature is checked. If trusted is non-nil the batch
+// must be signed by it.
+func VerifyInclusionProof(p InclusionProof, trusted ed25519.PublicKey) error {
+	// Hash the stored bytes: re-encoding the map can differ (null vs {})
+	var details map[string]string
+	if err := json.Unmarshal([]byte(p.DetailsJSON), &details); err != nil {
+		return fmt.Errorf("decoding proof details: %w", err)
+	}
+	if len(details) != len(p.Event.Details) {
+		return fmt.Errorf("event details do not match the hashed details")
+	}
+	for k, v := range details {
+		if got, ok := p.Event.Details[k]; !ok || got != v {
+			return fmt.Errorf("event details do not match the hashed details")
+		}
+	}
+	hash, err := eventHash(p.Event, p.DetailsJSON)
+	if err != nil {
+		return err
+	}
+	if hash != p.Event.Hash {
+		return fmt.Errorf("event hash mismatch: fields hash to %s, event claims %s", hash, p.Event.Hash)
+	}
+
+	node, err := merkleLeaf(hash)
+	if err != nil {
+		return err
+	}
+	for _, step := range p.Path {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return fmt.Errorf("invalid proof hash %q", step.Hash)
+		}
+		if step.Left {
+			node = merkleNode(sibling, node)
+		} else {
+			node = merkleNode(node, sibling)
+		}
+	}
+	if hex.EncodeToString(node) != p.Batch.Root {
+		return fmt.Errorf("proof does not lead to batch root %s", p.Batch.Root)
+	}
+	return p.Batch.verifySignature(trusted)
+}
+
+// RunBatcher seals pending events into signed batches every interval until
+// ctx is cancelled.
+func (l *Logger) RunBatcher(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+
+	seal := func() {
+		for {
+			b, err := l.SealBatch()
+			if err != nil {
+				log.Printf("Audit: sealing batch failed: %v", err)
+				return
+			}
+			if b == nil || b.Count < maxBatchSize {
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			seal()
+			return
+		case <-ticker.C:
+			seal()
+		}
+	}
+}
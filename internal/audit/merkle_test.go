@@ -0,0 +1,286 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestLogger returns a logger with a fresh Ed25519 CA signer.
+func newTestLogger(t *testing.T) (*Logger, ed25519.PublicKey) {
+	t.Helper()
+	l, err := NewLogger(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetSigner(signer)
+	return l, pub
+}
+
+func logEvents(t *testing.T, l *Logger, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		e := Event{Action: "tool.execute", User: "alice", Project: "/repos/api", Tool: "git"}
+		switch i % 3 {
+		case 1:
+			e.Details = map[string]string{"function": fmt.Sprintf("git_%d", i)}
+		case 2:
+			e.Details = map[string]string{} // stored as {}, omitted from JSON
+		}
+		if err := l.Log(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMerkleProofPaths(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([][]byte, n)
+		for i := range leaves {
+			leaf, err := merkleLeaf(hex.EncodeToString([]byte{byte(i)}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaves[i] = leaf
+		}
+		root, _ := merkleRoot(leaves, -1)
+		for i := range leaves {
+			r, path := merkleRoot(leaves, i)
+			node := leaves[i]
+			for _, step := range path {
+				sibling, _ := hex.DecodeString(step.Hash)
+				if step.Left {
+					node = merkleNode(sibling, node)
+				} else {
+					node = merkleNode(node, sibling)
+				}
+			}
+			if hex.EncodeToString(node) != hex.EncodeToString(root) || hex.EncodeToString(r) != hex.EncodeToString(root) {
+				t.Errorf("%d leaves: proof for leaf %d does not lead to the root", n, i)
+			}
+		}
+	}
+}
+
+func TestSealAndVerify(t *testing.T) {
+	l, pub := newTestLogger(t)
+	logEvents(t, l, 7)
+	b, err := l.SealBatch()
+	if err != nil || b == nil {
+		t.Fatalf("SealBatch = %v, %v", b, err)
+	}
+	if b.FirstEventID != 1 || b.LastEventID != 7 || b.Count != 7 {
+		t.Errorf("batch covers %d-%d (%d), want 1-7", b.FirstEventID, b.LastEventID, b.Count)
+	}
+	if b, err := l.SealBatch(); b != nil || err != nil {
+		t.Errorf("second SealBatch = %v, %v, want nothing to seal", b, err)
+	}
+
+	report, err := l.Verify()
+	if err != nil || !report.Valid {
+		t.Fatalf("Verify = %+v, %v", report, err)
+	}
+	if len(report.Batches) != 1 || !report.Batches[0].Valid || report.Batches[0].Pruned {
+		t.Errorf("batch status = %+v", report.Batches)
+	}
+
+	for id := int64(1); id <= 7; id++ {
+		proof, err := l.Proof(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Proofs are handed out as JSON and checked elsewhere
+		raw, _ := json.Marshal(proof)
+		var decoded InclusionProof
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyInclusionProof(decoded, pub); err != nil {
+			t.Errorf("proof for event %d: %v", id, err)
+		}
+	}
+}
+
+func TestVerifyInclusionProofRejects(t *testing.T) {
+	l, pub := newTestLogger(t)
+	logEvents(t, l, 4)
+	if _, err := l.SealBatch(); err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name    string
+		tamper  func(p *InclusionProof)
+		trusted ed25519.PublicKey
+		err     string
+	}{
+		{"changed user", func(p *InclusionProof) { p.Event.User = "mallory" }, pub, "event hash mismatch"},
+		{"changed details", func(p *InclusionProof) { p.Event.Details = map[string]string{"function": "git_push"} }, pub, "details do not match"},
+		{"changed stored details", func(p *InclusionProof) { p.DetailsJSON = `{"function":"git_push"}` }, pub, "details do not match"},
+		{"changed path", func(p *InclusionProof) { p.Path[0].Hash = strings.Repeat("0", 64) }, pub, "batch root"},
+		{"untrusted key", func(p *InclusionProof) {}, otherPub, "untrusted key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := l.Proof(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(p)
+			err = VerifyInclusionProof(*p, tt.trusted)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("VerifyInclusionProof error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		err  string
+	}{
+		{"event field", "UPDATE audit_events SET user = 'mallory' WHERE id = 3", "hash mismatch at event 3"},
+		{"project (HashV2)", "UPDATE audit_events SET project = '/repos/other' WHERE id = 3", "hash mismatch at event 3"},
+		{"deleted event", "DELETE FROM audit_events WHERE id = 3", "chain broken at event 4"},
+		{"batch root", "UPDATE audit_batches SET root = '" + strings.Repeat("0", 64) + "'", "batch 1 (events 1-5): invalid signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLogger(t)
+			logEvents(t, l, 5)
+			if _, err := l.SealBatch(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.db.Exec(tt.sql); err != nil {
+				t.Fatal(err)
+			}
+			report, err := l.Verify()
+			if report.Valid || err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Verify = valid %v, %v, want %q", report.Valid, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBatchMissingEvents(t *testing.T) {
+	l, _ := newTestLogger(t)
+	logEvents(t, l, 6)
+	if _, err := l.SealBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.db.Exec("DELETE FROM audit_events WHERE id <= 2"); err != nil {
+		t.Fatal(err)
+	}
+	key := l.verifyKey
+
+	tests := []struct {
+		name          string
+		prunedThrough int64
+		valid, pruned bool
+	}{
+		{"head deleted without checkpoint", 0, false, false},
+		{"checkpoint short of the gap", 1, false, false},
+		{"head pruned behind checkpoint", 2, true, true},
+	}
+	for _, tt := range tests {
+		statuses, err := l.batchStatuses(key, tt.prunedThrough)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := statuses[0]
+		if st.Valid != tt.valid || st.Pruned != tt.pruned {
+			t.Errorf("%s: valid %v pruned %v (%s), want valid %v pruned %v", tt.name, st.Valid, st.Pruned, st.Error, tt.valid, tt.pruned)
+		}
+	}
+
+	if _, err := l.db.Exec("DELETE FROM audit_events"); err != nil {
+		t.Fatal(err)
+	}
+	for through, valid := range map[int64]bool{5: false, 6: true} {
+		statuses, _ := l.batchStatuses(key, through)
+		if statuses[0].Valid != valid {
+			t.Errorf("all events gone, pruned through %d: valid %v (%s), want %v", through, statuses[0].Valid, statuses[0].Error, valid)
+		}
+	}
+}
+
+func TestHashVersions(t *testing.T) {
+	l, _ := newTestLogger(t)
+
+	// An event from before HashV2: the project is not covered
+	v1 := Event{Timestamp: time.Now(), Action: "session.connect", User: "alice", Project: "/repos/api", PrevHash: "genesis", HashVersion: HashV1}
+	hash, err := eventHash(v1, "null")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.db.Exec(`INSERT INTO audit_events (timestamp, action, user, project, details, hash, prev_hash, hash_version)
+		VALUES (?, ?, ?, ?, 'null', ?, ?, 1)`, v1.Timestamp, v1.Action, v1.User, v1.Project, hash, v1.PrevHash); err != nil {
+		t.Fatal(err)
+	}
+	l.lastHash = hash
+	logEvents(t, l, 2)
+
+	if report, err := l.Verify(); err != nil || report.Events != 3 {
+		t.Fatalf("mixed chain: Verify = %+v, %v", report, err)
+	}
+
+	if _, err := l.db.Exec("UPDATE audit_events SET project = '/repos/other' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Verify(); err != nil {
+		t.Errorf("HashV1 event should not cover the project: %v", err)
+	}
+
+	if _, err := l.db.Exec("UPDATE audit_events SET hash_version = 1 WHERE id = 3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Verify(); err == nil || !strings.Contains(err.Error(), "downgrade at event 3") {
+		t.Errorf("downgraded event 3: Verify error = %v", err)
+	}
+}
+
+func TestCanonicalEncodingV2(t *testing.T) {
+	e := Event{
+		Timestamp:   time.Date(2026, 3, 4, 12, 0, 0, 0, time.FixedZone("CET", 3600)),
+		Action:      "tool.execute",
+		PrevHash:    "genesis",
+		HashVersion: HashV2,
+	}
+	a, err := CanonicalEncoding(e, `{"b":"2","a":"1"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := CanonicalEncoding(e, `{"a":"1","b":"2"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) {
+		t.Errorf("details key order changes the encoding:\n%s\n%s", a, b)
+	}
+	if !strings.Contains(string(a), `"timestamp":"2026-03-04T11:00:00Z"`) {
+		t.Errorf("timestamp not normalised to UTC: %s", a)
+	}
+	if _, err := CanonicalEncoding(Event{HashVersion: 9}, "{}"); err == nil {
+		t.Error("unknown hash version accepted")
+	}
+}
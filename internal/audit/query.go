@@ -0,0 +1,200 @@
+package audit
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Actions counted by Stats.
+const (
+	ActionToolExecute = "tool.execute"
+	ActionModelCall   = "model.call"
+)
+
+// Page is one page of a cursor-paginated query.
+type Page struct {
+	Events     []Event `json:"events"`
+	NextCursor int64   `json:"next_cursor,omitempty"` // pass as QueryFilter.Cursor; 0 when done
+}
+
+// detailKeyPattern restricts details keys usable in filters and JSON paths.
+var detailKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// where builds the SQL condition for a filter.
+func (f QueryFilter) where() (string, []interface{}, error) {
+	conds := []string{"1=1"}
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+
+	if f.Cursor > 0 {
+		add("id < ?", f.Cursor)
+	}
+	if f.User != "" {
+		add("user = ?", f.User)
+	}
+	if f.Tool != "" {
+		add("tool = ?", f.Tool)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.ActionPrefix != "" {
+		add("substr(action, 1, ?) = ?", len(f.ActionPrefix))
+		args = append(args, f.ActionPrefix)
+	}
+	if f.SessionID != "" {
+		add("session_id = ?", f.SessionID)
+	}
+	if f.Project != "" {
+		add("project = ?", f.Project)
+	}
+	for k, v := range f.Details {
+		if !detailKeyPattern.MatchString(k) {
+			return "", nil, fmt.Errorf("invalid details key %q", k)
+		}
+		conds = append(conds, "json_extract(details, ?) = ?")
+		args = append(args, `$."`+k+`"`, v)
+	}
+	// Timestamps are stored in local time, compare in the same zone
+	if f.Since != nil {
+		add("timestamp >= ?", f.Since.Local())
+	}
+	if f.Until != nil {
+		add("timestamp <= ?", f.Until.Local())
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// ParseTime accepts a duration back from now ("24h", "7d"), a local date
+// ("2006-01-02") or an RFC 3339 timestamp.
+func ParseTime(s string) (time.Time, error) {
+	if strings.HasSuffix(s, "d") {
+		var days int
+		if _, err := fmt.Sscanf(s, "%dd", &days); err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use a duration (24h, 7d), a date or RFC 3339", s)
+}
+
+// QueryPage returns one page of events, newest first. Limit defaults to 50.
+func (l *Logger) QueryPage(filter QueryFilter) (Page, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 50
+	}
+	events, err := l.Query(filter)
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Events: events}
+	if len(events) == filter.Limit {
+		page.NextCursor = events[len(events)-1].ID
+	}
+	return page, nil
+}
+
+// ToolUsage counts tool executions per user, tool and day.
+type ToolUsage struct {
+	Day   string `json:"day"` // YYYY-MM-DD
+	User  string `json:"user"`
+	Tool  string `json:"tool"`
+	Count int64  `json:"count"`
+}
+
+// ProviderUsage counts model calls per provider and model.
+type ProviderUsage struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Count    int64  `json:"count"`
+}
+
+// DeniedUsage counts denied actions per action and user.
+type DeniedUsage struct {
+	Action string `json:"action"`
+	User   string `json:"user"`
+	Count  int64  `json:"count"`
+}
+
+// Stats aggregates audit events for compliance reports.
+type Stats struct {
+	Since          *time.Time      `json:"since,omitempty"`
+	Until          *time.Time      `json:"until,omitempty"`
+	TotalEvents    int64           `json:"total_events"`
+	ToolExecutions []ToolUsage     `json:"tool_executions"`
+	ModelCalls     []ProviderUsage `json:"model_calls"`
+	Denied         []DeniedUsage   `json:"denied"`
+}
+
+// Stats aggregates events matching the filter (Limit and Cursor are ignored).
+func (l *Logger) Stats(filter QueryFilter) (*Stats, error) {
+	filter.Limit, filter.Cursor = 0, 0
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{Since: filter.Since, Until: filter.Until}
+
+	if err := l.db.QueryRow("SELECT COUNT(*) FROM audit_events WHERE "+where, args...).Scan(&stats.TotalEvents); err != nil {
+		return nil, err
+	}
+
+	// The stored timestamp starts with the local date
+	rows, err := l.db.Query(`SELECT substr(timestamp, 1, 10), user, tool, COUNT(*) FROM audit_events
+		WHERE `+where+` AND action = ? GROUP BY 1, 2, 3 ORDER BY 1, 2, 3`, append(args, ActionToolExecute)...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var u ToolUsage
+		if err := rows.Scan(&u.Day, &u.User, &u.Tool, &u.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.ToolExecutions = append(stats.ToolExecutions, u)
+	}
+	rows.Close()
+
+	rows, err = l.db.Query(`SELECT COALESCE(json_extract(details, '$.provider'), ''), COALESCE(json_extract(details, '$.model'), ''),
+		COUNT(*) FROM audit_events WHERE `+where+` AND action = ? GROUP BY 1, 2 ORDER BY 3 DESC`, append(args, ActionModelCall)...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var u ProviderUsage
+		if err := rows.Scan(&u.Provider, &u.Model, &u.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.ModelCalls = append(stats.ModelCalls, u)
+	}
+	rows.Close()
+
+	rows, err = l.db.Query(`SELECT action, user, COUNT(*) FROM audit_events
+		WHERE `+where+` AND action LIKE '%.denied' GROUP BY 1, 2 ORDER BY 3 DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u DeniedUsage
+		if err := rows.Scan(&u.Action, &u.User, &u.Count); err != nil {
+			return nil, err
+		}
+		stats.Denied = append(stats.Denied, u)
+	}
+	return stats, rows.Err()
+}
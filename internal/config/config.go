@@ -23,6 +23,7 @@ type Config struct {
 	Index    IndexConfig    `toml:"index"`
 	Gateway  GatewayConfig  `toml:"gateway"`
 	Audit    AuditConfig    `toml:"audit"`
+	RBAC     RBACConfig     `toml:"rbac"`
 	AutoFix  AutoFixConfig  `toml:"autofix"`
 	Projects []ProjectEntry `toml:"projects"`
 }
@@ -37,40 +38,159 @@ type GeneralConfig struct {
 }
 
 type CAConfig struct {
-	CertLifetime        Duration `toml:"cert_lifetime"`
-	AutoRenewThreshold  float64  `toml:"auto_renew_threshold"` // percentage, e.g. 0.20
-	Algo                string   `toml:"algo"`
-	DeviceCertLifetime  Duration `toml:"device_cert_lifetime"`
-	MaxDevicesPerUser   int      `toml:"max_devices_per_user"`
-	PermissionsMode     string   `toml:"permissions_mode"`
-	AllowedDeviceTools  []string `toml:"allowed_device_tools"`
+	CertLifetime       Duration `toml:"cert_lifetime"`
+	AutoRenewThreshold float64  `toml:"auto_renew_threshold"` // percentage, e.g. 0.20
+	Algo               string   `toml:"algo"`
+	DeviceCertLifetime Duration `toml:"device_cert_lifetime"`
+	MaxDevicesPerUser  int      `toml:"max_devices_per_user"`
+	PermissionsMode    string   `toml:"permissions_mode"`
+	AllowedDeviceTools []string `toml:"allowed_device_tools"`
 }
 
 type AIConfig struct {
 	DefaultModel string           `toml:"default_model"`
 	Providers    []ProviderConfig `toml:"providers"`
 	Policies     []ModelPolicy    `toml:"policies"`
+	Retry        RetryConfig      `toml:"retry"`
+	Cache        CacheConfig      `toml:"cache"`
+	Firewall     FirewallConfig   `toml:"firewall"`
+
+	Classification []DataClassification `toml:"classification"`
+
+	Models []ModelConfig `toml:"models"`
+	Usage  UsageConfig   `toml:"usage"`
+
+	// Tasks routes task kinds (chat, summarize, classify, code_edit) to
+	// ordered provider or provider/model candidates, "local" for all local
+	// providers. Policies can override them per project.
+	Tasks map[string][]string `toml:"tasks"`
+}
+
+// UsageConfig controls the ledger of token usage per call and the budgets
+// that cap it.
+type UsageConfig struct {
+	Enabled  bool         `toml:"enabled"`
+	Path     string       `toml:"path"`     // SQLite file, default <data_dir>/usage.db
+	Currency string       `toml:"currency"` // label for costs, prices are in this currency
+	Prices   []ModelPrice `toml:"prices"`   // first match prices a call, unmatched calls cost 0
+	Budgets  []Budget     `toml:"budgets"`
+}
+
+// ModelPrice is the cost of a model per million tokens.
+type ModelPrice struct {
+	Match      string  `toml:"match"` // glob on "provider/model", e.g. "anthropic/claude-sonnet-4*"
+	Input      float64 `toml:"input"`
+	Output     float64 `toml:"output"`
+	CacheRead  float64 `toml:"cache_read"`  // prompt cache hits, default 0.1 × input
+	CacheWrite float64 `toml:"cache_write"` // prompt cache writes, default 1.25 × input
+}
+
+// Budget caps the cost of model calls per user or per project. Every user
+// or project matching the pattern has its own budget.
+type Budget struct {
+	Scope  string  `toml:"scope"`   // "user" or "project"
+	Match  string  `toml:"match"`   // glob on the user name, project path or directory name; "*" for everyone
+	Period string  `toml:"period"`  // "daily" or "monthly"
+	Limit  float64 `toml:"limit"`   // cost per period; calls are refused once it is reached
+	WarnAt float64 `toml:"warn_at"` // fraction of the limit that warns, default 0.8
+}
+
+// ModelConfig overrides the built-in capabilities of matching models, used
+// to budget requests to the model's context window.
+type ModelConfig struct {
+	Match         string   `toml:"match"`          // glob on "provider/model" or the model name, e.g. "ollama/qwen2.5-coder*"
+	ContextWindow int      `toml:"context_window"` // tokens; also sent to Ollama as num_ctx
+	MaxOutput     int      `toml:"max_output"`     // tokens the model can generate
+	Capabilities  []string `toml:"capabilities"`   // tools, vision
+	Family        string   `toml:"family"`         // for token estimates: claude, gpt, llama, mistral, qwen
+}
+
+// DataClassification labels files of matching projects by path. Content
+// from a file is only sent to providers cleared for its label.
+type DataClassification struct {
+	ProjectPattern string               `toml:"project_pattern"` // path or name glob like ai.policies, empty for all
+	Rules          []ClassificationRule `toml:"rules"`
+}
+
+// ClassificationRule labels the files matching a path glob.
+type ClassificationRule struct {
+	Path   string `toml:"path"`   // glob; no "/" matches the file name, a leading "/" anchors at the project root, ** spans directories
+	Label  string `toml:"label"`  // public, internal, confidential, restricted
+	Action string `toml:"action"` // for providers without clearance: "redact" (default) withholds the content, "block" fails the request
+}
+
+// FirewallConfig controls how secrets are hidden from model providers.
+type FirewallConfig struct {
+	Tokenize     bool           `toml:"tokenize"`      // reversible ⟦SECRET_n:label⟧ placeholders instead of [REDACTED]
+	Restore      []string       `toml:"restore"`       // where placeholders are restored: "responses", "tool_inputs"
+	Rules        []FirewallRule `toml:"rules"`         // added to the built-in rules, same id replaces one
+	DisableRules []string       `toml:"disable_rules"` // ids of built-in rules to turn off
+	Allowlist    []string       `toml:"allowlist"`     // regexes for values never redacted, e.g. test fixtures
+}
+
+// FirewallRule is a secret detection rule. The secret is the regex group
+// named "secret", or the value after the first ':' or '=' of the match.
+type FirewallRule struct {
+	ID        string   `toml:"id"`
+	Category  string   `toml:"category"` // redaction stats category, default the id
+	Regex     string   `toml:"regex"`
+	Keywords  []string `toml:"keywords"`  // one must appear within proximity chars before the secret
+	Proximity int      `toml:"proximity"` // chars before the match searched for a keyword
+	Entropy   float64  `toml:"entropy"`   // minimum Shannon entropy of the secret, bits per char
+}
+
+// CacheConfig controls the opt-in exact-match response cache. Requests with
+// temperature > 0 or tools that have side effects are never cached.
+type CacheConfig struct {
+	Enabled    bool     `toml:"enabled"`
+	Path       string   `toml:"path"`        // SQLite file, default <data_dir>/cache.db
+	TTL        Duration `toml:"ttl"`         // how long a response is reused
+	MaxEntries int      `toml:"max_entries"` // oldest entries are evicted beyond this
+	MaxSizeMB  int      `toml:"max_size_mb"` // total size of cached responses
+}
+
+// RetryConfig controls provider retries and the per-provider circuit breaker.
+type RetryConfig struct {
+	MaxAttempts      int      `toml:"max_attempts"`      // calls per provider, including the first
+	BaseDelay        Duration `toml:"base_delay"`        // first backoff, doubled per retry with jitter
+	MaxDelay         Duration `toml:"max_delay"`         // longer waits (incl. Retry-After) move to the next provider
+	BreakerWindow    int      `toml:"breaker_window"`    // recent calls considered for the error rate
+	BreakerThreshold float64  `toml:"breaker_threshold"` // error rate that opens the circuit, e.g. 0.5
+	BreakerCooldown  Duration `toml:"breaker_cooldown"`  // how long an open circuit skips the provider
 }
 
 type ProviderConfig struct {
-	Name     string `toml:"name"`     // anthropic, openai, ollama
-	Endpoint string `toml:"endpoint"` // URL
+	Name     string `toml:"name"`     // anthropic, openai, ollama, or any name for type openai_compatible
+	Type     string `toml:"type"`     // "openai_compatible" for vLLM, LM Studio etc.; default: the name
+	Endpoint string `toml:"endpoint"` // URL; base URL incl. /v1 for openai_compatible
 	APIKey   string `toml:"api_key"`  // keychain reference, not plaintext
 	Model    string `toml:"model"`    // default model for this provider
+
+	// openai_compatible only
+	AuthHeader    string   `toml:"auth_header"`    // header for api_key, default Authorization (sent as Bearer)
+	Models        []string `toml:"models"`         // served models; queried from /models if empty
+	ContextWindow int      `toml:"context_window"` // tokens, 0 if unknown
+	Capabilities  []string `toml:"capabilities"`   // tools, streaming, json_mode, vision
+	Local         bool     `toml:"local"`          // runs inside the network, matches "local" in policies
+	Clearance     string   `toml:"clearance"`      // most sensitive data label it may receive, default restricted if local, else internal; unknown is public
 }
 
 type ModelPolicy struct {
 	ProjectPattern   string   `toml:"project_pattern"`
-	AllowedProviders []string `toml:"allowed_providers"`
+	AllowedProviders []string `toml:"allowed_providers"` // provider names, "local" for all local providers
+	Fallback         []string `toml:"fallback"`          // ordered provider or provider/model chain, must be allowed
 	Reason           string   `toml:"reason"`
+	MaskSecrets      bool     `toml:"mask_secrets"` // never restore secret placeholders for this project
+
+	Tasks map[string][]string `toml:"tasks"` // task routes for this project, override [ai.tasks]
 }
 
 type SandboxConfig struct {
-	Enabled      bool   `toml:"enabled"`
-	DockerSocket string `toml:"docker_socket"`
-	NetworkMode  string `toml:"network_mode"`
-	CPULimit     string `toml:"cpu_limit"`
-	MemoryLimit  string `toml:"memory_limit"`
+	Enabled      bool     `toml:"enabled"`
+	DockerSocket string   `toml:"docker_socket"`
+	NetworkMode  string   `toml:"network_mode"`
+	CPULimit     string   `toml:"cpu_limit"`
+	MemoryLimit  string   `toml:"memory_limit"`
 	Timeout      Duration `toml:"timeout"`
 }
 
@@ -132,34 +252,58 @@ type GitHubConfig struct {
 type IndexConfig struct {
 	Enabled         bool   `toml:"enabled"`
 	BackgroundWatch bool   `toml:"background_watch"`
-	EmbeddingModel  string `toml:"embedding_model"`
+	EmbeddingModel  string `toml:"embedding_model"` // provider/model, default ollama/nomic-embed-text
 }
 
 type GatewayConfig struct {
-	Host      string   `toml:"host"`
-	Port      int      `toml:"port"`
-	WebUIPort int      `toml:"webui_port"`
-	TLS       bool     `toml:"tls"`
-	CertFile  string   `toml:"cert_file"`
-	KeyFile   string   `toml:"key_file"`
+	Host      string `toml:"host"`
+	Port      int    `toml:"port"`
+	WebUIPort int    `toml:"webui_port"`
+	TLS       bool   `toml:"tls"`
+	CertFile  string `toml:"cert_file"`
+	KeyFile   string `toml:"key_file"`
 }
 
 type AuditConfig struct {
-	Enabled    bool   `toml:"enabled"`
-	DBPath     string `toml:"db_path"`
-	RetainDays int    `toml:"retain_days"`
+	Enabled           bool     `toml:"enabled"`
+	DBPath            string   `toml:"db_path"`
+	RetainDays        int      `toml:"retain_days"`
+	RetentionInterval Duration `toml:"retention_interval"` // how often events older than retain_days are pruned
+	BatchInterval     Duration `toml:"batch_interval"`     // how often new events are sealed under a signed Merkle root
+
+	Forward AuditForwardConfig `toml:"forward"`
+}
+
+// AuditForwardConfig streams audit events to a SIEM.
+type AuditForwardConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	Sink     string   `toml:"sink"`     // syslog, file
+	Network  string   `toml:"network"`  // tcp, udp (syslog sink)
+	Address  string   `toml:"address"`  // host:port (syslog sink)
+	Path     string   `toml:"path"`     // output file (file sink)
+	Format   string   `toml:"format"`   // jsonl, cef, leef
+	Facility string   `toml:"facility"` // syslog facility, default local0
+	SDID     string   `toml:"sd_id"`    // syslog structured data ID, name@<your IANA enterprise number>
+	Interval Duration `toml:"interval"` // poll interval when idle
+}
+
+type RBACConfig struct {
+	PolicyFile     string   `toml:"policy_file"`     // default: <data_dir>/rbac.yaml
+	ReloadInterval Duration `toml:"reload_interval"` // how often the policy file is checked for changes
+	DefaultRole    string   `toml:"default_role"`    // role for gateway callers without a client certificate; "" requires one
+	LocalRole      string   `toml:"local_role"`      // role of the local user in CLI sessions
 }
 
 type AutoFixConfig struct {
-	DefaultPolicy string            `toml:"default_policy"` // notify_only, fix_and_pr, fix_and_merge
-	MaxAutoFixes  int               `toml:"max_auto_fixes"`
-	EscalateAfter Duration          `toml:"escalate_after"`
-	RepoPolicies  []RepoFixPolicy   `toml:"repo_policies"`
+	DefaultPolicy string          `toml:"default_policy"` // notify_only, fix_and_pr, fix_and_merge
+	MaxAutoFixes  int             `toml:"max_auto_fixes"`
+	EscalateAfter Duration        `toml:"escalate_after"`
+	RepoPolicies  []RepoFixPolicy `toml:"repo_policies"`
 }
 
 type RepoFixPolicy struct {
-	Repo    string           `toml:"repo"`
-	Rules   []BranchFixRule  `toml:"rules"`
+	Repo  string          `toml:"repo"`
+	Rules []BranchFixRule `toml:"rules"`
 }
 
 type BranchFixRule struct {
@@ -215,6 +359,39 @@ func DefaultConfig() *Config {
 		},
 		AI: AIConfig{
 			DefaultModel: "ollama/codestral",
+			Retry: RetryConfig{
+				MaxAttempts:      3,
+				BaseDelay:        Duration{500 * time.Millisecond},
+				MaxDelay:         Duration{20 * time.Second},
+				BreakerWindow:    20,
+				BreakerThreshold: 0.5,
+				BreakerCooldown:  Duration{30 * time.Second},
+			},
+			Cache: CacheConfig{
+				TTL:        Duration{24 * time.Hour},
+				MaxEntries: 10000,
+				MaxSizeMB:  100,
+			},
+			Firewall: FirewallConfig{
+				Tokenize: true,
+				Restore:  []string{"responses", "tool_inputs"},
+			},
+			Tasks: map[string][]string{
+				"summarize": {"local"},
+				"classify":  {"local"},
+			},
+			Usage: UsageConfig{
+				Enabled:  true,
+				Currency: "USD",
+				Prices: []ModelPrice{
+					{Match: "anthropic/claude-opus-4*", Input: 15, Output: 75},
+					{Match: "anthropic/claude-sonnet-4*", Input: 3, Output: 15},
+					{Match: "anthropic/claude-haiku-4*", Input: 1, Output: 5},
+					{Match: "openai/gpt-4o-mini*", Input: 0.15, Output: 0.6},
+					{Match: "openai/gpt-4o*", Input: 2.5, Output: 10},
+					{Match: "openai/gpt-4.1*", Input: 2, Output: 8},
+				},
+			},
 		},
 		Sandbox: SandboxConfig{
 			Enabled:     true,
@@ -244,8 +421,21 @@ func DefaultConfig() *Config {
 			WebUIPort: 18789,
 		},
 		Audit: AuditConfig{
-			Enabled:    true,
-			RetainDays: 90,
+			Enabled:           true,
+			RetainDays:        90,
+			RetentionInterval: Duration{time.Hour},
+			BatchInterval:     Duration{15 * time.Minute},
+			Forward: AuditForwardConfig{
+				Sink:     "syslog",
+				Network:  "tcp",
+				Format:   "cef",
+				Facility: "local0",
+				Interval: Duration{10 * time.Second},
+			},
+		},
+		RBAC: RBACConfig{
+			ReloadInterval: Duration{5 * time.Second},
+			LocalRole:      "developer",
 		},
 		AutoFix: AutoFixConfig{
 			DefaultPolicy: "notify_only",
@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greencode/greenforge/internal/rbac"
+	"golang.org/x/crypto/ssh"
+)
+
+// Clients authenticate with their GreenForge SSH certificate: the
+// certificate in authorized_keys format, a timestamp, a single-use nonce,
+// and a signature by the certificate's key over the timestamp, the nonce,
+// the request line and a digest of the body.
+const (
+	certHeader      = "X-GreenForge-Cert"
+	dateHeader      = "X-GreenForge-Date"
+	nonceHeader     = "X-GreenForge-Nonce"
+	signatureHeader = "X-GreenForge-Signature"
+)
+
+// maxClockSkew is how far the signed timestamp may be from the gateway clock.
+const maxClockSkew = 5 * time.Minute
+
+// SetUserCA sets the CA public key client certificates must be signed by.
+func (s *Server) SetUserCA(pub ssh.PublicKey) {
+	s.userCA = pub
+}
+
+// SignRequest adds the certificate headers to a request to the gateway,
+// signed with the private key of cert. The body is read and restored.
+func SignRequest(req *http.Request, cert *ssh.Certificate, signer ssh.Signer) error {
+	body, err := readBody(req)
+	if err != nil {
+		return fmt.Errorf("signing request: %w", err)
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("signing request: %w", err)
+	}
+	nonce := hex.EncodeToString(raw)
+	date := time.Now().UTC().Format(time.RFC3339)
+	sig, err := signer.Sign(rand.Reader, signedData(date, nonce, req.Method, req.URL.Path, body))
+	if err != nil {
+		return fmt.Errorf("signing request: %w", err)
+	}
+	req.Header.Set(certHeader, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))))
+	req.Header.Set(dateHeader, date)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, base64.StdEncoding.EncodeToString(ssh.Marshal(sig)))
+	return nil
+}
+
+func signedData(date, nonce, method, path string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	return []byte("greenforge-gateway\n" + date + "\n" + nonce + "\n" + method + " " + path + "\n" + hex.EncodeToString(digest[:]))
+}
+
+// readBody reads a request body and puts it back for the handler.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// nonceCache remembers the nonces of accepted requests until their
+// timestamp is outside the clock skew, so a signed request is accepted once.
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // nonce -> when it may be forgotten
+}
+
+// use records a nonce and reports whether it was new.
+func (c *nonceCache) use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	for n, until := range c.seen {
+		if now.After(until) {
+			delete(c.seen, n)
+		}
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = now.Add(2 * maxClockSkew)
+	return true
+}
+
+// identify returns the caller of a request. A client certificate must be
+// signed by the user CA and the request signed by its key, with a nonce not
+// seen before; user, role and device then come from the certificate.
+// Without one the caller gets rbac.default_role, and is refused if that is
+// empty.
+func (s *Server) identify(r *http.Request) (rbac.Identity, error) {
+	certLine := r.Header.Get(certHeader)
+	if certLine == "" {
+		if s.cfg.RBAC.DefaultRole == "" {
+			return rbac.Identity{}, fmt.Errorf("client certificate required")
+		}
+		return rbac.Identity{Role: s.cfg.RBAC.DefaultRole}, nil
+	}
+	if s.userCA == nil {
+		return rbac.Identity{}, fmt.Errorf("client certificates cannot be verified, no user CA key")
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certLine))
+	if err != nil {
+		return rbac.Identity{}, fmt.Errorf("parsing client certificate: %w", err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return rbac.Identity{}, fmt.Errorf("client key is not a certificate")
+	}
+	id := rbac.CertIdentity(cert)
+	// CheckCert verifies the signature but not who made it
+	if cert.CertType != ssh.UserCert || !bytes.Equal(cert.SignatureKey.Marshal(), s.userCA.Marshal()) {
+		return rbac.Identity{}, fmt.Errorf("client certificate is not signed by the user CA")
+	}
+	checker := &ssh.CertChecker{}
+	if err := checker.CheckCert(id.User, cert); err != nil {
+		return rbac.Identity{}, fmt.Errorf("client certificate: %w", err)
+	}
+	if id.Role == "" {
+		return rbac.Identity{}, fmt.Errorf("client certificate has no greenforge-role extension")
+	}
+
+	date := r.Header.Get(dateHeader)
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return rbac.Identity{}, fmt.Errorf("invalid %s header", dateHeader)
+	}
+	if skew := time.Since(t); skew > maxClockSkew || skew < -maxClockSkew {
+		return rbac.Identity{}, fmt.Errorf("request signature expired")
+	}
+	nonce := r.Header.Get(nonceHeader)
+	if len(nonce) < 16 {
+		return rbac.Identity{}, fmt.Errorf("invalid %s header", nonceHeader)
+	}
+	raw, err := base64.StdEncoding.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil {
+		return rbac.Identity{}, fmt.Errorf("invalid %s header", signatureHeader)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(raw, &sig); err != nil {
+		return rbac.Identity{}, fmt.Errorf("invalid %s header", signatureHeader)
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return rbac.Identity{}, err
+	}
+	if err := cert.Key.Verify(signedData(date, nonce, r.Method, r.URL.Path, body), &sig); err != nil {
+		return rbac.Identity{}, fmt.Errorf("request signature does not match the client certificate")
+	}
+	// Only after the signature checks out, so forged requests cannot burn nonces
+	if !s.nonces.use(nonce, time.Now()) {
+		return rbac.Identity{}, fmt.Errorf("request nonce already used")
+	}
+	return id, nil
+}
+
+// owns reports whether a caller may use a session: sessions opened with a
+// certificate belong to its user.
+func owns(id rbac.Identity, session *Session) bool {
+	return session.User == "" || session.User == id.User
+}
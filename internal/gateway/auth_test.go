@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greencode/greenforge/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// testClient returns a gateway trusting a fresh user CA and a certificate
+// for alice signed by it, with the matching signer.
+func testClient(t *testing.T) (*Server, *ssh.Certificate, ssh.Signer) {
+	t.Helper()
+	ca, user := newSigner(t), newSigner(t)
+	cert := &ssh.Certificate{
+		Key:             user.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "alice",
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		Permissions: ssh.Permissions{Extensions: map[string]string{
+			"greenforge-role@greenforge.dev": "developer",
+		}},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(config.DefaultConfig(), nil, nil)
+	s.SetUserCA(ca.PublicKey())
+	return s, cert, user
+}
+
+func signedRequest(t *testing.T, cert *ssh.Certificate, signer ssh.Signer, body string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/v1/chat", strings.NewReader(body))
+	if err := SignRequest(req, cert, signer); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestIdentify(t *testing.T) {
+	s, cert, signer := testClient(t)
+
+	req := signedRequest(t, cert, signer, `{"message":"hi"}`)
+	id, err := s.identify(req)
+	if err != nil {
+		t.Fatalf("identify: %v", err)
+	}
+	if id.User != "alice" || id.Role != "developer" {
+		t.Errorf("identity = %+v, want alice as developer", id)
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != `{"message":"hi"}` {
+		t.Errorf("body after identify = %q", body)
+	}
+}
+
+func TestIdentifyRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(s *Server, req *http.Request) *http.Request
+		err    string
+	}{
+		{"replayed request", func(s *Server, req *http.Request) *http.Request {
+			replay := req.Clone(req.Context())
+			replay.Body = io.NopCloser(strings.NewReader(`{"message":"hi"}`))
+			if _, err := s.identify(req); err != nil {
+				t.Fatalf("first request refused: %v", err)
+			}
+			return replay
+		}, "nonce already used"},
+		{"changed body", func(s *Server, req *http.Request) *http.Request {
+			req.Body = io.NopCloser(strings.NewReader(`{"message":"rm -rf"}`))
+			return req
+		}, "signature does not match"},
+		{"changed path", func(s *Server, req *http.Request) *http.Request {
+			req.URL.Path = "/api/v1/admin"
+			return req
+		}, "signature does not match"},
+		{"changed nonce", func(s *Server, req *http.Request) *http.Request {
+			req.Header.Set(nonceHeader, strings.Repeat("0", 32))
+			return req
+		}, "signature does not match"},
+		{"missing nonce", func(s *Server, req *http.Request) *http.Request {
+			req.Header.Del(nonceHeader)
+			return req
+		}, "invalid " + nonceHeader},
+		{"old date", func(s *Server, req *http.Request) *http.Request {
+			req.Header.Set(dateHeader, time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+			return req
+		}, "expired"},
+		{"other CA", func(s *Server, req *http.Request) *http.Request {
+			s.SetUserCA(newSigner(t).PublicKey())
+			return req
+		}, "client certificate"},
+		{"no certificate", func(s *Server, req *http.Request) *http.Request {
+			req.Header.Del(certHeader)
+			return req
+		}, "client certificate required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, cert, signer := testClient(t)
+			req := tt.tamper(s, signedRequest(t, cert, signer, `{"message":"hi"}`))
+			_, err := s.identify(req)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("identify error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIdentifyDefaultRole(t *testing.T) {
+	s, _, _ := testClient(t)
+	s.cfg.RBAC.DefaultRole = "viewer"
+	id, err := s.identify(httptest.NewRequest("GET", "/api/v1/status", nil))
+	if err != nil || id.Role != "viewer" {
+		t.Errorf("identify = %+v, %v, want the default role", id, err)
+	}
+}
@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/greencode/greenforge/internal/audit"
+	"github.com/greencode/greenforge/internal/config"
+	"github.com/greencode/greenforge/internal/model"
+)
+
+// ImageRef identifies an image stored with a session. Chat messages refer
+// to images by ID; the data is read from disk for every model call.
+type ImageRef struct {
+	ID        string `json:"id"`
+	MediaType string `json:"media_type"`
+	Size      int    `json:"size"`
+	Name      string `json:"name,omitempty"`
+}
+
+var imageExt = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// sessionDir is where a session's files are stored.
+func sessionDir(sessionID string) string {
+	return filepath.Join(config.GreenForgeHome(), "sessions", sessionID)
+}
+
+// saveImage checks an image and stores it with the session under a hash of
+// its content, so the same screenshot pasted twice is stored once.
+func saveImage(sessionID, name string, data []byte) (ImageRef, error) {
+	img, err := model.NewImage(name, data)
+	if err != nil {
+		return ImageRef{}, err
+	}
+	if name != "" {
+		name = filepath.Base(name)
+	}
+	sum := sha256.Sum256(data)
+	ref := ImageRef{ID: hex.EncodeToString(sum[:16]), MediaType: img.MediaType, Size: len(data), Name: name}
+
+	dir := filepath.Join(sessionDir(sessionID), "images")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ImageRef{}, fmt.Errorf("creating image directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ref.ID+imageExt[img.MediaType]), data, 0600); err != nil {
+		return ImageRef{}, fmt.Errorf("storing image: %w", err)
+	}
+	return ref, nil
+}
+
+// loadImage reads an image of the session by its ID.
+func loadImage(sessionID, id string) (model.Image, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return model.Image{}, fmt.Errorf("invalid image id %q", id)
+	}
+	for _, ext := range imageExt {
+		data, err := os.ReadFile(filepath.Join(sessionDir(sessionID), "images", id+ext))
+		if err == nil {
+			return model.NewImage(id+ext, data)
+		}
+	}
+	return model.Image{}, fmt.Errorf("image %s not found in session %s", id, sessionID)
+}
+
+// loadImages reads the images of a message.
+func loadImages(sessionID string, ids []string) ([]model.Image, error) {
+	var images []model.Image
+	for _, id := range ids {
+		img, err := loadImage(sessionID, id)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// removeSessionFiles deletes the files stored with a session.
+func removeSessionFiles(sessionID string) {
+	if err := os.RemoveAll(sessionDir(sessionID)); err != nil {
+		log.Printf("Warning: removing files of session %s: %v", sessionID, err)
+	}
+}
+
+// removeStaleSessionFiles deletes files left by an earlier run. Sessions
+// live in memory, so they belong to no session now.
+func removeStaleSessionFiles() {
+	entries, _ := os.ReadDir(filepath.Join(config.GreenForgeHome(), "sessions"))
+	for _, e := range entries {
+		if e.IsDir() {
+			removeSessionFiles(e.Name())
+		}
+	}
+}
+
+// handleImages uploads an image to a session (POST, multipart field
+// "image" or the raw image as body) or returns a stored one (GET with id).
+func (s *Server) handleImages(w http.ResponseWriter, r *http.Request) {
+	id, err := s.identify(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	session := s.sessions.Get(r.URL.Query().Get("session"))
+	if session == nil || !owns(id, session) {
+		http.Error(w, `{"error":"session not found"}`, http.StatusNotFound)
+		return
+	}
+	ctx := s.sessionContext(r.Context(), session, "")
+
+	switch r.Method {
+	case http.MethodGet:
+		if err := s.authorize(ctx, session, "session:read"); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		img, err := loadImage(session.ID, r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", img.MediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(img.Data)))
+		w.Write(img.Data)
+	case http.MethodPost:
+		if err := s.authorize(ctx, session, "session:write"); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, model.MaxImageBytes+64<<10)
+		name, data, err := readUpload(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ref, err := s.storeImage(session, name, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ref)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readUpload returns the uploaded file of a multipart form, or the body.
+func readUpload(r *http.Request) (string, []byte, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("image")
+		if err != nil {
+			return "", nil, fmt.Errorf("reading form field image: %w", err)
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		return header.Filename, data, err
+	}
+	data, err := io.ReadAll(r.Body)
+	return r.URL.Query().Get("name"), data, err
+}
+
+// storeImage saves an uploaded image with the session and audits it.
+func (s *Server) storeImage(session *Session, name string, data []byte) (ImageRef, error) {
+	ref, err := saveImage(session.ID, name, data)
+	if err != nil {
+		return ImageRef{}, err
+	}
+	s.auditor.Log(audit.Event{
+		Action:    "session.image",
+		User:      session.User,
+		SessionID: session.ID,
+		Project:   session.Project,
+		Details: map[string]string{
+			"image":      ref.ID,
+			"media_type": ref.MediaType,
+			"size":       strconv.Itoa(ref.Size),
+		},
+	})
+	return ref, nil
+}
@@ -3,11 +3,15 @@ package gateway
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,22 +26,26 @@ import (
 	"github.com/greencode/greenforge/internal/index"
 	"github.com/greencode/greenforge/internal/model"
 	"github.com/greencode/greenforge/internal/rbac"
+	"golang.org/x/crypto/ssh"
 )
 
 // Server is the main GreenForge gateway server handling WebSocket and REST.
 type Server struct {
-	cfg              *config.Config
-	sessions         *SessionManager
-	rbacEngine       *rbac.Engine
-	auditor          *audit.Logger
-	agentFn          func(cfg *config.Config) *agent.Runtime
-	router           *model.Router
-	webUI            *WebUIServer
-	indexEngine      *index.Engine
-	digestScheduler  *digest.Scheduler
-	pipelineWatcher  *autofix.Watcher
-	upgrader         websocket.Upgrader
-	mu               sync.RWMutex
+	cfg             *config.Config
+	sessions        *SessionManager
+	rbacEngine      *rbac.Engine
+	auditor         *audit.Logger
+	agentFn         func(cfg *config.Config) *agent.Runtime
+	router          *model.Router
+	webUI           *WebUIServer
+	indexEngine     *index.Engine
+	digestScheduler *digest.Scheduler
+	pipelineWatcher *autofix.Watcher
+	ledger          *model.UsageLedger
+	userCA          ssh.PublicKey // verifies client certificates
+	nonces          nonceCache    // request nonces seen within maxClockSkew
+	upgrader        websocket.Upgrader
+	mu              sync.RWMutex
 }
 
 // NewServer creates a new gateway server.
@@ -73,6 +81,11 @@ func (s *Server) SetWebUI(webUI *WebUIServer) {
 	s.webUI = webUI
 }
 
+// SetUsageLedger sets the ledger behind the usage API.
+func (s *Server) SetUsageLedger(ledger *model.UsageLedger) {
+	s.ledger = ledger
+}
+
 // SetIndexEngine sets the codebase index engine reference.
 func (s *Server) SetIndexEngine(engine *index.Engine) {
 	s.indexEngine = engine
@@ -90,6 +103,7 @@ func (s *Server) SetPipelineWatcher(watcher *autofix.Watcher) {
 
 // Start begins listening for connections.
 func (s *Server) Start(ctx context.Context) error {
+	removeStaleSessionFiles()
 	mux := http.NewServeMux()
 
 	// WebSocket endpoint for agent sessions
@@ -99,6 +113,9 @@ func (s *Server) Start(ctx context.Context) error {
 	mux.HandleFunc("/api/v1/sessions", s.handleSessions)
 	mux.HandleFunc("/api/v1/health", s.handleHealth)
 	mux.HandleFunc("/api/v1/audit", s.handleAudit)
+	mux.HandleFunc("/api/v1/audit/stats", s.handleAuditStats)
+	mux.HandleFunc("/api/v1/usage", s.handleUsage)
+	mux.HandleFunc("/api/v1/images", s.handleImages)
 
 	// Web UI routes (models, config, chat, static files)
 	if s.webUI != nil {
@@ -124,6 +141,9 @@ func (s *Server) Start(ctx context.Context) error {
 		webMux.HandleFunc("/api/v1/sessions", s.handleSessions)
 		webMux.HandleFunc("/api/v1/health", s.handleHealth)
 		webMux.HandleFunc("/api/v1/audit", s.handleAudit)
+		webMux.HandleFunc("/api/v1/audit/stats", s.handleAuditStats)
+		webMux.HandleFunc("/api/v1/usage", s.handleUsage)
+		webMux.HandleFunc("/api/v1/images", s.handleImages)
 		if s.webUI != nil {
 			s.webUI.SetupRoutes(webMux)
 		}
@@ -163,6 +183,11 @@ func (s *Server) Start(ctx context.Context) error {
 }
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	id, err := s.identify(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
@@ -176,18 +201,20 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	var session *Session
 	if sessionID != "" {
 		session = s.sessions.Get(sessionID)
-		if session == nil {
+		if session == nil || !owns(id, session) {
 			conn.WriteJSON(WSMessage{Type: "error", Data: "session not found"})
 			conn.Close()
 			return
 		}
 	} else {
 		session = s.sessions.Create(project)
+		session.setIdentity(id)
 	}
 
 	// Audit: session connected
 	s.auditor.Log(audit.Event{
 		Action:    "session.connect",
+		User:      id.User,
 		SessionID: session.ID,
 		Project:   project,
 		Details:   map[string]string{"remote_addr": r.RemoteAddr},
@@ -211,12 +238,18 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 		sessions := s.sessions.List()
 		json.NewEncoder(w).Encode(sessions)
 	case http.MethodPost:
+		id, err := s.identify(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
 		var req struct {
 			Project  string   `json:"project"`
 			Projects []string `json:"projects"`
 		}
 		json.NewDecoder(r.Body).Decode(&req)
 		session := s.sessions.Create(req.Project)
+		session.setIdentity(id)
 		if len(req.Projects) > 0 {
 			session.Projects = req.Projects
 		}
@@ -234,6 +267,12 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		closed := s.sessions.Close(req.ID)
+		if closed && s.router != nil {
+			s.router.ForgetSession(req.ID)
+		}
+		if closed {
+			removeSessionFiles(req.ID)
+		}
 		json.NewEncoder(w).Encode(map[string]interface{}{"closed": closed, "id": req.ID})
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -247,48 +286,295 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleAudit lists audit events newest first. Filters: user, tool, action,
+// action_prefix, project, session, since, until and repeated details=key=value.
+// Pass next_cursor from the response as cursor to fetch the next page.
 func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
-	events, err := s.auditor.Query(audit.QueryFilter{Limit: 50})
+	q := r.URL.Query()
+	filter, err := auditFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Limit = 50
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = min(n, 1000)
+	}
+	if v := q.Get("cursor"); v != "" {
+		if filter.Cursor, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := s.auditor.QueryPage(filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(events)
+	if page.Events == nil {
+		page.Events = []audit.Event{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
 }
 
-// getIndexContext loads summaries from all indexed projects for AI context.
-func (s *Server) getIndexContext() string {
-	indexDir := filepath.Join(config.GreenForgeHome(), "index")
-	entries, err := os.ReadDir(indexDir)
+// handleAuditStats returns tool executions per user/tool/day, model calls per
+// provider and denied actions. since defaults to 30 days.
+func (s *Server) handleAuditStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("since") == "" {
+		q.Set("since", "30d")
+	}
+	filter, err := auditFilter(q)
 	if err != nil {
-		return ""
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := s.auditor.Stats(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
 
-	var context string
-	for _, entry := range entries {
-		if !strings.HasSuffix(entry.Name(), ".db") {
-			continue
+// handleUsage returns model calls, tokens and cost grouped by user, project,
+// provider, model, session or day (by, default project), and the state of
+// every budget. Filters: user, project, since (default 30 days) and until.
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if s.ledger == nil {
+		http.Error(w, `{"error":"usage ledger disabled"}`, http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	filter := model.UsageFilter{User: q.Get("user"), Project: q.Get("project"), GroupBy: q.Get("by")}
+	if filter.GroupBy == "" {
+		filter.GroupBy = "project"
+	}
+	if q.Get("since") == "" {
+		q.Set("since", "30d")
+	}
+	for name, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := audit.ParseTime(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %v", name, err), http.StatusBadRequest)
+				return
+			}
+			*dst = &t
 		}
-		projectName := strings.TrimSuffix(entry.Name(), ".db")
-		dbPath := filepath.Join(indexDir, entry.Name())
-		idx, err := index.NewEngine(dbPath)
-		if err != nil {
-			continue
+	}
+	rows, err := s.ledger.Report(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	budgets, err := s.ledger.Budgets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rows == nil {
+		rows = []model.UsageRow{}
+	}
+	if budgets == nil {
+		budgets = []model.BudgetStatus{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"currency": s.ledger.Currency(),
+		"by":       filter.GroupBy,
+		"rows":     rows,
+		"budgets":  budgets,
+	})
+}
+
+// auditFilter parses the audit query parameters shared by list and stats.
+func auditFilter(q url.Values) (audit.QueryFilter, error) {
+	filter := audit.QueryFilter{
+		User:         q.Get("user"),
+		Tool:         q.Get("tool"),
+		Action:       q.Get("action"),
+		ActionPrefix: q.Get("action_prefix"),
+		Project:      q.Get("project"),
+		SessionID:    q.Get("session"),
+	}
+	for _, kv := range q["details"] {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return filter, fmt.Errorf("invalid details filter %q: use key=value", kv)
+		}
+		if filter.Details == nil {
+			filter.Details = make(map[string]string)
 		}
-		summary := idx.GetContextSummary(projectName)
-		idx.Close()
-		if summary != "" {
-			context += "\n" + summary
+		filter.Details[k] = v
+	}
+	for name, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := audit.ParseTime(v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = &t
 		}
 	}
+	return filter, nil
+}
+
+// sessionContext builds the request context for a session: the project for
+// model policy routing plus the caller identity and attributes used by
+// conditional RBAC grants.
+func (s *Server) sessionContext(ctx context.Context, session *Session, workingDir string) context.Context {
+	project := session.project(workingDir)
+	if project != "" {
+		ctx = model.WithProject(ctx, project)
+	}
+	ctx = model.WithSession(ctx, session.ID)
+
+	id := rbac.Identity{User: session.User, Role: session.Role, Device: session.Device, Cert: session.cert}
+	if id.Role == "" {
+		id.Role = s.cfg.RBAC.DefaultRole
+	}
+	ctx = rbac.WithIdentity(ctx, id)
+
+	return rbac.WithAttributes(ctx, rbac.Attributes{
+		Project:  project,
+		Branch:   CurrentBranch(workingDir),
+		CertType: id.CertType(),
+		Device:   id.Device,
+		Time:     time.Now(),
+	})
+}
+
+// authorize checks a permission for the session's identity and audits denials.
+func (s *Server) authorize(ctx context.Context, session *Session, perm string) error {
+	if s.rbacEngine == nil {
+		return nil
+	}
+	id, _ := rbac.IdentityFromContext(ctx)
+	d := s.rbacEngine.Explain(ctx, id.Role, rbac.ParsePermission(perm))
+	if d.Allowed {
+		return nil
+	}
+	s.auditor.Log(audit.Event{
+		Action:    "rbac.denied",
+		User:      id.User,
+		SessionID: session.ID,
+		Project:   session.Project,
+		Details: map[string]string{
+			"role":       id.Role,
+			"permission": perm,
+			"reason":     d.Reason,
+		},
+	})
+	return fmt.Errorf("%s", d.Reason)
+}
+
+// CurrentBranch returns the checked-out git branch of dir, or "" if unknown.
+func CurrentBranch(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Priorities of retrieved context: when a request does not fit the model's
+// context window, project summaries are dropped before search results.
+const (
+	priorityIndexSummary = 1
+	prioritySemantic     = 2
+)
 
-	if context != "" {
-		return "\n\nBelow is your knowledge base from indexed codebases. This is YOUR data that YOU indexed and analyzed. " +
-			"Answer questions about these projects confidently and directly based on this data. " +
+// getIndexContext loads the index summary of the session's project for AI
+// context, after a block with the instructions. Each entry carries the file
+// it describes for the data classification check. Summaries only change on
+// reindexing, so they are marked stable for prompt caching.
+func (s *Server) getIndexContext(project string) []model.ContextBlock {
+	if project == "" {
+		return nil
+	}
+	name := filepath.Base(project)
+	dbPath := filepath.Join(config.GreenForgeHome(), "index", name+".db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil
+	}
+	idx, err := index.NewEngine(dbPath)
+	if err != nil {
+		return nil
+	}
+	summary, entries := idx.GetContextSummary(name)
+	idx.Close()
+	if summary == "" {
+		return nil
+	}
+	sources := make([]model.Source, 0, len(entries))
+	for _, e := range entries {
+		sources = append(sources, model.Source{Project: project, Path: e.File, Text: e.Text})
+	}
+
+	// Listed first, so it is the last index block to be dropped
+	header := model.ContextBlock{
+		Name: "index",
+		Text: "\n\nBelow is your knowledge base from the indexed codebase. This is YOUR data that YOU indexed and analyzed. " +
+			"Answer questions about this project confidently and directly based on this data. " +
 			"Do NOT say the code is 'not available' or 'not accessible' - you HAVE the indexed data right here. " +
-			"Present the information as your own knowledge.\n" + context
+			"Present the information as your own knowledge.\n",
+		Priority: priorityIndexSummary,
+		Stable:   true,
+	}
+	return []model.ContextBlock{header, {
+		Name:     "index:" + name,
+		Text:     "\n" + summary,
+		Priority: priorityIndexSummary,
+		Stable:   true,
+		Sources:  sources,
+	}}
+}
+
+// getSemanticContext returns the indexed code most relevant to a message,
+// found by semantic search in the index of the session's project only, with
+// the files the snippets came from for the data classification check.
+func (s *Server) getSemanticContext(ctx context.Context, project, message string) []model.ContextBlock {
+	if s.router == nil || project == "" {
+		return nil
+	}
+	name := filepath.Base(project)
+	dbPath := filepath.Join(config.GreenForgeHome(), "index", name+".db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil
+	}
+	idx, err := index.NewEngine(dbPath)
+	if err != nil {
+		return nil
+	}
+	defer idx.Close()
+	idx.SetEmbedder(s.router)
+	results, err := idx.SemanticSearch(ctx, message, 5)
+	if err != nil || len(results) == 0 {
+		return nil
 	}
-	return ""
+	for i := range results {
+		results[i].Project = name
+	}
+	var sb strings.Builder
+	sources := make([]model.Source, 0, len(results))
+	sb.WriteString("\n\nIndexed code that may be relevant to the user's message (semantic search, verify before relying on it):\n")
+	for _, r := range results {
+		sb.WriteString(fmt.Sprintf("\n--- [%s] %s %s (%s:%d)\n%s\n", r.Project, r.Kind, r.Name, r.File, r.Line, r.Text))
+		sources = append(sources, model.Source{Project: project, Path: r.File, Text: r.Text})
+	}
+	return []model.ContextBlock{{Name: "search", Text: sb.String(), Priority: prioritySemantic, Sources: sources}}
 }
 
 // --- WebSocket message types ---
@@ -324,16 +610,61 @@ func (c *WSClient) readPump(s *Server) {
 
 		switch msg.Type {
 		case "chat":
-			// Process user message through agent
-			if data, ok := msg.Data.(string); ok {
-				go s.processMessage(c.session, c, data)
+			// Process user message through agent; data is the text, or the
+			// text with IDs of images uploaded before
+			switch data := msg.Data.(type) {
+			case string:
+				go s.processMessage(c.session, c, data, nil)
+			case map[string]interface{}:
+				var chat struct {
+					Text   string   `json:"text"`
+					Images []string `json:"images"`
+				}
+				if err := decodeData(data, &chat); err != nil {
+					c.send <- WSMessage{Type: "error", Data: err.Error(), ID: msg.ID}
+					continue
+				}
+				go s.processMessage(c.session, c, chat.Text, chat.Images)
+			}
+		case "image":
+			// Upload an image for a following chat message
+			var upload struct {
+				Name string `json:"name"`
+				Data []byte `json:"data"` // base64
 			}
+			if err := decodeData(msg.Data, &upload); err != nil {
+				c.send <- WSMessage{Type: "error", Data: err.Error(), ID: msg.ID}
+				continue
+			}
+			ctx := s.sessionContext(context.Background(), c.session, "")
+			if err := s.authorize(ctx, c.session, "session:write"); err != nil {
+				c.send <- WSMessage{Type: "error", Data: fmt.Sprintf("Access denied: %v", err), ID: msg.ID}
+				continue
+			}
+			ref, err := s.storeImage(c.session, upload.Name, upload.Data)
+			if err != nil {
+				c.send <- WSMessage{Type: "error", Data: fmt.Sprintf("Image rejected: %v", err), ID: msg.ID}
+				continue
+			}
+			c.send <- WSMessage{Type: "image", Data: ref, ID: msg.ID}
 		case "detach":
 			return
 		}
 	}
 }
 
+// decodeData converts the data of a WebSocket message into v.
+func decodeData(data interface{}, v interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid message data: %w", err)
+	}
+	return nil
+}
+
 func (c *WSClient) writePump() {
 	defer c.conn.Close()
 
@@ -345,7 +676,14 @@ func (c *WSClient) writePump() {
 	}
 }
 
-func (s *Server) processMessage(session *Session, client *WSClient, message string) {
+func (s *Server) processMessage(session *Session, client *WSClient, message string, images []string) {
+	// Images must have been uploaded to this session
+	current, err := loadImages(session.ID, images)
+	if err != nil {
+		client.send <- WSMessage{Type: "error", Data: err.Error()}
+		return
+	}
+
 	client.send <- WSMessage{
 		Type: "thinking",
 		Data: "Processing...",
@@ -357,13 +695,13 @@ func (s *Server) processMessage(session *Session, client *WSClient, message stri
 		Role:      "user",
 		Content:   message,
 		Timestamp: time.Now(),
+		Images:    images,
 	})
 	// Build messages from history
 	var msgs []model.Message
 
-	// Build system prompt with codebase index context
+	// Build system prompt; index summaries go into the request context
 	systemPrompt := "You are GreenForge, an AI developer assistant for JVM teams. Be concise and helpful. Respond in the same language as the user.\n"
-	systemPrompt += s.getIndexContext()
 
 	// Tell AI about selected projects it can browse
 	if len(session.Projects) > 0 {
@@ -378,9 +716,14 @@ func (s *Server) processMessage(session *Session, client *WSClient, message stri
 		Role:    "system",
 		Content: systemPrompt,
 	})
+	var loadErr error
 	for _, h := range session.history {
 		if h.Role == "user" || h.Role == "assistant" {
-			msgs = append(msgs, model.Message{Role: h.Role, Content: h.Content})
+			m := model.Message{Role: h.Role, Content: h.Content}
+			if m.Images, loadErr = loadImages(session.ID, h.Images); loadErr != nil {
+				break
+			}
+			msgs = append(msgs, m)
 		}
 	}
 
@@ -394,6 +737,20 @@ func (s *Server) processMessage(session *Session, client *WSClient, message stri
 	}
 	session.mu.Unlock()
 
+	if loadErr != nil {
+		client.send <- WSMessage{Type: "error", Data: fmt.Sprintf("Session history: %v", loadErr)}
+		return
+	}
+
+	ctx := s.sessionContext(context.Background(), session, workingDir)
+	if err := s.authorize(ctx, session, "session:write"); err != nil {
+		client.send <- WSMessage{
+			Type: "error",
+			Data: fmt.Sprintf("Access denied: %v", err),
+		}
+		return
+	}
+
 	if s.router == nil {
 		client.send <- WSMessage{
 			Type: "response",
@@ -403,40 +760,70 @@ func (s *Server) processMessage(session *Session, client *WSClient, message stri
 	}
 
 	var responseText string
-	ctx := context.Background()
-	if session.Project != "" {
-		ctx = model.WithProject(ctx, session.Project)
-	}
-
-	req := model.Request{
-		Messages:   msgs,
-		MaxTokens:  4096,
-		WorkingDir: workingDir,
-	}
-
-	// Use streaming for real-time progress
-	err := s.router.StreamComplete(ctx, req, func(chunk model.StreamChunk) {
-		if chunk.Done {
-			return
+	var redactions *model.RedactionStats
+	project := session.project(workingDir)
+	retrieved := append(s.getIndexContext(project), s.getSemanticContext(ctx, project, message)...)
+
+	rt := s.sessionRuntime(session)
+	if rt != nil {
+		// The agent loop keeps its own history and runs the tools the model
+		// calls, authorized for the session identity in ctx
+		responseText, err = s.runAgent(ctx, session, client, rt, agent.Turn{
+			Message:    message,
+			Images:     current,
+			Context:    retrieved,
+			WorkingDir: workingDir,
+		})
+	} else {
+		req := model.Request{
+			Messages:   msgs,
+			MaxTokens:  4096,
+			Task:       model.TaskChat,
+			WorkingDir: workingDir,
+			Context:    retrieved,
 		}
-		if len(chunk.ToolCalls) > 0 {
-			for _, tc := range chunk.ToolCalls {
+		redactions = &model.RedactionStats{}
+		// Use streaming for real-time progress
+		err = s.router.StreamComplete(ctx, req, func(chunk model.StreamChunk) {
+			if chunk.Done {
+				*redactions = chunk.Redactions
+				for _, warning := range chunk.Warnings {
+					client.send <- WSMessage{Type: "warning", Data: warning}
+				}
+				return
+			}
+			if len(chunk.ToolCalls) > 0 {
+				for _, tc := range chunk.ToolCalls {
+					client.send <- WSMessage{
+						Type: "tool_call",
+						Data: map[string]string{"name": tc.Name},
+					}
+				}
+				return
+			}
+			if chunk.Content != "" {
+				responseText += chunk.Content
 				client.send <- WSMessage{
-					Type: "tool_call",
-					Data: map[string]string{"name": tc.Name},
+					Type: "stream",
+					Data: chunk.Content,
 				}
 			}
-			return
-		}
-		if chunk.Content != "" {
-			responseText += chunk.Content
-			client.send <- WSMessage{
-				Type: "stream",
-				Data: chunk.Content,
+		})
+	}
+	if err != nil {
+		// Drop the images, which the session's models cannot take, but keep
+		// the text so the following messages still get through
+		var imgErr *model.ImageError
+		if errors.As(err, &imgErr) {
+			session.mu.Lock()
+			for i := range session.history {
+				session.history[i].Images = nil
+			}
+			session.mu.Unlock()
+			if rt != nil {
+				rt.DropImages(session.ID)
 			}
 		}
-	})
-	if err != nil {
 		client.send <- WSMessage{
 			Type: "error",
 			Data: fmt.Sprintf("AI error: %v", err),
@@ -463,14 +850,62 @@ func (s *Server) processMessage(session *Session, client *WSClient, message stri
 	})
 	session.mu.Unlock()
 
-	// Audit
+	// Audit; the router audits the redactions of each call of the agent loop
+	details := map[string]string{
+		"message_length": fmt.Sprintf("%d", len(responseText)),
+	}
+	if redactions != nil {
+		details["redactions"] = fmt.Sprintf("%d", redactions.Total())
+	}
 	s.auditor.Log(audit.Event{
 		Action:    "chat.complete",
 		SessionID: session.ID,
-		Details:   map[string]string{"message_length": fmt.Sprintf("%d", len(responseText))},
+		Details:   details,
 	})
 }
 
+// sessionRuntime returns the agent runtime of a session, created by the
+// agent factory on first use, or nil without a factory.
+func (s *Server) sessionRuntime(session *Session) *agent.Runtime {
+	if s.agentFn == nil {
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.runtime == nil {
+		session.runtime = s.agentFn(s.cfg)
+	}
+	return session.runtime
+}
+
+// runAgent runs a turn of the session's agent and returns its response.
+// Turns of a session run one at a time, since they share its history.
+// Denied tool calls are reported to the client as warnings.
+func (s *Server) runAgent(ctx context.Context, session *Session, client *WSClient, rt *agent.Runtime, turn agent.Turn) (string, error) {
+	session.turnMu.Lock()
+	defer session.turnMu.Unlock()
+
+	var response string
+	rt.SetCallbacks(agent.Callbacks{
+		OnResponse: func(text string) {
+			response = text
+		},
+		OnToolCall: func(toolName string, input map[string]interface{}) {
+			client.send <- WSMessage{Type: "tool_call", Data: map[string]string{"name": toolName}}
+		},
+		OnToolResult: func(toolName string, result agent.ToolResult) {
+			if result.Metadata["denied"] == "true" {
+				client.send <- WSMessage{Type: "warning", Data: fmt.Sprintf("Tool %s denied: %s", toolName, result.Error)}
+			}
+		},
+		OnWarning: func(warning string) {
+			client.send <- WSMessage{Type: "warning", Data: warning}
+		},
+	})
+	err := rt.ProcessTurn(ctx, session.ID, turn)
+	return response, err
+}
+
 // --- Session Manager ---
 
 // SessionManager tracks all active sessions.
@@ -493,10 +928,16 @@ type Session struct {
 	Status    string    `json:"status"`             // active, idle, detached
 	CreatedAt time.Time `json:"created_at"`
 	Device    string    `json:"device,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Role      string    `json:"role,omitempty"` // RBAC role, defaults to rbac.default_role
+
+	cert *ssh.Certificate // client certificate the session was opened with
 
 	mu      sync.RWMutex
 	clients []*WSClient
 	history []ChatMessage
+	runtime *agent.Runtime // agent of the session, see Server.sessionRuntime
+	turnMu  sync.Mutex     // held while the agent runs a turn
 }
 
 // ChatMessage represents a message in the session history.
@@ -506,6 +947,7 @@ type ChatMessage struct {
 	Timestamp time.Time `json:"timestamp"`
 	ToolName  string    `json:"tool_name,omitempty"`
 	ToolInput string    `json:"tool_input,omitempty"`
+	Images    []string  `json:"images,omitempty"` // IDs of images stored with the session
 }
 
 func (sm *SessionManager) Create(project string) *Session {
@@ -523,6 +965,23 @@ func (sm *SessionManager) Create(project string) *Session {
 	return session
 }
 
+// project returns the session's project, or the working directory of the
+// message if the session has none.
+func (s *Session) project(workingDir string) string {
+	if s.Project != "" {
+		return s.Project
+	}
+	return workingDir
+}
+
+// setIdentity makes a session act for the caller that opened it.
+func (s *Session) setIdentity(id rbac.Identity) {
+	s.User = id.User
+	s.Role = id.Role
+	s.Device = id.Device
+	s.cert = id.Cert
+}
+
 func (sm *SessionManager) Get(id string) *Session {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
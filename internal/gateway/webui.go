@@ -13,6 +13,7 @@ import (
 	"sort"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/greencode/greenforge/internal/config"
 	"github.com/greencode/greenforge/internal/model"
 )
@@ -164,10 +165,17 @@ func (w *WebUIServer) handleConfigGET(rw http.ResponseWriter, cfg *config.Config
 	var providers []map[string]interface{}
 	for _, p := range cfg.AI.Providers {
 		providers = append(providers, map[string]interface{}{
-			"name":     p.Name,
-			"endpoint": p.Endpoint,
-			"api_key":  maskSecret(p.APIKey),
-			"model":    p.Model,
+			"name":           p.Name,
+			"type":           p.Type,
+			"endpoint":       p.Endpoint,
+			"api_key":        maskSecret(p.APIKey),
+			"model":          p.Model,
+			"auth_header":    p.AuthHeader,
+			"models":         p.Models,
+			"context_window": p.ContextWindow,
+			"capabilities":   p.Capabilities,
+			"local":          p.Local,
+			"clearance":      p.Clearance,
 		})
 	}
 
@@ -177,7 +185,9 @@ func (w *WebUIServer) handleConfigGET(rw http.ResponseWriter, cfg *config.Config
 		policies = append(policies, map[string]interface{}{
 			"project_pattern":   p.ProjectPattern,
 			"allowed_providers": p.AllowedProviders,
+			"fallback":          p.Fallback,
 			"reason":            p.Reason,
+			"mask_secrets":      p.MaskSecrets,
 		})
 	}
 
@@ -214,11 +224,11 @@ func (w *WebUIServer) handleConfigGET(rw http.ResponseWriter, cfg *config.Config
 
 	json.NewEncoder(rw).Encode(map[string]interface{}{
 		"general": map[string]interface{}{
-			"name":     cfg.General.Name,
-			"email":    cfg.General.Email,
+			"name":      cfg.General.Name,
+			"email":     cfg.General.Email,
 			"log_level": cfg.General.LogLevel,
-			"language": cfg.General.Language,
-			"data_dir": cfg.General.DataDir,
+			"language":  cfg.General.Language,
+			"data_dir":  cfg.General.DataDir,
 		},
 		"gateway": map[string]interface{}{
 			"host":       cfg.Gateway.Host,
@@ -480,10 +490,17 @@ func (w *WebUIServer) applyConfigSection(cfg *config.Config, section string, dat
 					oldKey = cfg.AI.Providers[i].APIKey
 				}
 				newProviders = append(newProviders, config.ProviderConfig{
-					Name:     strVal(p, "name"),
-					Endpoint: strVal(p, "endpoint"),
-					APIKey:   secretOrKeep(strVal(p, "api_key"), oldKey),
-					Model:    strVal(p, "model"),
+					Name:          strVal(p, "name"),
+					Type:          strVal(p, "type"),
+					Endpoint:      strVal(p, "endpoint"),
+					APIKey:        secretOrKeep(strVal(p, "api_key"), oldKey),
+					Model:         strVal(p, "model"),
+					AuthHeader:    strVal(p, "auth_header"),
+					Models:        strSliceVal(p, "models"),
+					ContextWindow: intVal(p, "context_window"),
+					Capabilities:  strSliceVal(p, "capabilities"),
+					Local:         boolVal(p, "local"),
+					Clearance:     strVal(p, "clearance"),
 				})
 			}
 			cfg.AI.Providers = newProviders
@@ -494,7 +511,9 @@ func (w *WebUIServer) applyConfigSection(cfg *config.Config, section string, dat
 				newPolicies = append(newPolicies, config.ModelPolicy{
 					ProjectPattern:   strVal(p, "project_pattern"),
 					AllowedProviders: strSliceVal(p, "allowed_providers"),
+					Fallback:         strSliceVal(p, "fallback"),
 					Reason:           strVal(p, "reason"),
+					MaskSecrets:      boolVal(p, "mask_secrets"),
 				})
 			}
 			cfg.AI.Policies = newPolicies
@@ -889,7 +908,15 @@ func (w *WebUIServer) handleChat(rw http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Message  string   `json:"message"`
 		Model    string   `json:"model"`
+		Task     string   `json:"task"` // routes the call, default chat
 		Projects []string `json:"projects"`
+		// Sampling temperature; 0 makes the call cacheable
+		Temperature *float64 `json:"temperature"`
+		// JSON Schema the response must match; the response is then JSON
+		Schema json.RawMessage `json:"response_schema"`
+		// Images for the message as {"name", "data"} with base64 data;
+		// REST calls keep no session, so they are not stored
+		Images []model.Image `json:"images"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(rw, `{"error":"invalid request"}`, http.StatusBadRequest)
@@ -901,11 +928,8 @@ func (w *WebUIServer) handleChat(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build system prompt with index context
+	// Build system prompt; index summaries go into the request context
 	systemPrompt := "You are GreenForge, an AI developer assistant for JVM teams. Be concise and helpful. Respond in the same language as the user.\n"
-	if w.gateway != nil {
-		systemPrompt += w.gateway.getIndexContext()
-	}
 
 	// Add selected projects context
 	workingDir := ""
@@ -924,21 +948,60 @@ func (w *WebUIServer) handleChat(rw http.ResponseWriter, r *http.Request) {
 			{Role: "system", Content: systemPrompt},
 			{Role: "user", Content: req.Message},
 		},
-		MaxTokens:  4096,
-		Model:      req.Model,
-		WorkingDir: workingDir,
+		MaxTokens:   4096,
+		Model:       req.Model,
+		Task:        req.Task,
+		Temperature: req.Temperature,
+		WorkingDir:  workingDir,
+	}
+	if len(req.Schema) > 0 {
+		modelReq.ResponseSchema = req.Schema
+	}
+	for _, img := range req.Images {
+		checked, err := model.NewImage(img.Name, img.Data)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		modelReq.Messages[1].Images = append(modelReq.Messages[1].Images, checked)
+	}
+
+	ctx := r.Context()
+	if w.gateway != nil {
+		// A REST call is its own session, so its secret placeholders are
+		// never restored for another caller
+		id, err := w.gateway.identify(r)
+		if err != nil {
+			rw.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		session := &Session{ID: "rest-" + uuid.NewString()}
+		session.setIdentity(id)
+		defer w.router.ForgetSession(session.ID)
+		ctx = w.gateway.sessionContext(ctx, session, workingDir)
+		if err := w.gateway.authorize(ctx, session, "session:write"); err != nil {
+			rw.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		project := session.project(workingDir)
+		modelReq.Context = append(w.gateway.getIndexContext(project), w.gateway.getSemanticContext(ctx, project, req.Message)...)
 	}
 
-	resp, err := w.router.Complete(r.Context(), modelReq)
+	resp, err := w.router.Complete(ctx, modelReq)
 	if err != nil {
 		json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
 	json.NewEncoder(rw).Encode(map[string]interface{}{
-		"response": resp.Content,
-		"model":    resp.Model,
-		"usage":    resp.Usage,
+		"response":   resp.Content,
+		"model":      resp.Model,
+		"usage":      resp.Usage,
+		"redactions": resp.Redactions,
+		"warnings":   resp.Warnings,
 	})
 }
 
@@ -1042,8 +1105,8 @@ func (w *WebUIServer) handleReindex(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	json.NewEncoder(rw).Encode(map[string]interface{}{
-		"status":         "ok",
-		"files_indexed":  totalJava + totalKotlin,
+		"status":        "ok",
+		"files_indexed": totalJava + totalKotlin,
 	})
 }
 
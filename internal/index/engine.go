@@ -17,18 +17,19 @@ import (
 
 // Engine is the codebase index engine - zero-LLM, local-only.
 type Engine struct {
-	mu     sync.RWMutex
-	db     *sql.DB
-	dbPath string
+	mu       sync.RWMutex
+	db       *sql.DB
+	dbPath   string
+	embedder Embedder // optional, for semantic search
 }
 
 // IndexedFile represents a file in the index.
 type IndexedFile struct {
-	Path       string    `json:"path"`
-	Module     string    `json:"module"`
-	Language   string    `json:"language"` // java, kotlin, gradle, xml, yaml
-	Hash       string    `json:"hash"`
-	IndexedAt  time.Time `json:"indexed_at"`
+	Path      string    `json:"path"`
+	Module    string    `json:"module"`
+	Language  string    `json:"language"` // java, kotlin, gradle, xml, yaml
+	Hash      string    `json:"hash"`
+	IndexedAt time.Time `json:"indexed_at"`
 }
 
 // IndexedClass represents a class/interface in the index.
@@ -56,21 +57,21 @@ type IndexedMethod struct {
 
 // Endpoint represents a Spring REST endpoint.
 type Endpoint struct {
-	Method     string `json:"method"` // GET, POST, PUT, DELETE
-	Path       string `json:"path"`
-	Handler    string `json:"handler"` // ClassName.methodName
-	File       string `json:"file"`
-	Line       int    `json:"line"`
+	Method  string `json:"method"` // GET, POST, PUT, DELETE
+	Path    string `json:"path"`
+	Handler string `json:"handler"` // ClassName.methodName
+	File    string `json:"file"`
+	Line    int    `json:"line"`
 }
 
 // KafkaTopic represents a Kafka topic mapping.
 type KafkaTopic struct {
-	Topic     string `json:"topic"`
-	GroupID   string `json:"group_id"`
-	Type      string `json:"type"` // listener, producer
-	Handler   string `json:"handler"`
-	File      string `json:"file"`
-	Line      int    `json:"line"`
+	Topic   string `json:"topic"`
+	GroupID string `json:"group_id"`
+	Type    string `json:"type"` // listener, producer
+	Handler string `json:"handler"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
 }
 
 // ModuleDep represents a dependency between modules.
@@ -184,6 +185,32 @@ func initIndexSchema(db *sql.DB) error {
 			name, package, file, kind, annotations, content,
 			tokenize='porter unicode61'
 		);
+
+		-- Source chunks (classes, methods, config sections) for semantic search
+		CREATE TABLE IF NOT EXISTS chunks (
+			id    INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind  TEXT NOT NULL,
+			name  TEXT NOT NULL,
+			file  TEXT NOT NULL,
+			line  INTEGER DEFAULT 0,
+			text  TEXT NOT NULL,
+			hash  TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_chunks_file ON chunks(file);
+		CREATE INDEX IF NOT EXISTS idx_chunks_hash ON chunks(hash);
+
+		-- Chunk embeddings by text hash, reused while the text is unchanged
+		CREATE TABLE IF NOT EXISTS embeddings (
+			hash   TEXT NOT NULL,
+			model  TEXT NOT NULL,
+			vector BLOB NOT NULL,
+			PRIMARY KEY (hash, model)
+		);
+
+		CREATE VIRTUAL TABLE IF NOT EXISTS fts_chunks USING fts5(
+			name, text,
+			tokenize='porter unicode61'
+		);
 	`)
 	return err
 }
@@ -242,15 +269,28 @@ func (e *Engine) IndexProject(ctx context.Context, projectPath string) (*IndexSt
 		case ".properties":
 			if strings.Contains(name, "application") {
 				stats.ConfigFiles++
+				return e.indexConfigFile(path, projectPath)
 			}
 		}
 		return nil
 	})
 
+	if err == nil {
+		e.embedChunks(ctx, stats)
+	}
 	stats.Duration = time.Since(stats.StartTime)
 	return stats, err
 }
 
+// embedChunks embeds new chunks and records the outcome in stats. A failing
+// embedding model does not fail indexing; search then falls back to FTS.
+func (e *Engine) embedChunks(ctx context.Context, stats *IndexStats) {
+	n, err := e.embedPending(ctx)
+	stats.Embedded = n
+	stats.EmbedError = err
+	stats.Chunks, _ = e.chunkCounts()
+}
+
 // IncrementalUpdate re-indexes only changed files since last commit.
 func (e *Engine) IncrementalUpdate(ctx context.Context, projectPath string) (*IndexStats, error) {
 	e.mu.Lock()
@@ -291,6 +331,7 @@ func (e *Engine) IncrementalUpdate(ctx context.Context, projectPath string) (*In
 		}
 	}
 
+	e.embedChunks(ctx, stats)
 	stats.Duration = time.Since(stats.StartTime)
 	return stats, nil
 }
@@ -388,14 +429,23 @@ func (e *Engine) GetStats() (*IndexStatus, error) {
 	e.db.QueryRow("SELECT COUNT(*) FROM spring_beans").Scan(&status.SpringBeans)
 	e.db.QueryRow("SELECT COUNT(*) FROM entities").Scan(&status.Entities)
 	e.db.QueryRow("SELECT MAX(indexed_at) FROM files").Scan(&status.LastUpdate)
+	status.Chunks, status.Embedded = e.chunkCounts()
 	return status, nil
 }
 
-// GetContextSummary returns a text summary of the indexed project for AI context injection.
-func (e *Engine) GetContextSummary(projectName string) string {
+// SummaryEntry is a part of a context summary and the file it describes.
+type SummaryEntry struct {
+	File string
+	Text string // as it appears in the summary
+}
+
+// GetContextSummary returns a text summary of the indexed project for AI
+// context injection, and the entries of it that describe a file.
+func (e *Engine) GetContextSummary(projectName string) (string, []SummaryEntry) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
+	var entries []SummaryEntry
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("## Codebase Index: %s\n\n", projectName))
 
@@ -434,6 +484,7 @@ func (e *Engine) GetContextSummary(projectName string) string {
 			var method, path, handler, file string
 			epRows.Scan(&method, &path, &handler, &file)
 			eps = append(eps, fmt.Sprintf("  %s %s -> %s (%s)", method, path, handler, file))
+			entries = append(entries, SummaryEntry{File: file, Text: eps[len(eps)-1]})
 		}
 		epRows.Close()
 		if len(eps) > 0 {
@@ -443,13 +494,14 @@ func (e *Engine) GetContextSummary(projectName string) string {
 	}
 
 	// Kafka Topics
-	ktRows, err := e.db.Query("SELECT topic, group_id, type, handler FROM kafka_topics ORDER BY topic LIMIT 20")
+	ktRows, err := e.db.Query("SELECT topic, group_id, type, handler, file FROM kafka_topics ORDER BY topic LIMIT 20")
 	if err == nil {
 		var kts []string
 		for ktRows.Next() {
-			var topic, groupID, typ, handler string
-			ktRows.Scan(&topic, &groupID, &typ, &handler)
+			var topic, groupID, typ, handler, file string
+			ktRows.Scan(&topic, &groupID, &typ, &handler, &file)
 			kts = append(kts, fmt.Sprintf("  %s [%s] group=%s -> %s", topic, typ, groupID, handler))
+			entries = append(entries, SummaryEntry{File: file, Text: kts[len(kts)-1]})
 		}
 		ktRows.Close()
 		if len(kts) > 0 {
@@ -460,39 +512,41 @@ func (e *Engine) GetContextSummary(projectName string) string {
 
 	// Spring Beans (grouped by type)
 	for _, beanType := range []string{"service", "repository", "controller", "component", "configuration"} {
-		bRows, err := e.db.Query("SELECT class_name, module FROM spring_beans WHERE type = ? ORDER BY class_name LIMIT 20", beanType)
+		bRows, err := e.db.Query("SELECT class_name, module, file FROM spring_beans WHERE type = ? ORDER BY class_name LIMIT 20", beanType)
 		if err == nil {
 			var beans []string
 			for bRows.Next() {
-				var name, mod string
-				bRows.Scan(&name, &mod)
+				var name, mod, file string
+				bRows.Scan(&name, &mod, &file)
 				if mod != "" {
 					beans = append(beans, fmt.Sprintf("%s (%s)", name, mod))
 				} else {
 					beans = append(beans, name)
 				}
+				entries = append(entries, SummaryEntry{File: file, Text: beans[len(beans)-1]})
 			}
 			bRows.Close()
 			if len(beans) > 0 {
 				title := strings.ToUpper(beanType[:1]) + beanType[1:]
-			sb.WriteString(fmt.Sprintf("### Spring %ss\n", title))
+				sb.WriteString(fmt.Sprintf("### Spring %ss\n", title))
 				sb.WriteString(strings.Join(beans, ", ") + "\n\n")
 			}
 		}
 	}
 
 	// JPA Entities
-	entRows, err := e.db.Query("SELECT name, table_name FROM entities ORDER BY name LIMIT 20")
+	entRows, err := e.db.Query("SELECT name, table_name, file FROM entities ORDER BY name LIMIT 20")
 	if err == nil {
 		var ents []string
 		for entRows.Next() {
-			var name, tableName string
-			entRows.Scan(&name, &tableName)
+			var name, tableName, file string
+			entRows.Scan(&name, &tableName, &file)
 			if tableName != "" {
 				ents = append(ents, fmt.Sprintf("%s (table: %s)", name, tableName))
 			} else {
 				ents = append(ents, name)
 			}
+			entries = append(entries, SummaryEntry{File: file, Text: ents[len(ents)-1]})
 		}
 		entRows.Close()
 		if len(ents) > 0 {
@@ -502,17 +556,18 @@ func (e *Engine) GetContextSummary(projectName string) string {
 	}
 
 	// Key classes (top 30)
-	clRows, err := e.db.Query("SELECT name, package, kind, annotations, module FROM classes ORDER BY name LIMIT 30")
+	clRows, err := e.db.Query("SELECT name, package, kind, annotations, module, file FROM classes ORDER BY name LIMIT 30")
 	if err == nil {
 		var cls []string
 		for clRows.Next() {
-			var name, pkg, kind, anns, mod string
-			clRows.Scan(&name, &pkg, &kind, &anns, &mod)
+			var name, pkg, kind, anns, mod, file string
+			clRows.Scan(&name, &pkg, &kind, &anns, &mod, &file)
 			entry := fmt.Sprintf("%s.%s [%s]", pkg, name, kind)
 			if anns != "" {
 				entry += " " + anns
 			}
 			cls = append(cls, entry)
+			entries = append(entries, SummaryEntry{File: file, Text: entry})
 		}
 		clRows.Close()
 		if len(cls) > 0 {
@@ -524,7 +579,7 @@ func (e *Engine) GetContextSummary(projectName string) string {
 		}
 	}
 
-	return sb.String()
+	return sb.String(), entries
 }
 
 // ListSpringBeans returns all indexed Spring beans.
@@ -623,9 +678,23 @@ func (e *Engine) indexJavaFile(path, projectRoot string) error {
 			ent.Name, ent.TableName, ent.File, ent.Module)
 	}
 
+	e.indexSourceChunks(text, relPath, classes)
 	return nil
 }
 
+// indexSourceChunks stores the methods and semantic search chunks of a
+// Java or Kotlin file.
+func (e *Engine) indexSourceChunks(text, relPath string, classes []IndexedClass) {
+	chunks, methods := sourceChunks(text, relPath, classes)
+	e.db.Exec("DELETE FROM methods WHERE file = ?", relPath)
+	for _, m := range methods {
+		e.db.Exec(`INSERT INTO methods (name, class_name, file, line, return_type, params, annotations)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			m.Name, m.ClassName, m.File, m.Line, m.ReturnType, m.Params, strings.Join(m.Annotations, ","))
+	}
+	e.storeChunks(relPath, chunks)
+}
+
 func (e *Engine) indexKotlinFile(path, projectRoot string) error {
 	// Similar to Java but with Kotlin-specific syntax
 	content, err := os.ReadFile(path)
@@ -646,6 +715,7 @@ func (e *Engine) indexKotlinFile(path, projectRoot string) error {
 		e.db.Exec(`INSERT INTO classes (name, package, file, module, kind, annotations) VALUES (?, ?, ?, ?, ?, ?)`,
 			c.Name, c.Package, c.File, c.Module, c.Kind, strings.Join(c.Annotations, ","))
 	}
+	e.indexSourceChunks(text, relPath, classes)
 	return nil
 }
 
@@ -660,6 +730,9 @@ func (e *Engine) indexConfigFile(path, projectRoot string) error {
 	relPath, _ := filepath.Rel(projectRoot, path)
 	e.db.Exec("INSERT OR REPLACE INTO files (path, module, language, indexed_at) VALUES (?, ?, 'config', CURRENT_TIMESTAMP)",
 		relPath, detectModule(relPath))
+	if content, err := os.ReadFile(path); err == nil {
+		e.storeChunks(relPath, configChunks(string(content), relPath))
+	}
 	return nil
 }
 
@@ -671,6 +744,7 @@ func (e *Engine) removeFileEntries(relPath string) {
 	e.db.Exec("DELETE FROM spring_beans WHERE file = ?", relPath)
 	e.db.Exec("DELETE FROM entities WHERE file = ?", relPath)
 	e.db.Exec("DELETE FROM files WHERE path = ?", relPath)
+	e.deleteChunks(relPath)
 }
 
 // --- Types ---
@@ -692,6 +766,9 @@ type IndexStats struct {
 	ConfigFiles int
 	BuildTool   string
 	Incremental bool
+	Chunks      int   // semantic search chunks in the index
+	Embedded    int   // chunks embedded in this run
+	EmbedError  error // why embedding stopped, if it did
 }
 
 type IndexStatus struct {
@@ -702,6 +779,8 @@ type IndexStatus struct {
 	KafkaTopics int        `json:"kafka_topics"`
 	SpringBeans int        `json:"spring_beans"`
 	Entities    int        `json:"entities"`
+	Chunks      int        `json:"chunks"`
+	Embedded    int        `json:"embedded"` // chunks with a vector for the current model
 	LastUpdate  *time.Time `json:"last_update"`
 }
 
@@ -896,12 +975,12 @@ func extractSpringBeans(text, file, module string) []SpringBean {
 	lines := strings.Split(text, "\n")
 
 	beanAnnotations := map[string]string{
-		"@Service":       "service",
-		"@Repository":    "repository",
-		"@Component":     "component",
+		"@Service":        "service",
+		"@Repository":     "repository",
+		"@Component":      "component",
 		"@RestController": "controller",
-		"@Controller":    "controller",
-		"@Configuration": "configuration",
+		"@Controller":     "controller",
+		"@Configuration":  "configuration",
 	}
 
 	for i, line := range lines {
@@ -0,0 +1,655 @@
+package index
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Embedder computes text embeddings for semantic search. model.Router
+// implements it with the configured index.embedding_model.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	EmbeddingModel() string
+}
+
+// FileEmbedder is an Embedder that is told the file of each text, so it can
+// refuse texts from classified files. Refused texts get a nil vector.
+type FileEmbedder interface {
+	EmbedFiles(ctx context.Context, texts, files []string) ([][]float32, error)
+}
+
+// SetEmbedder enables chunk embeddings during indexing and vector
+// similarity in SemanticSearch. Without it search uses FTS only.
+func (e *Engine) SetEmbedder(emb Embedder) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.embedder = emb
+}
+
+// SemanticResult is a chunk found by SemanticSearch.
+type SemanticResult struct {
+	Project    string  `json:"project,omitempty"` // set by callers
+	Kind       string  `json:"kind"`              // class, method, config
+	Name       string  `json:"name"`
+	File       string  `json:"file"`
+	Line       int     `json:"line"`
+	Text       string  `json:"text"`
+	Score      float64 `json:"score"`                // fused rank score, higher is better
+	Similarity float64 `json:"similarity,omitempty"` // cosine similarity, 0 without embeddings
+	TextMatch  bool    `json:"text_match"`           // matched the full-text query
+}
+
+// chunk is a unit of source text that is embedded and searched.
+type chunk struct {
+	kind string
+	name string
+	line int
+	text string
+}
+
+const (
+	maxChunkChars  = 2000 // longer chunks are truncated before embedding
+	embedBatchSize = 32
+	rrfK           = 60 // reciprocal rank fusion constant
+)
+
+// storeChunks replaces the chunks of a file. Embeddings are keyed by the
+// chunk text hash, so unchanged chunks keep their vectors across reindexing.
+func (e *Engine) storeChunks(relPath string, chunks []chunk) {
+	e.deleteChunks(relPath)
+	for _, c := range chunks {
+		text := c.text
+		if len(text) > maxChunkChars {
+			text = strings.ToValidUTF8(text[:maxChunkChars], "")
+		}
+		sum := sha256.Sum256([]byte(text))
+		res, err := e.db.Exec("INSERT INTO chunks (kind, name, file, line, text, hash) VALUES (?, ?, ?, ?, ?, ?)",
+			c.kind, c.name, relPath, c.line, text, hex.EncodeToString(sum[:]))
+		if err != nil {
+			continue
+		}
+		id, _ := res.LastInsertId()
+		e.db.Exec("INSERT INTO fts_chunks (rowid, name, text) VALUES (?, ?, ?)", id, c.name, text)
+	}
+}
+
+func (e *Engine) deleteChunks(relPath string) {
+	e.db.Exec("DELETE FROM fts_chunks WHERE rowid IN (SELECT id FROM chunks WHERE file = ?)", relPath)
+	e.db.Exec("DELETE FROM chunks WHERE file = ?", relPath)
+}
+
+// embedPending embeds chunks that have no vector for the current model and
+// drops vectors no chunk uses any more. It returns the number embedded.
+func (e *Engine) embedPending(ctx context.Context) (int, error) {
+	if e.embedder == nil {
+		return 0, nil
+	}
+	model := e.embedder.EmbeddingModel()
+
+	rows, err := e.db.Query(`SELECT c.hash, MIN(c.text), MIN(c.file) FROM chunks c
+		LEFT JOIN embeddings v ON v.hash = c.hash AND v.model = ?
+		WHERE v.hash IS NULL GROUP BY c.hash`, model)
+	if err != nil {
+		return 0, err
+	}
+	var hashes, texts, files []string
+	for rows.Next() {
+		var h, t, f string
+		if err := rows.Scan(&h, &t, &f); err == nil {
+			hashes = append(hashes, h)
+			texts = append(texts, t)
+			files = append(files, f)
+		}
+	}
+	rows.Close()
+	fileEmb, _ := e.embedder.(FileEmbedder)
+
+	embedded := 0
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		var vectors [][]float32
+		if fileEmb != nil {
+			vectors, err = fileEmb.EmbedFiles(ctx, texts[start:end], files[start:end])
+		} else {
+			vectors, err = e.embedder.Embed(ctx, texts[start:end])
+		}
+		if err != nil {
+			return embedded, fmt.Errorf("embedding chunks: %w", err)
+		}
+		for i, v := range vectors {
+			if v == nil {
+				continue // withheld, found by full-text search only
+			}
+			if _, err := e.db.Exec("INSERT OR REPLACE INTO embeddings (hash, model, vector) VALUES (?, ?, ?)",
+				hashes[start+i], model, encodeVector(v)); err != nil {
+				return embedded, fmt.Errorf("storing embedding: %w", err)
+			}
+			embedded++
+		}
+	}
+
+	e.db.Exec("DELETE FROM embeddings WHERE model != ? OR hash NOT IN (SELECT hash FROM chunks)", model)
+	return embedded, nil
+}
+
+// SemanticSearch finds the k chunks that best match a natural language
+// query. Vector similarity and FTS rank are combined by reciprocal rank
+// fusion, so a chunk scores well if either finds it and best if both do.
+func (e *Engine) SemanticSearch(ctx context.Context, query string, k int) ([]SemanticResult, error) {
+	e.mu.RLock()
+	emb := e.embedder
+	e.mu.RUnlock()
+
+	vec, model, err := embedQuery(ctx, emb, query)
+	if err != nil {
+		log.Printf("Warning: semantic search without embeddings: %v", err)
+	}
+	return e.search(query, vec, model, k)
+}
+
+func embedQuery(ctx context.Context, emb Embedder, query string) ([]float32, string, error) {
+	if emb == nil {
+		return nil, "", nil
+	}
+	vectors, err := emb.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(vectors) != 1 {
+		return nil, "", fmt.Errorf("expected 1 embedding, got %d", len(vectors))
+	}
+	return vectors[0], emb.EmbeddingModel(), nil
+}
+
+// search fuses the vector ranking (if vec is set) with the FTS ranking.
+func (e *Engine) search(query string, vec []float32, model string, k int) ([]SemanticResult, error) {
+	if k <= 0 {
+		k = 10
+	}
+	candidates := k * 4
+	if candidates < 20 {
+		candidates = 20
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	scores := make(map[int64]*SemanticResult)
+	get := func(id int64) *SemanticResult {
+		r, ok := scores[id]
+		if !ok {
+			r = &SemanticResult{}
+			scores[id] = r
+		}
+		return r
+	}
+
+	if len(vec) > 0 {
+		ranked, err := e.vectorRank(vec, model, candidates)
+		if err != nil {
+			return nil, err
+		}
+		for rank, s := range ranked {
+			r := get(s.id)
+			r.Similarity = s.similarity
+			r.Score += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	if fq := ftsQuery(query); fq != "" {
+		rows, err := e.db.Query("SELECT rowid FROM fts_chunks WHERE fts_chunks MATCH ? ORDER BY rank LIMIT ?", fq, candidates)
+		if err != nil {
+			return nil, err
+		}
+		rank := 0
+		for rows.Next() {
+			var id int64
+			if rows.Scan(&id) != nil {
+				continue
+			}
+			r := get(id)
+			r.TextMatch = true
+			r.Score += 1 / float64(rrfK+rank+1)
+			rank++
+		}
+		rows.Close()
+	}
+
+	ids := make([]int64, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]].Score != scores[ids[j]].Score {
+			return scores[ids[i]].Score > scores[ids[j]].Score
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > k {
+		ids = ids[:k]
+	}
+
+	results := make([]SemanticResult, 0, len(ids))
+	for _, id := range ids {
+		r := scores[id]
+		err := e.db.QueryRow("SELECT kind, name, file, line, text FROM chunks WHERE id = ?", id).
+			Scan(&r.Kind, &r.Name, &r.File, &r.Line, &r.Text)
+		if err != nil {
+			continue
+		}
+		results = append(results, *r)
+	}
+	return results, nil
+}
+
+type scoredChunk struct {
+	id         int64
+	similarity float64
+}
+
+// vectorRank returns the n chunks most similar to vec by brute-force cosine
+// similarity over the stored embeddings of the given model.
+func (e *Engine) vectorRank(vec []float32, model string, n int) ([]scoredChunk, error) {
+	rows, err := e.db.Query(`SELECT c.id, v.vector FROM chunks c
+		JOIN embeddings v ON v.hash = c.hash AND v.model = ?`, model)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranked []scoredChunk
+	for rows.Next() {
+		var id int64
+		var blob []byte
+		if rows.Scan(&id, &blob) != nil {
+			continue
+		}
+		if sim, ok := cosine(vec, decodeVector(blob)); ok {
+			ranked = append(ranked, scoredChunk{id: id, similarity: sim})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].similarity > ranked[j].similarity })
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked, rows.Err()
+}
+
+// chunkCounts returns the number of chunks and of chunks with a vector.
+func (e *Engine) chunkCounts() (chunks, embedded int) {
+	e.db.QueryRow("SELECT COUNT(*) FROM chunks").Scan(&chunks)
+	model := ""
+	if e.embedder != nil {
+		model = e.embedder.EmbeddingModel()
+	}
+	var row *sql.Row
+	if model != "" {
+		row = e.db.QueryRow("SELECT COUNT(*) FROM chunks c JOIN embeddings v ON v.hash = c.hash AND v.model = ?", model)
+	} else {
+		row = e.db.QueryRow("SELECT COUNT(*) FROM chunks WHERE hash IN (SELECT hash FROM embeddings)")
+	}
+	row.Scan(&embedded)
+	return chunks, embedded
+}
+
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
+
+// cosine returns the cosine similarity, or false if the vectors differ in
+// dimension or one is zero.
+func cosine(a, b []float32) (float64, bool) {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0, false
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0, false
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb)), true
+}
+
+var (
+	wordPattern = regexp.MustCompile(`[\pL\pN_]+`)
+	stopWords   = map[string]bool{
+		"a": true, "an": true, "and": true, "are": true, "do": true, "does": true, "for": true,
+		"how": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true,
+		"our": true, "the": true, "to": true, "we": true, "what": true, "where": true,
+		"which": true, "who": true, "with": true,
+	}
+)
+
+// ftsQuery turns a natural language question into an FTS5 OR query of its
+// significant words, so "where do we validate IBANs?" matches any of
+// "validate" and "ibans" (stemmed by the porter tokenizer).
+func ftsQuery(q string) string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, w := range wordPattern.FindAllString(strings.ToLower(q), -1) {
+		if len(w) < 2 || stopWords[w] || seen[w] {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, `"`+w+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// --- Chunking ---
+
+var javaControlWords = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "catch": true, "return": true,
+	"new": true, "else": true, "try": true, "synchronized": true, "do": true, "throw": true,
+}
+
+// sourceChunks splits a Java or Kotlin file into one chunk per class (its
+// doc comment, annotations, declaration and member signatures) and one per
+// method (doc comment, annotations and body). Like the class parser it is
+// line based and approximate. The methods are returned for the index too.
+func sourceChunks(text, file string, classes []IndexedClass) ([]chunk, []IndexedMethod) {
+	lines := strings.Split(text, "\n")
+	var chunks []chunk
+	var methods []IndexedMethod
+
+	classNames := make(map[string]bool)
+	for _, c := range classes {
+		classNames[c.Name] = true
+	}
+	type classChunk struct {
+		chunk
+		members []string
+	}
+	var current *classChunk
+	var all []*classChunk
+
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "@") || strings.HasPrefix(trimmed, "//") ||
+			strings.HasPrefix(trimmed, "*") || strings.HasPrefix(trimmed, "/*") {
+			continue
+		}
+
+		if name := declaredClass(trimmed); name != "" && classNames[name] {
+			current = &classChunk{chunk: chunk{kind: "class", name: name, line: i + 1}}
+			current.text = leadingComments(lines, i) + trimmed
+			all = append(all, current)
+			continue
+		}
+
+		name, sig, block, ok := methodSignature(lines, i)
+		if !ok {
+			continue
+		}
+		end := i
+		if block {
+			end = blockEnd(lines, i)
+		}
+		body := strings.Join(lines[i:end+1], "\n")
+		className := ""
+		if current != nil {
+			className = current.name
+			current.members = append(current.members, sig)
+		}
+		qualified := name
+		if className != "" {
+			qualified = className + "." + name
+		}
+		chunks = append(chunks, chunk{
+			kind: "method",
+			name: qualified,
+			line: i + 1,
+			text: fmt.Sprintf("// %s in %s\n%s%s", qualified, file, leadingComments(lines, i), body),
+		})
+		methods = append(methods, methodInfo(name, className, file, i+1, sig, annotationsBefore(lines, i)))
+		i = end
+	}
+
+	for _, c := range all {
+		c.text = fmt.Sprintf("// %s in %s\n%s\n%s", c.name, file, c.text, strings.Join(c.members, "\n"))
+		chunks = append(chunks, c.chunk)
+	}
+	return chunks, methods
+}
+
+// declaredClass returns the class, interface or enum declared on a line.
+func declaredClass(line string) string {
+	for _, kind := range []string{"class", "interface", "enum"} {
+		idx := strings.Index(line, kind+" ")
+		if idx < 0 {
+			continue
+		}
+		// "foo(Bar.class, ...)" is a call, "data class Foo(...)" a declaration
+		if paren := strings.IndexByte(line, '('); paren >= 0 && paren < idx {
+			continue
+		}
+		if name := extractClassName(line, kind); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// methodSignature recognizes a method declaration starting at line i and
+// returns its name, its signature (up to the body) and whether the body is
+// a brace block; Kotlin expression bodies ("fun f() = ...") are not.
+func methodSignature(lines []string, i int) (name, sig string, block, ok bool) {
+	trimmed := strings.TrimSpace(lines[i])
+	paren := strings.IndexByte(trimmed, '(')
+	if paren <= 0 || strings.HasSuffix(trimmed, ";") {
+		return "", "", false, false
+	}
+	before := strings.Fields(trimmed[:paren])
+	if len(before) == 0 || strings.ContainsAny(trimmed[:paren], "=.") {
+		return "", "", false, false
+	}
+	name = before[len(before)-1]
+	if javaControlWords[before[0]] || javaControlWords[name] {
+		return "", "", false, false
+	}
+	// Kotlin: fun name(...), Java: at least a return type before the name
+	kotlin := containsWord(before, "fun")
+	if len(before) < 2 && !kotlin {
+		return "", "", false, false
+	}
+	if idx := strings.LastIndexByte(name, '>'); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if name == "" || name == "fun" {
+		return "", "", false, false
+	}
+	// The body must start within a few lines
+	for j := i; j < len(lines) && j < i+4; j++ {
+		line := lines[j]
+		brace := strings.IndexByte(line, '{')
+		eq := -1
+		if kotlin {
+			if rp := strings.LastIndexByte(line, ')'); rp >= 0 {
+				if k := strings.IndexByte(line[rp:], '='); k >= 0 {
+					eq = rp + k
+				}
+			} else if strings.HasPrefix(strings.TrimSpace(line), "=") {
+				eq = strings.IndexByte(line, '=')
+			}
+		}
+		if brace >= 0 || eq >= 0 {
+			block = brace >= 0 && (eq < 0 || brace < eq)
+			end := brace
+			if !block {
+				end = eq
+			}
+			sig = strings.TrimSpace(strings.Join(append(lines[i:j:j], line[:end]), " "))
+			return name, sig, block, true
+		}
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			break
+		}
+	}
+	return "", "", false, false
+}
+
+func containsWord(words []string, w string) bool {
+	for _, x := range words {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
+
+// blockEnd returns the line where the brace block opened at or after line
+// start closes. Braces inside string and char literals are ignored.
+func blockEnd(lines []string, start int) int {
+	depth := 0
+	opened := false
+	for j := start; j < len(lines); j++ {
+		inString := byte(0)
+		line := lines[j]
+		for k := 0; k < len(line); k++ {
+			ch := line[k]
+			switch {
+			case inString != 0:
+				if ch == '\\' {
+					k++
+				} else if ch == inString {
+					inString = 0
+				}
+			case ch == '"' || ch == '\'':
+				inString = ch
+			case ch == '/' && k+1 < len(line) && line[k+1] == '/':
+				k = len(line)
+			case ch == '{':
+				depth++
+				opened = true
+			case ch == '}':
+				depth--
+			}
+		}
+		if opened && depth <= 0 {
+			return j
+		}
+		if !opened && j > start+3 {
+			return start
+		}
+	}
+	return len(lines) - 1
+}
+
+// leadingComments returns the comment and annotation lines directly above
+// line i.
+func leadingComments(lines []string, i int) string {
+	start := i
+	for start > 0 {
+		t := strings.TrimSpace(lines[start-1])
+		if strings.HasPrefix(t, "@") || strings.HasPrefix(t, "//") || strings.HasPrefix(t, "/*") ||
+			strings.HasPrefix(t, "*") {
+			start--
+			continue
+		}
+		break
+	}
+	if start == i {
+		return ""
+	}
+	return strings.Join(lines[start:i], "\n") + "\n"
+}
+
+func annotationsBefore(lines []string, i int) []string {
+	var anns []string
+	for j := i - 1; j >= 0; j-- {
+		t := strings.TrimSpace(lines[j])
+		if !strings.HasPrefix(t, "@") {
+			break
+		}
+		if idx := strings.IndexByte(t, '('); idx >= 0 {
+			t = t[:idx]
+		}
+		anns = append([]string{t}, anns...)
+	}
+	return anns
+}
+
+func methodInfo(name, className, file string, line int, sig string, anns []string) IndexedMethod {
+	m := IndexedMethod{Name: name, ClassName: className, File: file, Line: line, Annotations: anns}
+	if open := strings.IndexByte(sig, '('); open >= 0 {
+		if close := strings.LastIndexByte(sig, ')'); close > open {
+			m.Params = sig[open+1 : close]
+			// Kotlin return type follows the parameters
+			if rest := strings.TrimSpace(sig[close+1:]); strings.HasPrefix(rest, ":") {
+				m.ReturnType = strings.TrimSpace(strings.TrimPrefix(rest, ":"))
+			}
+		}
+		if fields := strings.Fields(sig[:open]); len(fields) >= 2 && fields[0] != "fun" && m.ReturnType == "" {
+			m.ReturnType = fields[len(fields)-2]
+		}
+	}
+	return m
+}
+
+// configChunks splits application config: YAML by top-level key,
+// properties files by the first segment of the key.
+func configChunks(text, file string) []chunk {
+	lines := strings.Split(text, "\n")
+	var chunks []chunk
+	cur := -1
+	yaml := strings.HasSuffix(file, ".yml") || strings.HasSuffix(file, ".yaml")
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "!") {
+			continue
+		}
+		var section string
+		if yaml {
+			if line[0] != ' ' && line[0] != '\t' && line[0] != '-' {
+				section = strings.TrimSpace(strings.SplitN(trimmed, ":", 2)[0])
+			}
+			if trimmed == "---" {
+				section = "---"
+			}
+		} else {
+			key := strings.FieldsFunc(trimmed, func(r rune) bool { return r == '=' || r == ':' || r == ' ' })
+			if len(key) > 0 {
+				section = strings.SplitN(key[0], ".", 2)[0]
+			}
+		}
+		if section == "" && cur < 0 {
+			section = filepath.Base(file)
+		}
+		if section != "" && (cur < 0 || chunks[cur].name != section) {
+			chunks = append(chunks, chunk{kind: "config", name: section, line: i + 1, text: "# " + file + "\n"})
+			cur = len(chunks) - 1
+		}
+		chunks[cur].text += line + "\n"
+	}
+	return chunks
+}
@@ -285,23 +285,43 @@ func (p *AnthropicProvider) Models() []string {
 	return names
 }
 
-func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Response, error) {
-	// Extract system message
-	var system string
+// maxCacheBreakpoints is how many cache_control markers a request may carry.
+const maxCacheBreakpoints = 4
+
+// buildRequest converts a Request to the Messages API format. Tool calls
+// become tool_use blocks on the assistant turn and tool results become
+// tool_result blocks; consecutive results are merged into one user turn as
+// the API requires. Against the API itself, the tool definitions, the
+// system prompt and its stable context are marked for prompt caching, and a
+// response schema becomes a respond tool the model must call; the proxy
+// gets the schema in the system prompt instead.
+func (p *AnthropicProvider) buildRequest(req Request) anthropicRequest {
+	proxy := p.baseURL != defaultAnthropicAPI
+	if req.ResponseSchema != nil && proxy {
+		req.Messages = withSchemaInstruction(req.Messages, req.ResponseSchema)
+	}
+
+	var system Message
 	var messages []anthropicMessage
 	for _, msg := range req.Messages {
 		if msg.Role == "system" {
-			system = msg.Content
+			system = msg
 			continue
 		}
 
 		am := anthropicMessage{Role: msg.Role}
 		if msg.ToolCallID != "" {
-			am.Content = []anthropicContent{{
+			result := anthropicContent{
 				Type:      "tool_result",
 				ToolUseID: msg.ToolCallID,
 				Content:   msg.Content,
-			}}
+			}
+			if n := len(messages); n > 0 && messages[n-1].Role == "user" && isToolResultTurn(messages[n-1]) {
+				messages[n-1].Content = append(messages[n-1].Content, result)
+				continue
+			}
+			am.Role = "user"
+			am.Content = []anthropicContent{result}
 		} else if len(msg.ToolCalls) > 0 {
 			am.Role = "assistant"
 			for _, tc := range msg.ToolCalls {
@@ -319,7 +339,16 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Respons
 				}}, am.Content...)
 			}
 		} else {
-			am.Content = []anthropicContent{{Type: "text", Text: msg.Content}}
+			// Images go before the text that refers to them
+			for _, img := range msg.Images {
+				am.Content = append(am.Content, anthropicContent{
+					Type:   "image",
+					Source: &anthropicImageSource{Type: "base64", MediaType: img.MediaType, Data: img.base64Data()},
+				})
+			}
+			if msg.Content != "" || len(msg.Images) == 0 {
+				am.Content = append(am.Content, anthropicContent{Type: "text", Text: msg.Content})
+			}
 		}
 		messages = append(messages, am)
 	}
@@ -327,10 +356,12 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Respons
 	apiReq := anthropicRequest{
 		Model:     p.resolveModel(req.Model),
 		MaxTokens: req.MaxTokens,
-		System:    system,
 		Messages:  messages,
 		CWD:       req.WorkingDir,
 	}
+	if system.Content != "" {
+		apiReq.System = system.Content
+	}
 
 	if len(req.Tools) > 0 {
 		for _, t := range req.Tools {
@@ -341,6 +372,77 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Respons
 			})
 		}
 	}
+	if req.ResponseSchema != nil && !proxy {
+		schema, _ := objectSchema(req.ResponseSchema)
+		// Other tools stay usable, but the turn must end in some tool call
+		apiReq.ToolChoice = &anthropicToolChoice{Type: "any"}
+		if len(apiReq.Tools) == 0 {
+			apiReq.ToolChoice = &anthropicToolChoice{Type: "tool", Name: respondTool}
+		}
+		apiReq.Tools = append(apiReq.Tools, anthropicTool{
+			Name:        respondTool,
+			Description: respondDescription,
+			InputSchema: schema,
+		})
+	}
+
+	// The proxy takes a plain system prompt and caches on its own
+	if proxy {
+		return apiReq
+	}
+	marks := maxCacheBreakpoints
+	if n := len(apiReq.Tools); n > 0 {
+		apiReq.Tools[n-1].CacheControl = ephemeral
+		marks--
+	}
+	if system.Content != "" {
+		apiReq.System = systemBlocks(system.Content, system.CacheBreaks, marks)
+	}
+	return apiReq
+}
+
+// ephemeral is the cache_control marker for a prompt cache breakpoint.
+var ephemeral = &anthropicCacheControl{Type: "ephemeral"}
+
+// systemBlocks splits the system prompt at its cache breaks into text
+// blocks and marks the end of each stable prefix, at most max of them and
+// the longest prefixes first. A prompt without breaks is cached whole.
+func systemBlocks(system string, breaks []int, max int) []anthropicContent {
+	var cuts []int
+	for _, b := range breaks {
+		if b > 0 && b <= len(system) && (len(cuts) == 0 || b > cuts[len(cuts)-1]) {
+			cuts = append(cuts, b)
+		}
+	}
+	if len(cuts) == 0 {
+		cuts = []int{len(system)}
+	}
+	if len(cuts) > max {
+		cuts = cuts[len(cuts)-max:]
+	}
+	var blocks []anthropicContent
+	start := 0
+	for _, cut := range cuts {
+		blocks = append(blocks, anthropicContent{Type: "text", Text: system[start:cut], CacheControl: ephemeral})
+		start = cut
+	}
+	if start < len(system) {
+		blocks = append(blocks, anthropicContent{Type: "text", Text: system[start:]})
+	}
+	return blocks
+}
+
+func isToolResultTurn(m anthropicMessage) bool {
+	for _, c := range m.Content {
+		if c.Type != "tool_result" {
+			return false
+		}
+	}
+	return len(m.Content) > 0
+}
+
+func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	apiReq := p.buildRequest(req)
 
 	body, err := json.Marshal(apiReq)
 	if err != nil {
@@ -373,8 +475,7 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Respons
 	defer httpResp.Body.Close()
 
 	if httpResp.StatusCode != 200 {
-		respBody, _ := io.ReadAll(httpResp.Body)
-		return nil, fmt.Errorf("anthropic error %d: %s", httpResp.StatusCode, string(respBody))
+		return nil, newAPIError("anthropic", httpResp)
 	}
 
 	var apiResp anthropicResponse
@@ -383,11 +484,8 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Respons
 	}
 
 	resp := &Response{
-		Model: apiResp.Model,
-		Usage: Usage{
-			InputTokens:  apiResp.Usage.InputTokens,
-			OutputTokens: apiResp.Usage.OutputTokens,
-		},
+		Model:        apiResp.Model,
+		Usage:        apiResp.Usage,
 		FinishReason: apiResp.StopReason,
 	}
 
@@ -396,6 +494,10 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Respons
 		case "text":
 			resp.Content += block.Text
 		case "tool_use":
+			if req.ResponseSchema != nil && block.Name == respondTool {
+				resp.Content = respondContent(req.ResponseSchema, block.Input)
+				continue
+			}
 			resp.ToolCalls = append(resp.ToolCalls, ToolCall{
 				ID:    block.ID,
 				Name:  block.Name,
@@ -408,29 +510,8 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Respons
 }
 
 func (p *AnthropicProvider) StreamComplete(ctx context.Context, req Request, cb StreamCallback) error {
-	// Extract system message
-	var system string
-	var messages []anthropicMessage
-	for _, msg := range req.Messages {
-		if msg.Role == "system" {
-			system = msg.Content
-			continue
-		}
-		am := anthropicMessage{Role: msg.Role}
-		if msg.Content != "" {
-			am.Content = []anthropicContent{{Type: "text", Text: msg.Content}}
-		}
-		messages = append(messages, am)
-	}
-
-	apiReq := anthropicStreamRequest{
-		Model:     p.resolveModel(req.Model),
-		MaxTokens: req.MaxTokens,
-		System:    system,
-		Messages:  messages,
-		Stream:    true,
-		CWD:       req.WorkingDir,
-	}
+	apiReq := p.buildRequest(req)
+	apiReq.Stream = true
 
 	body, err := json.Marshal(apiReq)
 	if err != nil {
@@ -462,8 +543,7 @@ func (p *AnthropicProvider) StreamComplete(ctx context.Context, req Request, cb
 	defer httpResp.Body.Close()
 
 	if httpResp.StatusCode != 200 {
-		respBody, _ := io.ReadAll(httpResp.Body)
-		return fmt.Errorf("anthropic stream error %d: %s", httpResp.StatusCode, string(respBody))
+		return newAPIError("anthropic", httpResp)
 	}
 
 	// Detect proxy SSE vs native Anthropic SSE
@@ -524,7 +604,21 @@ func (p *AnthropicProvider) StreamComplete(ctx context.Context, req Request, cb
 		return nil
 	}
 
-	// Native Anthropic SSE
+	return parseAnthropicStream(scanner, cb)
+}
+
+// parseAnthropicStream reads native Messages API SSE events. Text deltas are
+// emitted as they arrive; tool_use blocks are assembled from their
+// input_json_delta fragments and emitted complete at content_block_stop.
+func parseAnthropicStream(scanner *bufio.Scanner, cb StreamCallback) error {
+	var streamModel string
+	var usage Usage
+	type toolBlock struct {
+		id, name string
+		input    strings.Builder
+	}
+	tools := make(map[int]*toolBlock)
+
 	for scanner.Scan() {
 		line := scanner.Text()
 		if !strings.HasPrefix(line, "data: ") {
@@ -536,28 +630,88 @@ func (p *AnthropicProvider) StreamComplete(ctx context.Context, req Request, cb
 		}
 
 		var event struct {
-			Type  string `json:"type"`
-			Delta *struct {
+			Type         string `json:"type"`
+			Index        int    `json:"index"`
+			ContentBlock *struct {
 				Type string `json:"type"`
-				Text string `json:"text"`
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"content_block"`
+			Delta *struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
 			} `json:"delta"`
+			Message *struct {
+				Model string `json:"model"`
+				Usage Usage  `json:"usage"`
+			} `json:"message"`
+			Usage *Usage `json:"usage"`
+			Error *struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
 		}
 		if err := json.Unmarshal([]byte(data), &event); err != nil {
 			continue
 		}
 
 		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				streamModel = event.Message.Model
+				usage = event.Message.Usage
+			}
+		case "content_block_start":
+			if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+				tools[event.Index] = &toolBlock{id: event.ContentBlock.ID, name: event.ContentBlock.Name}
+			}
 		case "content_block_delta":
-			if event.Delta != nil && event.Delta.Text != "" {
-				cb(StreamChunk{Content: event.Delta.Text})
+			if event.Delta == nil {
+				continue
+			}
+			switch event.Delta.Type {
+			case "input_json_delta":
+				if tb, ok := tools[event.Index]; ok {
+					tb.input.WriteString(event.Delta.PartialJSON)
+				}
+			default:
+				if event.Delta.Text != "" {
+					cb(StreamChunk{Content: event.Delta.Text})
+				}
+			}
+		case "content_block_stop":
+			tb, ok := tools[event.Index]
+			if !ok {
+				continue
+			}
+			delete(tools, event.Index)
+			input := map[string]interface{}{}
+			if raw := tb.input.String(); raw != "" {
+				if err := json.Unmarshal([]byte(raw), &input); err != nil {
+					return fmt.Errorf("anthropic stream: invalid input for tool %s: %w", tb.name, err)
+				}
+			}
+			cb(StreamChunk{ToolCalls: []ToolCall{{ID: tb.id, Name: tb.name, Input: input}}})
+		case "message_delta":
+			// Output tokens are cumulative
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
 			}
 		case "message_stop":
-			cb(StreamChunk{Done: true})
+			cb(StreamChunk{Done: true, Model: streamModel, Usage: &usage})
 			return nil
+		case "error":
+			if event.Error != nil {
+				return fmt.Errorf("anthropic stream error: %s: %s", event.Error.Type, event.Error.Message)
+			}
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading anthropic stream: %w", err)
+	}
 
-	cb(StreamChunk{Done: true})
+	cb(StreamChunk{Done: true, Model: streamModel, Usage: &usage})
 	return nil
 }
 
@@ -571,21 +725,14 @@ func (p *AnthropicProvider) resolveModel(override string) string {
 // --- Anthropic API types ---
 
 type anthropicRequest struct {
-	Model     string             `json:"model"`
-	MaxTokens int                `json:"max_tokens"`
-	System    string             `json:"system,omitempty"`
-	Messages  []anthropicMessage `json:"messages"`
-	Tools     []anthropicTool    `json:"tools,omitempty"`
-	CWD       string             `json:"cwd,omitempty"` // Working directory for proxy
-}
-
-type anthropicStreamRequest struct {
-	Model     string             `json:"model"`
-	MaxTokens int                `json:"max_tokens"`
-	System    string             `json:"system,omitempty"`
-	Messages  []anthropicMessage `json:"messages"`
-	Stream    bool               `json:"stream"`
-	CWD       string             `json:"cwd,omitempty"`
+	Model      string               `json:"model"`
+	MaxTokens  int                  `json:"max_tokens"`
+	System     interface{}          `json:"system,omitempty"` // string, or text blocks with cache breakpoints
+	Messages   []anthropicMessage   `json:"messages"`
+	Tools      []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Stream     bool                 `json:"stream,omitempty"`
+	CWD        string               `json:"cwd,omitempty"` // Working directory for proxy
 }
 
 type anthropicMessage struct {
@@ -601,12 +748,48 @@ type anthropicContent struct {
 	Input     map[string]interface{} `json:"input,omitempty"`
 	ToolUseID string                 `json:"tool_use_id,omitempty"`
 	Content   string                 `json:"content,omitempty"`
+
+	Source       *anthropicImageSource  `json:"source,omitempty"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"` // base64
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"` // "any" or "tool"
+	Name string `json:"name,omitempty"`
+}
+
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+// MarshalJSON always sends input on tool_use blocks, even when empty, since
+// the API requires it; omitempty would drop an empty map.
+func (c anthropicContent) MarshalJSON() ([]byte, error) {
+	type plain anthropicContent
+	if c.Type != "tool_use" {
+		return json.Marshal(plain(c))
+	}
+	input := c.Input
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	return json.Marshal(struct {
+		plain
+		Input map[string]interface{} `json:"input"`
+	}{plain(c), input})
 }
 
 type anthropicTool struct {
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	InputSchema interface{} `json:"input_schema"`
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description"`
+	InputSchema  interface{}            `json:"input_schema"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
 }
 
 type anthropicResponse struct {
@@ -614,8 +797,5 @@ type anthropicResponse struct {
 	Model      string             `json:"model"`
 	Content    []anthropicContent `json:"content"`
 	StopReason string             `json:"stop_reason"`
-	Usage      struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage"`
+	Usage      Usage              `json:"usage"`
 }
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/greencode/greenforge/internal/audit"
+	"github.com/greencode/greenforge/internal/rbac"
+)
+
+// callRecord collects what is audited about a single model call.
+type callRecord struct {
+	sel        *selection
+	served     target    // provider that answered, or the last one tried
+	attempts   []Attempt // failed calls before the final one
+	req        Request   // sanitized request as sent to the provider
+	redactions RedactionStats
+	model      string // model reported by the provider
+	usage      *Usage
+	stream     bool
+	cache      string // "hit", "miss", "bypass:<reason>", "" if the cache is off
+	fit        *contextFit
+	started    time.Time
+	err        error
+}
+
+// auditCall logs one "model.call" event. The prompt is recorded only as a
+// hash of the sanitized messages, never in clear text.
+func (r *Router) auditCall(ctx context.Context, rec callRecord) {
+	if r.auditor == nil {
+		return
+	}
+
+	details := map[string]string{
+		"stream":      strconv.FormatBool(rec.stream),
+		"duration_ms": strconv.FormatInt(time.Since(rec.started).Milliseconds(), 10),
+	}
+	if rec.sel != nil {
+		if rec.served.provider != nil {
+			details["provider"] = rec.served.provider.Name()
+		}
+		details["selection"] = rec.sel.reason
+		details["task"] = taskOf(rec.req)
+		details["fallback"] = strconv.FormatBool(rec.sel.fallbackTo(rec.served))
+		details["attempts"] = strconv.Itoa(len(rec.attempts) + 1)
+		if rec.sel.policy != nil {
+			details["policy"] = rec.sel.policy.ProjectPattern
+			if rec.sel.policy.Reason != "" {
+				details["policy_reason"] = rec.sel.policy.Reason
+			}
+		}
+		details["model"] = rec.served.model
+		if rec.model != "" {
+			details["model"] = rec.model
+		}
+		details["prompt_sha256"] = promptHash(rec.req)
+		details["messages"] = strconv.Itoa(len(rec.req.Messages))
+		if n := countImages(rec.req); n > 0 {
+			details["images"] = strconv.Itoa(n)
+		}
+		if rec.req.ResponseSchema != nil {
+			details["response_schema"] = "true"
+		}
+		details["redactions"] = strconv.Itoa(rec.redactions.Total())
+		categories := make([]string, 0, len(rec.redactions))
+		for c := range rec.redactions {
+			categories = append(categories, c)
+		}
+		sort.Strings(categories)
+		for _, c := range categories {
+			details["redacted."+c] = strconv.Itoa(rec.redactions[c])
+		}
+	}
+	if rec.cache != "" {
+		details["cache"] = rec.cache
+	}
+	if rec.fit != nil {
+		details["context_window"] = strconv.Itoa(rec.fit.window)
+		if rec.fit.tokens > 0 {
+			details["prompt_tokens_est"] = strconv.Itoa(rec.fit.tokens)
+		}
+		if trimmed := rec.fit.trimmed(); trimmed != "" {
+			details["context_trimmed"] = trimmed
+		}
+	}
+	if rec.usage != nil {
+		details["input_tokens"] = strconv.Itoa(rec.usage.InputTokens)
+		details["output_tokens"] = strconv.Itoa(rec.usage.OutputTokens)
+		if rec.usage.CacheReadTokens > 0 || rec.usage.CacheCreationTokens > 0 {
+			details["cache_read_tokens"] = strconv.Itoa(rec.usage.CacheReadTokens)
+			details["cache_creation_tokens"] = strconv.Itoa(rec.usage.CacheCreationTokens)
+		}
+	}
+	if rec.err != nil {
+		details["error"] = rec.err.Error()
+		var perr *PolicyError
+		if errors.As(rec.err, &perr) {
+			details["policy"] = perr.Policy.ProjectPattern
+			details["policy_denied"] = "true"
+		}
+	}
+
+	e := audit.Event{
+		Action:  audit.ActionModelCall,
+		Details: details,
+	}
+	if id, ok := rbac.IdentityFromContext(ctx); ok {
+		e.User = id.User
+	}
+	if project, ok := ctx.Value(ctxKeyProject{}).(string); ok {
+		e.Project = project
+	}
+	if session, ok := ctx.Value(ctxKeySession{}).(string); ok {
+		e.SessionID = session
+	}
+	r.auditor.Log(e)
+}
+
+// promptHash returns the SHA-256 of the messages and tool names sent.
+func promptHash(req Request) string {
+	tools := make([]string, len(req.Tools))
+	for i, t := range req.Tools {
+		tools[i] = t.Name
+	}
+	data, _ := json.Marshal(struct {
+		Messages []Message      `json:"messages"`
+		Context  []ContextBlock `json:"context,omitempty"`
+		Tools    []string       `json:"tools,omitempty"`
+	}{req.Messages, req.Context, tools})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
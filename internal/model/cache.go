@@ -0,0 +1,293 @@
+package model
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/greencode/greenforge/internal/config"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ResponseCache stores model responses keyed by an exact hash of the
+// sanitized request, so repeating a deterministic request does not call the
+// provider again. Only requests with temperature 0 and without tools that
+// have side effects are cached.
+type ResponseCache struct {
+	db         *sql.DB
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+}
+
+// CacheStats reports cache usage. Hits, misses and bypasses are counted
+// since the cache was created or last cleared.
+type CacheStats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Bypassed int64 `json:"bypassed"`
+	Entries  int64 `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// cachedResponse is what is stored for a cache entry.
+type cachedResponse struct {
+	Content      string     `json:"content"`
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
+	Model        string     `json:"model"`
+	FinishReason string     `json:"finish_reason"`
+}
+
+// OpenResponseCache opens or creates the cache database at path.
+func OpenResponseCache(path string, cfg config.CacheConfig) (*ResponseCache, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("opening cache db: %w", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS response_cache (
+			key        TEXT PRIMARY KEY,
+			provider   TEXT NOT NULL,
+			model      TEXT NOT NULL,
+			response   TEXT NOT NULL,
+			size       INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			last_used  INTEGER NOT NULL,
+			hits       INTEGER DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_response_cache_used ON response_cache(last_used);
+		CREATE TABLE IF NOT EXISTS cache_stats (
+			name  TEXT PRIMARY KEY,
+			value INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating cache schema: %w", err)
+	}
+
+	c := &ResponseCache{
+		db:         db,
+		ttl:        cfg.TTL.Duration,
+		maxEntries: cfg.MaxEntries,
+		maxBytes:   int64(cfg.MaxSizeMB) << 20,
+	}
+	if c.ttl <= 0 {
+		c.ttl = 24 * time.Hour
+	}
+	return c, nil
+}
+
+// Close closes the cache database.
+func (c *ResponseCache) Close() error {
+	return c.db.Close()
+}
+
+// get returns the cached response for key, or nil.
+func (c *ResponseCache) get(key string) (*Response, string) {
+	now := time.Now().Unix()
+	var provider, data string
+	err := c.db.QueryRow(`SELECT provider, response FROM response_cache WHERE key = ? AND expires_at > ?`,
+		key, now).Scan(&provider, &data)
+	if err != nil {
+		return nil, ""
+	}
+	var cr cachedResponse
+	if json.Unmarshal([]byte(data), &cr) != nil {
+		return nil, ""
+	}
+	c.db.Exec(`UPDATE response_cache SET last_used = ?, hits = hits + 1 WHERE key = ?`, now, key)
+	return &Response{
+		Content:      cr.Content,
+		ToolCalls:    cr.ToolCalls,
+		Model:        cr.Model,
+		FinishReason: cr.FinishReason,
+	}, provider
+}
+
+// put stores a response and evicts entries beyond the limits.
+func (c *ResponseCache) put(key, provider, model string, resp *Response) error {
+	data, err := json.Marshal(cachedResponse{
+		Content:      resp.Content,
+		ToolCalls:    resp.ToolCalls,
+		Model:        resp.Model,
+		FinishReason: resp.FinishReason,
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = c.db.Exec(`INSERT OR REPLACE INTO response_cache
+		(key, provider, model, response, size, created_at, expires_at, last_used)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		key, provider, model, string(data), len(data), now.Unix(), now.Add(c.ttl).Unix(), now.Unix())
+	if err != nil {
+		return fmt.Errorf("storing cached response: %w", err)
+	}
+	return c.evict()
+}
+
+// evict removes expired entries, then the least recently used ones until
+// the entry and size limits hold.
+func (c *ResponseCache) evict() error {
+	if _, err := c.db.Exec(`DELETE FROM response_cache WHERE expires_at <= ?`, time.Now().Unix()); err != nil {
+		return err
+	}
+	if c.maxEntries > 0 {
+		_, err := c.db.Exec(`DELETE FROM response_cache WHERE key IN (
+			SELECT key FROM response_cache ORDER BY last_used DESC, created_at DESC LIMIT -1 OFFSET ?)`, c.maxEntries)
+		if err != nil {
+			return err
+		}
+	}
+	if c.maxBytes > 0 {
+		_, err := c.db.Exec(`DELETE FROM response_cache WHERE key IN (
+			SELECT key FROM (
+				SELECT key, SUM(size) OVER (ORDER BY last_used DESC, created_at DESC, key) AS total
+				FROM response_cache)
+			WHERE total > ?)`, c.maxBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// count adds one to a hit/miss/bypass counter.
+func (c *ResponseCache) count(name string) {
+	c.db.Exec(`INSERT INTO cache_stats (name, value) VALUES (?, 1)
+		ON CONFLICT(name) DO UPDATE SET value = value + 1`, name)
+}
+
+// Stats returns the counters and the current size of the cache.
+func (c *ResponseCache) Stats() (CacheStats, error) {
+	var s CacheStats
+	err := c.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size), 0) FROM response_cache WHERE expires_at > ?`,
+		time.Now().Unix()).Scan(&s.Entries, &s.Bytes)
+	if err != nil {
+		return s, fmt.Errorf("reading cache size: %w", err)
+	}
+	rows, err := c.db.Query(`SELECT name, value FROM cache_stats`)
+	if err != nil {
+		return s, fmt.Errorf("reading cache counters: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return s, err
+		}
+		switch name {
+		case "hits":
+			s.Hits = value
+		case "misses":
+			s.Misses = value
+		case "bypassed":
+			s.Bypassed = value
+		}
+	}
+	return s, rows.Err()
+}
+
+// Clear removes all entries and resets the counters. It returns the number
+// of entries removed.
+func (c *ResponseCache) Clear() (int64, error) {
+	res, err := c.db.Exec(`DELETE FROM response_cache`)
+	if err != nil {
+		return 0, fmt.Errorf("clearing cache: %w", err)
+	}
+	if _, err := c.db.Exec(`DELETE FROM cache_stats`); err != nil {
+		return 0, fmt.Errorf("clearing cache counters: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// SetCache enables the response cache for Complete and StreamComplete.
+func (r *Router) SetCache(cache *ResponseCache) {
+	r.cache = cache
+}
+
+// cacheBypass returns why a request must not be cached, or "". Only
+// requests that set temperature 0 are deterministic enough to cache; the
+// providers' default temperatures are not 0.
+func cacheBypass(req Request) string {
+	if req.Temperature == nil || *req.Temperature > 0 {
+		return "temperature"
+	}
+	for _, t := range req.Tools {
+		if !t.ReadOnly {
+			return "tool " + t.Name
+		}
+	}
+	return ""
+}
+
+// cacheKey returns the hash identifying a sanitized request on a target.
+// encoding/json writes struct fields in order and map keys sorted, so equal
+// requests always produce the same key.
+func cacheKey(t target, req Request) string {
+	type tool struct {
+		Name        string      `json:"name"`
+		Description string      `json:"description"`
+		Schema      interface{} `json:"schema,omitempty"`
+	}
+	tools := make([]tool, len(req.Tools))
+	for i, td := range req.Tools {
+		tools[i] = tool{td.Name, td.Description, td.Schema}
+	}
+	data, _ := json.Marshal(struct {
+		Provider    string         `json:"provider"`
+		Model       string         `json:"model"`
+		Messages    []Message      `json:"messages"`
+		Context     []ContextBlock `json:"context,omitempty"`
+		Tools       []tool         `json:"tools"`
+		Temperature *float64       `json:"temperature"`
+		MaxTokens   int            `json:"max_tokens"`
+		Schema      interface{}    `json:"response_schema,omitempty"`
+	}{t.provider.Name(), t.model, req.Messages, req.Context, tools, req.Temperature, req.MaxTokens, req.ResponseSchema})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheLookup checks the cache for a sanitized request. It returns a hit
+// for the first target in the chain that has one, and the cache outcome
+// for the audit log: "hit", "miss", "bypass:<reason>" or "" when disabled.
+func (r *Router) cacheLookup(sel *selection, req Request) (*Response, target, string) {
+	if r.cache == nil {
+		return nil, target{}, ""
+	}
+	if why := cacheBypass(req); why != "" {
+		r.cache.count("bypassed")
+		return nil, target{}, "bypass:" + why
+	}
+	for _, t := range sel.chain {
+		if r.unsupported(t, req) != "" {
+			continue
+		}
+		if resp, provider := r.cache.get(cacheKey(t, req)); resp != nil {
+			r.cache.count("hits")
+			resp.Provider = provider
+			resp.Cached = true
+			return resp, t, "hit"
+		}
+	}
+	r.cache.count("misses")
+	return nil, target{}, "miss"
+}
+
+// cacheStore saves a response served by t after a cache miss.
+func (r *Router) cacheStore(outcome string, t target, req Request, resp *Response) {
+	if outcome != "miss" || (resp.Content == "" && len(resp.ToolCalls) == 0) {
+		return
+	}
+	if err := r.cache.put(cacheKey(t, req), t.provider.Name(), t.model, resp); err != nil {
+		log.Printf("Warning: response cache: %v", err)
+	}
+}
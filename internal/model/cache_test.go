@@ -0,0 +1,159 @@
+package model
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+func openTestCache(t *testing.T, cfg config.CacheConfig) *ResponseCache {
+	t.Helper()
+	c, err := OpenResponseCache(filepath.Join(t.TempDir(), "cache.db"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func zeroTemp() *float64 {
+	var t float64
+	return &t
+}
+
+func TestCacheKey(t *testing.T) {
+	anthropic := target{provider: &fakeProvider{name: "anthropic"}, model: "claude-sonnet"}
+	base := func() Request {
+		return Request{
+			Messages:    []Message{{Role: "user", Content: "explain this"}},
+			Tools:       []ToolDef{{Name: "read_file", Schema: map[string]interface{}{"type": "object", "required": []string{"path"}}, ReadOnly: true}},
+			Temperature: zeroTemp(),
+			MaxTokens:   1000,
+		}
+	}
+	key := cacheKey(anthropic, base())
+	if key != cacheKey(anthropic, base()) {
+		t.Fatal("equal requests have different keys")
+	}
+
+	tests := []struct {
+		name   string
+		target target
+		change func(r *Request)
+	}{
+		{"provider", target{provider: &fakeProvider{name: "openai"}, model: "claude-sonnet"}, func(r *Request) {}},
+		{"model", target{provider: anthropic.provider, model: "claude-haiku"}, func(r *Request) {}},
+		{"message", anthropic, func(r *Request) { r.Messages[0].Content = "explain that" }},
+		{"role", anthropic, func(r *Request) { r.Messages[0].Role = "system" }},
+		{"context", anthropic, func(r *Request) { r.Context = []ContextBlock{{Name: "index:api", Text: "GET /users"}} }},
+		{"tool schema", anthropic, func(r *Request) { r.Tools[0].Schema = map[string]interface{}{"type": "object"} }},
+		{"max tokens", anthropic, func(r *Request) { r.MaxTokens = 2000 }},
+		{"response schema", anthropic, func(r *Request) { r.ResponseSchema = map[string]interface{}{"type": "object"} }},
+	}
+	for _, tt := range tests {
+		req := base()
+		tt.change(&req)
+		if cacheKey(tt.target, req) == key {
+			t.Errorf("changing the %s keeps the key", tt.name)
+		}
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	warm := 0.7
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"temperature 0", Request{Temperature: zeroTemp()}, ""},
+		{"default temperature", Request{}, "temperature"},
+		{"warm", Request{Temperature: &warm}, "temperature"},
+		{"read-only tools", Request{Temperature: zeroTemp(), Tools: []ToolDef{{Name: "read_file", ReadOnly: true}}}, ""},
+		{"side effects", Request{Temperature: zeroTemp(), Tools: []ToolDef{{Name: "read_file", ReadOnly: true}, {Name: "git_push"}}}, "tool git_push"},
+	}
+	for _, tt := range tests {
+		if got := cacheBypass(tt.req); got != tt.want {
+			t.Errorf("%s: cacheBypass = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := openTestCache(t, config.CacheConfig{MaxEntries: 2})
+	for _, key := range []string{"a", "b"} {
+		if err := c.put(key, "anthropic", "", &Response{Content: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading "a" makes "b" the least recently used entry
+	time.Sleep(1100 * time.Millisecond)
+	if resp, _ := c.get("a"); resp == nil {
+		t.Fatal("entry a missing")
+	}
+	if err := c.put("c", "anthropic", "", &Response{Content: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	for key, kept := range map[string]bool{"a": true, "b": false, "c": true} {
+		if resp, _ := c.get(key); (resp != nil) != kept {
+			t.Errorf("entry %s kept = %v, want %v", key, resp != nil, kept)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c := openTestCache(t, config.CacheConfig{TTL: config.Duration{Duration: time.Second}})
+	if err := c.put("a", "anthropic", "", &Response{Content: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := c.get("a"); resp == nil {
+		t.Fatal("fresh entry missing")
+	}
+	time.Sleep(2100 * time.Millisecond)
+	if resp, _ := c.get("a"); resp != nil {
+		t.Error("expired entry returned")
+	}
+}
+
+func TestCompleteUsesCache(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AI.DefaultModel = "anthropic"
+	p := &fakeProvider{name: "anthropic"}
+	r := testRouter(cfg, p)
+	cache := openTestCache(t, config.CacheConfig{})
+	r.SetCache(cache)
+
+	req := Request{Messages: []Message{{Role: "user", Content: "hi"}}, Temperature: zeroTemp()}
+	first, err := r.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Cached || !second.Cached || second.Content != first.Content || second.Provider != "anthropic" {
+		t.Errorf("responses = %+v, %+v, want the second served from the cache", first, second)
+	}
+	if n := len(p.requests()); n != 1 {
+		t.Errorf("provider called %d times, want 1", n)
+	}
+
+	// Without temperature 0 the cache is not consulted
+	if _, err := r.Complete(context.Background(), Request{Messages: req.Messages}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.requests()); n != 2 {
+		t.Errorf("provider called %d times, want 2", n)
+	}
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Bypassed != 1 || stats.Entries != 1 {
+		t.Errorf("stats = %+v, want 1 hit, 1 miss, 1 bypass, 1 entry", stats)
+	}
+}
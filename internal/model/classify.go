@@ -0,0 +1,373 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/greencode/greenforge/internal/audit"
+	"github.com/greencode/greenforge/internal/rbac"
+)
+
+// Data classification labels, from least to most sensitive.
+const (
+	LabelPublic       = "public"
+	LabelInternal     = "internal"
+	LabelConfidential = "confidential"
+	LabelRestricted   = "restricted"
+)
+
+var labelRank = map[string]int{
+	LabelPublic:       0,
+	LabelInternal:     1,
+	LabelConfidential: 2,
+	LabelRestricted:   3,
+}
+
+// rank orders labels by sensitivity. Unknown labels count as restricted so
+// a typo in a file rule never lets content through; Clearance maps unknown
+// clearances to public for the same reason.
+func rank(label string) int {
+	if n, ok := labelRank[label]; ok {
+		return n
+	}
+	return labelRank[LabelRestricted]
+}
+
+// Source records which file some message content came from, so the router
+// can check it against the data classification before the request leaves.
+type Source struct {
+	Project string // project name or path; empty for the request's project
+	Path    string // file path, relative to the project root or absolute
+	Text    string // the part of the message from this file; empty for the whole message
+}
+
+// ClassificationError is returned when a request contains content from a
+// file whose label the selected providers are not cleared for and the rule
+// blocks instead of redacting.
+type ClassificationError struct {
+	Path      string
+	Label     string
+	Provider  string
+	Clearance string
+}
+
+func (e *ClassificationError) Error() string {
+	return fmt.Sprintf("%s is classified %s, provider %s is only cleared for %s", e.Path, e.Label, e.Provider, e.Clearance)
+}
+
+// Clearance returns the most sensitive label a provider may receive:
+// its configured clearance, or restricted for local providers and internal
+// for all others. An unknown configured clearance is public.
+func (r *Router) Clearance(provider string) string {
+	for _, pc := range r.cfg.AI.Providers {
+		if pc.Name == provider && pc.Clearance != "" {
+			if _, ok := labelRank[pc.Clearance]; !ok {
+				return LabelPublic
+			}
+			return pc.Clearance
+		}
+	}
+	if r.IsLocal(provider) {
+		return LabelRestricted
+	}
+	return LabelInternal
+}
+
+// chainClearance returns the lowest clearance in the selection's chain and
+// the provider it belongs to. Content is prepared once for the whole chain,
+// so a fallback never receives more than it is cleared for.
+func (r *Router) chainClearance(sel *selection) (clearance, provider string) {
+	for _, t := range sel.chain {
+		name := t.provider.Name()
+		c := r.Clearance(name)
+		if provider == "" || rank(c) < rank(clearance) {
+			clearance, provider = c, name
+		}
+	}
+	return clearance, provider
+}
+
+// Classify returns the label and action of the most sensitive rule matching
+// a file of a project, or "" if the file is not classified.
+func (r *Router) Classify(project, path string) (label, action string) {
+	root := r.projectRoot(project)
+	rel, anchored := relativeTo(root, path)
+	for _, dc := range r.cfg.AI.Classification {
+		if !r.projectMatches(dc.ProjectPattern, project, root) {
+			continue
+		}
+		for _, rule := range dc.Rules {
+			if !matchRule(rule.Path, rel, anchored) {
+				continue
+			}
+			a := "redact"
+			if rule.Action == "block" {
+				a = "block"
+			}
+			if label == "" || rank(rule.Label) > rank(label) || (rule.Label == label && a == "block") {
+				label, action = rule.Label, a
+			}
+		}
+	}
+	return label, action
+}
+
+// projectRoot resolves a project name or path to its directory, or "".
+func (r *Router) projectRoot(project string) string {
+	if filepath.IsAbs(project) {
+		return project
+	}
+	for _, p := range r.cfg.Projects {
+		if p.Name == project {
+			return p.Path
+		}
+	}
+	return ""
+}
+
+// projectMatches matches a classification's project pattern against the
+// project as given, its root directory and the directory name. The index
+// names projects by directory, sessions by path.
+func (r *Router) projectMatches(pattern, project, root string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, s := range []string{project, root, filepath.Base(root)} {
+		if s == "" || s == "." {
+			continue
+		}
+		if matched, _ := filepath.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeTo makes path relative to root. It reports whether the result is
+// anchored at the project root; paths outside a known root are not.
+func relativeTo(root, path string) (string, bool) {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if !filepath.IsAbs(path) {
+		return strings.TrimPrefix(path, "./"), root != ""
+	}
+	if root != "" {
+		if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel), true
+		}
+	}
+	return strings.TrimPrefix(path, "/"), false
+}
+
+// matchRule matches a rule's path glob. A glob without "/" matches the file
+// name, others match at any directory so "src/main/resources/**" covers
+// every module. A leading "/" anchors the glob at the project root, if the
+// path's root is known.
+func matchRule(pattern, rel string, anchored bool) bool {
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "/") {
+		matched, _ := filepath.Match(pattern, filepath.Base(rel))
+		return matched
+	}
+	rooted := strings.HasPrefix(pattern, "/") && anchored
+	segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		if matchGlob(segments, parts[i:]) {
+			return true
+		}
+		if rooted {
+			break
+		}
+	}
+	return false
+}
+
+// matchGlob matches path segments against pattern segments, where "**"
+// matches any number of directories.
+func matchGlob(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(parts); i >= 0; i-- {
+				if matchGlob(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if matched, _ := filepath.Match(pattern[0], parts[0]); !matched {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// unknownToolSource stands for tool output that names no file it came from.
+// In a classified project it counts as restricted: the tool may have read
+// any file.
+const unknownToolSource = "(tool output without a known source)"
+
+// classifies reports whether any classification rules apply to a project.
+func (r *Router) classifies(project string) bool {
+	root := r.projectRoot(project)
+	for _, dc := range r.cfg.AI.Classification {
+		if len(dc.Rules) > 0 && r.projectMatches(dc.ProjectPattern, project, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// classification is the decision for one classified file in a request.
+type classification struct {
+	path     string
+	project  string
+	label    string
+	action   string
+	decision string // allowed, redacted or blocked
+}
+
+// applyClassification checks message content from classified files against
+// the chain's clearance. Content above it is withheld wholesale, or the
+// request fails if a rule blocks. Tool output without sources is treated as
+// restricted. Every decision is audited.
+func (r *Router) applyClassification(ctx context.Context, sel *selection, req Request) (Request, error) {
+	if len(r.cfg.AI.Classification) == 0 {
+		return req, nil
+	}
+	project, _ := ctx.Value(ctxKeyProject{}).(string)
+	clearance, provider := r.chainClearance(sel)
+
+	decisions := make(map[string]*classification)
+	var blocked *ClassificationError
+	check := func(content string, sources []Source) string {
+		for _, src := range sources {
+			p := src.Project
+			if p == "" {
+				p = project
+			}
+			label, action := LabelRestricted, "redact"
+			if src.Path != unknownToolSource {
+				label, action = r.Classify(p, src.Path)
+			}
+			if label == "" {
+				continue
+			}
+			d := &classification{path: src.Path, project: p, label: label, action: action, decision: "allowed"}
+			if rank(label) > rank(clearance) {
+				if action == "block" {
+					d.decision = "blocked"
+					if blocked == nil {
+						blocked = &ClassificationError{Path: src.Path, Label: label, Provider: provider, Clearance: clearance}
+					}
+				} else {
+					d.decision = "redacted"
+					content = withhold(content, src, label)
+				}
+			}
+			key := p + "\x00" + src.Path
+			if prev, ok := decisions[key]; !ok || prev.decision == "allowed" {
+				decisions[key] = d
+			}
+		}
+		return content
+	}
+	msgs := make([]Message, len(req.Messages))
+	unknown := []Source{{Path: unknownToolSource}}
+	for i, msg := range req.Messages {
+		msgs[i] = msg
+		sources := msg.Sources
+		if msg.Role == "tool" && len(sources) == 0 && msg.Content != "" && r.classifies(project) {
+			sources = unknown
+		}
+		msgs[i].Content = check(msg.Content, sources)
+	}
+	blocks := make([]ContextBlock, len(req.Context))
+	for i, b := range req.Context {
+		blocks[i] = b
+		blocks[i].Text = check(b.Text, b.Sources)
+	}
+
+	keys := make([]string, 0, len(decisions))
+	for k := range decisions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		r.auditClassification(ctx, decisions[k], provider, clearance)
+	}
+	if blocked != nil {
+		return req, blocked
+	}
+	req.Messages = msgs
+	req.Context = blocks
+	return req, nil
+}
+
+// withhold replaces the content from a source by a notice for the model.
+func withhold(content string, src Source, label string) string {
+	notice := fmt.Sprintf("[WITHHELD: %s is classified %s]", src.Path, label)
+	if src.Text != "" && strings.Contains(content, src.Text) {
+		return strings.ReplaceAll(content, src.Text, notice)
+	}
+	return notice
+}
+
+// auditClassification logs a "model.classification" event, with the
+// ".denied" suffix when the request was blocked.
+func (r *Router) auditClassification(ctx context.Context, d *classification, provider, clearance string) {
+	if r.auditor == nil {
+		return
+	}
+	action := "model.classification"
+	if d.decision == "blocked" {
+		action += ".denied"
+	}
+	e := audit.Event{
+		Action:  action,
+		Project: d.project,
+		Details: map[string]string{
+			"path":      d.path,
+			"label":     d.label,
+			"action":    d.action,
+			"decision":  d.decision,
+			"provider":  provider,
+			"clearance": clearance,
+		},
+	}
+	if id, ok := rbac.IdentityFromContext(ctx); ok {
+		e.User = id.User
+	}
+	if session, ok := ctx.Value(ctxKeySession{}).(string); ok {
+		e.SessionID = session
+	}
+	r.auditor.Log(e)
+}
+
+// checkLabels warns about unknown labels and clearances in the
+// configuration. Unknown labels are treated as restricted, unknown
+// clearances as public.
+func (r *Router) checkLabels() {
+	for _, pc := range r.cfg.AI.Providers {
+		if _, ok := labelRank[pc.Clearance]; pc.Clearance != "" && !ok {
+			log.Printf("Warning: provider %s: unknown clearance %q, treating it as public", pc.Name, pc.Clearance)
+		}
+	}
+	for _, dc := range r.cfg.AI.Classification {
+		for _, rule := range dc.Rules {
+			if _, ok := labelRank[rule.Label]; !ok {
+				log.Printf("Warning: classification rule %s: unknown label %q, treating it as restricted", rule.Path, rule.Label)
+			}
+			if rule.Action != "" && rule.Action != "redact" && rule.Action != "block" {
+				log.Printf("Warning: classification rule %s: unknown action %q, redacting", rule.Path, rule.Action)
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+func classifiedConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.AI.DefaultModel = "anthropic"
+	cfg.AI.Classification = []config.DataClassification{{
+		ProjectPattern: "/c/GC/*",
+		Rules: []config.ClassificationRule{
+			{Path: "*.pem", Label: LabelRestricted, Action: "block"},
+			{Path: "application-prod.yml", Label: LabelRestricted},
+			{Path: "/secrets/**", Label: LabelConfidential},
+			{Path: "docs/**", Label: LabelPublic},
+		},
+	}}
+	return cfg
+}
+
+func TestClassifyPaths(t *testing.T) {
+	r := testRouter(classifiedConfig())
+	tests := []struct {
+		project, path string
+		label, action string
+	}{
+		{"/c/GC/bank", "certs/server.pem", LabelRestricted, "block"},
+		{"/c/GC/bank", "/c/GC/bank/app/src/main/resources/application-prod.yml", LabelRestricted, "redact"},
+		{"/c/GC/bank", "secrets/db.txt", LabelConfidential, "redact"},
+		{"/c/GC/bank", "/c/GC/bank/secrets/db.txt", LabelConfidential, "redact"},
+		{"/c/GC/bank", "/c/GC/bank/app/secrets/db.txt", "", ""}, // anchored at the root
+		{"/c/GC/bank", "module/docs/guide/intro.md", LabelPublic, "redact"},
+		{"/c/GC/bank", "src/Main.java", "", ""},
+		{"/home/me/oss", "certs/server.pem", "", ""},
+	}
+	for _, tt := range tests {
+		label, action := r.Classify(tt.project, tt.path)
+		if label != tt.label || action != tt.action {
+			t.Errorf("Classify(%s, %s) = %q, %q, want %q, %q", tt.project, tt.path, label, action, tt.label, tt.action)
+		}
+	}
+}
+
+// codeSearchMessage is a code_search tool result as the agent passes it on:
+// one source per hit, covering the hit's text.
+func codeSearchMessage(project string) Message {
+	prod := "--- config spring.datasource (src/main/resources/application-prod.yml:3)\npassword-policy: strict\n"
+	java := "--- class PaymentService (src/main/java/PaymentService.java:12)\npublic class PaymentService {}\n"
+	return Message{
+		Role:       "tool",
+		ToolCallID: "call_1",
+		Content:    prod + java,
+		Sources: []Source{
+			{Project: project, Path: "src/main/resources/application-prod.yml", Text: prod},
+			{Project: project, Path: "src/main/java/PaymentService.java", Text: java},
+		},
+	}
+}
+
+func sentContent(p *fakeProvider) string {
+	var sb strings.Builder
+	for _, req := range p.requests() {
+		for _, m := range req.Messages {
+			sb.WriteString(m.Content + "\n")
+		}
+	}
+	return sb.String()
+}
+
+func TestClassificationWithholds(t *testing.T) {
+	shell := Message{Role: "tool", ToolCallID: "call_2", Content: "$ cat deploy.env\nDB_HOST=prod-db\n"}
+	tests := []struct {
+		name     string
+		provider *fakeProvider
+		project  string
+		msgs     []Message
+		sent     []string
+		withheld []string
+		err      bool
+	}{
+		{"code_search hit from classified file", &fakeProvider{name: "anthropic"}, "/c/GC/bank",
+			[]Message{codeSearchMessage("/c/GC/bank")},
+			[]string{"public class PaymentService", "[WITHHELD: src/main/resources/application-prod.yml is classified restricted]"},
+			[]string{"password-policy"}, false},
+		{"local provider is cleared", &fakeProvider{name: "anthropic", local: true}, "/c/GC/bank",
+			[]Message{codeSearchMessage("/c/GC/bank")},
+			[]string{"password-policy", "public class PaymentService"}, nil, false},
+		{"tool output without sources", &fakeProvider{name: "anthropic"}, "/c/GC/bank",
+			[]Message{shell},
+			[]string{"[WITHHELD: " + unknownToolSource + " is classified restricted]"},
+			[]string{"DB_HOST"}, false},
+		{"unclassified project", &fakeProvider{name: "anthropic"}, "/home/me/oss",
+			[]Message{codeSearchMessage("/home/me/oss"), shell},
+			[]string{"password-policy", "DB_HOST"}, nil, false},
+		{"blocked file", &fakeProvider{name: "anthropic"}, "/c/GC/bank",
+			[]Message{{Role: "tool", Content: "-----BEGIN CERTIFICATE-----", Sources: []Source{{Path: "certs/server.pem"}}}},
+			nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRouter(classifiedConfig(), tt.provider)
+			msgs := append([]Message{{Role: "user", Content: "what does the config say?"}}, tt.msgs...)
+			_, err := r.Complete(WithProject(context.Background(), tt.project), Request{Messages: msgs})
+			if tt.err {
+				var cerr *ClassificationError
+				if !errors.As(err, &cerr) {
+					t.Fatalf("error = %v, want a ClassificationError", err)
+				}
+				if len(tt.provider.requests()) > 0 {
+					t.Error("blocked request was sent")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			sent := sentContent(tt.provider)
+			for _, want := range tt.sent {
+				if !strings.Contains(sent, want) {
+					t.Errorf("sent content lacks %q:\n%s", want, sent)
+				}
+			}
+			for _, secret := range tt.withheld {
+				if strings.Contains(sent, secret) {
+					t.Errorf("sent content contains %q:\n%s", secret, sent)
+				}
+			}
+		})
+	}
+}
+
+func TestClassificationContextBlocks(t *testing.T) {
+	p := &fakeProvider{name: "anthropic"}
+	r := testRouter(classifiedConfig(), p)
+	summary := "  GET /pay -> PaymentController.pay (src/PaymentController.java)\n  spring.datasource.url (src/main/resources/application-prod.yml)\n"
+	req := Request{
+		Messages: []Message{{Role: "user", Content: "hi"}},
+		Context: []ContextBlock{{Name: "index:bank", Text: summary, Sources: []Source{
+			{Path: "src/PaymentController.java", Text: "  GET /pay -> PaymentController.pay (src/PaymentController.java)"},
+			{Path: "src/main/resources/application-prod.yml", Text: "  spring.datasource.url (src/main/resources/application-prod.yml)"},
+		}}},
+	}
+	if _, err := r.Complete(WithProject(context.Background(), "/c/GC/bank"), req); err != nil {
+		t.Fatal(err)
+	}
+	sent := sentContent(p)
+	if !strings.Contains(sent, "PaymentController.pay") || strings.Contains(sent, "spring.datasource.url") {
+		t.Errorf("index summary not filtered per entry:\n%s", sent)
+	}
+}
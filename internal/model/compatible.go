@@ -0,0 +1,182 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+// ProviderTypeOpenAICompatible is the provider type for servers that speak
+// the OpenAI chat completions API, such as vLLM, LM Studio or llama.cpp.
+const ProviderTypeOpenAICompatible = "openai_compatible"
+
+// Capabilities describes what a provider's server supports.
+type Capabilities struct {
+	Tools         bool `json:"tools"`
+	Streaming     bool `json:"streaming"`
+	JSONMode      bool `json:"json_mode"`
+	Vision        bool `json:"vision"`
+	ContextWindow int  `json:"context_window,omitempty"` // tokens, 0 if unknown
+}
+
+// capable is implemented by providers whose capabilities are configured.
+// Providers without it are assumed to support everything.
+type capable interface {
+	Capabilities() Capabilities
+}
+
+// capabilitiesOf returns a provider's capabilities.
+func capabilitiesOf(p Provider) Capabilities {
+	if c, ok := p.(capable); ok {
+		return c.Capabilities()
+	}
+	return Capabilities{Tools: true, Streaming: true, JSONMode: true, Vision: true}
+}
+
+// unsupported returns why a target cannot serve a request, or "".
+func (r *Router) unsupported(t target, req Request) string {
+	if len(req.Tools) > 0 && !capabilitiesOf(t.provider).Tools {
+		return "does not support tools"
+	}
+	if len(req.Tools) > 0 && !r.ModelSpec(t.provider.Name(), t.model).Tools {
+		return modelName(t) + " does not support tools"
+	}
+	if hasImages(req) && !capabilitiesOf(t.provider).Vision {
+		return "does not support images"
+	}
+	if hasImages(req) && !r.ModelSpec(t.provider.Name(), t.model).Vision {
+		return modelName(t) + " does not support images"
+	}
+	return ""
+}
+
+// OpenAICompatibleProvider is a named instance of a self-hosted server with
+// an OpenAI-compatible API. Several can be registered under distinct names.
+type OpenAICompatibleProvider struct {
+	*OpenAIProvider
+	models []string
+	caps   Capabilities
+	local  bool
+}
+
+// NewOpenAICompatibleProvider creates a provider from an openai_compatible
+// config entry. The endpoint is the API base URL, e.g. http://gpu01:8000/v1.
+func NewOpenAICompatibleProvider(pc config.ProviderConfig) (*OpenAICompatibleProvider, error) {
+	if pc.Name == "" {
+		return nil, fmt.Errorf("%s provider needs a name", ProviderTypeOpenAICompatible)
+	}
+	if pc.Endpoint == "" {
+		return nil, fmt.Errorf("provider %s: endpoint is required", pc.Name)
+	}
+
+	p := &OpenAICompatibleProvider{
+		OpenAIProvider: &OpenAIProvider{
+			name:       pc.Name,
+			apiKey:     pc.APIKey,
+			authHeader: pc.AuthHeader,
+			model:      pc.Model,
+			baseURL:    strings.TrimRight(pc.Endpoint, "/"),
+			client:     &http.Client{Timeout: 5 * time.Minute},
+		},
+		models: pc.Models,
+		caps:   Capabilities{ContextWindow: pc.ContextWindow},
+		local:  pc.Local,
+	}
+	if p.authHeader == "" {
+		p.authHeader = "Authorization"
+	}
+	if p.model == "" && len(p.models) > 0 {
+		p.model = p.models[0]
+	}
+	for _, c := range pc.Capabilities {
+		switch strings.ToLower(c) {
+		case "tools":
+			p.caps.Tools = true
+		case "streaming":
+			p.caps.Streaming = true
+		case "json_mode", "json":
+			p.caps.JSONMode = true
+		case "vision":
+			p.caps.Vision = true
+		default:
+			return nil, fmt.Errorf("provider %s: unknown capability %q (use tools, streaming, json_mode, vision)", pc.Name, c)
+		}
+	}
+	// Without json_mode a response schema is enforced through a forced tool
+	// call, or only described in the prompt; the router validates either way
+	switch {
+	case p.caps.JSONMode:
+		p.schema = schemaNative
+	case p.caps.Tools:
+		p.schema = schemaTool
+	default:
+		p.schema = schemaPrompt
+	}
+	return p, nil
+}
+
+// Capabilities returns the configured capabilities.
+func (p *OpenAICompatibleProvider) Capabilities() Capabilities { return p.caps }
+
+// Local reports whether the server runs inside the network.
+func (p *OpenAICompatibleProvider) Local() bool { return p.local }
+
+// Available checks that the server answers on /models.
+func (p *OpenAICompatibleProvider) Available() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/models", nil)
+	p.setAuth(req)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == 200
+}
+
+// Models returns the configured model list, or asks the server.
+func (p *OpenAICompatibleProvider) Models() []string {
+	if len(p.models) > 0 {
+		return p.models
+	}
+	return p.OpenAIProvider.Models()
+}
+
+// Complete sends a chat completion. Tools are dropped if the server does not
+// support them; the router skips such providers for tool requests.
+func (p *OpenAICompatibleProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	if !p.caps.Tools {
+		req.Tools = nil
+	}
+	return p.OpenAIProvider.Complete(ctx, req)
+}
+
+// StreamComplete streams over SSE, or delivers the whole completion as one
+// chunk if the server cannot stream.
+func (p *OpenAICompatibleProvider) StreamComplete(ctx context.Context, req Request, cb StreamCallback) error {
+	if !p.caps.Tools {
+		req.Tools = nil
+	}
+	if p.caps.Streaming {
+		return p.OpenAIProvider.StreamComplete(ctx, req, cb)
+	}
+
+	resp, err := p.OpenAIProvider.Complete(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Content != "" {
+		cb(StreamChunk{Content: resp.Content})
+	}
+	if len(resp.ToolCalls) > 0 {
+		cb(StreamChunk{ToolCalls: resp.ToolCalls})
+	}
+	cb(StreamChunk{Done: true, Model: resp.Model, Usage: &resp.Usage})
+	return nil
+}
@@ -0,0 +1,265 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContextBlock is retrieved context for a request, such as an index summary
+// or semantic search results. The router appends the blocks that fit the
+// model's context window to the system prompt, stable blocks first, each
+// group in order.
+type ContextBlock struct {
+	Name     string   `json:"name"`
+	Text     string   `json:"text"`
+	Priority int      `json:"priority"`         // lowest priority is dropped first
+	Stable   bool     `json:"stable,omitempty"` // same across turns, so it can be prompt cached
+	Sources  []Source `json:"-"`                // files the text came from, checked against data classification
+}
+
+// defaultMaxTokens is the output budget of requests that do not set one.
+const defaultMaxTokens = 4096
+
+// fitMargin leaves room for the error of token estimates.
+const fitMargin = 0.9
+
+// toolExcerptChars is how much of an older tool result is kept when it has
+// to be shortened.
+const toolExcerptChars = 2000
+
+// ContextWindowError is returned when even the system prompt and the
+// current turn do not fit a model's context window. The router then tries
+// the next provider in the chain.
+type ContextWindowError struct {
+	Provider string
+	Model    string
+	Tokens   int // estimated prompt tokens after dropping everything optional
+	Budget   int // prompt tokens available in the context window
+}
+
+func (e *ContextWindowError) Error() string {
+	return fmt.Sprintf("request needs about %d prompt tokens, %s/%s has room for %d", e.Tokens, e.Provider, e.Model, e.Budget)
+}
+
+// contextFit records how a request was fitted to a model's context window.
+type contextFit struct {
+	window          int
+	tokens          int // estimated prompt tokens sent
+	droppedBlocks   int
+	trimmedResults  int
+	droppedMessages int
+}
+
+// trimmed describes what was left out, or "" if the request fit whole.
+func (f *contextFit) trimmed() string {
+	var parts []string
+	if f.droppedBlocks > 0 {
+		parts = append(parts, fmt.Sprintf("%d context blocks dropped", f.droppedBlocks))
+	}
+	if f.trimmedResults > 0 {
+		parts = append(parts, fmt.Sprintf("%d tool results shortened", f.trimmedResults))
+	}
+	if f.droppedMessages > 0 {
+		parts = append(parts, fmt.Sprintf("%d history messages dropped", f.droppedMessages))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fitContext assembles a request for a target's model: it limits MaxTokens
+// to what the model can generate and fits the prompt into the rest of the
+// context window. When it does not fit, the least important content goes
+// first: retrieved context blocks by priority, then older tool results are
+// shortened to an excerpt, then the oldest history turns are dropped, and
+// finally tool results of the current turn are shortened. System prompts
+// and the latest user message are always kept. The blocks that remain are
+// appended to the system prompt.
+func (r *Router) fitContext(t target, req Request) (Request, *contextFit, error) {
+	name := t.provider.Name()
+	spec := r.ModelSpec(name, t.model)
+	switch {
+	case req.MaxTokens <= 0:
+		req.MaxTokens = defaultMaxTokens
+		if spec.MaxOutput > 0 && spec.MaxOutput < req.MaxTokens {
+			req.MaxTokens = spec.MaxOutput
+		}
+	case spec.MaxOutput > 0 && req.MaxTokens > spec.MaxOutput:
+		req.MaxTokens = spec.MaxOutput
+	}
+	req.ContextWindow = spec.ContextWindow
+
+	fit := &contextFit{window: spec.ContextWindow}
+	budget := int(float64(spec.ContextWindow)*fitMargin) - req.MaxTokens - estimateTools(spec.Family, req.Tools)
+
+	msgs := make([]Message, len(req.Messages))
+	copy(msgs, req.Messages)
+	blocks := make([]ContextBlock, len(req.Context))
+	copy(blocks, req.Context)
+
+	tokens := 0
+	for _, msg := range msgs {
+		tokens += estimateMessage(spec.Family, msg)
+	}
+	for _, b := range blocks {
+		tokens += EstimateTokens(spec.Family, b.Text)
+	}
+
+	// Retrieved context, lowest priority and latest added first
+	if tokens > budget && len(blocks) > 0 {
+		order := make([]int, len(blocks))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			if blocks[order[a]].Priority != blocks[order[b]].Priority {
+				return blocks[order[a]].Priority < blocks[order[b]].Priority
+			}
+			return order[a] > order[b]
+		})
+		drop := make(map[int]bool)
+		for _, i := range order {
+			if tokens <= budget {
+				break
+			}
+			drop[i] = true
+			tokens -= EstimateTokens(spec.Family, blocks[i].Text)
+			fit.droppedBlocks++
+		}
+		kept := blocks[:0]
+		for i, b := range blocks {
+			if !drop[i] {
+				kept = append(kept, b)
+			}
+		}
+		blocks = kept
+	}
+
+	current := lastUserMessage(msgs)
+	shorten := func(from, to int) {
+		for i := from; i < to && tokens > budget; i++ {
+			if msgs[i].ToolCallID == "" {
+				continue
+			}
+			if short, ok := excerpt(msgs[i].Content, toolExcerptChars); ok {
+				tokens -= estimateMessage(spec.Family, msgs[i])
+				msgs[i].Content = short
+				tokens += estimateMessage(spec.Family, msgs[i])
+				fit.trimmedResults++
+			}
+		}
+	}
+	shorten(0, current)
+
+	// Oldest turns, each from a user message up to the next one, so tool
+	// results are never separated from the call that produced them
+	for tokens > budget {
+		start := -1
+		for i := 0; i < current; i++ {
+			if msgs[i].Role != "system" {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			break
+		}
+		end := start + 1
+		for end < current && !(msgs[end].Role == "user" && msgs[end].ToolCallID == "") {
+			end++
+		}
+		for _, msg := range msgs[start:end] {
+			tokens -= estimateMessage(spec.Family, msg)
+		}
+		msgs = append(msgs[:start], msgs[end:]...)
+		fit.droppedMessages += end - start
+		current -= end - start
+	}
+
+	shorten(current, len(msgs))
+	if tokens > budget {
+		return req, fit, &ContextWindowError{Provider: name, Model: modelName(t), Tokens: tokens, Budget: budget}
+	}
+
+	req.Messages = appendContext(msgs, blocks)
+	req.Context = nil
+	fit.tokens = tokens + estimateTools(spec.Family, req.Tools)
+	return req, fit, nil
+}
+
+// lastUserMessage returns the index of the latest message the user wrote,
+// or len(msgs) if there is none.
+func lastUserMessage(msgs []Message) int {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == "user" && msgs[i].ToolCallID == "" {
+			return i
+		}
+	}
+	return len(msgs)
+}
+
+// appendContext adds the blocks to the first system message, or to a new
+// one at the start: stable blocks first, then the others. The end of the
+// original prompt and of the stable blocks become cache breaks.
+func appendContext(msgs []Message, blocks []ContextBlock) []Message {
+	if len(blocks) == 0 {
+		return msgs
+	}
+	if len(msgs) == 0 || msgs[0].Role != "system" {
+		msgs = append([]Message{{Role: "system"}}, msgs...)
+	}
+	var sb strings.Builder
+	sb.WriteString(msgs[0].Content)
+	sources := append([]Source(nil), msgs[0].Sources...)
+	var breaks []int
+	mark := func() {
+		if n := sb.Len(); n > 0 && (len(breaks) == 0 || breaks[len(breaks)-1] != n) {
+			breaks = append(breaks, n)
+		}
+	}
+	mark()
+	for _, stable := range []bool{true, false} {
+		for _, b := range blocks {
+			if b.Stable == stable {
+				sb.WriteString(b.Text)
+				sources = append(sources, b.Sources...)
+			}
+		}
+		if stable {
+			mark()
+		}
+	}
+	msgs[0].Content = sb.String()
+	msgs[0].Sources = sources
+	msgs[0].CacheBreaks = breaks
+	return msgs
+}
+
+// excerpt shortens text to about max characters of whole lines from its
+// start and end. It reports false if the text is already short enough.
+func excerpt(text string, max int) (string, bool) {
+	if len(text) <= max {
+		return text, false
+	}
+	lines := strings.Split(text, "\n")
+	head, tail := 0, len(lines)
+	for size := 0; head < tail && size+len(lines[head]) <= max*2/3; head++ {
+		size += len(lines[head]) + 1
+	}
+	for size := 0; tail > head && size+len(lines[tail-1]) <= max/3; tail-- {
+		size += len(lines[tail-1]) + 1
+	}
+	if head == 0 && tail == len(lines) {
+		// A single long line
+		cut := max
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		return text[:cut] + "\n[... output shortened to fit the context window ...]", true
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.Join(lines[:head], "\n"))
+	sb.WriteString(fmt.Sprintf("\n[... %d lines omitted to fit the context window ...]\n", tail-head))
+	sb.WriteString(strings.Join(lines[tail:], "\n"))
+	return sb.String(), true
+}
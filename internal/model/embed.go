@@ -0,0 +1,154 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultEmbeddingModel is used when index.embedding_model is not set. It
+// runs on the local Ollama so indexed code does not leave the machine.
+const DefaultEmbeddingModel = "ollama/nomic-embed-text"
+
+// Embedder is implemented by providers that compute text embeddings.
+// An empty model selects the provider's default embedding model.
+type Embedder interface {
+	Embed(ctx context.Context, model string, texts []string) ([][]float32, error)
+}
+
+// EmbeddingModel returns the configured "provider/model" used by Embed.
+func (r *Router) EmbeddingModel() string {
+	if m := r.cfg.Index.EmbeddingModel; m != "" {
+		return m
+	}
+	return DefaultEmbeddingModel
+}
+
+// Embed returns one vector per text from the embedding model. The project's
+// model policy and budgets apply as for completions, the texts pass the
+// firewall first, and the call is retried, audited and recorded in the
+// usage ledger like a completion.
+func (r *Router) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return r.embed(ctx, texts, nil)
+}
+
+// EmbedFiles is Embed for texts from files of the project in ctx, one path
+// per text. Texts from files classified above the embedding provider's
+// clearance are not sent and get a nil vector.
+func (r *Router) EmbedFiles(ctx context.Context, texts, paths []string) ([][]float32, error) {
+	if len(paths) != len(texts) {
+		return nil, fmt.Errorf("embedding %d texts with %d paths", len(texts), len(paths))
+	}
+	return r.embed(ctx, texts, paths)
+}
+
+func (r *Router) embed(ctx context.Context, texts, paths []string) ([][]float32, error) {
+	started := time.Now()
+	id := r.EmbeddingModel()
+	name, m := splitModel(id)
+
+	project, _ := ctx.Value(ctxKeyProject{}).(string)
+	policy := r.matchPolicy(project)
+	sel := &selection{policy: policy, preferred: id, reason: "embedding model " + id}
+
+	var err error
+	p, ok := r.providers[name]
+	switch {
+	case policy != nil && !r.policyAllows(policy, name):
+		err = &PolicyError{Project: project, Policy: *policy, Requested: id, Reasons: []string{name + " is not allowed"}}
+	case !ok:
+		err = fmt.Errorf("embedding provider %s is not configured", name)
+	}
+	emb, canEmbed := p.(Embedder)
+	if err == nil && !canEmbed {
+		err = fmt.Errorf("provider %s does not support embeddings", name)
+	}
+	if err != nil {
+		r.auditCall(ctx, callRecord{started: started, err: err})
+		return nil, err
+	}
+	sel.chain = []target{{provider: p, model: m}}
+
+	if _, err := r.checkBudgets(ctx); err != nil {
+		r.auditCall(ctx, callRecord{sel: sel, started: started, err: err})
+		return nil, err
+	}
+
+	keep := r.embeddable(ctx, sel, texts, paths)
+	if len(keep) == 0 {
+		return make([][]float32, len(texts)), nil
+	}
+	req := Request{Model: m, Task: TaskEmbed, Messages: make([]Message, len(keep))}
+	for i, k := range keep {
+		req.Messages[i] = Message{Role: "user", Content: texts[k]}
+	}
+	sanitized, redactions := r.firewall.ScrubRequestStats(req)
+	inputs := make([]string, len(sanitized.Messages))
+	for i, msg := range sanitized.Messages {
+		inputs[i] = msg.Content
+	}
+
+	var vectors [][]float32
+	rec := callRecord{sel: sel, req: sanitized, redactions: redactions, started: started}
+	_, served, attempts, err := r.run(ctx, sel, sanitized, func(ctx context.Context, t target, req Request, _ []Attempt) (*Response, error) {
+		v, err := emb.Embed(ctx, req.Model, inputs)
+		if err != nil {
+			return nil, err
+		}
+		if len(v) != len(inputs) {
+			return nil, fmt.Errorf("%s returned %d embeddings for %d texts", t.provider.Name(), len(v), len(inputs))
+		}
+		vectors = v
+		return &Response{}, nil
+	}, nil)
+	rec.served, rec.attempts, rec.err = served, attempts, err
+	r.auditCall(ctx, rec)
+	if err != nil {
+		return nil, callError(served, attempts, err)
+	}
+	// Embedding APIs report no usage we parse, so the input is estimated
+	usage := Usage{}
+	for _, in := range inputs {
+		usage.InputTokens += EstimateTokens("", in)
+	}
+	rec.model, rec.usage = m, &usage
+	r.recordUsage(ctx, rec)
+	out := make([][]float32, len(texts))
+	for i, k := range keep {
+		out[k] = vectors[i]
+	}
+	return out, nil
+}
+
+// embeddable returns the indexes of the texts the embedding provider may
+// receive: all without paths, else those whose file is classified no
+// higher than its clearance. Each file withheld is audited.
+func (r *Router) embeddable(ctx context.Context, sel *selection, texts, paths []string) []int {
+	keep := make([]int, 0, len(texts))
+	if paths == nil || len(r.cfg.AI.Classification) == 0 {
+		for i := range texts {
+			keep = append(keep, i)
+		}
+		return keep
+	}
+	project, _ := ctx.Value(ctxKeyProject{}).(string)
+	clearance, provider := r.chainClearance(sel)
+	audited := make(map[string]bool)
+	for i, path := range paths {
+		label, action := r.Classify(project, path)
+		if label == "" || rank(label) <= rank(clearance) {
+			keep = append(keep, i)
+			continue
+		}
+		if audited[path] {
+			continue
+		}
+		audited[path] = true
+		d := &classification{path: path, project: project, label: label, action: action, decision: "redacted"}
+		if action == "block" {
+			d.decision = "blocked"
+		}
+		r.auditClassification(ctx, d, provider, clearance)
+	}
+	return keep
+}
@@ -1,128 +1,408 @@
 package model
 
 import (
+	"fmt"
+	"math"
 	"regexp"
 	"strings"
+
+	"github.com/greencode/greenforge/internal/config"
 )
 
 // Firewall scrubs secrets and sensitive data before sending to AI models.
 type Firewall struct {
-	patterns []*regexp.Regexp
-	keywords []string
+	rules []*secretRule
+	allow []*regexp.Regexp
+}
+
+// secretRule is a compiled detection rule. The secret is the regex group
+// named "secret" or, without one, the value after the first ':' or '='.
+type secretRule struct {
+	id        string
+	category  string // reported in redaction stats
+	re        *regexp.Regexp
+	keywords  []string // one must appear within proximity bytes before the match, same line
+	proximity int
+	entropy   float64 // minimum Shannon entropy of the secret in bits per char
+}
+
+// RedactionStats counts redacted secrets by pattern category.
+type RedactionStats map[string]int
+
+// Total returns the number of redactions over all categories.
+func (s RedactionStats) Total() int {
+	n := 0
+	for _, c := range s {
+		n += c
+	}
+	return n
+}
+
+// Finding is a rule match reported by Scan, redacted or not.
+type Finding struct {
+	Rule     string  `json:"rule"`
+	Category string  `json:"category"`
+	Line     int     `json:"line"`
+	Secret   string  `json:"-"`
+	Entropy  float64 `json:"entropy"`
+	Redacted bool    `json:"redacted"`
+	Reason   string  `json:"reason"` // why it was redacted or kept
 }
 
-// NewFirewall creates a firewall with default secret detection patterns.
+// NewFirewall creates a firewall with default secret detection rules.
 func NewFirewall() *Firewall {
-	return &Firewall{
-		patterns: compilePatterns(defaultPatterns),
-		keywords: defaultKeywords,
+	f, err := NewFirewallFromConfig(config.FirewallConfig{})
+	if err != nil {
+		panic(err) // the default rules are static
+	}
+	return f
+}
+
+// NewFirewallFromConfig creates a firewall with the default rules, minus
+// disabled ones, plus the configured rules. A configured rule with the id of
+// a default rule replaces it.
+func NewFirewallFromConfig(fc config.FirewallConfig) (*Firewall, error) {
+	f := &Firewall{}
+	disabled := make(map[string]bool)
+	for _, id := range fc.DisableRules {
+		disabled[id] = true
+	}
+	for _, r := range defaultRules {
+		if disabled[r.ID] {
+			delete(disabled, r.ID)
+			continue
+		}
+		if err := f.addRule(r); err != nil {
+			return nil, err
+		}
+	}
+	for id := range disabled {
+		return nil, fmt.Errorf("firewall: cannot disable unknown rule %q", id)
+	}
+
+	for _, r := range fc.Rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("firewall: rule with regex %q needs an id", r.Regex)
+		}
+		if err := f.addRule(r); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range append(append([]string{}, defaultAllowlist...), fc.Allowlist...) {
+		re, err := regexp.Compile(a)
+		if err != nil {
+			return nil, fmt.Errorf("firewall: allowlist entry %q: %w", a, err)
+		}
+		f.allow = append(f.allow, re)
 	}
+	return f, nil
 }
 
-var defaultPatterns = []string{
+// addRule compiles a rule, replacing an existing one with the same id.
+func (f *Firewall) addRule(r config.FirewallRule) error {
+	re, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return fmt.Errorf("firewall: rule %s: %w", r.ID, err)
+	}
+	rule := &secretRule{
+		id:        r.ID,
+		category:  r.Category,
+		re:        re,
+		proximity: r.Proximity,
+		entropy:   r.Entropy,
+	}
+	if rule.category == "" {
+		rule.category = r.ID
+	}
+	for _, k := range r.Keywords {
+		rule.keywords = append(rule.keywords, strings.ToLower(k))
+	}
+	for i, existing := range f.rules {
+		if existing.id == r.ID {
+			f.rules[i] = rule
+			return nil
+		}
+	}
+	f.rules = append(f.rules, rule)
+	return nil
+}
+
+var defaultRules = []config.FirewallRule{
+	// Private keys, header through footer, first so other rules leave the
+	// key body alone; a key cut off before its footer is redacted to the end
+	{ID: "private_key", Regex: `(?s)(?P<secret>-----BEGIN (?:RSA |EC |DSA |OPENSSH |ENCRYPTED )?PRIVATE KEY-----(?:.*?-----END (?:RSA |EC |DSA |OPENSSH |ENCRYPTED )?PRIVATE KEY-----|.*))`},
+
+	// JDBC connection strings with passwords, before the generic password rule
+	{ID: "jdbc", Regex: `(?i)jdbc:[a-z]+://[^\s]*password=[^\s&;]+`},
+
+	// Kafka SASL JAAS config: ...LoginModule required username="app" password="...";
+	{ID: "kafka_jaas", Regex: `(?i)LoginModule\s+required\b[^;]*?\bpassword\s*=\s*"(?P<secret>[^"]+)"`},
+
+	// Spring datasource password of any length; ${...} placeholders are allowlisted
+	{ID: "spring_datasource", Category: "password", Regex: `(?i)spring\.datasource\.password\s*[:=]\s*['"]?(?P<secret>[^\s'"]+)`},
+
 	// API keys and tokens
-	`(?i)(api[_-]?key|apikey)\s*[:=]\s*['"]?([A-Za-z0-9_\-]{20,})['"]?`,
-	`(?i)(secret|token|password|passwd|pwd)\s*[:=]\s*['"]?([^\s'"]{8,})['"]?`,
-	`(?i)(bearer\s+)[A-Za-z0-9_\-\.]{20,}`,
+	{ID: "api_key", Regex: `(?i)(api[_-]?key|apikey)\s*[:=]\s*['"]?([A-Za-z0-9_\-]{20,})['"]?`},
+	{ID: "password", Regex: `(?i)(secret|token|password|passwd|pwd)\s*[:=]\s*['"]?([^\s'"]{8,})['"]?`},
+	{ID: "bearer_token", Regex: `(?i)(bearer\s+)[A-Za-z0-9_\-\.]{20,}`},
 
 	// AWS
-	`AKIA[0-9A-Z]{16}`,
-	`(?i)aws[_-]?secret[_-]?access[_-]?key\s*[:=]\s*['"]?([A-Za-z0-9/+=]{40})['"]?`,
+	{ID: "aws_access_key", Category: "aws", Regex: `AKIA[0-9A-Z]{16}`},
+	{ID: "aws_secret_key", Category: "aws", Regex: `(?i)aws[_-]?secret[_-]?access[_-]?key\s*[:=]\s*['"]?([A-Za-z0-9/+=]{40})['"]?`},
 
 	// Azure
-	`(?i)(DefaultEndpointsProtocol=https;AccountName=)[^\s;]+`,
-	`(?i)(azure[_-]?(?:storage|devops|ad)[_-]?(?:key|token|secret|password))\s*[:=]\s*['"]?([^\s'"]{8,})['"]?`,
-
-	// JDBC connection strings with passwords
-	`(?i)jdbc:[a-z]+://[^\s]*password=[^\s&;]+`,
-
-	// Private keys
-	`-----BEGIN (?:RSA |EC |OPENSSH )?PRIVATE KEY-----`,
+	{ID: "azure_connection_string", Category: "azure", Regex: `(?i)(DefaultEndpointsProtocol=https;AccountName=)[^\s;]+`},
+	{ID: "azure_key", Category: "azure", Regex: `(?i)(azure[_-]?(?:storage|devops|ad)[_-]?(?:key|token|secret|password))\s*[:=]\s*['"]?([^\s'"]{8,})['"]?`},
 
 	// GitHub/GitLab tokens
-	`gh[ps]_[A-Za-z0-9_]{36,}`,
-	`glpat-[A-Za-z0-9_\-]{20,}`,
+	{ID: "github_token", Regex: `gh[ps]_[A-Za-z0-9_]{36,}`},
+	{ID: "gitlab_token", Regex: `glpat-[A-Za-z0-9_\-]{20,}`},
 
 	// Anthropic/OpenAI keys
-	`sk-ant-[A-Za-z0-9_\-]{20,}`,
-	`sk-[A-Za-z0-9]{20,}`,
+	{ID: "anthropic_key", Regex: `sk-ant-[A-Za-z0-9_\-]{20,}`},
+	{ID: "openai_key", Regex: `sk-[A-Za-z0-9]{20,}`},
+
+	// Random-looking base64 or hex strings close to a secret keyword
+	{ID: "generic_base64", Category: "generic_secret", Regex: `(?P<secret>[A-Za-z0-9+/_\-]{32,}={0,2})`,
+		Keywords: defaultKeywords, Proximity: 40, Entropy: 4.3},
+	{ID: "generic_hex", Category: "generic_secret", Regex: `\b(?P<secret>[0-9a-fA-F]{32,})\b`,
+		Keywords: defaultKeywords, Proximity: 40, Entropy: 3.0},
 }
 
 var defaultKeywords = []string{
 	"password", "passwd", "secret", "api_key", "apikey",
 	"access_token", "auth_token", "credentials",
-	"private_key", "client_secret",
+	"private_key", "client_secret", "token",
 }
 
-func compilePatterns(patterns []string) []*regexp.Regexp {
-	compiled := make([]*regexp.Regexp, 0, len(patterns))
-	for _, p := range patterns {
-		re, err := regexp.Compile(p)
-		if err == nil {
-			compiled = append(compiled, re)
-		}
-	}
-	return compiled
+// defaultAllowlist holds values that look like secrets but only refer to
+// one: ${DB_PASSWORD}-style placeholders without a default, templates and
+// masked values.
+var defaultAllowlist = []string{
+	`^\$\{[A-Za-z0-9_.\-]+\}$`,
+	`^\{\{[^}]*\}\}$`,
+	`^<[^>]+>$`,
+	`^[*•x]+$`,
 }
 
 // ScrubRequest sanitizes all messages in a request.
 func (f *Firewall) ScrubRequest(req Request) Request {
-	sanitized := Request{
-		Tools:       req.Tools,
-		MaxTokens:   req.MaxTokens,
-		Temperature: req.Temperature,
-		Model:       req.Model,
-	}
+	sanitized, _ := f.ScrubRequestStats(req)
+	return sanitized
+}
 
+// ScrubRequestStats sanitizes every outgoing field of a request and reports
+// how many secrets were redacted per category.
+func (f *Firewall) ScrubRequestStats(req Request) (Request, RedactionStats) {
+	return f.TokenizeRequest(req, nil)
+}
+
+// TokenizeRequest sanitizes every outgoing field of a request: message
+// content including system prompts and tool results, tool-call inputs and
+// tool descriptions. Secrets are replaced with the vault's placeholders, and
+// secrets the vault already knows are replaced wherever they appear, such as
+// restored tool-call inputs. A nil vault redacts secrets irreversibly. Fields
+// that are not sent as text, like WorkingDir, are kept as they are.
+func (f *Firewall) TokenizeRequest(req Request, vault *SecretVault) (Request, RedactionStats) {
+	stats := RedactionStats{}
+	scrub := func(s string) string { return f.scrub(s, stats, vault) }
+
+	sanitized := req
 	sanitized.Messages = make([]Message, len(req.Messages))
 	for i, msg := range req.Messages {
-		sanitized.Messages[i] = Message{
-			Role:       msg.Role,
-			Content:    f.ScrubText(msg.Content),
-			ToolCalls:  msg.ToolCalls,
-			ToolCallID: msg.ToolCallID,
+		msg.Content = scrub(msg.Content)
+		if len(msg.ToolCalls) > 0 {
+			msg.ToolCalls = mapToolInputs(msg.ToolCalls, scrub)
+		}
+		sanitized.Messages[i] = msg
+	}
+	if len(req.Context) > 0 {
+		sanitized.Context = make([]ContextBlock, len(req.Context))
+		for i, b := range req.Context {
+			b.Text = scrub(b.Text)
+			sanitized.Context[i] = b
+		}
+	}
+	if len(req.Tools) > 0 {
+		sanitized.Tools = make([]ToolDef, len(req.Tools))
+		for i, t := range req.Tools {
+			t.Description = scrub(t.Description)
+			sanitized.Tools[i] = t
 		}
 	}
 
-	return sanitized
+	return sanitized, stats
+}
+
+// ScrubTextStats redacts text like ScrubText, replacing secrets with the
+// vault's placeholders if vault is non-nil, and reports the redactions.
+func (f *Firewall) ScrubTextStats(text string, vault *SecretVault) (string, RedactionStats) {
+	stats := RedactionStats{}
+	return f.scrub(text, stats, vault), stats
 }
 
 // ScrubText replaces detected secrets in text with redacted placeholders.
 func (f *Firewall) ScrubText(text string) string {
-	result := text
+	return f.scrub(text, nil, nil)
+}
+
+// scrub redacts text, counting redactions into stats if non-nil. With a
+// vault, secrets are replaced by reversible placeholders instead.
+func (f *Firewall) scrub(text string, stats RedactionStats, vault *SecretVault) string {
+	return f.apply(text, stats, vault, nil)
+}
 
-	for _, pattern := range f.patterns {
-		result = pattern.ReplaceAllStringFunc(result, func(match string) string {
-			// Keep the key name, redact the value
-			if idx := strings.IndexAny(match, ":="); idx >= 0 {
-				return match[:idx+1] + " [REDACTED]"
+// Scan reports every rule match in text: what would be redacted, and what
+// was kept because of the allowlist, entropy or keyword proximity.
+func (f *Firewall) Scan(text string) []Finding {
+	var findings []Finding
+	f.apply(text, nil, nil, func(fd Finding) { findings = append(findings, fd) })
+	return findings
+}
+
+// apply runs the rules in order over text and replaces the secrets they
+// find. report, if set, receives every match with the decision.
+func (f *Firewall) apply(text string, stats RedactionStats, vault *SecretVault, report func(Finding)) string {
+	if vault != nil {
+		text = vault.conceal(text, stats)
+	}
+
+	for _, rule := range f.rules {
+		locs := rule.re.FindAllStringSubmatchIndex(text, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, loc := range locs {
+			start, end, key := rule.secretSpan(text, loc)
+			secret := text[start:end]
+			if secret == "" || strings.HasPrefix(secret, "[REDACTED") || isPlaceholder(secret) {
+				continue
+			}
+
+			reason := f.keep(rule, text, loc[0], secret)
+			if report != nil {
+				fd := Finding{
+					Rule:     rule.id,
+					Category: rule.category,
+					Line:     strings.Count(text[:start], "\n") + 1,
+					Secret:   secret,
+					Entropy:  entropy(secret),
+					Redacted: reason == "",
+					Reason:   reason,
+				}
+				if fd.Redacted {
+					fd.Reason = "matched rule " + rule.id
+				}
+				report(fd)
+			}
+			if reason != "" {
+				continue
+			}
+
+			if stats != nil {
+				stats[rule.category]++
+			}
+			b.WriteString(text[last:start])
+			switch {
+			case vault != nil:
+				b.WriteString(vault.token(secret, rule.category, secretLabel(rule.category, key)))
+			case strings.HasPrefix(secret, "-----BEGIN"):
+				b.WriteString("[REDACTED PRIVATE KEY]")
+			default:
+				b.WriteString("[REDACTED]")
 			}
-			if strings.HasPrefix(match, "-----BEGIN") {
-				return "[REDACTED PRIVATE KEY]"
+			last = end
+		}
+		if last > 0 {
+			b.WriteString(text[last:])
+			text = b.String()
+		}
+	}
+
+	return text
+}
+
+// secretSpan returns the position of the secret in a match and the key it
+// is assigned to: the "secret" group if the rule has one, otherwise the
+// value after the key name (see splitSecret).
+func (r *secretRule) secretSpan(text string, loc []int) (start, end int, key string) {
+	if g := r.re.SubexpIndex("secret"); g > 0 && loc[2*g] >= 0 {
+		start, end = loc[2*g], loc[2*g+1]
+		return start, end, strings.TrimRight(text[loc[0]:start], " \t:='\"")
+	}
+	match := text[loc[0]:loc[1]]
+	prefix, secret, _, key := splitSecret(r.category, match)
+	start = loc[0] + len(prefix)
+	return start, start + len(secret), key
+}
+
+// keep returns why a match is not redacted, or "" if it is.
+func (f *Firewall) keep(rule *secretRule, text string, matchStart int, secret string) string {
+	for _, a := range f.allow {
+		if a.MatchString(secret) {
+			return fmt.Sprintf("allowlisted by %s", a)
+		}
+	}
+	if rule.entropy > 0 {
+		if e := entropy(secret); e < rule.entropy {
+			return fmt.Sprintf("entropy %.2f below %.2f", e, rule.entropy)
+		}
+	}
+	if len(rule.keywords) > 0 {
+		// Only the same line counts
+		from := matchStart - rule.proximity
+		if nl := strings.LastIndexByte(text[:matchStart], '\n') + 1; from < nl {
+			from = nl
+		}
+		window := strings.ToLower(text[from:matchStart] + secret)
+		found := false
+		for _, k := range rule.keywords {
+			if strings.Contains(window, k) {
+				found = true
+				break
 			}
-			return "[REDACTED]"
-		})
+		}
+		if !found {
+			return fmt.Sprintf("no keyword within %d chars on the line", rule.proximity)
+		}
 	}
+	return ""
+}
 
-	return result
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
 }
 
 // ContainsSecret checks if text likely contains secrets.
 func (f *Firewall) ContainsSecret(text string) bool {
-	for _, pattern := range f.patterns {
-		if pattern.MatchString(text) {
+	for _, fd := range f.Scan(text) {
+		if fd.Redacted {
 			return true
 		}
 	}
 	return false
 }
 
-// AddPattern adds a custom secret detection pattern.
+// AddPattern adds a custom secret detection pattern, reported as category
+// "custom".
 func (f *Firewall) AddPattern(pattern string) error {
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return err
-	}
-	f.patterns = append(f.patterns, re)
-	return nil
+	return f.addRule(config.FirewallRule{ID: fmt.Sprintf("custom_%d", len(f.rules)+1), Category: "custom", Regex: pattern})
 }
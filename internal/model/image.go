@@ -0,0 +1,97 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Image is an image part of a message, e.g. a pasted screenshot.
+type Image struct {
+	MediaType string `json:"media_type"` // image/png, image/jpeg, image/gif or image/webp
+	Data      []byte `json:"data"`       // base64 in JSON
+	Name      string `json:"name,omitempty"`
+}
+
+// MaxImageBytes is the largest image the providers accept.
+const MaxImageBytes = 5 << 20
+
+// imageTokens approximates the prompt tokens of one image; providers scale
+// images down to about 1.15 megapixels, which costs roughly this much.
+const imageTokens = 1600
+
+// imageTypes are the media types every vision API accepts.
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// NewImage checks image data and detects its media type from the content,
+// not from what the client claims.
+func NewImage(name string, data []byte) (Image, error) {
+	if len(data) == 0 {
+		return Image{}, fmt.Errorf("empty image")
+	}
+	if len(data) > MaxImageBytes {
+		return Image{}, fmt.Errorf("image is %d bytes, at most %d are allowed", len(data), MaxImageBytes)
+	}
+	mediaType := http.DetectContentType(data)
+	if !imageTypes[mediaType] {
+		return Image{}, fmt.Errorf("unsupported image type %s (use PNG, JPEG, GIF or WebP)", mediaType)
+	}
+	return Image{MediaType: mediaType, Data: data, Name: name}, nil
+}
+
+// base64Data returns the image data base64-encoded.
+func (img Image) base64Data() string {
+	return base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// dataURL returns the image as a data: URL.
+func (img Image) dataURL() string {
+	return "data:" + img.MediaType + ";base64," + img.base64Data()
+}
+
+// ImageError refuses a request with images when no model in its chain
+// accepts them.
+type ImageError struct {
+	Chain []string // provider/model IDs that were considered
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("no model for this request accepts images (%s); choose a vision model",
+		strings.Join(e.Chain, ", "))
+}
+
+// checkImages returns an ImageError if a request has images but no target
+// of the chain supports vision.
+func (r *Router) checkImages(sel *selection, req Request) error {
+	if !hasImages(req) {
+		return nil
+	}
+	var chain []string
+	for _, t := range sel.chain {
+		if r.unsupported(t, req) == "" {
+			return nil
+		}
+		chain = append(chain, t.provider.Name()+"/"+modelName(t))
+	}
+	return &ImageError{Chain: chain}
+}
+
+// countImages returns the number of image parts in a request.
+func countImages(req Request) int {
+	n := 0
+	for _, msg := range req.Messages {
+		n += len(msg.Images)
+	}
+	return n
+}
+
+// hasImages reports whether any message of a request has image parts.
+func hasImages(req Request) bool {
+	return countImages(req) > 0
+}
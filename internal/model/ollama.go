@@ -32,6 +32,9 @@ func NewOllamaProvider(endpoint, defaultModel string) *OllamaProvider {
 
 func (p *OllamaProvider) Name() string { return "ollama" }
 
+// Local reports true: Ollama always counts as local for policies.
+func (p *OllamaProvider) Local() bool { return true }
+
 func (p *OllamaProvider) Available() bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
@@ -76,6 +79,8 @@ func (p *OllamaProvider) Models() []string {
 	return names
 }
 
+// Complete sends a chat request. A response schema is passed as format,
+// which constrains the model's output to it.
 func (p *OllamaProvider) Complete(ctx context.Context, req Request) (*Response, error) {
 	ollamaReq := ollamaChatRequest{
 		Model:    p.resolveModel(req.Model),
@@ -84,7 +89,9 @@ func (p *OllamaProvider) Complete(ctx context.Context, req Request) (*Response,
 		Options: ollamaOptions{
 			Temperature: req.Temperature,
 			NumPredict:  req.MaxTokens,
+			NumCtx:      req.ContextWindow,
 		},
+		Format: req.ResponseSchema,
 	}
 
 	if len(req.Tools) > 0 {
@@ -109,8 +116,7 @@ func (p *OllamaProvider) Complete(ctx context.Context, req Request) (*Response,
 	defer httpResp.Body.Close()
 
 	if httpResp.StatusCode != 200 {
-		respBody, _ := io.ReadAll(httpResp.Body)
-		return nil, fmt.Errorf("ollama error %d: %s", httpResp.StatusCode, string(respBody))
+		return nil, newAPIError("ollama", httpResp)
 	}
 
 	var ollamaResp ollamaChatResponse
@@ -141,6 +147,8 @@ func (p *OllamaProvider) Complete(ctx context.Context, req Request) (*Response,
 	return resp, nil
 }
 
+// StreamComplete streams /api/chat as NDJSON. Ollama sends tool calls whole
+// in a message chunk, so they are emitted as soon as they arrive.
 func (p *OllamaProvider) StreamComplete(ctx context.Context, req Request, cb StreamCallback) error {
 	ollamaReq := ollamaChatRequest{
 		Model:    p.resolveModel(req.Model),
@@ -149,7 +157,13 @@ func (p *OllamaProvider) StreamComplete(ctx context.Context, req Request, cb Str
 		Options: ollamaOptions{
 			Temperature: req.Temperature,
 			NumPredict:  req.MaxTokens,
+			NumCtx:      req.ContextWindow,
 		},
+		Format: req.ResponseSchema,
+	}
+
+	if len(req.Tools) > 0 {
+		ollamaReq.Tools = convertTools(req.Tools)
 	}
 
 	body, err := json.Marshal(ollamaReq)
@@ -165,31 +179,92 @@ func (p *OllamaProvider) StreamComplete(ctx context.Context, req Request, cb Str
 
 	httpResp, err := p.client.Do(httpReq)
 	if err != nil {
-		return err
+		return fmt.Errorf("ollama request failed: %w", err)
 	}
 	defer httpResp.Body.Close()
 
-	decoder := json.NewDecoder(httpResp.Body)
+	if httpResp.StatusCode != 200 {
+		return newAPIError("ollama", httpResp)
+	}
+
+	return parseOllamaStream(httpResp.Body, cb)
+}
+
+// parseOllamaStream reads NDJSON chat chunks and emits them as StreamChunks.
+func parseOllamaStream(body io.Reader, cb StreamCallback) error {
+	decoder := json.NewDecoder(body)
+	calls := 0
 	for {
 		var chunk ollamaChatResponse
 		if err := decoder.Decode(&chunk); err != nil {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return fmt.Errorf("decoding ollama stream: %w", err)
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("ollama stream error: %s", chunk.Error)
 		}
 
-		cb(StreamChunk{
+		sc := StreamChunk{
 			Content: chunk.Message.Content,
 			Done:    chunk.Done,
-		})
+		}
+		for _, tc := range chunk.Message.ToolCalls {
+			sc.ToolCalls = append(sc.ToolCalls, ToolCall{
+				ID:    fmt.Sprintf("call_%d", calls),
+				Name:  tc.Function.Name,
+				Input: tc.Function.Arguments,
+			})
+			calls++
+		}
+		if chunk.Done {
+			sc.Model = chunk.Model
+			sc.Usage = &Usage{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount}
+		}
+		if sc.Content != "" || len(sc.ToolCalls) > 0 || sc.Done {
+			cb(sc)
+		}
 
 		if chunk.Done {
-			break
+			return nil
 		}
 	}
 
-	return nil
+	// Connection closed without a done marker: the response may be cut short
+	return fmt.Errorf("ollama stream ended without done marker: %w", io.ErrUnexpectedEOF)
+}
+
+// Embed computes embeddings via /api/embed, by default with nomic-embed-text.
+func (p *OllamaProvider) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	if model == "" {
+		model = "nomic-embed-text"
+	}
+	body, err := json.Marshal(ollamaEmbedRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.endpoint+"/api/embed", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("ollama request: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != 200 {
+		return nil, newAPIError("ollama", httpResp)
+	}
+
+	var result ollamaEmbedResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding ollama embeddings: %w", err)
+	}
+	return result.Embeddings, nil
 }
 
 func (p *OllamaProvider) resolveModel(override string) string {
@@ -207,12 +282,14 @@ type ollamaChatRequest struct {
 	Stream   bool            `json:"stream"`
 	Tools    []ollamaTool    `json:"tools,omitempty"`
 	Options  ollamaOptions   `json:"options,omitempty"`
+	Format   interface{}     `json:"format,omitempty"` // JSON Schema the output is constrained to
 }
 
 type ollamaMessage struct {
 	Role      string           `json:"role"`
 	Content   string           `json:"content"`
 	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	Images    []string         `json:"images,omitempty"` // base64, for vision models
 }
 
 type ollamaToolCall struct {
@@ -236,25 +313,50 @@ type ollamaFunction struct {
 }
 
 type ollamaOptions struct {
-	Temperature float64 `json:"temperature,omitempty"`
-	NumPredict  int     `json:"num_predict,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"` // context window the request was fitted to
 }
 
 type ollamaChatResponse struct {
 	Model           string        `json:"model"`
 	Message         ollamaMessage `json:"message"`
 	Done            bool          `json:"done"`
+	Error           string        `json:"error,omitempty"`
 	PromptEvalCount int           `json:"prompt_eval_count"`
 	EvalCount       int           `json:"eval_count"`
 }
 
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
 func convertMessages(msgs []Message) []ollamaMessage {
 	result := make([]ollamaMessage, len(msgs))
 	for i, msg := range msgs {
-		result[i] = ollamaMessage{
+		om := ollamaMessage{
 			Role:    msg.Role,
 			Content: msg.Content,
 		}
+		// Tool results go back as role "tool", the calls on the assistant turn
+		if msg.ToolCallID != "" {
+			om.Role = "tool"
+		}
+		for _, tc := range msg.ToolCalls {
+			om.ToolCalls = append(om.ToolCalls, ollamaToolCall{
+				Function: ollamaFunctionCall{Name: tc.Name, Arguments: tc.Input},
+			})
+		}
+		for _, img := range msg.Images {
+			om.Images = append(om.Images, img.base64Data())
+		}
+		result[i] = om
 	}
 	return result
 }
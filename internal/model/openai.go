@@ -1,20 +1,26 @@
 package model
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
 // OpenAIProvider implements Provider for OpenAI GPT models.
 type OpenAIProvider struct {
-	apiKey string
-	model  string
-	client *http.Client
+	name       string
+	apiKey     string
+	authHeader string // header carrying apiKey; Authorization is sent as Bearer
+	model      string
+	baseURL    string
+	client     *http.Client
+	schema     string // how a response schema is enforced, schemaNative if empty
 }
 
 func NewOpenAIProvider(apiKey, defaultModel string) *OpenAIProvider {
@@ -22,13 +28,16 @@ func NewOpenAIProvider(apiKey, defaultModel string) *OpenAIProvider {
 		defaultModel = "gpt-4o"
 	}
 	return &OpenAIProvider{
-		apiKey: apiKey,
-		model:  defaultModel,
-		client: &http.Client{Timeout: 5 * time.Minute},
+		name:       "openai",
+		apiKey:     apiKey,
+		authHeader: "Authorization",
+		model:      defaultModel,
+		baseURL:    "https://api.openai.com/v1",
+		client:     &http.Client{Timeout: 5 * time.Minute},
 	}
 }
 
-func (p *OpenAIProvider) Name() string { return "openai" }
+func (p *OpenAIProvider) Name() string { return p.name }
 
 func (p *OpenAIProvider) Available() bool {
 	return p.apiKey != ""
@@ -43,8 +52,8 @@ func (p *OpenAIProvider) Models() []string {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	req, _ := http.NewRequestWithContext(ctx, "GET", "https://api.openai.com/v1/models", nil)
-	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req, _ := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/models", nil)
+	p.setAuth(req)
 
 	resp, err := p.client.Do(req)
 	if err != nil {
@@ -76,13 +85,28 @@ func (p *OpenAIProvider) Models() []string {
 	return names
 }
 
-func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+// buildRequest converts a Request to the chat completions format. A
+// response schema is sent as a json_schema response format, or per the
+// provider's schema strategy as a forced respond function or a system
+// prompt instruction.
+func (p *OpenAIProvider) buildRequest(req Request) openaiRequest {
+	if req.ResponseSchema != nil && p.schemaStrategy() == schemaPrompt {
+		req.Messages = withSchemaInstruction(req.Messages, req.ResponseSchema)
+	}
 	messages := make([]openaiMessage, 0, len(req.Messages))
 	for _, msg := range req.Messages {
 		om := openaiMessage{
 			Role:    msg.Role,
 			Content: msg.Content,
 		}
+		if len(msg.Images) > 0 {
+			if msg.Content != "" {
+				om.Parts = append(om.Parts, openaiPart{Type: "text", Text: msg.Content})
+			}
+			for _, img := range msg.Images {
+				om.Parts = append(om.Parts, openaiPart{Type: "image_url", ImageURL: &openaiImageURL{URL: img.dataURL()}})
+			}
+		}
 		if msg.ToolCallID != "" {
 			om.ToolCallID = msg.ToolCallID
 			om.Role = "tool"
@@ -123,28 +147,86 @@ func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response,
 		}
 	}
 
+	if req.ResponseSchema != nil {
+		schema, _ := objectSchema(req.ResponseSchema)
+		switch p.schemaStrategy() {
+		case schemaNative:
+			apiReq.ResponseFormat = &openaiResponseFormat{
+				Type:       "json_schema",
+				JSONSchema: &openaiJSONSchema{Name: "response", Schema: schema},
+			}
+		case schemaTool:
+			apiReq.ToolChoice = "required"
+			if len(apiReq.Tools) == 0 {
+				apiReq.ToolChoice = map[string]interface{}{"type": "function", "function": map[string]string{"name": respondTool}}
+			}
+			apiReq.Tools = append(apiReq.Tools, openaiTool{
+				Type: "function",
+				Function: openaiToolFunction{
+					Name:        respondTool,
+					Description: respondDescription,
+					Parameters:  schema,
+				},
+			})
+		}
+	}
+	return apiReq
+}
+
+// schemaStrategy returns how the provider enforces a response schema.
+func (p *OpenAIProvider) schemaStrategy() string {
+	if p.schema == "" {
+		return schemaNative
+	}
+	return p.schema
+}
+
+// setAuth adds the API key, if any, to a request.
+func (p *OpenAIProvider) setAuth(req *http.Request) {
+	if p.apiKey == "" {
+		return
+	}
+	if strings.EqualFold(p.authHeader, "Authorization") {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+		return
+	}
+	req.Header.Set(p.authHeader, p.apiKey)
+}
+
+// post sends a chat completions request and returns the 200 response.
+func (p *OpenAIProvider) post(ctx context.Context, apiReq openaiRequest) (*http.Response, error) {
 	body, err := json.Marshal(apiReq)
 	if err != nil {
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/chat/completions", bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	p.setAuth(httpReq)
+	if apiReq.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
 
 	httpResp, err := p.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("openai request: %w", err)
+		return nil, fmt.Errorf("%s request: %w", p.name, err)
 	}
-	defer httpResp.Body.Close()
-
 	if httpResp.StatusCode != 200 {
-		respBody, _ := io.ReadAll(httpResp.Body)
-		return nil, fmt.Errorf("openai error %d: %s", httpResp.StatusCode, string(respBody))
+		defer httpResp.Body.Close()
+		return nil, newAPIError(p.name, httpResp)
 	}
+	return httpResp, nil
+}
+
+func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	httpResp, err := p.post(ctx, p.buildRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
 
 	var apiResp openaiResponse
 	if err := json.NewDecoder(httpResp.Body).Decode(&apiResp); err != nil {
@@ -152,12 +234,18 @@ func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response,
 	}
 
 	if len(apiResp.Choices) == 0 {
-		return nil, fmt.Errorf("openai returned no choices")
+		return nil, fmt.Errorf("%s returned no choices", p.name)
 	}
 
 	choice := apiResp.Choices[0]
+	content := choice.Message.Content
+	if req.ResponseSchema != nil && p.schemaStrategy() == schemaNative {
+		if _, wrapped := objectSchema(req.ResponseSchema); wrapped {
+			content = unwrapValue(content)
+		}
+	}
 	resp := &Response{
-		Content: choice.Message.Content,
+		Content: content,
 		Model:   apiResp.Model,
 		Usage: Usage{
 			InputTokens:  apiResp.Usage.PromptTokens,
@@ -169,6 +257,10 @@ func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response,
 	for _, tc := range choice.Message.ToolCalls {
 		var input map[string]interface{}
 		json.Unmarshal([]byte(tc.Function.Arguments), &input)
+		if req.ResponseSchema != nil && tc.Function.Name == respondTool {
+			resp.Content = respondContent(req.ResponseSchema, input)
+			continue
+		}
 		resp.ToolCalls = append(resp.ToolCalls, ToolCall{
 			ID:    tc.ID,
 			Name:  tc.Function.Name,
@@ -179,19 +271,162 @@ func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response,
 	return resp, nil
 }
 
+// StreamComplete streams a chat completion over SSE. Text deltas are emitted
+// as they arrive; tool calls are assembled from their argument deltas and
+// emitted complete once the model finishes them.
 func (p *OpenAIProvider) StreamComplete(ctx context.Context, req Request, cb StreamCallback) error {
-	resp, err := p.Complete(ctx, req)
+	apiReq := p.buildRequest(req)
+	apiReq.Stream = true
+	apiReq.StreamOptions = &openaiStreamOptions{IncludeUsage: true}
+
+	httpResp, err := p.post(ctx, apiReq)
 	if err != nil {
 		return err
 	}
-	cb(StreamChunk{
-		Content:   resp.Content,
-		ToolCalls: resp.ToolCalls,
-		Done:      true,
-	})
+	defer httpResp.Body.Close()
+
+	return parseOpenAIStream(httpResp.Body, cb)
+}
+
+// parseOpenAIStream reads chat completion chunks from an SSE body.
+func parseOpenAIStream(body io.Reader, cb StreamCallback) error {
+	var streamModel string
+	var usage *Usage
+	var pending []*openaiToolCall // indexed by tool call index
+	done := false
+
+	flushTools := func() {
+		var calls []ToolCall
+		for _, tc := range pending {
+			if tc == nil {
+				continue
+			}
+			var input map[string]interface{}
+			if tc.Function.Arguments != "" {
+				json.Unmarshal([]byte(tc.Function.Arguments), &input)
+			}
+			calls = append(calls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Input: input})
+		}
+		pending = nil
+		if len(calls) > 0 {
+			cb(StreamChunk{ToolCalls: calls})
+		}
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 256*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+
+		var chunk openaiStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("openai stream error: %s", chunk.Error.Message)
+		}
+		if chunk.Model != "" {
+			streamModel = chunk.Model
+		}
+		if chunk.Usage != nil {
+			usage = &Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				cb(StreamChunk{Content: choice.Delta.Content})
+			}
+			for _, d := range choice.Delta.ToolCalls {
+				if d.Index < 0 || d.Index > 128 {
+					continue
+				}
+				for len(pending) <= d.Index {
+					pending = append(pending, nil)
+				}
+				tc := pending[d.Index]
+				if tc == nil {
+					tc = &openaiToolCall{Type: "function"}
+					pending[d.Index] = tc
+				}
+				if d.ID != "" {
+					tc.ID = d.ID
+				}
+				if d.Function.Name != "" {
+					tc.Function.Name = d.Function.Name
+				}
+				tc.Function.Arguments += d.Function.Arguments
+			}
+			if choice.FinishReason != "" {
+				flushTools()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading openai stream: %w", err)
+	}
+	if !done {
+		// Connection closed before [DONE]: the response may be cut short
+		return fmt.Errorf("openai stream ended without [DONE]: %w", io.ErrUnexpectedEOF)
+	}
+
+	flushTools()
+	cb(StreamChunk{Done: true, Model: streamModel, Usage: usage})
 	return nil
 }
 
+// Embed computes embeddings via /embeddings, by default with
+// text-embedding-3-small.
+func (p *OpenAIProvider) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	if model == "" {
+		model = "text-embedding-3-small"
+	}
+	body, err := json.Marshal(openaiEmbedRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	p.setAuth(httpReq)
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s request: %w", p.name, err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != 200 {
+		return nil, newAPIError(p.name, httpResp)
+	}
+
+	var result openaiEmbedResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding %s embeddings: %w", p.name, err)
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index >= 0 && d.Index < len(vectors) {
+			vectors[d.Index] = d.Embedding
+		}
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("%s returned no embedding for input %d", p.name, i)
+		}
+	}
+	return vectors, nil
+}
+
 func (p *OpenAIProvider) resolveModel(override string) string {
 	if override != "" {
 		return override
@@ -202,11 +437,41 @@ func (p *OpenAIProvider) resolveModel(override string) string {
 // --- OpenAI API types ---
 
 type openaiRequest struct {
-	Model       string          `json:"model"`
-	Messages    []openaiMessage `json:"messages"`
-	Tools       []openaiTool    `json:"tools,omitempty"`
-	MaxTokens   int             `json:"max_tokens,omitempty"`
-	Temperature float64         `json:"temperature,omitempty"`
+	Model          string                `json:"model"`
+	Messages       []openaiMessage       `json:"messages"`
+	Tools          []openaiTool          `json:"tools,omitempty"`
+	ToolChoice     interface{}           `json:"tool_choice,omitempty"` // "required" or a named function
+	ResponseFormat *openaiResponseFormat `json:"response_format,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	Temperature    *float64              `json:"temperature,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *openaiStreamOptions  `json:"stream_options,omitempty"`
+}
+
+type openaiResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *openaiJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openaiJSONSchema struct {
+	Name   string      `json:"name"`
+	Schema interface{} `json:"schema"`
+}
+
+type openaiEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openaiEmbedResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+type openaiStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type openaiMessage struct {
@@ -214,6 +479,30 @@ type openaiMessage struct {
 	Content    string           `json:"content"`
 	ToolCallID string           `json:"tool_call_id,omitempty"`
 	ToolCalls  []openaiToolCall `json:"tool_calls,omitempty"`
+	Parts      []openaiPart     `json:"-"` // sent as the content when set, for images
+}
+
+// MarshalJSON sends a message with parts as a content array, which the API
+// requires for images.
+func (m openaiMessage) MarshalJSON() ([]byte, error) {
+	type plain openaiMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []openaiPart `json:"content"`
+	}{plain(m), m.Parts})
+}
+
+type openaiPart struct {
+	Type     string          `json:"type"` // text or image_url
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openaiImageURL `json:"image_url,omitempty"`
+}
+
+type openaiImageURL struct {
+	URL string `json:"url"` // a data: URL
 }
 
 type openaiToolCall struct {
@@ -250,3 +539,26 @@ type openaiResponse struct {
 		CompletionTokens int `json:"completion_tokens"`
 	} `json:"usage"`
 }
+
+// openaiStreamChunk is one SSE event of a streamed chat completion.
+type openaiStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int            `json:"index"`
+				ID       string         `json:"id"`
+				Function openaiFunction `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
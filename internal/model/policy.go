@@ -0,0 +1,327 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+// PolicyError is returned when a project's model policy matches but no
+// provider qualifies. The request is never sent to another provider.
+type PolicyError struct {
+	Project   string
+	Policy    config.ModelPolicy
+	Requested string   // explicit model override, if any
+	Reasons   []string // why each candidate was rejected
+}
+
+func (e *PolicyError) Error() string {
+	msg := fmt.Sprintf("model policy %q for project %s allows only [%s]",
+		e.Policy.ProjectPattern, e.Project, strings.Join(e.Policy.AllowedProviders, ", "))
+	if e.Policy.Reason != "" {
+		msg += " (" + e.Policy.Reason + ")"
+	}
+	if e.Requested != "" {
+		msg += "; requested " + e.Requested
+	}
+	if len(e.Reasons) > 0 {
+		msg += ": " + strings.Join(e.Reasons, "; ")
+	}
+	return msg
+}
+
+// Candidate is a provider considered for a request.
+type Candidate struct {
+	Provider   string `json:"provider"`
+	Configured bool   `json:"configured"`
+	Available  bool   `json:"available"`
+	Allowed    bool   `json:"allowed"`
+	Local      bool   `json:"local,omitempty"`   // matches "local" in allowed_providers
+	Circuit    string `json:"circuit,omitempty"` // closed, open or half-open
+	Clearance  string `json:"clearance,omitempty"`
+	Note       string `json:"note,omitempty"`
+}
+
+// PolicyExplanation describes how a request for a project would be routed.
+type PolicyExplanation struct {
+	Project    string              `json:"project"`
+	Task       string              `json:"task"`
+	Requested  string              `json:"requested,omitempty"`
+	Policy     *config.ModelPolicy `json:"policy,omitempty"`
+	Candidates []Candidate         `json:"candidates"`
+	Chain      []string            `json:"chain,omitempty"` // fallback order after availability checks
+	Selected   string              `json:"selected,omitempty"`
+	Reason     string              `json:"reason,omitempty"`
+	Fallback   bool                `json:"fallback"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// matchPolicy returns the first policy whose pattern matches the project.
+func (r *Router) matchPolicy(project string) *config.ModelPolicy {
+	if project == "" {
+		return nil
+	}
+	for i, policy := range r.cfg.AI.Policies {
+		if matched, _ := filepath.Match(policy.ProjectPattern, project); matched {
+			return &r.cfg.AI.Policies[i]
+		}
+	}
+	return nil
+}
+
+// policyLocal in allowed_providers allows every provider that runs inside
+// the network: Ollama and openai_compatible providers marked local.
+const policyLocal = "local"
+
+func (r *Router) policyAllows(policy *config.ModelPolicy, provider string) bool {
+	for _, allowed := range policy.AllowedProviders {
+		if allowed == provider || (allowed == policyLocal && r.IsLocal(provider)) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedProviders returns the policy's providers with "local" expanded.
+func (r *Router) allowedProviders(policy *config.ModelPolicy) []string {
+	return r.expandLocal(policy.AllowedProviders)
+}
+
+// splitModel splits "provider/model" into its parts.
+func splitModel(id string) (provider, model string) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// target is one provider and model in a fallback chain.
+type target struct {
+	provider Provider
+	model    string // without provider prefix, empty for the provider default
+}
+
+func (t target) String() string {
+	if t.model == "" {
+		return t.provider.Name()
+	}
+	return t.provider.Name() + "/" + t.model
+}
+
+// selection is the ordered chain of providers for a request and why.
+type selection struct {
+	chain     []target
+	preferred string              // first candidate before availability checks
+	policy    *config.ModelPolicy // matched project policy, nil if none
+	reason    string              // why this chain was chosen
+}
+
+// fallbackTo reports whether t is not the preferred candidate.
+func (s *selection) fallbackTo(t target) bool {
+	return t.provider == nil || t.String() != s.preferred
+}
+
+// candidates returns the ordered provider/model IDs to try and why.
+// A task route (the policy's, else [ai.tasks]) comes first unless the model
+// is overridden, followed by the usual chain. With a matched policy only its
+// providers are listed: an explicit override first, then the policy's
+// fallback chain, or else the default model (if allowed) followed by the
+// allowed providers. Without a policy: the override, or the default model
+// followed by the built-in anthropic, ollama fallback.
+func (r *Router) candidates(policy *config.ModelPolicy, task, modelOverride string) ([]string, string) {
+	ids, reason := r.chainCandidates(policy, modelOverride)
+	if modelOverride != "" {
+		return ids, reason
+	}
+	// Route entries the policy does not allow are left out
+	var route []string
+	for _, id := range r.expandLocal(r.taskRoute(policy, task)) {
+		if name, _ := splitModel(id); policy == nil || r.policyAllows(policy, name) {
+			route = append(route, id)
+		}
+	}
+	if len(route) == 0 {
+		return ids, reason
+	}
+	return append(route, ids...), describeRoute(task, route)
+}
+
+// chainCandidates returns the candidates of a request without a task route.
+func (r *Router) chainCandidates(policy *config.ModelPolicy, modelOverride string) ([]string, string) {
+	defaultModel := r.cfg.AI.DefaultModel
+	switch {
+	case modelOverride != "":
+		ids := []string{modelOverride}
+		if policy != nil {
+			ids = append(ids, policy.Fallback...)
+		}
+		return ids, "explicit model " + modelOverride
+	case policy != nil && len(policy.Fallback) > 0:
+		return policy.Fallback, "policy fallback chain"
+	case policy != nil:
+		var ids []string
+		reason := "policy provider order"
+		if name, _ := splitModel(defaultModel); name != "" && r.policyAllows(policy, name) {
+			ids = append(ids, defaultModel)
+			reason = "default model " + defaultModel
+		}
+		return append(ids, r.allowedProviders(policy)...), reason
+	}
+	var ids []string
+	reason := "built-in fallback"
+	if defaultModel != "" {
+		ids = append(ids, defaultModel)
+		reason = "default model " + defaultModel
+	}
+	return append(ids, "anthropic", "ollama"), reason
+}
+
+// selectProvider builds the fallback chain for a request. Candidates that
+// are not allowed by the matched policy, not configured, behind an open
+// circuit or unavailable are skipped; if none remain the request fails, with
+// a PolicyError when a policy matched. An explicit model the policy does not
+// allow fails the request. Nothing outside the policy is ever chosen.
+func (r *Router) selectProvider(ctx context.Context, task, modelOverride string) (*selection, error) {
+	return r.selectChain(ctx, task, modelOverride, false)
+}
+
+// selectChain is selectProvider. With dryRun set, circuits are only read,
+// so an explanation never uses up a half-open circuit's probe or opens one.
+func (r *Router) selectChain(ctx context.Context, task, modelOverride string, dryRun bool) (*selection, error) {
+	project, _ := ctx.Value(ctxKeyProject{}).(string)
+	policy := r.matchPolicy(project)
+	ids, reason := r.candidates(policy, task, modelOverride)
+
+	// An override the policy refuses is an error, not a reason to fall back
+	if name, _ := splitModel(modelOverride); policy != nil && modelOverride != "" && !r.policyAllows(policy, name) {
+		return nil, &PolicyError{Project: project, Policy: *policy, Requested: modelOverride,
+			Reasons: []string{name + " is not allowed"}}
+	}
+
+	sel := &selection{policy: policy, reason: reason}
+	if policy != nil {
+		sel.reason = fmt.Sprintf("project %s matches policy pattern %s, %s", project, policy.ProjectPattern, reason)
+	}
+
+	var rejected []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		name, m := splitModel(id)
+		// A bare provider adds nothing once the provider is in the chain
+		if seen[id] || (m == "" && seen[name]) {
+			continue
+		}
+		seen[id], seen[name] = true, true
+		if sel.preferred == "" {
+			sel.preferred = id
+		}
+
+		p, ok := r.providers[name]
+		switch {
+		case policy != nil && !r.policyAllows(policy, name):
+			rejected = append(rejected, name+" is not allowed")
+		case !ok:
+			rejected = append(rejected, name+" is not configured")
+		case dryRun && r.breaker(name).state() == "open", !dryRun && !r.breaker(name).allow():
+			rejected = append(rejected, name+" circuit is open")
+		case !r.available(name, p):
+			if !dryRun {
+				r.breaker(name).open(r.cfg.AI.Retry.BreakerCooldown.Duration)
+			}
+			rejected = append(rejected, name+" is unavailable")
+		default:
+			sel.chain = append(sel.chain, target{provider: p, model: m})
+		}
+	}
+
+	if len(sel.chain) > 0 {
+		return sel, nil
+	}
+	if policy != nil {
+		if len(ids) == 0 {
+			rejected = append(rejected, "policy allows no providers")
+		}
+		return nil, &PolicyError{Project: project, Policy: *policy, Requested: modelOverride, Reasons: rejected}
+	}
+	if modelOverride != "" {
+		if name, _ := splitModel(modelOverride); r.providers[name] == nil {
+			return nil, fmt.Errorf("unknown provider: %s", name)
+		}
+	}
+	return nil, fmt.Errorf("no available AI model provider: %s", strings.Join(rejected, "; "))
+}
+
+// Explain reports how a request of a task kind for project (with an
+// optional "provider/model" override) would be routed, without sending
+// anything. An empty task is chat.
+func (r *Router) Explain(project, task, modelOverride string) PolicyExplanation {
+	if task == "" {
+		task = TaskChat
+	}
+	exp := PolicyExplanation{Project: project, Task: task, Requested: modelOverride}
+	exp.Policy = r.matchPolicy(project)
+
+	names := make(map[string]bool)
+	var order []string
+	add := func(name string) {
+		if name != "" && !names[name] {
+			names[name] = true
+			order = append(order, name)
+		}
+	}
+	if exp.Policy != nil {
+		for _, id := range exp.Policy.Fallback {
+			name, _ := splitModel(id)
+			add(name)
+		}
+		for _, name := range r.allowedProviders(exp.Policy) {
+			add(name)
+		}
+	}
+	for _, pc := range r.cfg.AI.Providers {
+		add(pc.Name)
+	}
+	for _, name := range r.ListProviders() {
+		add(name)
+	}
+
+	for _, name := range order {
+		c := Candidate{Provider: name, Allowed: exp.Policy == nil || r.policyAllows(exp.Policy, name)}
+		if p, ok := r.providers[name]; ok {
+			c.Configured = true
+			c.Local = r.IsLocal(name)
+			c.Available = r.available(name, p)
+			c.Circuit = r.breaker(name).state()
+			c.Clearance = r.Clearance(name)
+		}
+		switch {
+		case !c.Allowed:
+			c.Note = "blocked by policy"
+		case !c.Configured:
+			c.Note = "not configured"
+		case c.Circuit == "open":
+			c.Note = "circuit open"
+		case !c.Available:
+			c.Note = "unavailable"
+		}
+		exp.Candidates = append(exp.Candidates, c)
+	}
+
+	ctx := WithProject(context.Background(), project)
+	sel, err := r.selectChain(ctx, task, modelOverride, true)
+	if err != nil {
+		exp.Error = err.Error()
+		return exp
+	}
+	for _, t := range sel.chain {
+		exp.Chain = append(exp.Chain, t.String())
+	}
+	exp.Selected = sel.chain[0].String()
+	exp.Reason = sel.reason
+	exp.Fallback = sel.fallbackTo(sel.chain[0])
+	return exp
+}
@@ -0,0 +1,163 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+// fakeProvider answers with a fixed reply, or with the errors in errs first.
+type fakeProvider struct {
+	name  string
+	local bool
+	down  bool
+	reply string
+
+	mu    sync.Mutex
+	errs  []error
+	calls []Request
+}
+
+func (p *fakeProvider) Name() string     { return p.name }
+func (p *fakeProvider) Available() bool  { return !p.down }
+func (p *fakeProvider) Models() []string { return nil }
+func (p *fakeProvider) Local() bool      { return p.local }
+func (p *fakeProvider) requests() []Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Request(nil), p.calls...)
+}
+
+func (p *fakeProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, req)
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return nil, err
+	}
+	reply := p.reply
+	if reply == "" {
+		reply = "ok from " + p.name
+	}
+	return &Response{Content: reply, Model: req.Model, Usage: Usage{InputTokens: 100, OutputTokens: 10}}, nil
+}
+
+func (p *fakeProvider) StreamComplete(ctx context.Context, req Request, cb StreamCallback) error {
+	resp, err := p.Complete(ctx, req)
+	if err != nil {
+		return err
+	}
+	cb(StreamChunk{Content: resp.Content})
+	cb(StreamChunk{Done: true, Usage: &resp.Usage})
+	return nil
+}
+
+// testRouter returns a router over fake providers, with no task routes and
+// retries without delay.
+func testRouter(cfg *config.Config, providers ...*fakeProvider) *Router {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	cfg.AI.Providers = nil
+	cfg.AI.Tasks = nil
+	cfg.AI.Usage.Enabled = false
+	cfg.AI.Retry.BaseDelay.Duration = 0
+	cfg.AI.Retry.MaxDelay.Duration = 0
+	r := NewRouter(cfg)
+	r.providers = make(map[string]Provider)
+	for _, p := range providers {
+		r.providers[p.name] = p
+	}
+	return r
+}
+
+func policyConfig(policies ...config.ModelPolicy) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.AI.DefaultModel = "anthropic/claude-sonnet"
+	cfg.AI.Policies = policies
+	return cfg
+}
+
+func TestSelectProvider(t *testing.T) {
+	localOnly := config.ModelPolicy{ProjectPattern: "/c/GC/*", AllowedProviders: []string{"local"}, Reason: "code stays inside"}
+	chain := config.ModelPolicy{ProjectPattern: "/oss/*", AllowedProviders: []string{"anthropic", "ollama"},
+		Fallback: []string{"ollama/qwen", "anthropic/claude-haiku"}}
+	tests := []struct {
+		name     string
+		project  string
+		override string
+		chain    []string
+		err      string
+	}{
+		{"no policy uses default model", "/home/me/x", "", []string{"anthropic/claude-sonnet", "ollama"}, ""},
+		{"no policy override", "/home/me/x", "ollama/llama3", []string{"ollama/llama3"}, ""},
+		{"local policy skips default model", "/c/GC/bank", "", []string{"ollama"}, ""},
+		{"allowed override first", "/c/GC/bank", "vllm/qwen-coder", []string{"vllm/qwen-coder"}, ""},
+		{"override refused by policy", "/c/GC/bank", "anthropic/claude-opus", nil,
+			`model policy "/c/GC/*" for project /c/GC/bank allows only [local] (code stays inside); requested anthropic/claude-opus: anthropic is not allowed`},
+		{"policy fallback chain", "/oss/lib", "", []string{"ollama/qwen", "anthropic/claude-haiku"}, ""},
+		{"override then policy fallback", "/oss/lib", "anthropic/claude-opus", []string{"anthropic/claude-opus", "ollama/qwen", "anthropic/claude-haiku"}, ""},
+		{"unknown provider without policy", "/home/me/x", "mistral/large", nil, "unknown provider: mistral"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRouter(policyConfig(localOnly, chain),
+				&fakeProvider{name: "anthropic"}, &fakeProvider{name: "ollama", local: true}, &fakeProvider{name: "vllm", local: true})
+			ctx := WithProject(context.Background(), tt.project)
+			sel, err := r.selectProvider(ctx, TaskChat, tt.override)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, target := range sel.chain {
+				got = append(got, target.String())
+			}
+			if !reflect.DeepEqual(got, tt.chain) {
+				t.Errorf("chain = %v, want %v", got, tt.chain)
+			}
+		})
+	}
+}
+
+func TestPolicyNeverFallsOutside(t *testing.T) {
+	policy := config.ModelPolicy{ProjectPattern: "/c/GC/*", AllowedProviders: []string{"ollama"}}
+	ollama := &fakeProvider{name: "ollama", local: true, down: true}
+	anthropic := &fakeProvider{name: "anthropic"}
+	r := testRouter(policyConfig(policy), ollama, anthropic)
+
+	_, err := r.Complete(WithProject(context.Background(), "/c/GC/bank"), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	var perr *PolicyError
+	if !errors.As(err, &perr) || !strings.Contains(err.Error(), "ollama is unavailable") {
+		t.Fatalf("error = %v, want a PolicyError", err)
+	}
+	if len(anthropic.requests()) > 0 {
+		t.Error("request sent to a provider outside the policy")
+	}
+}
+
+func TestExplainRefusedOverride(t *testing.T) {
+	policy := config.ModelPolicy{ProjectPattern: "/c/GC/*", AllowedProviders: []string{"ollama"}}
+	r := testRouter(policyConfig(policy), &fakeProvider{name: "ollama", local: true}, &fakeProvider{name: "anthropic"})
+
+	exp := r.Explain("/c/GC/bank", "", "anthropic/claude-opus")
+	if exp.Selected != "" || !strings.Contains(exp.Error, "requested anthropic/claude-opus") {
+		t.Errorf("explain = selected %q, error %q", exp.Selected, exp.Error)
+	}
+	exp = r.Explain("/c/GC/bank", "", "")
+	if exp.Selected != "ollama" || exp.Error != "" {
+		t.Errorf("explain = selected %q, error %q, want ollama", exp.Selected, exp.Error)
+	}
+}
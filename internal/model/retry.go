@@ -0,0 +1,360 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+// APIError is a non-200 response from a provider API.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header, 0 if absent
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s error %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// newAPIError reads the response body and Retry-After header into an APIError.
+func newAPIError(provider string, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return &APIError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Body:       string(body),
+	}
+}
+
+// parseRetryAfter accepts delay-seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Attempt records a failed provider call that was retried or fallen back from.
+type Attempt struct {
+	Provider   string        `json:"provider"`
+	Model      string        `json:"model,omitempty"`
+	Error      string        `json:"error"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Backoff    time.Duration `json:"backoff,omitempty"` // wait before retrying the same provider
+}
+
+// classify reports whether an error is worth retrying on the same provider
+// and whether the next provider in the chain should be tried. Errors it does
+// not recognise fail the request.
+func classify(err error) (retry, fallback bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case 408, 425, 429, 500, 502, 503, 504, 529:
+			return true, true
+		case 401, 403, 404:
+			return false, true // provider misconfigured, another may work
+		}
+		return false, false // request error, would fail everywhere
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true, true
+	}
+	// Another model may have a larger window or follow the schema
+	var cwErr *ContextWindowError
+	var schemaErr *SchemaError
+	if errors.As(err, &cwErr) || errors.As(err, &schemaErr) || errors.Is(err, syscall.ECONNREFUSED) {
+		return false, true
+	}
+	return false, false
+}
+
+// providerFault reports whether a classified error counts against the
+// provider's circuit breaker. Rejected requests, requests too large for the
+// model and output not matching the schema are the request's fault.
+func providerFault(err error, retry, fallback bool) bool {
+	var cwErr *ContextWindowError
+	var schemaErr *SchemaError
+	if errors.As(err, &cwErr) || errors.As(err, &schemaErr) {
+		return false
+	}
+	return retry || fallback
+}
+
+// backoff returns the wait before retry n (1-based): exponential with jitter,
+// or the server's Retry-After if that is longer.
+func backoff(rc config.RetryConfig, n int, retryAfter time.Duration) time.Duration {
+	d := rc.BaseDelay.Duration << (n - 1)
+	if d <= 0 || d > rc.MaxDelay.Duration {
+		d = rc.MaxDelay.Duration
+	}
+	// Equal jitter: between d/2 and d
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int63n(half+1))
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d
+}
+
+// minBreakerSamples is the number of recent calls needed before the error
+// rate can open a circuit.
+const minBreakerSamples = 5
+
+// breaker is a per-provider circuit breaker. It opens when the error rate
+// over the last window calls reaches the threshold, or when the provider
+// reports itself unavailable, and skips the provider until the cooldown has
+// passed. The first call after that is a probe: success closes the circuit,
+// failure opens it again.
+type breaker struct {
+	mu        sync.Mutex
+	results   []bool // recent outcomes, true = failure
+	openUntil time.Time
+	probing   bool
+	checked   time.Time // when up was last learned, zero to probe again
+	up        bool      // provider availability as of checked
+}
+
+// allow reports whether the provider may be called.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.openUntil = time.Time{}
+	b.probing = true
+	return true
+}
+
+// open skips the provider for the cooldown and starts a fresh window.
+func (b *breaker) open(cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trip(cooldown)
+}
+
+func (b *breaker) trip(cooldown time.Duration) {
+	b.openUntil = time.Now().Add(cooldown)
+	b.results = b.results[:0]
+	b.probing = false
+	b.checked = time.Time{}
+}
+
+// record adds a call outcome and opens the circuit if needed.
+func (b *breaker) record(failed bool, rc config.RetryConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.checked, b.up = time.Now(), true
+	}
+	if b.probing {
+		b.probing = false
+		if failed {
+			b.trip(rc.BreakerCooldown.Duration)
+			return
+		}
+	}
+
+	b.results = append(b.results, failed)
+	if window := rc.BreakerWindow; window > 0 && len(b.results) > window {
+		b.results = b.results[len(b.results)-window:]
+	}
+	if len(b.results) < minBreakerSamples || rc.BreakerThreshold <= 0 {
+		return
+	}
+	failures := 0
+	for _, f := range b.results {
+		if f {
+			failures++
+		}
+	}
+	if float64(failures)/float64(len(b.results)) >= rc.BreakerThreshold {
+		b.trip(rc.BreakerCooldown.Duration)
+	}
+}
+
+// state describes the breaker for display.
+func (b *breaker) state() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case !b.openUntil.IsZero() && time.Now().Before(b.openUntil):
+		return "open"
+	case b.probing || !b.openUntil.IsZero():
+		return "half-open"
+	}
+	return "closed"
+}
+
+// availabilityTTL is how long a provider's Available result is reused.
+const availabilityTTL = 30 * time.Second
+
+// available reports whether a provider is up. Available is an HTTP probe,
+// so its result is kept on the breaker for availabilityTTL; a successful
+// call also counts as up, and an opened circuit probes again.
+func (r *Router) available(name string, p Provider) bool {
+	b := r.breaker(name)
+	b.mu.Lock()
+	if !b.checked.IsZero() && time.Since(b.checked) < availabilityTTL {
+		up := b.up
+		b.mu.Unlock()
+		return up
+	}
+	b.mu.Unlock()
+
+	up := p.Available()
+	b.mu.Lock()
+	b.checked, b.up = time.Now(), up
+	b.mu.Unlock()
+	return up
+}
+
+// breaker returns the circuit breaker for a provider.
+func (r *Router) breaker(name string) *breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[name]
+	if !ok {
+		b = &breaker{}
+		r.breakers[name] = b
+	}
+	return b
+}
+
+// callFunc performs one provider call. attempts holds the failures so far.
+type callFunc func(ctx context.Context, t target, req Request, attempts []Attempt) (*Response, error)
+
+// run calls the selection's chain in order, retrying each provider with
+// backoff on retryable errors and moving to the next one when retries are
+// exhausted or the error is provider-specific. committed, if set, reports
+// that output was already delivered and the call can no longer be repeated.
+func (r *Router) run(ctx context.Context, sel *selection, req Request, call callFunc, committed func() bool) (*Response, target, []Attempt, error) {
+	rc := r.cfg.AI.Retry
+	if rc.MaxAttempts <= 0 {
+		rc.MaxAttempts = 1
+	}
+
+	var attempts []Attempt
+	var last target
+	var lastErr error
+	for i, t := range sel.chain {
+		name := t.provider.Name()
+		if why := r.unsupported(t, req); why != "" {
+			attempts = append(attempts, Attempt{Provider: name, Model: t.model, Error: why})
+			last, lastErr = t, errors.New(why)
+			continue
+		}
+		b := r.breaker(name)
+		// The first target was checked during selection
+		if i > 0 && !b.allow() {
+			attempts = append(attempts, Attempt{Provider: name, Model: t.model, Error: "circuit open"})
+			continue
+		}
+		last = t
+
+		treq := req
+		treq.Model = t.model
+		for n := 1; ; n++ {
+			resp, err := call(ctx, t, treq, attempts)
+			if err == nil {
+				b.record(false, rc)
+				return resp, t, attempts, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				return nil, t, attempts, err
+			}
+			retry, fallback := classify(err)
+			if providerFault(err, retry, fallback) {
+				b.record(true, rc)
+			}
+
+			a := Attempt{Provider: name, Model: t.model, Error: err.Error()}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				a.StatusCode = apiErr.StatusCode
+			}
+			if committed != nil && committed() {
+				return nil, t, append(attempts, a), err
+			}
+
+			if retry && n < rc.MaxAttempts && b.allow() {
+				var after time.Duration
+				if apiErr != nil {
+					after = apiErr.RetryAfter
+				}
+				if d := backoff(rc, n, after); d <= rc.MaxDelay.Duration {
+					a.Backoff = d
+					attempts = append(attempts, a)
+					select {
+					case <-ctx.Done():
+						return nil, t, attempts, ctx.Err()
+					case <-time.After(d):
+					}
+					continue
+				}
+			}
+			attempts = append(attempts, a)
+			if !fallback {
+				return nil, t, attempts, err
+			}
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no provider could be called")
+	}
+	return nil, last, attempts, lastErr
+}
+
+// callError wraps the final error of a chain with the failed attempts.
+func callError(t target, attempts []Attempt, err error) error {
+	name := "none"
+	if t.provider != nil {
+		name = t.provider.Name()
+	}
+	if len(attempts) <= 1 {
+		return fmt.Errorf("provider %s: %w", name, err)
+	}
+	tried := make([]string, len(attempts))
+	for i, a := range attempts {
+		tried[i] = a.Provider
+		if a.StatusCode != 0 {
+			tried[i] += " " + strconv.Itoa(a.StatusCode)
+		}
+	}
+	return fmt.Errorf("provider %s: %w (after %d attempts: %s)", name, err, len(attempts), strings.Join(tried, ", "))
+}
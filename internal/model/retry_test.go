@@ -0,0 +1,161 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		retry, fallback bool
+		fault           bool
+	}{
+		{"rate limited", &APIError{StatusCode: 429}, true, true, true},
+		{"overloaded", &APIError{StatusCode: 529}, true, true, true},
+		{"server error", &APIError{StatusCode: 503}, true, true, true},
+		{"bad key", &APIError{StatusCode: 401}, false, true, true},
+		{"unknown model", &APIError{StatusCode: 404}, false, true, true},
+		{"bad request", &APIError{StatusCode: 400}, false, false, false},
+		{"timeout", fmt.Errorf("calling: %w", timeoutError{}), true, true, true},
+		{"stream cut", fmt.Errorf("stream ended: %w", io.ErrUnexpectedEOF), true, true, true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true, true, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, false, true, true},
+		{"context window", &ContextWindowError{Tokens: 10, Budget: 5}, false, true, false},
+		{"schema", &SchemaError{Reason: "missing severity"}, false, true, false},
+		{"unknown", errors.New("decoding response: invalid character"), false, false, false},
+	}
+	for _, tt := range tests {
+		retry, fallback := classify(tt.err)
+		if retry != tt.retry || fallback != tt.fallback {
+			t.Errorf("%s: classify = %v, %v, want %v, %v", tt.name, retry, fallback, tt.retry, tt.fallback)
+		}
+		if fault := providerFault(tt.err, retry, fallback); fault != tt.fault {
+			t.Errorf("%s: providerFault = %v, want %v", tt.name, fault, tt.fault)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	rc := config.RetryConfig{BaseDelay: config.Duration{Duration: 100 * time.Millisecond}, MaxDelay: config.Duration{Duration: time.Second}}
+	tests := []struct {
+		n          int
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{1, 0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{3, 0, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 0, 500 * time.Millisecond, time.Second},
+		{64, 0, 500 * time.Millisecond, time.Second}, // shift overflow
+		{1, 5 * time.Second, 5 * time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := backoff(rc, tt.n, tt.retryAfter); d < tt.min || d > tt.max {
+				t.Errorf("backoff(%d, %v) = %v, want %v-%v", tt.n, tt.retryAfter, d, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	rc := config.RetryConfig{BreakerWindow: 10, BreakerThreshold: 0.5, BreakerCooldown: config.Duration{Duration: 20 * time.Millisecond}}
+	b := &breaker{}
+
+	for i := 0; i < minBreakerSamples-1; i++ {
+		b.record(true, rc)
+	}
+	if b.state() != "closed" {
+		t.Fatalf("opened before %d samples", minBreakerSamples)
+	}
+	b.record(true, rc)
+	if b.state() != "open" || b.allow() {
+		t.Fatalf("state = %s after %d failures, want open", b.state(), minBreakerSamples)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() || b.state() != "half-open" {
+		t.Fatalf("state = %s after cooldown, want a half-open probe", b.state())
+	}
+	b.record(true, rc)
+	if b.state() != "open" {
+		t.Fatalf("failed probe: state = %s, want open", b.state())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	b.allow()
+	b.record(false, rc)
+	if b.state() != "closed" {
+		t.Fatalf("successful probe: state = %s, want closed", b.state())
+	}
+
+	// Below the threshold the circuit stays closed
+	for i := 0; i < 10; i++ {
+		b.record(i%3 == 0, rc)
+	}
+	if b.state() != "closed" {
+		t.Errorf("state = %s at a 40%% error rate, want closed", b.state())
+	}
+}
+
+func TestCompleteRetriesAndFallsBack(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		provider  string
+		attempts  int
+		fails     bool
+		fallbacks bool
+	}{
+		{"retried on 503", []error{&APIError{StatusCode: 503}}, "anthropic", 1, false, false},
+		{"retries exhausted", []error{&APIError{StatusCode: 503}, &APIError{StatusCode: 503}, &APIError{StatusCode: 503}}, "ollama", 3, false, true},
+		{"no retry on 401", []error{&APIError{StatusCode: 401}}, "ollama", 1, false, true},
+		{"bad request fails fast", []error{&APIError{StatusCode: 400}}, "", 1, true, false},
+		{"unknown error fails fast", []error{errors.New("decoding response")}, "", 1, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.AI.DefaultModel = "anthropic"
+			anthropic := &fakeProvider{name: "anthropic", errs: tt.errs}
+			ollama := &fakeProvider{name: "ollama", local: true}
+			r := testRouter(cfg, anthropic, ollama)
+
+			resp, err := r.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("Complete succeeded via %s", resp.Provider)
+				}
+				if len(ollama.requests()) > 0 {
+					t.Error("failed request was sent to the next provider")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Provider != tt.provider || len(resp.Attempts) != tt.attempts || resp.Fallback != tt.fallbacks {
+				t.Errorf("served by %s after %d failed attempts (fallback %v), want %s after %d (fallback %v)",
+					resp.Provider, len(resp.Attempts), resp.Fallback, tt.provider, tt.attempts, tt.fallbacks)
+			}
+		})
+	}
+}
@@ -3,9 +3,12 @@ package model
 import (
 	"context"
 	"fmt"
-	"path/filepath"
+	"log"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/greencode/greenforge/internal/audit"
 	"github.com/greencode/greenforge/internal/config"
 )
 
@@ -15,6 +18,12 @@ type Router struct {
 	cfg       *config.Config
 	providers map[string]Provider
 	firewall  *Firewall
+	auditor   *audit.Logger
+	cache     *ResponseCache
+	mu        sync.Mutex
+	breakers  map[string]*breaker
+	vaults    map[string]*SecretVault // per session
+	ledger    *UsageLedger
 }
 
 // Provider is the interface all AI model backends must implement.
@@ -32,19 +41,45 @@ type StreamCallback func(chunk StreamChunk)
 
 // StreamChunk is a streaming response fragment.
 type StreamChunk struct {
-	Content   string
-	ToolCalls []ToolCall
-	Done      bool
+	Content    string
+	ToolCalls  []ToolCall
+	Done       bool
+	Model      string         // set on the final chunk if the provider reports it
+	Usage      *Usage         // set on the final chunk if the provider reports it
+	Provider   string         // set on the final chunk by the router
+	Attempts   []Attempt      // failed attempts before this stream, final chunk only
+	Fallback   bool           // served by a fallback provider, final chunk only
+	Cached     bool           // replayed from the response cache, final chunk only
+	Redactions RedactionStats // secrets the firewall replaced in the request, final chunk only
+	Warnings   []string       // budgets close to their limit, final chunk only
+}
+
+// Temperature returns t as a Request temperature.
+func Temperature(t float64) *float64 {
+	return &t
 }
 
 // Request represents a model completion request.
 type Request struct {
-	Messages    []Message   `json:"messages"`
-	Tools       []ToolDef   `json:"tools,omitempty"`
-	MaxTokens   int         `json:"max_tokens"`
-	Temperature float64     `json:"temperature"`
-	Model       string      `json:"model,omitempty"`
-	WorkingDir  string      `json:"working_dir,omitempty"` // Project workspace for file access
+	Messages    []Message `json:"messages"`
+	Tools       []ToolDef `json:"tools,omitempty"`
+	MaxTokens   int       `json:"max_tokens"`
+	Temperature *float64  `json:"temperature,omitempty"` // nil for the provider's default
+	Model       string    `json:"model,omitempty"`
+	Task        string    `json:"task,omitempty"`        // chat, summarize, classify, code_edit or embed; routes the request
+	WorkingDir  string    `json:"working_dir,omitempty"` // Project workspace for file access
+
+	// Retrieved context the router adds to the system prompt as far as the
+	// model's context window allows.
+	Context []ContextBlock `json:"context,omitempty"`
+	// ContextWindow is set by the router to the window the request was
+	// fitted to; Ollama uses it as num_ctx.
+	ContextWindow int `json:"-"`
+
+	// ResponseSchema is a JSON Schema the response content must match. The
+	// router validates the output and asks again when it does not; see
+	// SchemaError.
+	ResponseSchema interface{} `json:"response_schema,omitempty"`
 }
 
 // Message is a chat message.
@@ -53,15 +88,27 @@ type Message struct {
 	Content    string     `json:"content"`
 	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Images     []Image    `json:"images,omitempty"` // image parts of a user message, only for vision models
+	Sources    []Source   `json:"-"`                // files the content came from, checked against data classification
+
+	// CacheBreaks are offsets in Content where a prefix ends that stays the
+	// same across requests; providers with prompt caching cache up to them.
+	CacheBreaks []int `json:"-"`
 }
 
 // Response from a model completion.
 type Response struct {
-	Content    string     `json:"content"`
-	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
-	Model      string     `json:"model"`
-	Usage      Usage      `json:"usage"`
-	FinishReason string   `json:"finish_reason"`
+	Content      string         `json:"content"`
+	ToolCalls    []ToolCall     `json:"tool_calls,omitempty"`
+	Model        string         `json:"model"`
+	Usage        Usage          `json:"usage"`
+	FinishReason string         `json:"finish_reason"`
+	Provider     string         `json:"provider,omitempty"`   // provider that served the request
+	Attempts     []Attempt      `json:"attempts,omitempty"`   // failed calls retried or fallen back from
+	Fallback     bool           `json:"fallback,omitempty"`   // served by a fallback, not the preferred provider
+	Cached       bool           `json:"cached,omitempty"`     // answered from the response cache
+	Redactions   RedactionStats `json:"redactions,omitempty"` // secrets replaced by the firewall in the request
+	Warnings     []string       `json:"warnings,omitempty"`   // budgets close to their limit
 }
 
 // ToolCall represents a tool invocation requested by the model.
@@ -76,12 +123,17 @@ type ToolDef struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
 	Schema      interface{} `json:"input_schema,omitempty"`
+	ReadOnly    bool        `json:"-"` // no side effects; other tools disable the response cache
 }
 
-// Usage tracks token consumption.
+// Usage tracks token consumption. With prompt caching, InputTokens counts
+// only the prompt tokens that were neither read from nor written to the
+// cache.
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens         int `json:"input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+	CacheReadTokens     int `json:"cache_read_input_tokens,omitempty"`     // prompt tokens read from the provider's cache
+	CacheCreationTokens int `json:"cache_creation_input_tokens,omitempty"` // prompt tokens written to the provider's cache
 }
 
 // NewRouter creates a model router with configured providers.
@@ -89,11 +141,34 @@ func NewRouter(cfg *config.Config) *Router {
 	r := &Router{
 		cfg:       cfg,
 		providers: make(map[string]Provider),
-		firewall:  NewFirewall(),
+		breakers:  make(map[string]*breaker),
+		vaults:    make(map[string]*SecretVault),
 	}
 
+	fw, err := NewFirewallFromConfig(cfg.AI.Firewall)
+	if err != nil {
+		log.Printf("Warning: %v, using the default firewall rules", err)
+		fw = NewFirewall()
+	}
+	r.firewall = fw
+	r.checkLabels()
+	r.checkTasks()
+
 	// Initialize providers from config
 	for _, pc := range cfg.AI.Providers {
+		if pc.Type == ProviderTypeOpenAICompatible {
+			p, err := NewOpenAICompatibleProvider(pc)
+			if err != nil {
+				log.Printf("Warning: skipping AI provider: %v", err)
+				continue
+			}
+			if _, exists := r.providers[pc.Name]; exists {
+				log.Printf("Warning: skipping AI provider %s: name already registered", pc.Name)
+				continue
+			}
+			r.providers[pc.Name] = p
+			continue
+		}
 		switch pc.Name {
 		case "ollama":
 			endpoint := pc.Endpoint
@@ -127,89 +202,214 @@ func NewRouter(cfg *config.Config) *Router {
 	return r
 }
 
-// Complete sends a request to the appropriate provider.
+// SetAuditor enables a "model.call" audit event for every completion.
+func (r *Router) SetAuditor(auditor *audit.Logger) {
+	r.auditor = auditor
+}
+
+// Complete sends a request to the appropriate provider, retrying and falling
+// back along the selected chain.
 func (r *Router) Complete(ctx context.Context, req Request) (*Response, error) {
-	provider, err := r.selectProvider(ctx, req.Model)
+	started := time.Now()
+	sel, err := r.selectProvider(ctx, taskOf(req), req.Model)
 	if err != nil {
+		r.auditCall(ctx, callRecord{started: started, err: err})
 		return nil, err
 	}
 
-	// Apply firewall: scrub secrets from messages
-	sanitized := r.firewall.ScrubRequest(req)
+	// Refuse calls over budget before anything is sent
+	warnings, err := r.checkBudgets(ctx)
+	if err != nil {
+		r.auditCall(ctx, callRecord{sel: sel, started: started, err: err})
+		return nil, err
+	}
 
-	resp, err := provider.Complete(ctx, sanitized)
+	if err := r.checkImages(sel, req); err != nil {
+		r.auditCall(ctx, callRecord{sel: sel, started: started, err: err})
+		return nil, err
+	}
+
+	// Withhold content from files the chain is not cleared for
+	if req, err = r.applyClassification(ctx, sel, req); err != nil {
+		r.auditCall(ctx, callRecord{sel: sel, started: started, err: err})
+		return nil, err
+	}
+
+	// Apply firewall: replace secrets in messages with placeholders
+	vault := r.vault(ctx)
+	sanitized, redactions := r.firewall.TokenizeRequest(req, vault)
+	restore := r.restorerFor(sel, vault)
+
+	rec := callRecord{sel: sel, req: sanitized, redactions: redactions, started: started}
+	cached, hit, outcome := r.cacheLookup(sel, sanitized)
+	rec.cache = outcome
+	if cached != nil {
+		cached.Fallback = sel.fallbackTo(hit)
+		cached.Redactions = redactions
+		cached.Warnings = warnings
+		rec.served, rec.model = hit, cached.Model
+		r.auditCall(ctx, rec)
+		r.recordUsage(ctx, rec)
+		return restore.response(cached), nil
+	}
+
+	resp, served, attempts, err := r.run(ctx, sel, sanitized, func(ctx context.Context, t target, req Request, _ []Attempt) (*Response, error) {
+		req, fit, err := r.fitContext(t, req)
+		rec.fit = fit
+		if err != nil {
+			return nil, err
+		}
+		if req.ResponseSchema != nil {
+			return r.completeStructured(ctx, t, req)
+		}
+		return t.provider.Complete(ctx, req)
+	}, nil)
+	rec.served, rec.attempts = served, attempts
 	if err != nil {
-		return nil, fmt.Errorf("provider %s: %w", provider.Name(), err)
+		rec.err = err
+		r.auditCall(ctx, rec)
+		return nil, callError(served, attempts, err)
 	}
 
-	return resp, nil
+	resp.Provider = served.provider.Name()
+	resp.Attempts = attempts
+	resp.Fallback = sel.fallbackTo(served)
+	resp.Redactions = redactions
+	resp.Warnings = warnings
+	rec.model, rec.usage = resp.Model, &resp.Usage
+	r.auditCall(ctx, rec)
+	r.recordUsage(ctx, rec)
+	r.cacheStore(outcome, served, sanitized, resp)
+	return restore.response(resp), nil
 }
 
-// StreamComplete sends a streaming request.
+// StreamComplete sends a streaming request. Retries and fallbacks only
+// happen before the first chunk has been delivered; the final chunk reports
+// the provider and any failed attempts.
 func (r *Router) StreamComplete(ctx context.Context, req Request, cb StreamCallback) error {
-	provider, err := r.selectProvider(ctx, req.Model)
+	// Structured output is validated as a whole before any of it is delivered
+	if req.ResponseSchema != nil {
+		resp, err := r.Complete(ctx, req)
+		if err != nil {
+			return err
+		}
+		deliver(resp, cb)
+		return nil
+	}
+
+	started := time.Now()
+	sel, err := r.selectProvider(ctx, taskOf(req), req.Model)
 	if err != nil {
+		r.auditCall(ctx, callRecord{started: started, err: err, stream: true})
 		return err
 	}
 
-	sanitized := r.firewall.ScrubRequest(req)
-	return provider.StreamComplete(ctx, sanitized, cb)
-}
+	warnings, err := r.checkBudgets(ctx)
+	if err != nil {
+		r.auditCall(ctx, callRecord{sel: sel, started: started, err: err, stream: true})
+		return err
+	}
 
-func (r *Router) selectProvider(ctx context.Context, modelOverride string) (Provider, error) {
-	// If explicit model requested (e.g. "ollama/codestral")
-	if modelOverride != "" {
-		parts := strings.SplitN(modelOverride, "/", 2)
-		providerName := parts[0]
-		if p, ok := r.providers[providerName]; ok {
-			return p, nil
-		}
-		return nil, fmt.Errorf("unknown provider: %s", providerName)
+	if err := r.checkImages(sel, req); err != nil {
+		r.auditCall(ctx, callRecord{sel: sel, started: started, err: err, stream: true})
+		return err
+	}
+
+	if req, err = r.applyClassification(ctx, sel, req); err != nil {
+		r.auditCall(ctx, callRecord{sel: sel, started: started, err: err, stream: true})
+		return err
 	}
 
-	// Check project-based model policy
-	projectPath := ctx.Value(ctxKeyProject{})
-	if projectPath != nil {
-		if pp, ok := projectPath.(string); ok {
-			provider := r.resolveByPolicy(pp)
-			if provider != nil {
-				return provider, nil
+	vault := r.vault(ctx)
+	sanitized, redactions := r.firewall.TokenizeRequest(req, vault)
+	restore := r.restorerFor(sel, vault)
+
+	rec := callRecord{sel: sel, req: sanitized, redactions: redactions, started: started, stream: true}
+	cached, hit, outcome := r.cacheLookup(sel, sanitized)
+	rec.cache = outcome
+	if cached != nil {
+		replay(cached, sel.fallbackTo(hit), func(chunk StreamChunk) {
+			if chunk.Done {
+				chunk.Redactions = redactions
+				chunk.Warnings = warnings
 			}
-		}
+			cb(restore.chunk(chunk))
+		})
+		rec.served, rec.model = hit, cached.Model
+		r.auditCall(ctx, rec)
+		r.recordUsage(ctx, rec)
+		return nil
 	}
 
-	// Use default model
-	defaultModel := r.cfg.AI.DefaultModel
-	if defaultModel != "" {
-		parts := strings.SplitN(defaultModel, "/", 2)
-		if p, ok := r.providers[parts[0]]; ok {
-			return p, nil
+	// The streamed output is collected for the cache on a miss
+	var full Response
+	delivered := false
+	_, served, attempts, err := r.run(ctx, sel, sanitized, func(ctx context.Context, t target, req Request, attempts []Attempt) (*Response, error) {
+		req, fit, err := r.fitContext(t, req)
+		rec.fit = fit
+		if err != nil {
+			return nil, err
 		}
+		return nil, t.provider.StreamComplete(ctx, req, func(chunk StreamChunk) {
+			full.Content += chunk.Content
+			full.ToolCalls = append(full.ToolCalls, chunk.ToolCalls...)
+			if chunk.Model != "" {
+				rec.model = chunk.Model
+			}
+			if chunk.Usage != nil {
+				rec.usage = chunk.Usage
+			}
+			if chunk.Done {
+				chunk.Provider = t.provider.Name()
+				chunk.Attempts = attempts
+				chunk.Fallback = sel.fallbackTo(t)
+				chunk.Redactions = redactions
+				chunk.Warnings = warnings
+			}
+			delivered = true
+			cb(restore.chunk(chunk))
+		})
+	}, func() bool { return delivered })
+	rec.served, rec.attempts = served, attempts
+	if err != nil {
+		rec.err = err
+		r.auditCall(ctx, rec)
+		return callError(served, attempts, err)
 	}
+	r.auditCall(ctx, rec)
+	r.recordUsage(ctx, rec)
+	if outcome == "miss" {
+		full.Model = rec.model
+		r.cacheStore(outcome, served, sanitized, &full)
+	}
+	return nil
+}
 
-	// Fallback: try anthropic, then ollama
-	if p, ok := r.providers["anthropic"]; ok && p.Available() {
-		return p, nil
+// replay delivers a cached response as a stream.
+func replay(resp *Response, fallback bool, cb StreamCallback) {
+	if resp.Content != "" {
+		cb(StreamChunk{Content: resp.Content})
 	}
-	if p, ok := r.providers["ollama"]; ok {
-		return p, nil
+	if len(resp.ToolCalls) > 0 {
+		cb(StreamChunk{ToolCalls: resp.ToolCalls})
 	}
-
-	return nil, fmt.Errorf("no available AI model provider")
+	cb(StreamChunk{Done: true, Model: resp.Model, Provider: resp.Provider, Fallback: fallback, Cached: true})
 }
 
-func (r *Router) resolveByPolicy(projectPath string) Provider {
-	for _, policy := range r.cfg.AI.Policies {
-		matched, _ := filepath.Match(policy.ProjectPattern, projectPath)
-		if matched {
-			for _, allowed := range policy.AllowedProviders {
-				if p, ok := r.providers[allowed]; ok && p.Available() {
-					return p
-				}
-			}
-		}
+// deliver passes a complete response on as a stream.
+func deliver(resp *Response, cb StreamCallback) {
+	if resp.Content != "" {
+		cb(StreamChunk{Content: resp.Content})
 	}
-	return nil
+	if len(resp.ToolCalls) > 0 {
+		cb(StreamChunk{ToolCalls: resp.ToolCalls})
+	}
+	usage := resp.Usage
+	cb(StreamChunk{
+		Done: true, Model: resp.Model, Usage: &usage, Provider: resp.Provider,
+		Attempts: resp.Attempts, Fallback: resp.Fallback, Cached: resp.Cached,
+		Redactions: resp.Redactions, Warnings: resp.Warnings,
+	})
 }
 
 // ListProviders returns names of configured providers.
@@ -221,6 +421,34 @@ func (r *Router) ListProviders() []string {
 	return names
 }
 
+// localProvider is implemented by providers that may run inside the network.
+type localProvider interface {
+	Local() bool
+}
+
+// IsLocal reports whether a provider runs inside the network. Policies can
+// allow all such providers with the name "local".
+func (r *Router) IsLocal(name string) bool {
+	lp, ok := r.providers[name].(localProvider)
+	return ok && lp.Local()
+}
+
+// localProviders returns the names of local providers in config order.
+func (r *Router) localProviders() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, pc := range r.cfg.AI.Providers {
+		if !seen[pc.Name] && r.IsLocal(pc.Name) {
+			seen[pc.Name] = true
+			names = append(names, pc.Name)
+		}
+	}
+	if !seen["ollama"] && r.IsLocal("ollama") {
+		names = append(names, "ollama")
+	}
+	return names
+}
+
 // ModelInfo describes an available model for display.
 type ModelInfo struct {
 	ID       string `json:"id"`
@@ -228,6 +456,9 @@ type ModelInfo struct {
 	Model    string `json:"model"`
 	Active   bool   `json:"active"`
 	Status   string `json:"status"`
+
+	ContextWindow int  `json:"context_window,omitempty"` // tokens
+	Vision        bool `json:"vision,omitempty"`         // accepts images
 }
 
 // ListModels dynamically queries all providers for their available models.
@@ -243,12 +474,16 @@ func (r *Router) ListModels() []ModelInfo {
 
 		for _, m := range p.Models() {
 			id := name + "/" + m
+			spec := r.ModelSpec(name, m)
 			models = append(models, ModelInfo{
 				ID:       id,
 				Provider: name,
 				Model:    m,
 				Active:   id == current,
 				Status:   status,
+
+				ContextWindow: spec.ContextWindow,
+				Vision:        spec.Vision && capabilitiesOf(p).Vision,
 			})
 		}
 	}
@@ -276,8 +511,14 @@ func (r *Router) GetDefaultModel() string {
 }
 
 type ctxKeyProject struct{}
+type ctxKeySession struct{}
 
 // WithProject adds project path to context for policy resolution.
 func WithProject(ctx context.Context, projectPath string) context.Context {
 	return context.WithValue(ctx, ctxKeyProject{}, projectPath)
 }
+
+// WithSession adds the gateway session ID to context for auditing.
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, ctxKeySession{}, sessionID)
+}
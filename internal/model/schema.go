@@ -0,0 +1,383 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// schemaAttempts is how often a provider is asked for output matching the
+// response schema before the router gives up on it.
+const schemaAttempts = 3
+
+// respondTool is the tool a provider without a native JSON mode is forced
+// to call, with the response as its input.
+const respondTool = "respond"
+
+// Schema strategies of providers.
+const (
+	schemaNative = "native" // the API constrains output to the schema
+	schemaTool   = "tool"   // the model is forced to call respondTool
+	schemaPrompt = "prompt" // the schema is only described in the system prompt
+)
+
+// SchemaError is returned when a provider's output never matched the
+// request's ResponseSchema. The router then tries the next provider in the
+// chain.
+type SchemaError struct {
+	Provider string
+	Model    string
+	Attempts int
+	Reason   string // why the last output did not match
+	Output   string // the last output
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s/%s gave no output matching the response schema in %d attempts: %s",
+		e.Provider, e.Model, e.Attempts, e.Reason)
+}
+
+// completeStructured calls a target until its output is JSON matching the
+// request's schema, telling the model what was wrong after each miss. A
+// response with tool calls is returned as is, since it is not the answer
+// yet. Usage covers every attempt.
+func (r *Router) completeStructured(ctx context.Context, t target, req Request) (*Response, error) {
+	schema, err := normalizeSchema(req.ResponseSchema)
+	if err != nil {
+		return nil, err
+	}
+	req.ResponseSchema = schema
+
+	var usage Usage
+	for n := 1; ; n++ {
+		resp, err := t.provider.Complete(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		usage = addUsage(usage, resp.Usage)
+		resp.Usage = usage
+		if len(resp.ToolCalls) > 0 {
+			return resp, nil
+		}
+		content, why := conform(schema, resp.Content)
+		if why == "" {
+			resp.Content = content
+			return resp, nil
+		}
+		if n >= schemaAttempts {
+			model := resp.Model
+			if model == "" {
+				model = modelName(t)
+			}
+			return nil, &SchemaError{Provider: t.provider.Name(), Model: model, Attempts: n, Reason: why, Output: resp.Content}
+		}
+		msgs := make([]Message, len(req.Messages), len(req.Messages)+2)
+		copy(msgs, req.Messages)
+		req.Messages = append(msgs,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: "That reply does not match the JSON Schema: " + why +
+				". Reply again with only the corrected JSON value."})
+	}
+}
+
+// addUsage sums the token counts of two calls.
+func addUsage(a, b Usage) Usage {
+	return Usage{
+		InputTokens:         a.InputTokens + b.InputTokens,
+		OutputTokens:        a.OutputTokens + b.OutputTokens,
+		CacheReadTokens:     a.CacheReadTokens + b.CacheReadTokens,
+		CacheCreationTokens: a.CacheCreationTokens + b.CacheCreationTokens,
+	}
+}
+
+// normalizeSchema turns a schema given as a map, struct or raw JSON into
+// the generic form the validator reads. Maps go through JSON as well, so
+// values such as []string become []interface{}.
+func normalizeSchema(schema interface{}) (map[string]interface{}, error) {
+	var data []byte
+	switch s := schema.(type) {
+	case json.RawMessage:
+		data = s
+	case []byte:
+		data = s
+	case string:
+		data = []byte(s)
+	default:
+		var err error
+		if data, err = json.Marshal(schema); err != nil {
+			return nil, fmt.Errorf("invalid response schema: %w", err)
+		}
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid response schema: %w", err)
+	}
+	return m, nil
+}
+
+// conform parses output as JSON, ignoring a surrounding code fence, and
+// validates it. It returns the bare JSON, or why the output does not match.
+func conform(schema map[string]interface{}, output string) (string, string) {
+	text := strings.TrimSpace(output)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text[3:], "json")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return "", "not valid JSON: " + err.Error()
+	}
+	if err := validateSchema(schema, v, "$"); err != nil {
+		return "", err.Error()
+	}
+	return text, ""
+}
+
+// validateSchema checks a decoded JSON value against the common JSON Schema
+// keywords: type, enum, const, properties, required, additionalProperties,
+// items, anyOf, oneOf, allOf, and the length and range limits. Other
+// keywords are ignored.
+func validateSchema(schema map[string]interface{}, v interface{}, path string) error {
+	if t, ok := schema["type"]; ok && !matchesType(t, v) {
+		return fmt.Errorf("%s: expected %s, got %s", path, typeNames(t), jsonType(v))
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %s is not one of the allowed values", path, compactJSON(v))
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		return fmt.Errorf("%s: must be %s", path, compactJSON(c))
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if n, ok := name.(string); ok {
+					if _, present := x[n]; !present {
+						return fmt.Errorf("%s: missing required property %q", path, n)
+					}
+				}
+			}
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := props[k].(map[string]interface{}); ok {
+				if err := validateSchema(ps, x[k], path+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unexpected property %q", path, k)
+				}
+			case map[string]interface{}:
+				if err := validateSchema(extra, x[k], path+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if n, ok := number(schema["minItems"]); ok && float64(len(x)) < n {
+			return fmt.Errorf("%s: needs at least %v items", path, n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(x)) > n {
+			return fmt.Errorf("%s: allows at most %v items", path, n)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, e := range x {
+				if err := validateSchema(items, e, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(x)))
+		if n, ok := number(schema["minLength"]); ok && length < n {
+			return fmt.Errorf("%s: needs at least %v characters", path, n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && length > n {
+			return fmt.Errorf("%s: allows at most %v characters", path, n)
+		}
+	case float64:
+		if n, ok := number(schema["minimum"]); ok && x < n {
+			return fmt.Errorf("%s: %v is below the minimum %v", path, x, n)
+		}
+		if n, ok := number(schema["maximum"]); ok && x > n {
+			return fmt.Errorf("%s: %v is above the maximum %v", path, x, n)
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range all {
+			if sub, ok := s.(map[string]interface{}); ok {
+				if err := validateSchema(sub, v, path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		alts, ok := schema[key].([]interface{})
+		if !ok {
+			continue
+		}
+		matched := 0
+		var first error
+		for _, s := range alts {
+			sub, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := validateSchema(sub, v, path); err != nil {
+				if first == nil {
+					first = err
+				}
+				continue
+			}
+			matched++
+		}
+		switch {
+		case matched == 0 && first != nil:
+			return fmt.Errorf("%s: matches none of the %s alternatives, e.g. %v", path, key, first)
+		case key == "oneOf" && matched > 1:
+			return fmt.Errorf("%s: matches %d oneOf alternatives, expected exactly one", path, matched)
+		}
+	}
+	return nil
+}
+
+// matchesType checks a value against a "type" keyword, a name or a list.
+func matchesType(t interface{}, v interface{}) bool {
+	switch tt := t.(type) {
+	case string:
+		actual := jsonType(v)
+		return actual == tt || (tt == "number" && actual == "integer")
+	case []interface{}:
+		for _, e := range tt {
+			if matchesType(e, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func typeNames(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, len(list))
+		for i, e := range list {
+			names[i] = fmt.Sprint(e)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// jsonType names the JSON type of a decoded value.
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == math.Trunc(x) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func number(v interface{}) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// objectSchema returns a schema with an object at the root, as tool inputs
+// and OpenAI's json_schema format require. Other schemas are wrapped in a
+// "value" property, which unwrapValue takes off again.
+func objectSchema(schema interface{}) (interface{}, bool) {
+	if m, ok := schema.(map[string]interface{}); ok && m["type"] == "object" {
+		return schema, false
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"value": schema},
+		"required":   []string{"value"},
+	}, true
+}
+
+// unwrapValue returns the "value" property of a wrapped response, or the
+// response unchanged if it is not one.
+func unwrapValue(content string) string {
+	var wrapper struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(content), &wrapper); err != nil || wrapper.Value == nil {
+		return content
+	}
+	return string(wrapper.Value)
+}
+
+// respondDescription describes respondTool to the model.
+const respondDescription = "Give your final answer by calling this tool. Its input is the answer."
+
+// respondContent turns the input of a respondTool call into the response
+// content.
+func respondContent(schema interface{}, input map[string]interface{}) string {
+	data, _ := json.Marshal(input)
+	if _, wrapped := objectSchema(schema); wrapped {
+		return unwrapValue(string(data))
+	}
+	return string(data)
+}
+
+// withSchemaInstruction describes the response schema in the system prompt,
+// for providers that cannot constrain their output to it.
+func withSchemaInstruction(msgs []Message, schema interface{}) []Message {
+	data, _ := json.MarshalIndent(schema, "", "  ")
+	note := "\n\nReply with only a JSON value, without code fences or explanations, that matches this JSON Schema:\n" + string(data) + "\n"
+	out := make([]Message, len(msgs))
+	copy(out, msgs)
+	if len(out) == 0 || out[0].Role != "system" {
+		return append([]Message{{Role: "system", Content: strings.TrimSpace(note)}}, out...)
+	}
+	out[0].Content += note
+	return out
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+// findingSchema is a code review finding, with Go-typed lists as callers
+// usually write them.
+var findingSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []string{"severity", "line"},
+	"properties": map[string]interface{}{
+		"severity": map[string]interface{}{"type": "string", "enum": []string{"low", "medium", "high"}},
+		"line":     map[string]interface{}{"type": "integer", "minimum": 1},
+		"message":  map[string]interface{}{"type": "string", "maxLength": 20},
+		"tags":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "maxItems": 2},
+	},
+	"additionalProperties": false,
+}
+
+func TestNormalizeSchema(t *testing.T) {
+	type schema struct {
+		Type     string   `json:"type"`
+		Required []string `json:"required"`
+	}
+	for _, s := range []interface{}{
+		map[string]interface{}{"type": "object", "required": []string{"a"}},
+		schema{Type: "object", Required: []string{"a"}},
+		`{"type":"object","required":["a"]}`,
+		json.RawMessage(`{"type":"object","required":["a"]}`),
+	} {
+		m, err := normalizeSchema(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, why := conform(m, `{"b":1}`); !strings.Contains(why, `missing required property "a"`) {
+			t.Errorf("%T schema: conform = %q, want the required property enforced", s, why)
+		}
+	}
+	if _, err := normalizeSchema(`[1, 2]`); err == nil {
+		t.Error("array accepted as a schema")
+	}
+}
+
+func TestConform(t *testing.T) {
+	schema, err := normalizeSchema(findingSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		output string
+		why    string // "" if the output matches
+	}{
+		{"valid", `{"severity":"high","line":3,"tags":["sql"]}`, ""},
+		{"code fence", "```json\n{\"severity\":\"low\",\"line\":1}\n```", ""},
+		{"not json", "The severity is high.", "not valid JSON"},
+		{"missing required", `{"severity":"high"}`, `$: missing required property "line"`},
+		{"enum", `{"severity":"critical","line":3}`, `$.severity: "critical" is not one of the allowed values`},
+		{"integer", `{"severity":"low","line":2.5}`, "$.line: expected integer, got number"},
+		{"minimum", `{"severity":"low","line":0}`, "$.line: 0 is below the minimum 1"},
+		{"max length", `{"severity":"low","line":1,"message":"this message is far too long"}`, "$.message: allows at most 20 characters"},
+		{"item type", `{"severity":"low","line":1,"tags":["a",2]}`, "$.tags[1]: expected string, got integer"},
+		{"max items", `{"severity":"low","line":1,"tags":["a","b","c"]}`, "$.tags: allows at most 2 items"},
+		{"additional property", `{"severity":"low","line":1,"fix":"x"}`, `$: unexpected property "fix"`},
+		{"root type", `[]`, "$: expected object, got array"},
+	}
+	for _, tt := range tests {
+		content, why := conform(schema, tt.output)
+		if tt.why == "" {
+			if why != "" || !json.Valid([]byte(content)) {
+				t.Errorf("%s: conform = %q, %q", tt.name, content, why)
+			}
+			continue
+		}
+		if !strings.Contains(why, tt.why) {
+			t.Errorf("%s: conform reason = %q, want %q", tt.name, why, tt.why)
+		}
+	}
+}
+
+func TestValidateCombinators(t *testing.T) {
+	schema, _ := normalizeSchema(`{
+		"oneOf": [{"type": "string"}, {"type": "integer"}, {"type": "number", "minimum": 10}],
+		"allOf": [{"maxLength": 3}]
+	}`)
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{`"x"`, ""},
+		{`3`, ""},
+		{`"long"`, "allows at most 3 characters"},
+		{`20`, "matches 2 oneOf alternatives"},
+		{`true`, "matches none of the oneOf alternatives"},
+	}
+	for _, tt := range tests {
+		var v interface{}
+		json.Unmarshal([]byte(tt.value), &v)
+		err := validateSchema(schema, v, "$")
+		if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: error = %v, want %q", tt.value, err, tt.err)
+		}
+	}
+
+	nullable, _ := normalizeSchema(`{"type": ["string", "null"], "const": null}`)
+	if err := validateSchema(nullable, nil, "$"); err != nil {
+		t.Errorf("null: %v", err)
+	}
+	if err := validateSchema(nullable, "x", "$"); err == nil || !strings.Contains(err.Error(), "must be null") {
+		t.Errorf("const: error = %v", err)
+	}
+}
+
+func TestObjectSchemaWrapping(t *testing.T) {
+	list := map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	wrapped, ok := objectSchema(list)
+	if !ok || wrapped.(map[string]interface{})["properties"].(map[string]interface{})["value"] == nil {
+		t.Fatalf("array schema not wrapped: %v", wrapped)
+	}
+	if got := respondContent(list, map[string]interface{}{"value": []interface{}{"a", "b"}}); got != `["a","b"]` {
+		t.Errorf("respondContent = %s", got)
+	}
+	if _, ok := objectSchema(findingSchema); ok {
+		t.Error("object schema wrapped")
+	}
+	if got := respondContent(findingSchema, map[string]interface{}{"value": 1}); got != `{"value":1}` {
+		t.Errorf("unwrapped object input: %s", got)
+	}
+}
+
+func TestCompleteStructured(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AI.DefaultModel = "anthropic"
+	anthropic := &fakeProvider{name: "anthropic", reply: `{"severity":"urgent","line":3}`}
+	ollama := &fakeProvider{name: "ollama", local: true, reply: "```json\n{\"severity\":\"high\",\"line\":3}\n```"}
+	r := testRouter(cfg, anthropic, ollama)
+
+	resp, err := r.Complete(context.Background(), Request{
+		Messages:       []Message{{Role: "user", Content: "review this"}},
+		ResponseSchema: findingSchema,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Provider != "ollama" || resp.Content != `{"severity":"high","line":3}` {
+		t.Errorf("served by %s: %q", resp.Provider, resp.Content)
+	}
+	calls := anthropic.requests()
+	if len(calls) != schemaAttempts {
+		t.Fatalf("anthropic asked %d times, want %d", len(calls), schemaAttempts)
+	}
+	last := calls[len(calls)-1].Messages
+	if fix := last[len(last)-1].Content; !strings.Contains(fix, `"urgent" is not one of the allowed values`) {
+		t.Errorf("retry does not say what was wrong: %q", fix)
+	}
+	if len(resp.Attempts) != 1 {
+		t.Fatalf("attempts = %+v", resp.Attempts)
+	}
+
+	// Without a fallback the SchemaError reaches the caller
+	r = testRouter(cfg, &fakeProvider{name: "anthropic", reply: "not json"})
+	_, err = r.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}, ResponseSchema: findingSchema})
+	var serr *SchemaError
+	if !errors.As(err, &serr) || serr.Attempts != schemaAttempts || serr.Output != "not json" {
+		t.Errorf("error = %v, want a SchemaError after %d attempts", err, schemaAttempts)
+	}
+}
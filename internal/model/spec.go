@@ -0,0 +1,204 @@
+package model
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// ModelSpec describes the limits and capabilities of a model.
+type ModelSpec struct {
+	Family        string `json:"family"`         // tokenizer family used for estimates
+	ContextWindow int    `json:"context_window"` // tokens, prompt and output together
+	MaxOutput     int    `json:"max_output"`     // tokens the model can generate
+	Tools         bool   `json:"tools"`
+	Vision        bool   `json:"vision"` // accepts image parts
+}
+
+// knownModels lists the limits of common models by name prefix. The longest
+// matching prefix wins; [[ai.models]] in the config overrides them.
+var knownModels = []struct {
+	prefix string
+	spec   ModelSpec
+}{
+	{"claude-opus-4", ModelSpec{"claude", 200000, 32000, true, true}},
+	{"claude-sonnet-4", ModelSpec{"claude", 200000, 64000, true, true}},
+	{"claude-haiku-4", ModelSpec{"claude", 200000, 64000, true, true}},
+	{"claude-3-7-sonnet", ModelSpec{"claude", 200000, 64000, true, true}},
+	{"claude-3-5", ModelSpec{"claude", 200000, 8192, true, true}},
+	{"claude-3", ModelSpec{"claude", 200000, 4096, true, true}},
+	{"claude", ModelSpec{"claude", 200000, 8192, true, true}},
+	{"gpt-5", ModelSpec{"gpt", 400000, 128000, true, true}},
+	{"gpt-4.1", ModelSpec{"gpt", 1047576, 32768, true, true}},
+	{"gpt-4o", ModelSpec{"gpt", 128000, 16384, true, true}},
+	{"gpt-4-turbo", ModelSpec{"gpt", 128000, 4096, true, true}},
+	{"gpt-4", ModelSpec{"gpt", 8192, 4096, true, false}},
+	{"gpt-3.5-turbo", ModelSpec{"gpt", 16385, 4096, true, false}},
+	{"o1", ModelSpec{"gpt", 200000, 100000, true, true}},
+	{"o3", ModelSpec{"gpt", 200000, 100000, true, true}},
+	{"o4-mini", ModelSpec{"gpt", 200000, 100000, true, true}},
+	{"codestral", ModelSpec{"mistral", 32768, 8192, false, false}},
+	{"mistral", ModelSpec{"mistral", 32768, 8192, true, false}},
+	{"qwen2.5-coder", ModelSpec{"qwen", 32768, 8192, true, false}},
+	{"qwen3", ModelSpec{"qwen", 40960, 8192, true, false}},
+	{"llama3.2-vision", ModelSpec{"llama", 131072, 8192, false, true}},
+	{"llava", ModelSpec{"llama", 32768, 4096, false, true}},
+	{"qwen2.5vl", ModelSpec{"qwen", 128000, 8192, false, true}},
+	{"gemma3", ModelSpec{"gemma", 131072, 8192, false, true}},
+	{"llama3.1", ModelSpec{"llama", 131072, 8192, true, false}},
+	{"llama3.2", ModelSpec{"llama", 131072, 8192, true, false}},
+	{"llama3.3", ModelSpec{"llama", 131072, 8192, true, false}},
+	{"llama3", ModelSpec{"llama", 8192, 4096, false, false}},
+	{"deepseek-coder-v2", ModelSpec{"deepseek", 163840, 8192, false, false}},
+	{"deepseek-coder", ModelSpec{"deepseek", 16384, 4096, false, false}},
+	{"starcoder2", ModelSpec{"starcoder", 16384, 4096, false, false}},
+	{"nomic-embed-text", ModelSpec{"bert", 8192, 0, false, false}},
+}
+
+// defaultSpec is assumed for models that are neither known nor configured.
+var defaultSpec = ModelSpec{Family: "llama", ContextWindow: 8192, MaxOutput: 4096, Tools: true}
+
+// modelResolver is implemented by providers that fill in a default model.
+type modelResolver interface {
+	resolveModel(override string) string
+}
+
+// modelName returns the concrete model a target calls.
+func modelName(t target) string {
+	if r, ok := t.provider.(modelResolver); ok {
+		return r.resolveModel(t.model)
+	}
+	return t.model
+}
+
+// ModelSpec returns the limits of a provider's model: the first matching
+// [[ai.models]] entry, else the provider's configured context window and the
+// built-in table, else conservative defaults. An empty model is the
+// provider's default.
+func (r *Router) ModelSpec(provider, model string) ModelSpec {
+	if p, ok := r.providers[provider]; ok {
+		model = modelName(target{provider: p, model: model})
+	}
+	spec := builtinSpec(provider, model)
+	for _, pc := range r.cfg.AI.Providers {
+		if pc.Name == provider && pc.ContextWindow > 0 {
+			spec.ContextWindow = pc.ContextWindow
+		}
+	}
+	for _, mc := range r.cfg.AI.Models {
+		if !specMatches(mc.Match, provider, model) {
+			continue
+		}
+		if mc.ContextWindow > 0 {
+			spec.ContextWindow = mc.ContextWindow
+		}
+		if mc.MaxOutput > 0 {
+			spec.MaxOutput = mc.MaxOutput
+		}
+		if mc.Capabilities != nil {
+			spec.Tools, spec.Vision = false, false
+			for _, c := range mc.Capabilities {
+				switch c {
+				case "tools":
+					spec.Tools = true
+				case "vision":
+					spec.Vision = true
+				}
+			}
+		}
+		if mc.Family != "" {
+			spec.Family = mc.Family
+		}
+		break
+	}
+	return spec
+}
+
+// builtinSpec looks a model up in knownModels by its name without an
+// organisation prefix or Ollama tag, e.g. "Qwen/Qwen2.5-Coder-32B" or
+// "codestral:latest".
+func builtinSpec(provider, model string) ModelSpec {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	best := -1
+	for i, k := range knownModels {
+		if strings.HasPrefix(name, k.prefix) && (best < 0 || len(k.prefix) > len(knownModels[best].prefix)) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return knownModels[best].spec
+	}
+	spec := defaultSpec
+	switch provider {
+	case "anthropic":
+		spec.Family = "claude"
+	case "openai":
+		spec.Family = "gpt"
+	}
+	return spec
+}
+
+func specMatches(pattern, provider, model string) bool {
+	for _, s := range []string{provider + "/" + model, model} {
+		if matched, _ := filepath.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// charsPerToken is the rough average number of ASCII characters per token
+// of a tokenizer family for English text and source code.
+var charsPerToken = map[string]float64{
+	"claude":   3.5,
+	"gpt":      4.0,
+	"llama":    3.8,
+	"mistral":  3.6,
+	"qwen":     3.8,
+	"deepseek": 3.8,
+}
+
+// messageOverhead approximates the tokens a chat format adds per message.
+const messageOverhead = 4
+
+// EstimateTokens approximates the number of tokens text has for a tokenizer
+// family. Non-ASCII characters, which tokenizers split more finely, count
+// as one token each, so the estimate errs on the high side.
+func EstimateTokens(family, text string) int {
+	cpt, ok := charsPerToken[family]
+	if !ok {
+		cpt = 3.5
+	}
+	ascii, other := 0, 0
+	for _, c := range text {
+		if c < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return int(float64(ascii)/cpt+0.999) + other
+}
+
+// estimateMessage approximates the tokens of one message.
+func estimateMessage(family string, msg Message) int {
+	n := messageOverhead + EstimateTokens(family, msg.Content) + len(msg.Images)*imageTokens
+	for _, tc := range msg.ToolCalls {
+		input, _ := json.Marshal(tc.Input)
+		n += EstimateTokens(family, tc.Name) + EstimateTokens(family, string(input))
+	}
+	return n
+}
+
+// estimateTools approximates the tokens of the tool definitions.
+func estimateTools(family string, tools []ToolDef) int {
+	n := 0
+	for _, t := range tools {
+		schema, _ := json.Marshal(t.Schema)
+		n += messageOverhead + EstimateTokens(family, t.Name+" "+t.Description) + EstimateTokens(family, string(schema))
+	}
+	return n
+}
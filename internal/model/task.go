@@ -0,0 +1,84 @@
+package model
+
+import (
+	"log"
+	"strings"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+// Task kinds of a request. Routes in [ai.tasks] and in project policies map
+// them to models, so internal work such as summarizing history goes to a
+// cheap local model while interactive coding gets the default model.
+const (
+	TaskChat      = "chat"
+	TaskSummarize = "summarize"
+	TaskClassify  = "classify"
+	TaskCodeEdit  = "code_edit"
+	TaskEmbed     = "embed"
+)
+
+var taskKinds = map[string]bool{
+	TaskChat:      true,
+	TaskSummarize: true,
+	TaskClassify:  true,
+	TaskCodeEdit:  true,
+	TaskEmbed:     true,
+}
+
+// taskOf returns a request's task kind; requests without one are chat.
+func taskOf(req Request) string {
+	if req.Task == "" {
+		return TaskChat
+	}
+	return req.Task
+}
+
+// taskRoute returns the candidates configured for a task: the policy's
+// route if it has one, else the global route.
+func (r *Router) taskRoute(policy *config.ModelPolicy, task string) []string {
+	if policy != nil {
+		if route, ok := policy.Tasks[task]; ok {
+			return route
+		}
+	}
+	return r.cfg.AI.Tasks[task]
+}
+
+// expandLocal replaces "local" in a list of providers or provider/model IDs
+// by the local providers.
+func (r *Router) expandLocal(ids []string) []string {
+	var out []string
+	for _, id := range ids {
+		if id == policyLocal {
+			out = append(out, r.localProviders()...)
+			continue
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// checkTasks warns about routes for unknown task kinds and for embeddings,
+// which always use [index] embedding_model so vectors stay comparable.
+func (r *Router) checkTasks() {
+	check := func(where string, routes map[string][]string) {
+		for task := range routes {
+			switch {
+			case task == TaskEmbed:
+				log.Printf("Warning: %s: route for %s is ignored, embeddings use [index] embedding_model", where, task)
+			case !taskKinds[task]:
+				log.Printf("Warning: %s: unknown task %q, use one of chat, summarize, classify, code_edit", where, task)
+			}
+		}
+	}
+	check("[ai.tasks]", r.cfg.AI.Tasks)
+	for _, policy := range r.cfg.AI.Policies {
+		check("policy "+policy.ProjectPattern, policy.Tasks)
+	}
+}
+
+// describeRoute formats a task route for a selection reason.
+func describeRoute(task string, route []string) string {
+	return "task " + task + " routed to " + strings.Join(route, ", ")
+}
@@ -0,0 +1,458 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/greencode/greenforge/internal/audit"
+	"github.com/greencode/greenforge/internal/config"
+	"github.com/greencode/greenforge/internal/rbac"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// UsageLedger records the token usage and cost of every model call and
+// enforces the configured budgets.
+type UsageLedger struct {
+	db  *sql.DB
+	cfg config.UsageConfig
+}
+
+// UsageEntry is one model call in the ledger.
+type UsageEntry struct {
+	Time         time.Time `json:"time"`
+	User         string    `json:"user,omitempty"`
+	Session      string    `json:"session,omitempty"`
+	Project      string    `json:"project,omitempty"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CacheRead    int       `json:"cache_read_tokens,omitempty"`
+	CacheWrite   int       `json:"cache_creation_tokens,omitempty"`
+	Cost         float64   `json:"cost"`
+	Saved        float64   `json:"saved,omitempty"`  // prompt caching savings, negative while the cache fills
+	Cached       bool      `json:"cached,omitempty"` // answered from the response cache
+}
+
+// UsageFilter selects ledger entries for a report.
+type UsageFilter struct {
+	Since   *time.Time
+	Until   *time.Time
+	User    string
+	Project string
+	GroupBy string // user, project, provider, model, session or day
+}
+
+// UsageRow sums the calls of one group in a report.
+type UsageRow struct {
+	Group        string  `json:"group"`
+	Calls        int64   `json:"calls"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CacheRead    int64   `json:"cache_read_tokens"`
+	CacheWrite   int64   `json:"cache_creation_tokens"`
+	Cost         float64 `json:"cost"`
+	Saved        float64 `json:"saved"` // by prompt caching
+}
+
+// BudgetStatus is the state of one budget for one user or project in the
+// current period.
+type BudgetStatus struct {
+	Scope  string    `json:"scope"`
+	Name   string    `json:"name"`
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	Spent  float64   `json:"spent"`
+	Limit  float64   `json:"limit"`
+	State  string    `json:"state"` // ok, warning or exceeded
+}
+
+// BudgetError is returned when a call would exceed a budget.
+type BudgetError struct {
+	Status   BudgetStatus
+	Currency string
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s budget of %s %s is used up: %.2f of %.2f %s",
+		e.Status.Period, e.Status.Scope, e.Status.Name, e.Status.Spent, e.Status.Limit, e.Currency)
+}
+
+// usageGroups maps report groupings to SQL expressions.
+var usageGroups = map[string]string{
+	"user":     "user",
+	"project":  "project",
+	"provider": "provider",
+	"model":    "provider || '/' || model",
+	"session":  "session",
+	"day":      "date(ts, 'unixepoch', 'localtime')",
+}
+
+// OpenUsageLedger opens or creates the ledger database at path.
+func OpenUsageLedger(path string, cfg config.UsageConfig) (*UsageLedger, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("opening usage db: %w", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS usage (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			ts            INTEGER NOT NULL,
+			user          TEXT NOT NULL,
+			session       TEXT NOT NULL,
+			project       TEXT NOT NULL,
+			provider      TEXT NOT NULL,
+			model         TEXT NOT NULL,
+			input_tokens  INTEGER NOT NULL,
+			output_tokens INTEGER NOT NULL,
+			cost          REAL NOT NULL,
+			cached        INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_usage_ts ON usage(ts);
+		CREATE INDEX IF NOT EXISTS idx_usage_user ON usage(user, ts);
+		CREATE INDEX IF NOT EXISTS idx_usage_project ON usage(project, ts);
+		CREATE TABLE IF NOT EXISTS budget_alerts (
+			scope  TEXT NOT NULL,
+			name   TEXT NOT NULL,
+			period TEXT NOT NULL,
+			start  INTEGER NOT NULL,
+			PRIMARY KEY (scope, name, period, start)
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating usage schema: %w", err)
+	}
+	// Ledgers from before prompt caching
+	for _, col := range []string{"cache_read_tokens INTEGER", "cache_creation_tokens INTEGER", "saved REAL"} {
+		_, err = db.Exec("ALTER TABLE usage ADD COLUMN " + col + " NOT NULL DEFAULT 0")
+		if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, fmt.Errorf("migrating usage schema: %w", err)
+		}
+	}
+	for _, b := range cfg.Budgets {
+		if (b.Scope != "user" && b.Scope != "project") || (b.Period != "daily" && b.Period != "monthly") {
+			log.Printf("Warning: ignoring budget %s %q: scope must be user or project, period daily or monthly", b.Scope, b.Match)
+		}
+	}
+	return &UsageLedger{db: db, cfg: cfg}, nil
+}
+
+// Close closes the ledger database.
+func (l *UsageLedger) Close() error {
+	return l.db.Close()
+}
+
+// Currency returns the label prices and budgets are in.
+func (l *UsageLedger) Currency() string {
+	return l.cfg.Currency
+}
+
+// cost prices token usage by the first matching price table entry and
+// returns what prompt caching saved against sending every token uncached.
+func (l *UsageLedger) cost(provider, model string, u Usage) (cost, saved float64) {
+	for _, p := range l.cfg.Prices {
+		if matched, _ := filepath.Match(p.Match, provider+"/"+model); !matched {
+			continue
+		}
+		read, write := p.CacheRead, p.CacheWrite
+		if read == 0 {
+			read = p.Input * 0.1
+		}
+		if write == 0 {
+			write = p.Input * 1.25
+		}
+		cost = float64(u.InputTokens)*p.Input + float64(u.OutputTokens)*p.Output +
+			float64(u.CacheReadTokens)*read + float64(u.CacheCreationTokens)*write
+		saved = float64(u.CacheReadTokens)*(p.Input-read) + float64(u.CacheCreationTokens)*(p.Input-write)
+		return cost / 1e6, saved / 1e6
+	}
+	return 0, 0
+}
+
+// record adds a call to the ledger.
+func (l *UsageLedger) record(e UsageEntry) error {
+	_, err := l.db.Exec(`INSERT INTO usage
+		(ts, user, session, project, provider, model, input_tokens, output_tokens,
+		 cache_read_tokens, cache_creation_tokens, cost, saved, cached)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Time.Unix(), e.User, e.Session, e.Project, e.Provider, e.Model, e.InputTokens, e.OutputTokens,
+		e.CacheRead, e.CacheWrite, e.Cost, e.Saved, e.Cached)
+	if err != nil {
+		return fmt.Errorf("recording usage: %w", err)
+	}
+	return nil
+}
+
+// Report sums calls, tokens and cost per group, most expensive first.
+func (l *UsageLedger) Report(f UsageFilter) ([]UsageRow, error) {
+	group, ok := usageGroups[f.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown grouping %q: use user, project, provider, model, session or day", f.GroupBy)
+	}
+	var where []string
+	var args []interface{}
+	if f.Since != nil {
+		where, args = append(where, "ts >= ?"), append(args, f.Since.Unix())
+	}
+	if f.Until != nil {
+		where, args = append(where, "ts < ?"), append(args, f.Until.Unix())
+	}
+	if f.User != "" {
+		where, args = append(where, "user = ?"), append(args, f.User)
+	}
+	if f.Project != "" {
+		where, args = append(where, "project = ?"), append(args, f.Project)
+	}
+	query := `SELECT ` + group + `, COUNT(*), SUM(input_tokens), SUM(output_tokens),
+		SUM(cache_read_tokens), SUM(cache_creation_tokens), SUM(cost), SUM(saved) FROM usage`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " GROUP BY 1 ORDER BY 7 DESC, 1"
+
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying usage: %w", err)
+	}
+	defer rows.Close()
+	var report []UsageRow
+	for rows.Next() {
+		var row UsageRow
+		if err := rows.Scan(&row.Group, &row.Calls, &row.InputTokens, &row.OutputTokens,
+			&row.CacheRead, &row.CacheWrite, &row.Cost, &row.Saved); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+// periodStart returns when the current daily or monthly period began.
+func periodStart(period string, now time.Time) time.Time {
+	y, m, d := now.Date()
+	if period == "monthly" {
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+}
+
+// status returns a budget's state for one user or project.
+func (l *UsageLedger) status(b config.Budget, name string, now time.Time) (BudgetStatus, error) {
+	st := BudgetStatus{Scope: b.Scope, Name: name, Period: b.Period, Start: periodStart(b.Period, now), Limit: b.Limit, State: "ok"}
+	err := l.db.QueryRow(`SELECT COALESCE(SUM(cost), 0) FROM usage WHERE `+b.Scope+` = ? AND ts >= ?`,
+		name, st.Start.Unix()).Scan(&st.Spent)
+	if err != nil {
+		return st, fmt.Errorf("reading %s budget of %s: %w", b.Period, name, err)
+	}
+	warnAt := b.WarnAt
+	if warnAt <= 0 {
+		warnAt = 0.8
+	}
+	switch {
+	case st.Spent >= b.Limit:
+		st.State = "exceeded"
+	case st.Spent >= b.Limit*warnAt:
+		st.State = "warning"
+	}
+	return st, nil
+}
+
+// validBudget reports whether a budget can be evaluated.
+func validBudget(b config.Budget) bool {
+	return (b.Scope == "user" || b.Scope == "project") && (b.Period == "daily" || b.Period == "monthly") && b.Limit > 0
+}
+
+// budgetMatches matches a budget's pattern against a user name or a
+// project path and its directory name. An empty pattern or "*" matches all.
+func budgetMatches(pattern, name string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	for _, s := range []string{name, filepath.Base(name)} {
+		if matched, _ := filepath.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the state of every budget that applies to a user and
+// project, skipping scopes whose name is unknown.
+func (l *UsageLedger) check(user, project string, now time.Time) ([]BudgetStatus, error) {
+	var out []BudgetStatus
+	for _, b := range l.cfg.Budgets {
+		name := user
+		if b.Scope == "project" {
+			name = project
+		}
+		if !validBudget(b) || name == "" {
+			continue
+		}
+		if !budgetMatches(b.Match, name) {
+			continue
+		}
+		st, err := l.status(b, name, now)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// Budgets returns the state of every budget for each user and project that
+// has used models in the budget's current period.
+func (l *UsageLedger) Budgets() ([]BudgetStatus, error) {
+	now := time.Now()
+	var out []BudgetStatus
+	for _, b := range l.cfg.Budgets {
+		if !validBudget(b) {
+			continue
+		}
+		rows, err := l.db.Query(`SELECT DISTINCT `+b.Scope+` FROM usage WHERE ts >= ? AND `+b.Scope+` != '' ORDER BY 1`,
+			periodStart(b.Period, now).Unix())
+		if err != nil {
+			return nil, fmt.Errorf("reading budgets: %w", err)
+		}
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if budgetMatches(b.Match, name) {
+				names = append(names, name)
+			}
+		}
+		rows.Close()
+		for _, name := range names {
+			st, err := l.status(b, name, now)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, st)
+		}
+	}
+	return out, nil
+}
+
+// firstAlert reports whether this is the first warning for a budget in its
+// current period, so it is logged and audited only once.
+func (l *UsageLedger) firstAlert(st BudgetStatus) bool {
+	res, err := l.db.Exec(`INSERT OR IGNORE INTO budget_alerts (scope, name, period, start) VALUES (?, ?, ?, ?)`,
+		st.Scope, st.Name, st.Period, st.Start.Unix())
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n > 0
+}
+
+// SetUsageLedger enables usage recording and budgets for completions.
+func (r *Router) SetUsageLedger(ledger *UsageLedger) {
+	r.ledger = ledger
+}
+
+// anonymousUser books calls without a user name, such as gateway callers
+// without a client certificate, so user budgets still apply to them.
+const anonymousUser = "anonymous"
+
+// callIdentity returns the user, session and project of a call.
+func callIdentity(ctx context.Context) (user, session, project string) {
+	if id, ok := rbac.IdentityFromContext(ctx); ok {
+		user = id.User
+	}
+	if user == "" {
+		user = anonymousUser
+	}
+	session, _ = ctx.Value(ctxKeySession{}).(string)
+	project, _ = ctx.Value(ctxKeyProject{}).(string)
+	return user, session, project
+}
+
+// checkBudgets refuses a call when a budget of the caller or the project is
+// used up, or cannot be read, and returns warnings for budgets past their
+// warning threshold.
+func (r *Router) checkBudgets(ctx context.Context) ([]string, error) {
+	if r.ledger == nil {
+		return nil, nil
+	}
+	user, session, project := callIdentity(ctx)
+	statuses, err := r.ledger.check(user, project, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("checking usage budgets: %w", err)
+	}
+	var warnings []string
+	for _, st := range statuses {
+		switch st.State {
+		case "exceeded":
+			r.auditBudget(user, session, project, st, "usage.budget.denied")
+			return warnings, &BudgetError{Status: st, Currency: r.ledger.Currency()}
+		case "warning":
+			msg := fmt.Sprintf("%s budget of %s %s is %.0f%% used (%.2f of %.2f %s)",
+				st.Period, st.Scope, st.Name, st.Spent/st.Limit*100, st.Spent, st.Limit, r.ledger.Currency())
+			warnings = append(warnings, msg)
+			if r.ledger.firstAlert(st) {
+				log.Printf("Warning: %s", msg)
+				r.auditBudget(user, session, project, st, "usage.budget.warning")
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// auditBudget logs a budget warning or refusal.
+func (r *Router) auditBudget(user, session, project string, st BudgetStatus, action string) {
+	if r.auditor == nil {
+		return
+	}
+	r.auditor.Log(audit.Event{
+		Action:    action,
+		User:      user,
+		SessionID: session,
+		Project:   project,
+		Details: map[string]string{
+			"scope":  st.Scope,
+			"name":   st.Name,
+			"period": st.Period,
+			"spent":  fmt.Sprintf("%.4f", st.Spent),
+			"limit":  fmt.Sprintf("%.2f", st.Limit),
+		},
+	})
+}
+
+// recordUsage adds a completed call to the ledger.
+func (r *Router) recordUsage(ctx context.Context, rec callRecord) {
+	if r.ledger == nil || rec.served.provider == nil {
+		return
+	}
+	user, session, project := callIdentity(ctx)
+	e := UsageEntry{
+		Time:     time.Now(),
+		User:     user,
+		Session:  session,
+		Project:  project,
+		Provider: rec.served.provider.Name(),
+		Model:    rec.model,
+		Cached:   rec.cache == "hit",
+	}
+	if e.Model == "" {
+		e.Model = modelName(rec.served)
+	}
+	if rec.usage != nil && !e.Cached {
+		e.InputTokens, e.OutputTokens = rec.usage.InputTokens, rec.usage.OutputTokens
+		e.CacheRead, e.CacheWrite = rec.usage.CacheReadTokens, rec.usage.CacheCreationTokens
+		e.Cost, e.Saved = r.ledger.cost(e.Provider, e.Model, *rec.usage)
+	}
+	if err := r.ledger.record(e); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
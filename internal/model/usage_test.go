@@ -0,0 +1,188 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/greencode/greenforge/internal/config"
+	"github.com/greencode/greenforge/internal/rbac"
+)
+
+func openTestLedger(t *testing.T, cfg config.UsageConfig) *UsageLedger {
+	t.Helper()
+	l, err := OpenUsageLedger(filepath.Join(t.TempDir(), "usage.db"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestUsageCost(t *testing.T) {
+	l := openTestLedger(t, config.UsageConfig{Prices: []config.ModelPrice{
+		{Match: "anthropic/claude-haiku*", Input: 1, Output: 5, CacheRead: 0.5},
+		{Match: "anthropic/*", Input: 3, Output: 15},
+	}})
+	tests := []struct {
+		model       string
+		usage       Usage
+		cost, saved float64
+	}{
+		{"claude-sonnet-4", Usage{InputTokens: 1e6, OutputTokens: 1e6}, 18, 0},
+		{"claude-haiku-3", Usage{InputTokens: 1e6, OutputTokens: 1e6}, 6, 0},
+		// Cache reads default to 0.1 × input, writes to 1.25 × input
+		{"claude-sonnet-4", Usage{CacheReadTokens: 1e6, CacheCreationTokens: 1e6}, 0.3 + 3.75, 2.7 - 0.75},
+		{"claude-haiku-3", Usage{CacheReadTokens: 1e6}, 0.5, 0.5},
+	}
+	for _, tt := range tests {
+		cost, saved := l.cost("anthropic", tt.model, tt.usage)
+		if math.Abs(cost-tt.cost) > 1e-9 || math.Abs(saved-tt.saved) > 1e-9 {
+			t.Errorf("cost(%s, %+v) = %v, %v, want %v, %v", tt.model, tt.usage, cost, saved, tt.cost, tt.saved)
+		}
+	}
+	if cost, _ := l.cost("ollama", "llama3", Usage{InputTokens: 1e6}); cost != 0 {
+		t.Errorf("unpriced model costs %v", cost)
+	}
+}
+
+func TestBudgetStates(t *testing.T) {
+	l := openTestLedger(t, config.UsageConfig{Budgets: []config.Budget{
+		{Scope: "user", Match: "*", Period: "daily", Limit: 10},
+		{Scope: "project", Match: "bank", Period: "monthly", Limit: 100, WarnAt: 0.5},
+		{Scope: "team", Match: "*", Period: "daily", Limit: 1}, // ignored
+	}})
+	now := time.Now()
+	for _, e := range []UsageEntry{
+		{Time: now, User: "alice", Project: "/c/GC/bank", Cost: 8},
+		{Time: now, User: "bob", Project: "/c/GC/bank", Cost: 50},
+		{Time: now.AddDate(0, 0, -40), User: "alice", Project: "/c/GC/bank", Cost: 500},
+	} {
+		if err := l.record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		user, project string
+		states        []string
+	}{
+		{"alice", "/c/GC/bank", []string{"warning", "warning"}},
+		{"bob", "/c/GC/bank", []string{"exceeded", "warning"}},
+		{"carol", "/home/me/oss", []string{"ok"}},
+		{"carol", "", []string{"ok"}},
+	}
+	for _, tt := range tests {
+		statuses, err := l.check(tt.user, tt.project, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var states []string
+		for _, st := range statuses {
+			states = append(states, st.State)
+		}
+		if !reflect.DeepEqual(states, tt.states) {
+			t.Errorf("check(%s, %s) = %v, want %v", tt.user, tt.project, states, tt.states)
+		}
+	}
+
+	all, err := l.Budgets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Budgets = %+v, want alice, bob and bank", all)
+	}
+}
+
+func TestCompleteEnforcesBudgets(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AI.DefaultModel = "anthropic/claude-sonnet"
+	p := &fakeProvider{name: "anthropic"}
+	r := testRouter(cfg, p)
+	usage := config.UsageConfig{
+		Prices:  []config.ModelPrice{{Match: "anthropic/*", Input: 3000, Output: 15000}},
+		Budgets: []config.Budget{{Scope: "user", Match: "*", Period: "daily", Limit: 1}},
+	}
+	ledger := openTestLedger(t, usage)
+	r.SetUsageLedger(ledger)
+
+	// Each call costs 0.45: 100 input and 10 output tokens
+	alice := rbac.WithIdentity(WithProject(context.Background(), "/repos/api"), rbac.Identity{User: "alice"})
+	req := Request{Messages: []Message{{Role: "user", Content: "hi"}}}
+	for i, warnings := range []int{0, 0, 1} {
+		resp, err := r.Complete(alice, req)
+		if err != nil || len(resp.Warnings) != warnings {
+			t.Fatalf("call %d = %+v, %v, want %d budget warnings", i+1, resp, err, warnings)
+		}
+	}
+	_, err := r.Complete(alice, req)
+	var berr *BudgetError
+	if !errors.As(err, &berr) || berr.Status.Name != "alice" {
+		t.Fatalf("error = %v, want alice's budget used up", err)
+	}
+	if n := len(p.requests()); n != 3 {
+		t.Errorf("provider called %d times, want 3", n)
+	}
+
+	// Callers without an identity share the anonymous budget
+	if _, err := r.Complete(context.Background(), req); err != nil {
+		t.Errorf("anonymous call: %v", err)
+	}
+	rows, err := ledger.Report(UsageFilter{GroupBy: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Group != "alice" || rows[0].Calls != 3 || rows[1].Group != anonymousUser {
+		t.Errorf("report = %+v", rows)
+	}
+	if math.Abs(rows[0].Cost-1.35) > 1e-9 || rows[0].InputTokens != 300 {
+		t.Errorf("alice's usage = %+v, want 300 input tokens for 1.35", rows[0])
+	}
+}
+
+func TestBudgetUnreadable(t *testing.T) {
+	r := testRouter(nil, &fakeProvider{name: "anthropic"})
+	ledger := openTestLedger(t, config.UsageConfig{Budgets: []config.Budget{{Scope: "user", Period: "daily", Limit: 1}}})
+	r.SetUsageLedger(ledger)
+	ledger.Close()
+	if _, err := r.checkBudgets(context.Background()); err == nil {
+		t.Error("call allowed although the budget could not be read")
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	l := openTestLedger(t, config.UsageConfig{})
+	now := time.Now()
+	for _, e := range []UsageEntry{
+		{Time: now, User: "alice", Provider: "anthropic", Model: "claude-sonnet", Cost: 2},
+		{Time: now, User: "alice", Provider: "ollama", Model: "llama3"},
+		{Time: now.Add(-48 * time.Hour), User: "bob", Provider: "anthropic", Model: "claude-sonnet", Cost: 5},
+	} {
+		if err := l.record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := l.Report(UsageFilter{GroupBy: "model"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Group != "anthropic/claude-sonnet" || rows[0].Calls != 2 || rows[0].Cost != 7 {
+		t.Errorf("by model = %+v", rows)
+	}
+	since := now.Add(-time.Hour)
+	rows, err = l.Report(UsageFilter{GroupBy: "user", Since: &since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Group != "alice" || rows[0].Calls != 2 {
+		t.Errorf("today by user = %+v", rows)
+	}
+	if _, err := l.Report(UsageFilter{GroupBy: "team"}); err == nil {
+		t.Error("unknown grouping accepted")
+	}
+}
@@ -0,0 +1,274 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// placeholderRe matches placeholders issued by a SecretVault.
+var placeholderRe = regexp.MustCompile(`⟦SECRET_\d+:[a-z0-9-]+⟧`)
+
+// maxPlaceholderLen bounds how much streamed text is held back while a
+// placeholder may still be incomplete.
+const maxPlaceholderLen = 64
+
+// SecretVault maps secrets to placeholders such as ⟦SECRET_3:jdbc-password⟧
+// so the model can refer to a secret without seeing it. The same secret
+// always gets the same placeholder from one vault, which the router keeps
+// per session. The mapping exists only in memory and is never sent,
+// logged or cached.
+type SecretVault struct {
+	mu       sync.Mutex
+	tokens   map[string]string // secret → placeholder
+	secrets  map[string]string // placeholder → secret
+	category map[string]string // secret → detection category
+	ordered  []string          // known secrets, longest first
+}
+
+// NewSecretVault creates an empty vault.
+func NewSecretVault() *SecretVault {
+	return &SecretVault{
+		tokens:   make(map[string]string),
+		secrets:  make(map[string]string),
+		category: make(map[string]string),
+	}
+}
+
+// Len returns the number of secrets in the vault.
+func (v *SecretVault) Len() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.tokens)
+}
+
+// token returns the placeholder for a secret, issuing a new one if needed.
+func (v *SecretVault) token(secret, category, label string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t, ok := v.tokens[secret]; ok {
+		return t
+	}
+	t := fmt.Sprintf("⟦SECRET_%d:%s⟧", len(v.tokens)+1, label)
+	v.tokens[secret] = t
+	v.secrets[t] = secret
+	v.category[secret] = category
+	v.ordered = append(v.ordered, secret)
+	sort.SliceStable(v.ordered, func(i, j int) bool { return len(v.ordered[i]) > len(v.ordered[j]) })
+	return t
+}
+
+// conceal replaces every known secret in text by its placeholder. This
+// catches secrets that were restored earlier and now appear without the
+// context a detection pattern needs.
+func (v *SecretVault) conceal(text string, stats RedactionStats) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, secret := range v.ordered {
+		if n := strings.Count(text, secret); n > 0 {
+			text = strings.ReplaceAll(text, secret, v.tokens[secret])
+			if stats != nil {
+				stats[v.category[secret]] += n
+			}
+		}
+	}
+	return text
+}
+
+// Restore replaces the vault's placeholders in text by the secrets.
+// Placeholders the vault did not issue are left as they are.
+func (v *SecretVault) Restore(text string) string {
+	if !strings.Contains(text, "⟦SECRET_") {
+		return text
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return placeholderRe.ReplaceAllStringFunc(text, func(t string) string {
+		if secret, ok := v.secrets[t]; ok {
+			return secret
+		}
+		return t
+	})
+}
+
+// isPlaceholder reports whether a detected value is already a placeholder.
+func isPlaceholder(s string) bool {
+	return strings.HasPrefix(s, "⟦SECRET_")
+}
+
+// splitSecret splits a pattern match into the part to keep, the secret
+// value and a closing quote, and returns the key name the value belongs to.
+func splitSecret(category, match string) (prefix, secret, suffix, key string) {
+	if strings.HasPrefix(match, "-----BEGIN") {
+		return "", match, "", ""
+	}
+	idx := strings.IndexAny(match, ":=")
+	if category == "jdbc" {
+		idx = strings.LastIndex(strings.ToLower(match), "password=") + len("password")
+	}
+	if idx < 0 {
+		// "Bearer <token>": keep the scheme
+		if i := strings.LastIndexAny(match, " \t"); i >= 0 {
+			return match[:i+1], match[i+1:], "", ""
+		}
+		return "", match, "", ""
+	}
+	key = match[:idx]
+	value := strings.TrimLeft(match[idx+1:], " \t'\"")
+	prefix = match[:len(match)-len(value)]
+	secret = strings.TrimRight(value, "'\"")
+	return prefix, secret, value[len(secret):], key
+}
+
+// secretLabel names a placeholder after the detection category and the key
+// the secret was assigned to, e.g. "jdbc-password" or "db-password".
+func secretLabel(category, key string) string {
+	label := strings.ReplaceAll(category, "_", "-")
+	if i := strings.LastIndexAny(key, ".?&; \t"); i >= 0 {
+		key = key[i+1:]
+	}
+	key = strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, key), "-")
+	switch {
+	case key == "" || key == label:
+		return label
+	case strings.Contains(key, label), label == "password":
+		// The generic rule also matches secret=, token=, pwd=
+		return key
+	}
+	return label + "-" + key
+}
+
+// mapToolInputs returns copies of tool calls with f applied to every string
+// in their inputs.
+func mapToolInputs(calls []ToolCall, f func(string) string) []ToolCall {
+	out := make([]ToolCall, len(calls))
+	for i, tc := range calls {
+		out[i] = tc
+		if tc.Input != nil {
+			out[i].Input = mapStrings(tc.Input, f).(map[string]interface{})
+		}
+	}
+	return out
+}
+
+func mapStrings(v interface{}, f func(string) string) interface{} {
+	switch x := v.(type) {
+	case string:
+		return f(x)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = mapStrings(e, f)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(x))
+		for i, e := range x {
+			s[i] = mapStrings(e, f)
+		}
+		return s
+	}
+	return v
+}
+
+// vault returns the secret vault for the session in ctx, or a fresh one for
+// calls outside a session. It returns nil if tokenization is disabled.
+func (r *Router) vault(ctx context.Context) *SecretVault {
+	if !r.cfg.AI.Firewall.Tokenize {
+		return nil
+	}
+	session, _ := ctx.Value(ctxKeySession{}).(string)
+	if session == "" {
+		return NewSecretVault()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.vaults[session]
+	if !ok {
+		v = NewSecretVault()
+		r.vaults[session] = v
+	}
+	return v
+}
+
+// ForgetSession drops the secret vault of a closed session.
+func (r *Router) ForgetSession(session string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.vaults, session)
+}
+
+// Sanitize passes text through the firewall with the session's vault, for
+// content that is kept or logged outside a model request, such as tool
+// results in agent memory.
+func (r *Router) Sanitize(ctx context.Context, text string) (string, RedactionStats) {
+	return r.firewall.ScrubTextStats(text, r.vault(ctx))
+}
+
+// restorer puts secrets back into model output where configuration and the
+// project's policy allow it.
+type restorer struct {
+	vault      *SecretVault
+	content    bool
+	toolInputs bool
+	pending    string // streamed text held back inside a possible placeholder
+}
+
+// restorerFor returns how placeholders in the response to sel are restored.
+func (r *Router) restorerFor(sel *selection, vault *SecretVault) *restorer {
+	rs := &restorer{vault: vault}
+	if vault == nil || (sel.policy != nil && sel.policy.MaskSecrets) {
+		return rs
+	}
+	for _, where := range r.cfg.AI.Firewall.Restore {
+		switch where {
+		case "responses":
+			rs.content = true
+		case "tool_inputs":
+			rs.toolInputs = true
+		}
+	}
+	return rs
+}
+
+// response returns a copy of resp with placeholders restored.
+func (rs *restorer) response(resp *Response) *Response {
+	out := *resp
+	if rs.content {
+		out.Content = rs.vault.Restore(out.Content)
+	}
+	if rs.toolInputs && len(out.ToolCalls) > 0 {
+		out.ToolCalls = mapToolInputs(out.ToolCalls, rs.vault.Restore)
+	}
+	return &out
+}
+
+// chunk restores placeholders in a streamed chunk. Text from an opening
+// "⟦" that may be the start of a placeholder is held back until the next
+// chunk, or the final one, completes it.
+func (rs *restorer) chunk(c StreamChunk) StreamChunk {
+	if rs.content {
+		text := rs.pending + c.Content
+		rs.pending = ""
+		if i := strings.LastIndex(text, "⟦"); i >= 0 && !c.Done &&
+			!strings.Contains(text[i:], "⟧") && len(text)-i < maxPlaceholderLen {
+			text, rs.pending = text[:i], text[i:]
+		}
+		c.Content = rs.vault.Restore(text)
+	}
+	if rs.toolInputs && len(c.ToolCalls) > 0 {
+		c.ToolCalls = mapToolInputs(c.ToolCalls, rs.vault.Restore)
+	}
+	return c
+}
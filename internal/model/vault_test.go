@@ -0,0 +1,133 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/greencode/greenforge/internal/config"
+)
+
+const prodConfig = "spring.datasource.password=Xk9#mQ2vLp7$wR4t\nspring.datasource.username=bank\n"
+
+func TestVaultPlaceholders(t *testing.T) {
+	f := NewFirewall()
+	v := NewSecretVault()
+
+	first, stats := f.ScrubTextStats(prodConfig, v)
+	if strings.Contains(first, "Xk9#mQ2vLp7$wR4t") || stats.Total() != 1 {
+		t.Fatalf("secret not tokenized (%v):\n%s", stats, first)
+	}
+	token := placeholderRe.FindString(first)
+	if token == "" {
+		t.Fatalf("no placeholder in:\n%s", first)
+	}
+
+	// The same secret gets the same placeholder, also where no rule would
+	// find it once it has been restored
+	second, _ := f.ScrubTextStats("mysql -pXk9#mQ2vLp7$wR4t bank", v)
+	if !strings.Contains(second, token) || v.Len() != 1 {
+		t.Errorf("restored secret not concealed again with %s: %s", token, second)
+	}
+
+	if got := v.Restore(first); got != prodConfig {
+		t.Errorf("Restore = %q, want %q", got, prodConfig)
+	}
+	if got := v.Restore("⟦SECRET_9:other⟧"); got != "⟦SECRET_9:other⟧" {
+		t.Errorf("unknown placeholder restored to %q", got)
+	}
+	if got := NewSecretVault().Restore(first); got != first {
+		t.Errorf("another vault restored %q", got)
+	}
+}
+
+func TestRestoreChunks(t *testing.T) {
+	v := NewSecretVault()
+	token := v.token("hunter2hunter2", "password", "password")
+	rs := &restorer{vault: v, content: true}
+
+	// The placeholder arrives split over three chunks
+	parts := []string{"use " + token[:5], token[5:12], token[12:] + " here"}
+	var out strings.Builder
+	for _, p := range parts {
+		out.WriteString(rs.chunk(StreamChunk{Content: p}).Content)
+	}
+	out.WriteString(rs.chunk(StreamChunk{Done: true}).Content)
+	if out.String() != "use hunter2hunter2 here" {
+		t.Errorf("streamed = %q", out.String())
+	}
+
+	// A lone "⟦" is flushed with the final chunk
+	rs = &restorer{vault: v, content: true}
+	got := rs.chunk(StreamChunk{Content: "a ⟦"}).Content + rs.chunk(StreamChunk{Content: "b", Done: true}).Content
+	if got != "a ⟦b" {
+		t.Errorf("held back text = %q", got)
+	}
+}
+
+func TestCompleteRestoresSecrets(t *testing.T) {
+	tests := []struct {
+		name     string
+		restore  []string
+		mask     bool
+		content  bool
+		toolArgs bool
+	}{
+		{"responses and tool inputs", []string{"responses", "tool_inputs"}, false, true, true},
+		{"tool inputs only", []string{"tool_inputs"}, false, false, true},
+		{"masked by policy", []string{"responses", "tool_inputs"}, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.AI.DefaultModel = "anthropic"
+			cfg.AI.Firewall.Restore = tt.restore
+			if tt.mask {
+				cfg.AI.Policies = []config.ModelPolicy{{ProjectPattern: "/c/GC/*", AllowedProviders: []string{"anthropic"}, MaskSecrets: true}}
+			}
+			p := &fakeProvider{name: "anthropic"}
+			r := testRouter(cfg, p)
+			ctx := WithSession(WithProject(context.Background(), "/c/GC/bank"), "s1")
+
+			sanitized, _ := r.Sanitize(ctx, prodConfig)
+			token := placeholderRe.FindString(sanitized)
+			if token == "" {
+				t.Fatalf("no placeholder in %q", sanitized)
+			}
+			p.reply = "the password is " + token
+
+			resp, err := r.Complete(ctx, Request{Messages: []Message{{Role: "tool", Content: prodConfig}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sent := sentContent(p); strings.Contains(sent, "Xk9#mQ2vLp7$wR4t") || !strings.Contains(sent, token) {
+				t.Errorf("session placeholder not sent:\n%s", sent)
+			}
+			if restored := strings.Contains(resp.Content, "Xk9#mQ2vLp7$wR4t"); restored != tt.content {
+				t.Errorf("content %q, restored = %v, want %v", resp.Content, restored, tt.content)
+			}
+
+			rs := r.restorerFor(&selection{policy: r.matchPolicy("/c/GC/bank")}, r.vault(ctx))
+			calls := rs.response(&Response{ToolCalls: []ToolCall{{Name: "shell", Input: map[string]interface{}{
+				"args": []interface{}{"mysql", "-p" + token},
+			}}}}).ToolCalls
+			arg := calls[0].Input["args"].([]interface{})[1].(string)
+			if restored := arg == "-pXk9#mQ2vLp7$wR4t"; restored != tt.toolArgs {
+				t.Errorf("tool input %q, restored = %v, want %v", arg, restored, tt.toolArgs)
+			}
+		})
+	}
+}
+
+func TestForgetSession(t *testing.T) {
+	r := testRouter(nil)
+	ctx := WithSession(context.Background(), "s1")
+	r.Sanitize(ctx, prodConfig)
+	if r.vault(ctx).Len() != 1 {
+		t.Fatal("session vault not kept")
+	}
+	r.ForgetSession("s1")
+	if r.vault(ctx).Len() != 0 {
+		t.Error("vault kept after ForgetSession")
+	}
+}
@@ -0,0 +1,241 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Certificate types distinguished by conditional grants.
+const (
+	CertTypeUser   = "user"
+	CertTypeDevice = "device"
+)
+
+// Grant is a set of permissions (or denies) that only applies when its
+// conditions match the request attributes.
+type Grant struct {
+	Permissions []string `yaml:"permissions,omitempty"`
+	Deny        []string `yaml:"deny,omitempty"`
+	Conditions  `yaml:",inline"`
+}
+
+// Conditions restrict when a grant applies. Empty fields match everything.
+type Conditions struct {
+	Projects  []string `yaml:"projects,omitempty"`   // project globs, same matching as ModelPolicy.ProjectPattern
+	Branches  []string `yaml:"branches,omitempty"`   // branch globs, typically used with vcs:write
+	CertTypes []string `yaml:"cert_types,omitempty"` // user, device
+	Hours     string   `yaml:"hours,omitempty"`      // "08:00-18:00", may wrap midnight
+	Days      []string `yaml:"days,omitempty"`       // mon, tue, wed, thu, fri, sat, sun
+}
+
+// Attributes describe the request a permission is checked for.
+type Attributes struct {
+	Project  string    // project path
+	Branch   string    // VCS branch, if known
+	CertType string    // user or device
+	Device   string    // device name for device certificates
+	Time     time.Time // evaluation time, defaults to now
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	User   string
+	Role   string
+	Device string           // set for device certificates
+	Cert   *ssh.Certificate // nil for local, unauthenticated sessions
+}
+
+// CertType reports whether the identity comes from a user or a device certificate.
+func (id Identity) CertType() string {
+	if id.Device != "" {
+		return CertTypeDevice
+	}
+	return CertTypeUser
+}
+
+type ctxKeyAttributes struct{}
+type ctxKeyIdentity struct{}
+
+// WithAttributes adds request attributes to context for conditional permission checks.
+func WithAttributes(ctx context.Context, attrs Attributes) context.Context {
+	return context.WithValue(ctx, ctxKeyAttributes{}, attrs)
+}
+
+// AttributesFromContext returns the request attributes stored in ctx.
+func AttributesFromContext(ctx context.Context) Attributes {
+	if ctx == nil {
+		return Attributes{}
+	}
+	attrs, _ := ctx.Value(ctxKeyAttributes{}).(Attributes)
+	return attrs
+}
+
+// WithIdentity adds the caller identity to context.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, ctxKeyIdentity{}, id)
+}
+
+// IdentityFromContext returns the caller identity stored in ctx.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	if ctx == nil {
+		return Identity{}, false
+	}
+	id, ok := ctx.Value(ctxKeyIdentity{}).(Identity)
+	return id, ok
+}
+
+// CertIdentity builds an Identity from a GreenForge SSH certificate.
+func CertIdentity(cert *ssh.Certificate) Identity {
+	id := Identity{
+		Role:   cert.Permissions.Extensions["greenforge-role@greenforge.dev"],
+		Device: cert.Permissions.Extensions["greenforge-device@greenforge.dev"],
+		Cert:   cert,
+	}
+	if len(cert.ValidPrincipals) > 0 {
+		id.User = cert.ValidPrincipals[0]
+	} else {
+		id.User = cert.KeyId
+	}
+	return id
+}
+
+// match reports whether the conditions hold for attrs. A condition on an
+// attribute that is unknown (empty) does not hold, for allows and denies
+// alike; callers fill in the project and branch where they can.
+func (c Conditions) match(attrs Attributes) bool {
+	if len(c.Projects) > 0 && (attrs.Project == "" || !matchAnyGlob(c.Projects, attrs.Project)) {
+		return false
+	}
+	if len(c.Branches) > 0 && (attrs.Branch == "" || !matchAnyGlob(c.Branches, attrs.Branch)) {
+		return false
+	}
+
+	if len(c.CertTypes) > 0 {
+		certType := attrs.CertType
+		if certType == "" {
+			certType = CertTypeUser
+		}
+		found := false
+		for _, t := range c.CertTypes {
+			if t == certType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	now := attrs.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if len(c.Days) > 0 {
+		day := strings.ToLower(now.Weekday().String()[:3])
+		found := false
+		for _, d := range c.Days {
+			if strings.ToLower(d) == day {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if c.Hours != "" {
+		start, end, err := parseHours(c.Hours)
+		if err != nil {
+			return false
+		}
+		minute := now.Hour()*60 + now.Minute()
+		if start <= end {
+			if minute < start || minute >= end {
+				return false
+			}
+		} else if minute < start && minute >= end {
+			// Window wraps midnight, e.g. 22:00-06:00
+			return false
+		}
+	}
+
+	return true
+}
+
+// String summarizes the conditions for explanations.
+func (c Conditions) String() string {
+	var parts []string
+	if len(c.Projects) > 0 {
+		parts = append(parts, "projects="+strings.Join(c.Projects, ","))
+	}
+	if len(c.Branches) > 0 {
+		parts = append(parts, "branches="+strings.Join(c.Branches, ","))
+	}
+	if len(c.CertTypes) > 0 {
+		parts = append(parts, "cert_types="+strings.Join(c.CertTypes, ","))
+	}
+	if c.Hours != "" {
+		parts = append(parts, "hours="+c.Hours)
+	}
+	if len(c.Days) > 0 {
+		parts = append(parts, "days="+strings.Join(c.Days, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c Conditions) validate() error {
+	for _, p := range append(append([]string{}, c.Projects...), c.Branches...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", p, err)
+		}
+	}
+	for _, t := range c.CertTypes {
+		if t != CertTypeUser && t != CertTypeDevice {
+			return fmt.Errorf("unknown cert type %q (use user or device)", t)
+		}
+	}
+	for _, d := range c.Days {
+		switch strings.ToLower(d) {
+		case "mon", "tue", "wed", "thu", "fri", "sat", "sun":
+		default:
+			return fmt.Errorf("unknown day %q", d)
+		}
+	}
+	if c.Hours != "" {
+		if _, _, err := parseHours(c.Hours); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseHours parses "HH:MM-HH:MM" into minutes since midnight. The end may
+// be 24:00 for a window that lasts until midnight.
+func parseHours(s string) (int, int, error) {
+	var sh, sm, eh, em int
+	if _, err := fmt.Sscanf(s, "%d:%d-%d:%d", &sh, &sm, &eh, &em); err != nil {
+		return 0, 0, fmt.Errorf("invalid hours %q, use HH:MM-HH:MM", s)
+	}
+	start, end := sh*60+sm, eh*60+em
+	if sh < 0 || sh > 23 || eh < 0 || eh > 24 || sm < 0 || sm > 59 || em < 0 || em > 59 || end > 24*60 {
+		return 0, 0, fmt.Errorf("invalid hours %q", s)
+	}
+	return start, end, nil
+}
+
+func matchAnyGlob(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if matched, _ := filepath.Match(p, value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -1,21 +1,42 @@
 package rbac
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
 // Engine evaluates RBAC policies based on SSH certificate extensions.
 type Engine struct {
+	mu    sync.RWMutex
 	roles map[string]*Role
 }
 
 // Role defines a set of permissions.
 type Role struct {
 	Name        string   `yaml:"name"`
-	Permissions []string `yaml:"permissions"` // e.g. "vcs:*", "build:execute", "shell"
+	Description string   `yaml:"description,omitempty"`
+	Extends     string   `yaml:"extends,omitempty"`  // parent role whose rules are inherited
+	Permissions []string `yaml:"permissions"`        // e.g. "vcs:*", "build:execute", "shell"
+	Deny        []string `yaml:"deny,omitempty"`     // explicit denies, always override allows
+	Projects    []string `yaml:"projects,omitempty"` // project globs the role is bound to (empty = all)
+	Grants      []Grant  `yaml:"grants,omitempty"`   // conditional permissions and denies
+}
+
+// Decision explains the outcome of a permission check.
+type Decision struct {
+	Allowed    bool   `json:"allowed"`
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+	Effect     string `json:"effect"`              // allow, deny, or "" when no rule matched
+	Rule       string `json:"rule,omitempty"`      // the matching policy rule, e.g. "db:*"
+	Source     string `json:"source,omitempty"`    // role that defines the rule (may be inherited)
+	Condition  string `json:"condition,omitempty"` // conditions of the matching grant, if any
+	Reason     string `json:"reason"`
 }
 
 // Permission represents a checked permission.
@@ -26,20 +47,27 @@ type Permission struct {
 
 // NewEngine creates an RBAC engine with the given roles.
 func NewEngine(roles []*Role) *Engine {
-	e := &Engine{
-		roles: make(map[string]*Role),
-	}
+	e := &Engine{}
+	e.SetRoles(roles)
+	return e
+}
+
+// SetRoles atomically replaces the engine's role definitions.
+func (e *Engine) SetRoles(roles []*Role) {
+	m := make(map[string]*Role, len(roles))
 	for _, r := range roles {
-		e.roles[r.Name] = r
+		m[r.Name] = r
 	}
-	return e
+	e.mu.Lock()
+	e.roles = m
+	e.mu.Unlock()
 }
 
 // DefaultRoles returns the built-in role definitions.
 func DefaultRoles() []*Role {
 	return []*Role{
 		{
-			Name: "admin",
+			Name:        "admin",
 			Permissions: []string{"*"},
 		},
 		{
@@ -47,43 +75,160 @@ func DefaultRoles() []*Role {
 			Permissions: []string{
 				"vcs:*", "build:*", "shell", "db:read", "db:write",
 				"analysis:*", "logs:read", "cicd:read", "cicd:trigger",
-				"notify:send", "index:*",
+				"notify:send", "index:*", "session:*",
+				"filesystem:read", "filesystem:write", "network:outbound:https",
 			},
 		},
 		{
 			Name: "viewer",
 			Permissions: []string{
 				"vcs:read", "logs:read", "cicd:read", "audit:read",
-				"index:read",
+				"index:read", "session:read",
 			},
 		},
 	}
 }
 
 // CheckCert extracts the role from an SSH certificate and checks a permission.
-func (e *Engine) CheckCert(cert *ssh.Certificate, perm Permission) error {
-	roleName, ok := cert.Permissions.Extensions["greenforge-role@greenforge.dev"]
-	if !ok {
+// The certificate type (user or device) overrides any type set in ctx.
+func (e *Engine) CheckCert(ctx context.Context, cert *ssh.Certificate, perm Permission) error {
+	id := CertIdentity(cert)
+	if id.Role == "" {
 		return fmt.Errorf("certificate has no greenforge-role extension")
 	}
-	return e.Check(roleName, perm)
+	attrs := AttributesFromContext(ctx)
+	attrs.CertType = id.CertType()
+	attrs.Device = id.Device
+	return e.Check(WithAttributes(ctx, attrs), id.Role, perm)
 }
 
-// Check verifies if a role has a given permission.
-func (e *Engine) Check(roleName string, perm Permission) error {
-	role, exists := e.roles[roleName]
-	if !exists {
-		return fmt.Errorf("unknown role: %s", roleName)
+// Check verifies if a role has a given permission for the request attributes
+// carried by ctx (project, branch, certificate type, time).
+func (e *Engine) Check(ctx context.Context, roleName string, perm Permission) error {
+	d := e.Explain(ctx, roleName, perm)
+	if d.Allowed {
+		return nil
 	}
+	return fmt.Errorf("%s", d.Reason)
+}
 
+// Explain evaluates a permission for a role and reports which rule decided it.
+// Deny rules anywhere in the inheritance chain take precedence over allows.
+// A role bound to other projects grants nothing; an inherited role bound to
+// other projects is skipped, and the roles it extends still apply.
+func (e *Engine) Explain(ctx context.Context, roleName string, perm Permission) Decision {
 	permStr := perm.String()
-	for _, p := range role.Permissions {
-		if matchPermission(p, permStr) {
-			return nil
+	d := Decision{Role: roleName, Permission: permStr}
+	attrs := AttributesFromContext(ctx)
+	if attrs.Time.IsZero() {
+		attrs.Time = time.Now()
+	}
+
+	e.mu.RLock()
+	chain, err := e.inheritanceChain(roleName)
+	e.mu.RUnlock()
+	if err != nil {
+		d.Reason = err.Error()
+		return d
+	}
+
+	if r := chain[0]; len(r.Projects) > 0 && !matchAnyGlob(r.Projects, attrs.Project) {
+		d.Reason = fmt.Sprintf("role %q is not bound to project %q", roleName, attrs.Project)
+		return d
+	}
+	bound := chain[:0:0]
+	for _, r := range chain {
+		if len(r.Projects) == 0 || matchAnyGlob(r.Projects, attrs.Project) {
+			bound = append(bound, r)
+		}
+	}
+	chain = bound
+
+	deny := func(rule, source, cond string) Decision {
+		d.Effect = "deny"
+		d.Rule = rule
+		d.Source = source
+		d.Condition = cond
+		d.Reason = fmt.Sprintf("role %q denies %q (deny rule %q from role %q)", roleName, permStr, rule, source)
+		if cond != "" {
+			d.Reason += " when " + cond
+		}
+		return d
+	}
+	allow := func(rule, source, cond string) Decision {
+		d.Allowed = true
+		d.Effect = "allow"
+		d.Rule = rule
+		d.Source = source
+		d.Condition = cond
+		d.Reason = fmt.Sprintf("role %q allows %q (rule %q from role %q)", roleName, permStr, rule, source)
+		if cond != "" {
+			d.Reason += " when " + cond
+		}
+		return d
+	}
+
+	for _, r := range chain {
+		for _, p := range r.Deny {
+			if matchPermission(p, permStr) {
+				return deny(p, r.Name, "")
+			}
+		}
+		for _, g := range r.Grants {
+			if !g.match(attrs) {
+				continue
+			}
+			for _, p := range g.Deny {
+				if matchPermission(p, permStr) {
+					return deny(p, r.Name, g.Conditions.String())
+				}
+			}
+		}
+	}
+
+	for _, r := range chain {
+		for _, p := range r.Permissions {
+			if matchPermission(p, permStr) {
+				return allow(p, r.Name, "")
+			}
+		}
+		for _, g := range r.Grants {
+			if !g.match(attrs) {
+				continue
+			}
+			for _, p := range g.Permissions {
+				if matchPermission(p, permStr) {
+					return allow(p, r.Name, g.Conditions.String())
+				}
+			}
 		}
 	}
 
-	return fmt.Errorf("role %q does not have permission %q", roleName, permStr)
+	d.Reason = fmt.Sprintf("role %q does not have permission %q", roleName, permStr)
+	return d
+}
+
+// inheritanceChain returns the role followed by its ancestors. Caller must hold e.mu.
+func (e *Engine) inheritanceChain(roleName string) ([]*Role, error) {
+	var chain []*Role
+	seen := make(map[string]bool)
+	for name := roleName; name != ""; {
+		if seen[name] {
+			return nil, fmt.Errorf("role %q has cyclic inheritance via %q", roleName, name)
+		}
+		seen[name] = true
+
+		role, exists := e.roles[name]
+		if !exists {
+			if name == roleName {
+				return nil, fmt.Errorf("unknown role: %s", roleName)
+			}
+			return nil, fmt.Errorf("role %q extends unknown role %q", roleName, name)
+		}
+		chain = append(chain, role)
+		name = role.Extends
+	}
+	return chain, nil
 }
 
 // CheckTools verifies if a cert has access to specific tools.
@@ -127,12 +272,16 @@ func (e *Engine) CheckSecrets(cert *ssh.Certificate, secretName string) error {
 
 // GetRole returns the role for a given name.
 func (e *Engine) GetRole(name string) (*Role, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	r, ok := e.roles[name]
 	return r, ok
 }
 
 // ListRoles returns all defined roles.
 func (e *Engine) ListRoles() []*Role {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	roles := make([]*Role, 0, len(e.roles))
 	for _, r := range e.roles {
 		roles = append(roles, r)
@@ -140,6 +289,15 @@ func (e *Engine) ListRoles() []*Role {
 	return roles
 }
 
+// ParsePermission converts a "resource:action" string into a Permission.
+func ParsePermission(s string) Permission {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 1 {
+		return Permission{Resource: parts[0]}
+	}
+	return Permission{Resource: parts[0], Action: parts[1]}
+}
+
 func (p Permission) String() string {
 	if p.Action == "" || p.Action == "*" {
 		return p.Resource
@@ -0,0 +1,208 @@
+package rbac
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRoles mirrors configs/rbac.yaml plus a project-bound role.
+func testRoles() []*Role {
+	return []*Role{
+		{Name: "admin", Permissions: []string{"*"}},
+		{Name: "developer", Permissions: []string{"vcs:*", "build:*", "shell", "db:read", "db:write", "index:*"}},
+		{Name: "viewer", Permissions: []string{"vcs:read", "index:read"}},
+		{
+			Name:    "contractor",
+			Extends: "developer",
+			Deny:    []string{"db:write"},
+			Grants: []Grant{
+				{Deny: []string{"vcs:write"}, Conditions: Conditions{Branches: []string{"main", "release/*"}}},
+				{Deny: []string{"vcs:write", "shell"}, Conditions: Conditions{CertTypes: []string{CertTypeDevice}}},
+				{Deny: []string{"*"}, Conditions: Conditions{CertTypes: []string{CertTypeDevice}, Hours: "20:00-07:00"}},
+			},
+		},
+		{Name: "partner", Extends: "viewer", Projects: []string{"/repos/partner/*"}, Permissions: []string{"build:execute"}},
+		{Name: "auditor", Extends: "partner", Permissions: []string{"audit:read"}},
+	}
+}
+
+func at(hour, minute int) time.Time {
+	return time.Date(2026, 3, 4, hour, minute, 0, 0, time.Local) // a Wednesday
+}
+
+func TestExplain(t *testing.T) {
+	e := NewEngine(testRoles())
+	noon := at(12, 0)
+
+	tests := []struct {
+		name    string
+		role    string
+		perm    string
+		attrs   Attributes
+		allowed bool
+		source  string
+		rule    string
+	}{
+		{"wildcard", "admin", "db:write", Attributes{}, true, "admin", "*"},
+		{"own permission", "developer", "vcs:write", Attributes{}, true, "developer", "vcs:*"},
+		{"missing permission", "viewer", "vcs:write", Attributes{}, false, "", ""},
+		{"inherited allow", "contractor", "db:read", Attributes{}, true, "developer", "db:read"},
+		{"deny overrides inherited allow", "contractor", "db:write", Attributes{}, false, "contractor", "db:write"},
+		{"branch deny matches", "contractor", "vcs:write", Attributes{Branch: "main", Time: noon}, false, "contractor", "vcs:write"},
+		{"branch deny glob", "contractor", "vcs:write", Attributes{Branch: "release/1.2", Time: noon}, false, "contractor", "vcs:write"},
+		{"other branch allowed", "contractor", "vcs:write", Attributes{Branch: "feature/x", Time: noon}, true, "developer", "vcs:*"},
+		{"unknown branch does not match deny", "contractor", "vcs:write", Attributes{Time: noon}, true, "developer", "vcs:*"},
+		{"device deny", "contractor", "shell", Attributes{CertType: CertTypeDevice, Time: noon}, false, "contractor", "shell"},
+		{"device read in hours", "contractor", "vcs:read", Attributes{CertType: CertTypeDevice, Time: noon}, true, "developer", "vcs:*"},
+		{"device after hours", "contractor", "vcs:read", Attributes{CertType: CertTypeDevice, Time: at(21, 0)}, false, "contractor", "*"},
+		{"device before hours end", "contractor", "vcs:read", Attributes{CertType: CertTypeDevice, Time: at(6, 59)}, false, "contractor", "*"},
+		{"device at hours end", "contractor", "vcs:read", Attributes{CertType: CertTypeDevice, Time: at(7, 0)}, true, "developer", "vcs:*"},
+		{"bound role in project", "partner", "build:execute", Attributes{Project: "/repos/partner/api"}, true, "partner", "build:execute"},
+		{"bound role outside project", "partner", "vcs:read", Attributes{Project: "/repos/internal"}, false, "", ""},
+		{"bound role without project", "partner", "vcs:read", Attributes{}, false, "", ""},
+		{"inherits past unbound parent", "auditor", "vcs:read", Attributes{Project: "/repos/internal"}, true, "viewer", "vcs:read"},
+		{"unbound parent contributes nothing", "auditor", "build:execute", Attributes{Project: "/repos/internal"}, false, "", ""},
+		{"bound parent in project", "auditor", "build:execute", Attributes{Project: "/repos/partner/api"}, true, "partner", "build:execute"},
+		{"unknown role", "nobody", "vcs:read", Attributes{}, false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithAttributes(context.Background(), tt.attrs)
+			d := e.Explain(ctx, tt.role, ParsePermission(tt.perm))
+			if d.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v (%s)", d.Allowed, tt.allowed, d.Reason)
+			}
+			if d.Source != tt.source || d.Rule != tt.rule {
+				t.Errorf("decided by %q from %q, want %q from %q (%s)", d.Rule, d.Source, tt.rule, tt.source, d.Reason)
+			}
+			if err := e.Check(ctx, tt.role, ParsePermission(tt.perm)); (err == nil) != tt.allowed {
+				t.Errorf("Check = %v, want allowed %v", err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestParseHours(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end int
+		ok         bool
+	}{
+		{"08:00-18:00", 8 * 60, 18 * 60, true},
+		{"20:00-07:00", 20 * 60, 7 * 60, true},
+		{"00:00-24:00", 0, 24 * 60, true},
+		{"09:30-17:45", 9*60 + 30, 17*60 + 45, true},
+		{"24:00-06:00", 0, 0, false},
+		{"08:00-24:59", 0, 0, false},
+		{"08:00-25:00", 0, 0, false},
+		{"08:60-18:00", 0, 0, false},
+		{"-1:00-18:00", 0, 0, false},
+		{"8-18", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, err := parseHours(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseHours(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && (start != tt.start || end != tt.end) {
+			t.Errorf("parseHours(%q) = %d, %d, want %d, %d", tt.in, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestConditionsMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		c     Conditions
+		attrs Attributes
+		want  bool
+	}{
+		{"no conditions", Conditions{}, Attributes{}, true},
+		{"project glob", Conditions{Projects: []string{"/repos/*"}}, Attributes{Project: "/repos/api"}, true},
+		{"project mismatch", Conditions{Projects: []string{"/repos/*"}}, Attributes{Project: "/other/api"}, false},
+		{"project unknown", Conditions{Projects: []string{"/repos/*"}}, Attributes{}, false},
+		{"branch unknown", Conditions{Branches: []string{"main"}}, Attributes{}, false},
+		{"cert type defaults to user", Conditions{CertTypes: []string{CertTypeUser}}, Attributes{}, true},
+		{"device only", Conditions{CertTypes: []string{CertTypeDevice}}, Attributes{}, false},
+		{"weekday", Conditions{Days: []string{"Wed"}}, Attributes{Time: at(12, 0)}, true},
+		{"weekend", Conditions{Days: []string{"sat", "sun"}}, Attributes{Time: at(12, 0)}, false},
+		{"until midnight", Conditions{Hours: "18:00-24:00"}, Attributes{Time: at(23, 59)}, true},
+		{"invalid hours never match", Conditions{Hours: "08:00-24:59"}, Attributes{Time: at(12, 0)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.c.match(tt.attrs); got != tt.want {
+			t.Errorf("%s: match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		policy, requested string
+		want              bool
+	}{
+		{"*", "db:write", true},
+		{"vcs:*", "vcs:write", true},
+		{"vcs", "vcs:read", true},
+		{"vcs:read", "vcs:write", false},
+		{"db:read", "vcs:read", false},
+		{"network:outbound:https", "network:outbound:https", true},
+		{"network:outbound:https", "network:outbound:http", false},
+	}
+	for _, tt := range tests {
+		if got := matchPermission(tt.policy, tt.requested); got != tt.want {
+			t.Errorf("matchPermission(%q, %q) = %v, want %v", tt.policy, tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRoles(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []*Role
+		err   string
+	}{
+		{"valid", testRoles(), ""},
+		{"unknown permission", []*Role{{Name: "a", Permissions: []string{"vcs:fly"}}}, "unknown action"},
+		{"unknown parent", []*Role{{Name: "a", Extends: "b"}}, "extends unknown role"},
+		{"cycle", []*Role{{Name: "a", Extends: "b"}, {Name: "b", Extends: "a"}}, "cyclic"},
+		{"duplicate", []*Role{{Name: "a"}, {Name: "a"}}, "duplicate"},
+		{"empty grant", []*Role{{Name: "a", Grants: []Grant{{}}}}, "no permissions"},
+		{"invalid hours", []*Role{{Name: "a", Grants: []Grant{{Deny: []string{"*"}, Conditions: Conditions{Hours: "24:59-08:00"}}}}}, "invalid hours"},
+		{"unknown cert type", []*Role{{Name: "a", Grants: []Grant{{Deny: []string{"*"}, Conditions: Conditions{CertTypes: []string{"robot"}}}}}}, "cert type"},
+	}
+	for _, tt := range tests {
+		err := ValidateRoles(tt.roles)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestReloadKeepsRolesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac.yaml")
+	if err := os.WriteFile(path, []byte("roles:\n  - name: dev\n    permissions: [\"vcs:*\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngineFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("roles:\n  - name: dev\n    permissions: [\"vcs:fly\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reload(path); err == nil {
+		t.Fatal("Reload accepted an invalid policy")
+	}
+	if err := e.Check(context.Background(), "dev", ParsePermission("vcs:write")); err != nil {
+		t.Errorf("previous roles lost after failed reload: %v", err)
+	}
+}
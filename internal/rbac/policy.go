@@ -0,0 +1,192 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is the on-disk RBAC policy (configs/rbac.yaml).
+type Policy struct {
+	Roles []*Role `yaml:"roles"`
+}
+
+// knownPermissions lists valid resources and their actions. A nil action list
+// means the resource is a single permission without actions (e.g. "shell").
+var knownPermissions = map[string][]string{
+	"vcs":        {"read", "write"},
+	"build":      {"read", "execute"},
+	"shell":      nil,
+	"db":         {"read", "write"},
+	"analysis":   {"review", "spring", "kafka"},
+	"logs":       {"read"},
+	"cicd":       {"read", "trigger"},
+	"notify":     {"send"},
+	"index":      {"read", "write"},
+	"session":    {"read", "write"},
+	"audit":      {"read"},
+	"filesystem": {"read", "write"},
+	"network":    {"outbound:http", "outbound:https"},
+}
+
+// ValidatePermission checks that a permission string refers to a known
+// resource and action. Wildcards ("*", "vcs:*") are accepted.
+func ValidatePermission(perm string) error {
+	if perm == "*" {
+		return nil
+	}
+	parts := strings.SplitN(perm, ":", 2)
+	actions, ok := knownPermissions[parts[0]]
+	if !ok {
+		return fmt.Errorf("unknown permission resource %q in %q", parts[0], perm)
+	}
+	if len(parts) == 1 || parts[1] == "*" {
+		return nil
+	}
+	for _, a := range actions {
+		if a == parts[1] {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown action %q for resource %q in %q", parts[1], parts[0], perm)
+}
+
+// ValidateRoles checks role names, inheritance and permission strings.
+func ValidateRoles(roles []*Role) error {
+	byName := make(map[string]*Role, len(roles))
+	for _, r := range roles {
+		if r.Name == "" {
+			return fmt.Errorf("role without name")
+		}
+		if _, dup := byName[r.Name]; dup {
+			return fmt.Errorf("duplicate role %q", r.Name)
+		}
+		byName[r.Name] = r
+	}
+
+	for _, r := range roles {
+		for _, p := range r.Permissions {
+			if err := ValidatePermission(p); err != nil {
+				return fmt.Errorf("role %q: %w", r.Name, err)
+			}
+		}
+		for _, p := range r.Deny {
+			if err := ValidatePermission(p); err != nil {
+				return fmt.Errorf("role %q deny: %w", r.Name, err)
+			}
+		}
+		for _, p := range r.Projects {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return fmt.Errorf("role %q: invalid project glob %q: %w", r.Name, p, err)
+			}
+		}
+		for i, g := range r.Grants {
+			if len(g.Permissions) == 0 && len(g.Deny) == 0 {
+				return fmt.Errorf("role %q grant %d: no permissions or deny rules", r.Name, i+1)
+			}
+			for _, p := range append(append([]string{}, g.Permissions...), g.Deny...) {
+				if err := ValidatePermission(p); err != nil {
+					return fmt.Errorf("role %q grant %d: %w", r.Name, i+1, err)
+				}
+			}
+			if err := g.validate(); err != nil {
+				return fmt.Errorf("role %q grant %d: %w", r.Name, i+1, err)
+			}
+		}
+
+		// Walk the inheritance chain to catch unknown parents and cycles
+		seen := map[string]bool{r.Name: true}
+		for parent := r.Extends; parent != ""; {
+			p, ok := byName[parent]
+			if !ok {
+				return fmt.Errorf("role %q extends unknown role %q", r.Name, parent)
+			}
+			if seen[parent] {
+				return fmt.Errorf("role %q has cyclic inheritance via %q", r.Name, parent)
+			}
+			seen[parent] = true
+			parent = p.Extends
+		}
+	}
+	return nil
+}
+
+// LoadPolicyFile reads and validates roles from a YAML policy file.
+func LoadPolicyFile(path string) ([]*Role, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rbac policy: %w", err)
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parsing rbac policy %s: %w", path, err)
+	}
+	if len(policy.Roles) == 0 {
+		return nil, fmt.Errorf("rbac policy %s defines no roles", path)
+	}
+	if err := ValidateRoles(policy.Roles); err != nil {
+		return nil, fmt.Errorf("invalid rbac policy %s: %w", path, err)
+	}
+	return policy.Roles, nil
+}
+
+// NewEngineFromFile creates an engine from a YAML policy file.
+func NewEngineFromFile(path string) (*Engine, error) {
+	roles, err := LoadPolicyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(roles), nil
+}
+
+// Reload re-reads the policy file and swaps in the new roles. On error the
+// currently active roles are kept.
+func (e *Engine) Reload(path string) error {
+	roles, err := LoadPolicyFile(path)
+	if err != nil {
+		return err
+	}
+	e.SetRoles(roles)
+	return nil
+}
+
+// Watch polls the policy file and hot-reloads it whenever its modification
+// time changes. Blocks until ctx is cancelled.
+func (e *Engine) Watch(ctx context.Context, path string, interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+			if err := e.Reload(path); err != nil {
+				log.Printf("RBAC: keeping previous policy, reload failed: %v", err)
+				continue
+			}
+			log.Printf("RBAC: policy reloaded from %s", path)
+		}
+	}
+}
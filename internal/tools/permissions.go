@@ -0,0 +1,204 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/greencode/greenforge/internal/audit"
+	"github.com/greencode/greenforge/internal/rbac"
+)
+
+// PermissionRule adds permissions to a function call when an input parameter
+// has one of the listed values, e.g. git_branch with action=create needs
+// vcs:write while action=list only needs vcs:read.
+type PermissionRule struct {
+	Param       string   `yaml:"param"`
+	Values      []string `yaml:"values"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// validatePermissions checks that every function permission is a known
+// permission declared in spec.permissions, the tool's upper bound.
+func validatePermissions(tool *ToolDef) error {
+	declared := make(map[string]bool, len(tool.Spec.Permissions))
+	for _, p := range tool.Spec.Permissions {
+		if err := rbac.ValidatePermission(p); err != nil {
+			return err
+		}
+		declared[p] = true
+	}
+
+	check := func(fn, p string) error {
+		if !declared[p] {
+			return fmt.Errorf("function %s requires %q which is not declared in spec.permissions", fn, p)
+		}
+		return nil
+	}
+	for _, fn := range tool.Spec.Functions {
+		for _, p := range fn.Permissions {
+			if err := check(fn.Name, p); err != nil {
+				return err
+			}
+		}
+		for _, rule := range fn.PermissionRules {
+			if rule.Param == "" || len(rule.Values) == 0 {
+				return fmt.Errorf("function %s: permission rule needs param and values", fn.Name)
+			}
+			for _, p := range rule.Permissions {
+				if err := check(fn.Name, p); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolve finds the tool and function for a call. The name may be a function
+// (git_commit), or a tool name (git) with the function passed as
+// input["function"]. Caller must hold r.mu.
+func (r *Registry) resolve(name string, input map[string]interface{}) (*ToolDef, *FunctionDef) {
+	if tool, ok := r.tools[name]; ok {
+		fnName := name
+		if f, ok := input["function"].(string); ok && f != "" {
+			fnName = f
+		}
+		return tool, tool.function(fnName)
+	}
+	for _, tool := range r.tools {
+		if fn := tool.function(name); fn != nil {
+			return tool, fn
+		}
+	}
+	return nil, nil
+}
+
+func (t *ToolDef) function(name string) *FunctionDef {
+	for i := range t.Spec.Functions {
+		if t.Spec.Functions[i].Name == name {
+			return &t.Spec.Functions[i]
+		}
+	}
+	return nil
+}
+
+// readOnly reports whether every permission the tool can require is a read
+// permission, so its calls have no side effects. Tools that declare no
+// permissions are not assumed to be read-only.
+func (t *ToolDef) readOnly() bool {
+	if len(t.Spec.Permissions) == 0 {
+		return false
+	}
+	for _, p := range t.Spec.Permissions {
+		if !strings.HasSuffix(p, ":read") {
+			return false
+		}
+	}
+	return true
+}
+
+// requiredPermissions returns the permissions a call needs. Unknown functions
+// and functions without their own list require every declared permission.
+func requiredPermissions(tool *ToolDef, fn *FunctionDef, input map[string]interface{}) []string {
+	if fn == nil || len(fn.Permissions) == 0 && len(fn.PermissionRules) == 0 {
+		return tool.Spec.Permissions
+	}
+
+	perms := append([]string{}, fn.Permissions...)
+	for _, rule := range fn.PermissionRules {
+		v, ok := input[rule.Param]
+		if !ok {
+			continue
+		}
+		value := fmt.Sprint(v)
+		for _, want := range rule.Values {
+			if strings.EqualFold(value, want) {
+				perms = append(perms, rule.Permissions...)
+				break
+			}
+		}
+	}
+	return perms
+}
+
+// authorize checks the caller identity in ctx against the certificate tool
+// restrictions and the RBAC engine. Denials are written to the audit log.
+func (r *Registry) authorize(ctx context.Context, engine *rbac.Engine, tool *ToolDef, fn *FunctionDef, input map[string]interface{}) error {
+	fnName := tool.Metadata.Name
+	if fn != nil {
+		fnName = fn.Name
+	}
+
+	id, ok := rbac.IdentityFromContext(ctx)
+	if !ok {
+		return r.deny(ctx, id, tool, fnName, "", "no caller identity in request context")
+	}
+
+	if id.Cert != nil {
+		// greenforge-tools@ may list tool or function names
+		toolErr := engine.CheckTools(id.Cert, tool.Metadata.Name)
+		if toolErr != nil && fnName != tool.Metadata.Name {
+			toolErr = engine.CheckTools(id.Cert, fnName)
+		}
+		if toolErr != nil {
+			return r.deny(ctx, id, tool, fnName, "", toolErr.Error())
+		}
+	}
+
+	// Branch named in the input makes branch-scoped grants apply to vcs:write
+	attrs := rbac.AttributesFromContext(ctx)
+	if branch, ok := input["branch"].(string); ok && branch != "" {
+		attrs.Branch = branch
+	}
+	ctx = rbac.WithAttributes(ctx, attrs)
+
+	// A tool that declares nothing could do anything, so only admins may run it
+	perms := requiredPermissions(tool, fn, input)
+	if len(perms) == 0 {
+		perms = []string{"*"}
+	}
+	for _, p := range perms {
+		perm := rbac.ParsePermission(p)
+		var err error
+		if id.Cert != nil {
+			err = engine.CheckCert(ctx, id.Cert, perm)
+		} else {
+			err = engine.Check(ctx, id.Role, perm)
+		}
+		if err != nil {
+			return r.deny(ctx, id, tool, fnName, p, err.Error())
+		}
+	}
+	return nil
+}
+
+func (r *Registry) deny(ctx context.Context, id rbac.Identity, tool *ToolDef, fnName, perm, reason string) error {
+	if r.auditor != nil {
+		r.auditor.Log(audit.Event{
+			Action:  "tool.denied",
+			User:    id.User,
+			Project: rbac.AttributesFromContext(ctx).Project,
+			Tool:    tool.Metadata.Name,
+			Details: map[string]string{
+				"function":   fnName,
+				"role":       id.Role,
+				"device":     id.Device,
+				"permission": perm,
+				"reason":     reason,
+			},
+		})
+	}
+	return fmt.Errorf("permission denied: %s may not call %s: %s", describeCaller(id), fnName, reason)
+}
+
+func describeCaller(id rbac.Identity) string {
+	switch {
+	case id.Role == "":
+		return "anonymous caller"
+	case id.User != "":
+		return fmt.Sprintf("%s (role %s)", id.User, id.Role)
+	default:
+		return "role " + id.Role
+	}
+}
@@ -0,0 +1,140 @@
+package tools
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/greencode/greenforge/internal/agent"
+	"github.com/greencode/greenforge/internal/rbac"
+)
+
+func gitTool() *ToolDef {
+	return &ToolDef{
+		Metadata: Metadata{Name: "git"},
+		Spec: ToolSpec{
+			Permissions: []string{"vcs:read", "vcs:write"},
+			Functions: []FunctionDef{
+				{Name: "git_status", Permissions: []string{"vcs:read"}},
+				{
+					Name:        "git_branch",
+					Permissions: []string{"vcs:read"},
+					PermissionRules: []PermissionRule{
+						{Param: "action", Values: []string{"create", "delete"}, Permissions: []string{"vcs:write"}},
+					},
+				},
+				{Name: "git_gc"},
+			},
+		},
+	}
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	tool := gitTool()
+	tests := []struct {
+		fn    string
+		input map[string]interface{}
+		want  []string
+	}{
+		{"git_status", nil, []string{"vcs:read"}},
+		{"git_branch", map[string]interface{}{"action": "list"}, []string{"vcs:read"}},
+		{"git_branch", map[string]interface{}{"action": "Create"}, []string{"vcs:read", "vcs:write"}},
+		{"git_branch", nil, []string{"vcs:read"}},
+		{"git_gc", nil, []string{"vcs:read", "vcs:write"}},
+		{"git_unknown", nil, []string{"vcs:read", "vcs:write"}},
+	}
+	for _, tt := range tests {
+		got := requiredPermissions(tool, tool.function(tt.fn), tt.input)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v: permissions = %v, want %v", tt.fn, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	if err := validatePermissions(gitTool()); err != nil {
+		t.Errorf("valid tool rejected: %v", err)
+	}
+
+	undeclared := gitTool()
+	undeclared.Spec.Permissions = []string{"vcs:read"}
+	if err := validatePermissions(undeclared); err == nil || !strings.Contains(err.Error(), "not declared") {
+		t.Errorf("undeclared permission: error = %v", err)
+	}
+
+	unknown := gitTool()
+	unknown.Spec.Permissions = append(unknown.Spec.Permissions, "vcs:fly")
+	if err := validatePermissions(unknown); err == nil {
+		t.Error("unknown permission accepted")
+	}
+}
+
+func TestExecuteAuthorizes(t *testing.T) {
+	r := NewRegistry(nil, nil, nil)
+	r.SetRBAC(rbac.NewEngine(rbac.DefaultRoles()))
+	ok := func(ctx context.Context, input map[string]interface{}) (agent.ToolResult, error) {
+		return agent.ToolResult{Output: "ok"}, nil
+	}
+	r.RegisterBuiltin("code_search", "search", "index", []string{"index:read"}, ok)
+	r.RegisterBuiltin("undeclared", "declares nothing", "misc", nil, ok)
+	r.mu.Lock()
+	git := gitTool()
+	git.handler = ok
+	r.tools["git"] = git
+	r.mu.Unlock()
+
+	tests := []struct {
+		name    string
+		role    string
+		tool    string
+		input   map[string]interface{}
+		allowed bool
+	}{
+		{"viewer reads index", "viewer", "code_search", nil, true},
+		{"no identity", "", "code_search", nil, false},
+		{"undeclared tool denied", "developer", "undeclared", nil, false},
+		{"undeclared tool for admin", "admin", "undeclared", nil, true},
+		{"viewer lists branches", "viewer", "git_branch", map[string]interface{}{"action": "list"}, true},
+		{"viewer creates branch", "viewer", "git_branch", map[string]interface{}{"action": "create"}, false},
+		{"developer creates branch", "developer", "git_branch", map[string]interface{}{"action": "create"}, true},
+		{"function named via input", "viewer", "git", map[string]interface{}{"function": "git_status"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.role != "" {
+				ctx = rbac.WithIdentity(ctx, rbac.Identity{User: "alice", Role: tt.role})
+			}
+			input := tt.input
+			if input == nil {
+				input = map[string]interface{}{}
+			}
+			res, err := r.Execute(ctx, tt.tool, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed := res.Error == ""; allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v (%s)", allowed, tt.allowed, res.Error)
+			}
+			if !tt.allowed && res.Metadata["denied"] != "true" {
+				t.Errorf("denial not marked in metadata: %v", res.Metadata)
+			}
+		})
+	}
+}
+
+func TestBundledToolsDeclarePermissions(t *testing.T) {
+	r := NewRegistry(nil, nil, nil)
+	if err := r.LoadFromDir("../../tools"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.tools) == 0 {
+		t.Fatal("no bundled tools loaded")
+	}
+	for name, tool := range r.tools {
+		if len(tool.Spec.Permissions) == 0 {
+			t.Errorf("tool %s declares no permissions", name)
+		}
+	}
+}
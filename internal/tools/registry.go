@@ -5,22 +5,25 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/greencode/greenforge/internal/agent"
 	"github.com/greencode/greenforge/internal/audit"
+	"github.com/greencode/greenforge/internal/rbac"
 	"github.com/greencode/greenforge/internal/sandbox"
 	"gopkg.in/yaml.v3"
 )
 
 // Registry manages tool discovery, validation, and execution.
 type Registry struct {
-	mu       sync.RWMutex
-	tools    map[string]*ToolDef
-	sandbox  *sandbox.Engine
-	secrets  *sandbox.SecretManager
-	auditor  *audit.Logger
+	mu      sync.RWMutex
+	tools   map[string]*ToolDef
+	sandbox *sandbox.Engine
+	secrets *sandbox.SecretManager
+	auditor *audit.Logger
+	rbac    *rbac.Engine
 }
 
 // ToolDef represents a tool loaded from TOOL.yaml manifest.
@@ -42,22 +45,24 @@ type Metadata struct {
 }
 
 type ToolSpec struct {
-	Functions   []FunctionDef   `yaml:"functions"`
-	Sandbox     SandboxSpec     `yaml:"sandbox"`
-	Permissions []string        `yaml:"permissions"`
+	Functions   []FunctionDef `yaml:"functions"`
+	Sandbox     SandboxSpec   `yaml:"sandbox"`
+	Permissions []string      `yaml:"permissions"`
 }
 
 type FunctionDef struct {
-	Name        string      `yaml:"name"`
-	Description string      `yaml:"description"`
-	Parameters  interface{} `yaml:"parameters"`
+	Name            string           `yaml:"name"`
+	Description     string           `yaml:"description"`
+	Parameters      interface{}      `yaml:"parameters"`
+	Permissions     []string         `yaml:"permissions"`     // permissions this function needs; default: all of spec.permissions
+	PermissionRules []PermissionRule `yaml:"permissionRules"` // extra permissions depending on input
 }
 
 type SandboxSpec struct {
-	Image      string            `yaml:"image"`
-	Network    NetworkSpec       `yaml:"network"`
-	Filesystem FilesystemSpec    `yaml:"filesystem"`
-	Resources  ResourceSpec      `yaml:"resources"`
+	Image      string         `yaml:"image"`
+	Network    NetworkSpec    `yaml:"network"`
+	Filesystem FilesystemSpec `yaml:"filesystem"`
+	Resources  ResourceSpec   `yaml:"resources"`
 }
 
 type NetworkSpec struct {
@@ -91,6 +96,14 @@ func NewRegistry(sandbox *sandbox.Engine, secrets *sandbox.SecretManager, audito
 	}
 }
 
+// SetRBAC enables permission checks against the caller identity carried in
+// the execution context (see rbac.WithIdentity).
+func (r *Registry) SetRBAC(engine *rbac.Engine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rbac = engine
+}
+
 // LoadFromDir discovers and loads all tools from a directory.
 func (r *Registry) LoadFromDir(toolsDir string) error {
 	entries, err := os.ReadDir(toolsDir)
@@ -134,6 +147,9 @@ func (r *Registry) LoadTool(manifestPath string) error {
 	if tool.Metadata.Name == "" {
 		return fmt.Errorf("tool manifest missing name: %s", manifestPath)
 	}
+	if err := validatePermissions(&tool); err != nil {
+		return fmt.Errorf("tool %s: %w", tool.Metadata.Name, err)
+	}
 
 	r.mu.Lock()
 	r.tools[tool.Metadata.Name] = &tool
@@ -142,8 +158,9 @@ func (r *Registry) LoadTool(manifestPath string) error {
 	return nil
 }
 
-// RegisterBuiltin registers a built-in tool (not from YAML manifest).
-func (r *Registry) RegisterBuiltin(name, description, category string, handler BuiltinHandler) {
+// RegisterBuiltin registers a built-in tool (not from YAML manifest). Calls
+// need every permission in perms, like a manifest's spec.permissions.
+func (r *Registry) RegisterBuiltin(name, description, category string, perms []string, handler BuiltinHandler) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -154,6 +171,7 @@ func (r *Registry) RegisterBuiltin(name, description, category string, handler B
 			Category:    category,
 		},
 		Spec: ToolSpec{
+			Permissions: perms,
 			Functions: []FunctionDef{
 				{Name: name, Description: description},
 			},
@@ -165,26 +183,44 @@ func (r *Registry) RegisterBuiltin(name, description, category string, handler B
 // BuiltinHandler is the signature for built-in tool implementations.
 type BuiltinHandler func(ctx context.Context, input map[string]interface{}) (agent.ToolResult, error)
 
-// Execute runs a tool by name.
+// Execute runs a tool by name. toolName may be a tool or one of its functions.
+// When RBAC is enabled the caller in ctx must hold the function's permissions;
+// denied calls are audited and returned as a tool error, not a Go error.
 func (r *Registry) Execute(ctx context.Context, toolName string, input map[string]interface{}) (agent.ToolResult, error) {
 	r.mu.RLock()
-	tool, exists := r.tools[toolName]
+	tool, fn := r.resolve(toolName, input)
+	engine := r.rbac
 	r.mu.RUnlock()
 
-	if !exists {
+	if tool == nil {
 		return agent.ToolResult{}, fmt.Errorf("unknown tool: %s", toolName)
 	}
 
+	if engine != nil {
+		if denied := r.authorize(ctx, engine, tool, fn, input); denied != nil {
+			return agent.ToolResult{
+				Error:    denied.Error(),
+				Metadata: map[string]string{"denied": "true"},
+			}, nil
+		}
+	}
+
 	start := time.Now()
 
 	// Audit: tool execution started
 	if r.auditor != nil {
+		id, _ := rbac.IdentityFromContext(ctx)
+		details := map[string]string{
+			"category": tool.Metadata.Category,
+		}
+		if fn != nil {
+			details["function"] = fn.Name
+		}
 		r.auditor.Log(audit.Event{
-			Action: "tool.execute",
-			Tool:   toolName,
-			Details: map[string]string{
-				"category": tool.Metadata.Category,
-			},
+			Action:  "tool.execute",
+			User:    id.User,
+			Tool:    tool.Metadata.Name,
+			Details: details,
 		})
 	}
 
@@ -198,7 +234,7 @@ func (r *Registry) Execute(ctx context.Context, toolName string, input map[strin
 		// Sandboxed tool
 		result, err = r.executeSandboxed(ctx, tool, input)
 	} else {
-		err = fmt.Errorf("no execution method available for tool %s", toolName)
+		err = fmt.Errorf("no execution method available for tool %s", tool.Metadata.Name)
 	}
 
 	result.Duration = time.Since(start)
@@ -230,9 +266,9 @@ func (r *Registry) executeSandboxed(ctx context.Context, tool *ToolDef, input ma
 	}
 
 	runResult, err := r.sandbox.Run(ctx, sandbox.RunConfig{
-		Image:    spec.Image,
-		Command:  command,
-		Mounts:   mounts,
+		Image:   spec.Image,
+		Command: command,
+		Mounts:  mounts,
 		Network: sandbox.NetworkPolicy{
 			Mode:         spec.Network.Mode,
 			AllowedHosts: spec.Network.AllowedHosts,
@@ -275,8 +311,11 @@ func (r *Registry) ListTools() []agent.ToolInfo {
 			Name:        tool.Metadata.Name,
 			Description: tool.Metadata.Description,
 			Category:    tool.Metadata.Category,
+			ReadOnly:    tool.readOnly(),
 		})
 	}
+	// Sorted, so the tool definitions keep the prompt cache prefix stable
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
 	return tools
 }
 
@@ -292,4 +331,3 @@ func buildCommand(toolName string, input map[string]interface{}) []string {
 	// Default: run the tool binary with JSON input
 	return []string{"/usr/local/bin/greenforge-tool", toolName}
 }
-